@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"pcap_agent/internal/common"
+	"pcap_agent/internal/executor"
+	"pcap_agent/internal/planner"
+	"pcap_agent/internal/session"
+	conversationsummary "pcap_agent/internal/summary"
+	"pcap_agent/internal/tools"
+	"pcap_agent/internal/virtual_env"
+
+	"github.com/cloudwego/eino-ext/components/model/openai"
+	"github.com/cloudwego/eino-ext/components/tool/commandline"
+	"github.com/cloudwego/eino-ext/components/tool/commandline/sandbox"
+
+	"github.com/cloudwego/eino/components/tool"
+	"github.com/cloudwego/eino/compose"
+	"github.com/cloudwego/eino/flow/agent/react"
+)
+
+// agentFlags are the flags shared by the interactive REPL and serve mode.
+type agentFlags struct {
+	dbPath         string
+	parallel       int
+	replan         bool
+	repairAttempts int
+	maxReactSteps  int
+	stepToolCalls  int
+	stepTimeout    time.Duration
+	stepTokens     int
+	planMaxSteps   int
+	planRetries    int
+	historyTokens  int
+	historyRecent  int
+}
+
+func (f *agentFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.dbPath, "db", "pcap_agent.db", "Session store: SQLite database path or postgres:// URL")
+	fs.IntVar(&f.parallel, "parallel", executor.DefaultMaxParallelSteps, "Max independent plan steps executed concurrently")
+	fs.BoolVar(&f.replan, "replan", true, "Let the replanner revise remaining steps after each wave")
+	fs.IntVar(&f.repairAttempts, "repair-attempts", executor.DefaultMaxRepairAttempts, "Times malformed planner/executor JSON is sent back for repair")
+	fs.IntVar(&f.maxReactSteps, "max-react-steps", 200, "Hard cap on ReAct graph steps per invocation")
+	fs.IntVar(&f.stepToolCalls, "step-max-tool-calls", 40, "Default max tool calls per executor step (0 = unlimited)")
+	fs.DurationVar(&f.stepTimeout, "step-timeout", 10*time.Minute, "Default wall-clock deadline per executor step (0 = none)")
+	fs.IntVar(&f.stepTokens, "step-max-tokens", 0, "Default max model tokens per executor step (0 = unlimited)")
+	fs.IntVar(&f.planMaxSteps, "plan-max-steps", planner.DefaultMaxSteps, "Max steps in a generated plan, including the final step")
+	fs.IntVar(&f.planRetries, "plan-retries", planner.DefaultMaxPlanRetries, "Times planning is re-run when the plan fails validation")
+	fs.IntVar(&f.historyTokens, "history-tokens", session.DefaultHistoryMaxTokens, "Approximate token budget for previous-round context given to the planner")
+	fs.IntVar(&f.historyRecent, "history-recent-rounds", session.DefaultHistoryRecentRounds, "Most recent rounds given to the planner verbatim; older rounds are digested")
+}
+
+// plannerConfig returns the planner settings; m, the bare chat model, repairs
+// malformed JSON.
+func (f *agentFlags) plannerConfig(m *openai.ChatModel) *planner.Config {
+	return &planner.Config{
+		MaxRepairAttempts: f.repairAttempts,
+		MaxSteps:          f.planMaxSteps,
+		MaxPlanRetries:    f.planRetries,
+		RepairModel:       m,
+	}
+}
+
+// executorConfig returns the executor settings; m, the bare chat model, repairs
+// malformed JSON.
+func (f *agentFlags) executorConfig(m *openai.ChatModel) *executor.Config {
+	return &executor.Config{
+		MaxParallelSteps:  f.parallel,
+		Replan:            f.replan,
+		MaxRepairAttempts: f.repairAttempts,
+		DefaultBudget: common.Budget{
+			MaxToolCalls: f.stepToolCalls,
+			TimeoutSec:   int(f.stepTimeout.Seconds()),
+			MaxTokens:    f.stepTokens,
+		},
+		RepairModel: m,
+	}
+}
+
+func (f *agentFlags) historyConfig(m *openai.ChatModel) *session.HistoryConfig {
+	return &session.HistoryConfig{
+		MaxTokens:    f.historyTokens,
+		RecentRounds: f.historyRecent,
+		Digest:       session.NewModelDigest(m),
+	}
+}
+
+// startSandbox creates the Docker sandbox the agents run commands in. The
+// returned cleanup removes the container and is safe to call multiple times.
+func startSandbox(ctx context.Context) (commandline.Operator, func(), error) {
+	op, err := virtual_env.GetOperator(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("create sandbox operator: %w", err)
+	}
+	var cleanupOnce sync.Once
+	cleanup := func() {
+		cleanupOnce.Do(func() {
+			if entity, ok := op.(*sandbox.DockerSandbox); ok {
+				fmt.Println("Cleaning up Docker container...")
+				entity.Cleanup(ctx)
+			}
+		})
+	}
+	return op, cleanup, nil
+}
+
+// newAgent creates the chat model (configured by the ARK_* environment
+// variables) and the ReAct agent that drives the sandbox tools.
+func newAgent(ctx context.Context, op commandline.Operator, maxReactSteps int) (*openai.ChatModel, *react.Agent, error) {
+	// --- LLM ---
+	arkModel, err := openai.NewChatModel(ctx, &openai.ChatModelConfig{
+		APIKey:  os.Getenv("ARK_API_KEY"),
+		Model:   os.Getenv("ARK_MODEL_NAME"),
+		BaseURL: os.Getenv("ARK_BASE_URL"),
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("create chat model: %w", err)
+	}
+
+	// --- Tools ---
+	bash := tools.NewBashTool(op)
+	sre, err := commandline.NewStrReplaceEditor(ctx, &commandline.EditorConfig{Operator: op})
+	if err != nil {
+		return nil, nil, fmt.Errorf("create str_replace_editor: %w", err)
+	}
+
+	// --- Summarization middleware ---
+	sumMW, err := conversationsummary.New(ctx, &conversationsummary.Config{
+		Model:                      arkModel,
+		MaxTokensBeforeSummary:     64 * 1024,
+		MaxTokensForRecentMessages: 20 * 1024,
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("create summarization middleware: %w", err)
+	}
+
+	// --- ReAct agent ---
+	rAgent, err := react.NewAgent(ctx, &react.AgentConfig{
+		MessageRewriter:  sumMW.MessageModifier,
+		ToolCallingModel: arkModel,
+		ToolsConfig: compose.ToolsNodeConfig{
+			Tools: []tool.BaseTool{
+				executor.WrapToolBudget(bash),
+				executor.WrapToolBudget(tools.WrapToolSafe(tools.WrapEditorEvents(sre))),
+			},
+		},
+		MaxStep: maxReactSteps,
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("create react agent: %w", err)
+	}
+	return arkModel, rAgent, nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"pcap_agent/internal/session"
+	"pcap_agent/internal/virtual_env"
+
+	"github.com/cloudwego/eino-ext/components/tool/commandline/sandbox"
+)
+
+// containerPcapDir is where captures are copied inside the sandbox.
+const containerPcapDir = "/home/linuxbrew/pcaps/"
+
+// pcapList collects -pcap values; the flag may be repeated and each value may
+// hold several comma-separated paths.
+type pcapList []string
+
+func (l *pcapList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *pcapList) Set(v string) error {
+	for _, p := range strings.Split(v, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			*l = append(*l, p)
+		}
+	}
+	return nil
+}
+
+// stagePcaps registers each local capture, copies it into the sandbox under
+// containerDir (ending in "/") and returns the captures to attach to the session. existing are the captures the
+// session already has. A file whose contents are already staged is skipped, and
+// different files with the same name get distinct container paths.
+func stagePcaps(ctx context.Context, store session.Store, dockerSandbox *sandbox.DockerSandbox, containerDir string, localPaths []string, existing []session.SessionPcap) ([]session.SessionPcap, error) {
+	var staged []session.SessionPcap
+	seenHash := map[string]bool{}
+	usedPath := map[string]bool{}
+	for _, p := range existing {
+		usedPath[p.ContainerPath] = true
+		if p.FileHash != "" {
+			seenHash[p.FileHash] = true
+		}
+	}
+	for _, localPath := range localPaths {
+		pcapFile, duplicate, err := session.RegisterPcapFile(store, localPath)
+		if err != nil {
+			return nil, fmt.Errorf("register pcap %s: %w", localPath, err)
+		}
+		if seenHash[pcapFile.FileHash] {
+			fmt.Printf("Skipping %s: the session already has a capture with the same contents\n", localPath)
+			continue
+		}
+		seenHash[pcapFile.FileHash] = true
+
+		if duplicate {
+			fmt.Printf("Capture %s already registered as #%d (%s, sha256 %s)\n",
+				localPath, pcapFile.ID, pcapFile.FileName, shortHash(pcapFile.FileHash))
+			if sessions, err := store.GetPcapFileSessions(pcapFile.ID); err == nil && len(sessions) > 0 {
+				fmt.Printf("  Previously analysed in: %s (continue one with -session <id>)\n", strings.Join(sessions, ", "))
+			}
+		} else {
+			fmt.Printf("Registered capture #%d %s (%d bytes, sha256 %s)\n",
+				pcapFile.ID, localPath, pcapFile.FileSize, shortHash(pcapFile.FileHash))
+		}
+
+		base := filepath.Base(localPath)
+		containerPath := containerDir + base
+		for n := 2; usedPath[containerPath]; n++ {
+			containerPath = fmt.Sprintf("%s%d_%s", containerDir, n, base)
+		}
+		usedPath[containerPath] = true
+
+		if err := virtual_env.CopyFileToContainer(ctx, dockerSandbox, localPath, containerPath); err != nil {
+			return nil, fmt.Errorf("copy pcap %s to container: %w", localPath, err)
+		}
+		fmt.Printf("Copied %s → container:%s\n", localPath, containerPath)
+
+		staged = append(staged, session.SessionPcap{
+			PcapFileID:    pcapFile.ID,
+			ContainerPath: containerPath,
+			FileName:      base,
+			FileSize:      pcapFile.FileSize,
+			FileHash:      pcapFile.FileHash,
+		})
+	}
+	return staged, nil
+}
+
+// describePcaps summarizes a session's captures for status lines.
+func describePcaps(pcaps []session.SessionPcap) string {
+	switch len(pcaps) {
+	case 0:
+		return "no captures"
+	case 1:
+		return "pcap: " + pcaps[0].ContainerPath
+	}
+	names := make([]string, len(pcaps))
+	for i, p := range pcaps {
+		names[i] = p.FileName
+	}
+	return fmt.Sprintf("%d pcaps: %s", len(pcaps), strings.Join(names, ", "))
+}
@@ -9,73 +9,59 @@ import (
 	"log"
 	"os"
 	"os/signal"
-	"path/filepath"
 	"strings"
-	"sync"
 	"syscall"
 	"time"
 
+	"pcap_agent/internal/common"
 	"pcap_agent/internal/events"
 	"pcap_agent/internal/executor"
 	"pcap_agent/internal/planner"
 	"pcap_agent/internal/session"
-	conversationsummary "pcap_agent/internal/summary"
-	"pcap_agent/internal/tools"
-	"pcap_agent/internal/virtual_env"
 	"pcap_agent/pkg/logger"
 
-	"github.com/cloudwego/eino-ext/components/model/openai"
-	"github.com/cloudwego/eino-ext/components/tool/commandline"
 	"github.com/cloudwego/eino-ext/components/tool/commandline/sandbox"
-
-	"github.com/cloudwego/eino/components/tool"
-	"github.com/cloudwego/eino/compose"
-	"github.com/cloudwego/eino/flow/agent/react"
 )
 
 func main() {
+	// --- Management subcommands (no sandbox or model needed) ---
+	if isSubcommand(os.Args[1:]) {
+		os.Exit(runSubcommand(os.Args[1:]))
+	}
+
+	// --- HTTP API mode ---
+	if len(os.Args) > 1 && os.Args[1] == "serve" {
+		os.Exit(runServe(os.Args[2:]))
+	}
+
 	// --- Flags ---
-	pcapFlag := flag.String("pcap", "", "Local PCAP file path (required for new session)")
+	var pcapFlags pcapList
+	var af agentFlags
+	flag.Var(&pcapFlags, "pcap", "Local PCAP file path; repeat or comma-separate for several captures analysed together (required for new session, adds captures when resuming)")
 	sessionID := flag.String("session", "", "Resume an existing session by ID")
-	dbPath := flag.String("db", "pcap_agent.db", "SQLite database path")
+	resumeRound := flag.Bool("resume-round", false, "Resume the session's failed or interrupted round from its last checkpoint (requires -session)")
+	review := flag.Bool("review", stdinIsTerminal(), "Review, edit, accept or reject each plan before it is executed; on by default when stdin is a terminal")
+	af.register(flag.CommandLine)
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), subcommandUsage)
+		fmt.Fprintln(flag.CommandLine.Output(), "\nInteractive session flags:")
+		flag.PrintDefaults()
+	}
 	flag.Parse()
 
 	ctx := context.Background()
 
-	// --- SQLite store ---
-	store, err := session.OpenStore(*dbPath)
+	// --- Session store ---
+	store, err := session.Open(af.dbPath)
 	if err != nil {
 		log.Fatalf("open store: %v", err)
 	}
 	defer store.Close()
 
-	// --- Event system ---
-	emitter := events.NewChannelEmitter(512)
-	defer emitter.Close()
-
-	// Event printer goroutine (CLI consumer)
-	go func() {
-		ch := emitter.Subscribe()
-		for ev := range ch {
-			printEvent(ev)
-		}
-	}()
-
 	// --- Docker sandbox ---
-	op, err := virtual_env.GetOperator(ctx)
+	op, cleanup, err := startSandbox(ctx)
 	if err != nil {
-		log.Fatalf("create sandbox operator: %v", err)
-	}
-
-	// Cleanup — safe to call multiple times via sync.Once.
-	var cleanupOnce sync.Once
-	cleanup := func() {
-		cleanupOnce.Do(func() {
-			if entity, ok := op.(*sandbox.DockerSandbox); ok {
-				fmt.Println("Cleaning up Docker container...")
-				entity.Cleanup(ctx)
-			}
-		})
+		log.Fatalf("%v", err)
 	}
 	defer cleanup()
 
@@ -97,89 +83,98 @@ func main() {
 		os.Exit(1)
 	}
 
-	// --- Copy PCAP into container (if starting new session) ---
-	var containerPcapPath string
-	if *pcapFlag != "" {
+	// --- Register and copy PCAPs into container ---
+	var newPcaps []session.SessionPcap
+	if len(pcapFlags) > 0 {
 		dockerSandbox, ok := op.(*sandbox.DockerSandbox)
 		if !ok {
 			fatal("operator is not DockerSandbox, cannot copy PCAP")
 		}
-		containerPcapPath = "/home/linuxbrew/pcaps/" + filepath.Base(*pcapFlag)
-		if err := virtual_env.CopyFileToContainer(ctx, dockerSandbox, *pcapFlag, containerPcapPath); err != nil {
-			fatal("copy pcap to container: %v", err)
+		var existing []session.SessionPcap
+		if *sessionID != "" {
+			if existing, err = store.GetSessionPcaps(*sessionID); err != nil {
+				fatal("load session captures: %v", err)
+			}
+		}
+		newPcaps, err = stagePcaps(ctx, store, dockerSandbox, containerPcapDir, pcapFlags, existing)
+		if err != nil {
+			fatal("%v", err)
 		}
-		fmt.Printf("Copied %s → container:%s\n", *pcapFlag, containerPcapPath)
-	}
-
-	// --- LLM ---
-	arkApiKey := os.Getenv("ARK_API_KEY")
-	arkModelName := os.Getenv("ARK_MODEL_NAME")
-	arkBaseUrl := os.Getenv("ARK_BASE_URL")
-
-	arkModel, err := openai.NewChatModel(ctx, &openai.ChatModelConfig{
-		APIKey:  arkApiKey,
-		Model:   arkModelName,
-		BaseURL: arkBaseUrl,
-	})
-	if err != nil {
-		fatal("create chat model: %v", err)
-	}
-
-	// --- Tools ---
-	bash := tools.NewBashTool(op)
-	sre, err := commandline.NewStrReplaceEditor(ctx, &commandline.EditorConfig{Operator: op})
-	if err != nil {
-		fatal("create str_replace_editor: %v", err)
-	}
-
-	// --- Summarization middleware ---
-	sumMW, err := conversationsummary.New(ctx, &conversationsummary.Config{
-		Model:                      arkModel,
-		MaxTokensBeforeSummary:     64 * 1024,
-		MaxTokensForRecentMessages: 20 * 1024,
-	})
-	if err != nil {
-		fatal("create summarization middleware: %v", err)
-	}
-
-	// --- ReAct agent ---
-	rAgent, err := react.NewAgent(ctx, &react.AgentConfig{
-		MessageRewriter:  sumMW.MessageModifier,
-		ToolCallingModel: arkModel,
-		ToolsConfig: compose.ToolsNodeConfig{
-			Tools: []tool.BaseTool{bash, tools.WrapToolSafe(sre)},
-		},
-		MaxStep: 200,
-	})
-	if err != nil {
-		fatal("create react agent: %v", err)
 	}
 
-	// --- Planner & Executor ---
-	p, err := planner.NewPlanner(ctx, rAgent, emitter)
+	// --- LLM, tools and ReAct agent ---
+	arkModel, rAgent, err := newAgent(ctx, op, af.maxReactSteps)
 	if err != nil {
-		fatal("create planner: %v", err)
+		fatal("%v", err)
 	}
-	exec := executor.NewExecutor(rAgent, emitter)
 
 	// --- Session ---
 	var sess *session.Session
 	if *sessionID != "" {
-		sess, err = session.ResumeSession(store, emitter, *sessionID)
+		sess, err = session.ResumeSession(store, *sessionID)
 		if err != nil {
 			fatal("resume session %s: %v", *sessionID, err)
 		}
-		containerPcapPath = sess.PcapPath // use the stored container path
-		fmt.Printf("Resumed session %s (pcap: %s, round: %d)\n", sess.ID, sess.PcapPath, sess.RoundNum)
+		for _, p := range newPcaps {
+			if err := sess.AddPcap(p); err != nil {
+				fatal("add capture to session: %v", err)
+			}
+		}
+		fmt.Printf("Resumed session %s (%s, round: %d)\n", sess.ID, describePcaps(sess.Pcaps), sess.RoundNum)
 	} else {
-		if *pcapFlag == "" {
+		if len(newPcaps) == 0 {
 			fatal("--pcap is required for new sessions")
 		}
-		sess, err = session.NewSession(store, emitter, containerPcapPath)
+		sess, err = session.NewSession(store, newPcaps)
 		if err != nil {
 			fatal("create session: %v", err)
 		}
-		fmt.Printf("New session %s (pcap: %s)\n", sess.ID, sess.PcapPath)
+		fmt.Printf("New session %s (%s)\n", sess.ID, describePcaps(sess.Pcaps))
+	}
+	captures := sess.Captures()
+
+	// --- Event system (persisted to the session's event log) ---
+	emitter := session.NewEventLog(store, sess.ID)
+	defer emitter.Close()
+
+	// Event printer goroutine (CLI consumer). It keeps up easily, so it may
+	// briefly hold up the agents rather than lose findings.
+	printer := newEventPrinter()
+	printerCh := emitter.SubscribeWith(&events.SubscribeOptions{Name: "cli", Policy: events.Block})
+	go func() {
+		for ev := range printerCh {
+			printer.printEvent(ev)
+		}
+	}()
+
+	// --- Planner & Executor ---
+	plannerCfg := af.plannerConfig(arkModel)
+	p, err := planner.NewPlanner(ctx, rAgent, emitter, plannerCfg)
+	if err != nil {
+		fatal("create planner: %v", err)
+	}
+	exec := executor.NewExecutor(rAgent, emitter, af.executorConfig(arkModel))
+
+	sess.SetHistoryConfig(af.historyConfig(arkModel))
+
+	// --- Resume an unfinished round ---
+	if *resumeRound {
+		if *sessionID == "" {
+			fatal("--resume-round requires --session")
+		}
+		cp, err := sess.PendingRound()
+		if err != nil {
+			fatal("load checkpoint: %v", err)
+		}
+		if cp == nil {
+			fatal("session %s has no unfinished round to resume", sess.ID)
+		}
+		fmt.Printf("\n--- Resuming round %d (%s, %d/%d steps completed) ---\n",
+			cp.RoundNum, cp.Status, len(cp.State.Completed), len(cp.State.Plan.Steps))
+		fmt.Printf("Query: %s\n", cp.UserQuery)
+		rctx := logger.WithRun(ctx, sess.ID, cp.RoundNum, logger.NewRunID())
+		result, err := exec.Resume(rctx, &cp.State, cp.UserQuery, captures, sess.Checkpoint(cp.UserQuery))
+		finishRound(rctx, sess, printer, cp.UserQuery, result, err)
 	}
 
 	// --- REPL ---
@@ -190,6 +185,8 @@ func main() {
 	fmt.Println("Type your query and press Enter. Type 'quit' or 'exit' to stop.\n")
 
 	for {
+		// Write the previous round's events to the log before waiting for input
+		emitter.Flush()
 		fmt.Printf("[round %d] > ", sess.RoundNum+1)
 		if !scanner.Scan() {
 			break
@@ -203,74 +200,191 @@ func main() {
 			break
 		}
 
+		// Every log line and event of this round carries its session, round and run ID
+		rctx := logger.WithRun(ctx, sess.ID, sess.RoundNum+1, logger.NewRunID())
+
 		// Load session history for multi-round context
-		history, err := sess.History()
+		history, err := sess.History(rctx)
 		if err != nil {
-			logger.Errorf("load session history: %v", err)
+			logger.CtxErrorf(rctx, "load session history: %v", err)
 		}
 
 		// --- Plan ---
 		fmt.Println("\n--- Planning ---")
-		plan, err := p.Run(ctx, planner.PlannerInput{
+		plan, err := p.Run(rctx, planner.PlannerInput{
 			UserQuery: query,
-			PcapPath:  containerPcapPath,
+			Captures:  captures,
 			History:   history,
 		})
 		if err != nil {
-			logger.Errorf("planner failed: %v", err)
+			logger.CtxErrorf(rctx, "planner failed: %v", err)
 			fmt.Printf("Planner error: %v\n\n", err)
 			continue
 		}
 
-		fmt.Printf("\nPlan: %d steps\n", len(plan.Steps))
-		for _, s := range plan.Steps {
-			fmt.Printf("  Step %d: %s\n", s.StepID, s.Intent)
+		// --- Review (approval gate) ---
+		if *review {
+			edited, edits, accepted := reviewPlan(scanner, plan, plannerCfg)
+			if !accepted {
+				fmt.Print("Plan rejected; nothing was executed.\n\n")
+				continue
+			}
+			if len(edits) > 0 {
+				plan = edited
+				emitPlanModified(rctx, emitter, plan, edits)
+			}
+		} else {
+			printPlan(plan)
 		}
 
-		// --- Execute ---
+		// --- Execute (checkpointed after every step) ---
 		fmt.Println("\n--- Executing ---")
-		result, err := exec.Run(ctx, plan, query, containerPcapPath)
-		if err != nil {
-			logger.Errorf("executor failed: %v", err)
-			fmt.Printf("Executor error: %v\n\n", err)
-			continue
-		}
+		result, err := exec.Run(rctx, plan, query, captures, sess.Checkpoint(query))
+		finishRound(rctx, sess, printer, query, result, err)
+	}
+
+	// Allow events to flush
+	time.Sleep(500 * time.Millisecond)
+}
 
-		// --- Save round ---
-		if err := sess.SaveRound(query, plan, result.Report, result.Findings, result.OperationLog); err != nil {
-			logger.Errorf("save round: %v", err)
+// finishRound persists a successful round and waits for its streamed report to be
+// printed, or records the failure so the round can be resumed later with --resume-round.
+func finishRound(ctx context.Context, sess *session.Session, printer *eventPrinter, query string, result *executor.Result, err error) {
+	if err != nil {
+		logger.CtxErrorf(ctx, "executor failed: %v", err)
+		fmt.Printf("Executor error: %v\n", err)
+		if ferr := sess.FailRound(err); ferr != nil {
+			logger.CtxErrorf(ctx, "record failed round: %v", ferr)
 		}
+		fmt.Printf("Resume later with: -session %s -resume-round\n\n", sess.ID)
+		return
+	}
 
-		// --- Print report ---
-		fmt.Println("\n===== REPORT =====")
-		fmt.Println(result.Report)
-		fmt.Println("==================\n")
+	// --- Save round (with the plan as revised during execution) ---
+	if err := sess.SaveRound(query, result.Plan, result.Report, result.Findings, result.OperationLog, result.Steps, result.IOCs); err != nil {
+		logger.CtxErrorf(ctx, "save round: %v", err)
 	}
 
-	// Allow events to flush
-	time.Sleep(500 * time.Millisecond)
+	// --- Report is printed by the event printer as it streams ---
+	printer.waitReport(5 * time.Second)
+}
+
+// eventPrinter prints events to the terminal. It is used from a single goroutine,
+// except for waitReport.
+type eventPrinter struct {
+	streaming  bool // a report header has been printed and deltas are arriving
+	lastSeq    int  // seq of the last rendered report delta
+	rendered   int  // bytes of the report rendered from deltas
+	gap        bool // a delta was dropped, so the rendered report is incomplete
+	reportDone chan struct{}
+}
+
+func newEventPrinter() *eventPrinter {
+	return &eventPrinter{reportDone: make(chan struct{}, 1)}
+}
+
+// waitReport blocks until the final report has been printed, or timeout elapses.
+func (p *eventPrinter) waitReport(timeout time.Duration) {
+	select {
+	case <-p.reportDone:
+	case <-time.After(timeout):
+	}
 }
 
 // printEvent formats and prints an event to the terminal.
-func printEvent(ev events.Event) {
+func (p *eventPrinter) printEvent(ev events.Event) {
 	switch ev.Type {
 	case events.TypePlanCreated:
 		fmt.Printf("[EVENT] Plan created\n")
+	case events.TypePlanModified:
+		var d events.PlanModifiedData
+		_ = json.Unmarshal(ev.Data, &d)
+		fmt.Printf("[EVENT] Plan modified by analyst (%d edits)\n", len(d.Edits))
+	case events.TypePlanRevised:
+		var d events.PlanRevisedData
+		_ = json.Unmarshal(ev.Data, &d)
+		fmt.Printf("[EVENT] Plan revised (#%d): %s\n", d.Revision, d.Thought)
+		for _, s := range d.Steps {
+			fmt.Printf("  Step %d: %s\n", s.StepID, s.Intent)
+		}
 	case events.TypeStepStarted:
 		var d events.StepStartedData
 		_ = json.Unmarshal(ev.Data, &d)
 		fmt.Printf("[EVENT] Step %d/%d started: %s\n", d.StepID, d.TotalSteps, d.Intent)
 	case events.TypeStepFindings:
-		fmt.Printf("[EVENT] Step findings captured\n")
+		var d events.StepFindingsData
+		_ = json.Unmarshal(ev.Data, &d)
+		if len(d.IOCs) > 0 {
+			fmt.Printf("[EVENT] Step %d findings captured (%d indicators)\n", d.StepID, len(d.IOCs))
+		} else {
+			fmt.Printf("[EVENT] Step %d findings captured\n", d.StepID)
+		}
+	case events.TypeStepRetry:
+		var d events.RetryData
+		_ = json.Unmarshal(ev.Data, &d)
+		fmt.Printf("[EVENT] %s output invalid, retry %d/%d: %s\n", d.Phase, d.Attempt, d.MaxAttempts, d.Error)
+	case events.TypeStepBudget:
+		var d events.BudgetExhaustedData
+		_ = json.Unmarshal(ev.Data, &d)
+		fmt.Printf("[EVENT] Step %d stopped: %s\n", d.StepID, d.Reason)
+	case events.TypeToolFinished:
+		// tool.started is not printed; the finished line says everything.
+		var d events.ToolFinishedData
+		_ = json.Unmarshal(ev.Data, &d)
+		status := fmt.Sprintf("exit %d", d.ExitCode)
+		if d.Error != "" {
+			status = "not run: " + d.Error
+		}
+		caller := "Planner"
+		if ev.StepID > 0 {
+			caller = fmt.Sprintf("Step %d", ev.StepID)
+		}
+		fmt.Printf("[EVENT] %s ran %s (%s, %dms, %d+%d bytes): %s\n", caller, d.Tool, status,
+			d.DurationMs, d.StdoutBytes, d.StderrBytes, common.TruncateStr(strings.ReplaceAll(d.Command, "\n", " "), 100))
 	case events.TypeStepError:
 		var d events.ErrorData
 		_ = json.Unmarshal(ev.Data, &d)
 		fmt.Printf("[EVENT] Step error: %s\n", d.Message)
+	case events.TypeReportDelta:
+		var d events.ReportDeltaData
+		_ = json.Unmarshal(ev.Data, &d)
+		if !p.streaming {
+			fmt.Println("\n===== REPORT =====")
+			p.streaming = true
+		}
+		if d.Seq != p.lastSeq+1 {
+			p.gap = true
+		}
+		p.lastSeq = d.Seq
+		p.rendered += len(d.Delta)
+		fmt.Print(d.Delta)
 	case events.TypeReportGenerated:
-		fmt.Printf("[EVENT] Report generated\n")
+		var d events.ReportData
+		_ = json.Unmarshal(ev.Data, &d)
+		switch {
+		case !p.streaming:
+			fmt.Println("\n===== REPORT =====")
+			fmt.Println(d.Report)
+		case p.gap || p.rendered != len(d.Report):
+			// Deltas were dropped or the report was replaced (e.g. budget fallback).
+			fmt.Print("\n----- full report -----\n")
+			fmt.Println(d.Report)
+		default:
+			fmt.Println()
+		}
+		fmt.Print("==================\n\n")
+		p.streaming, p.lastSeq, p.rendered, p.gap = false, 0, 0, false
+		select {
+		case p.reportDone <- struct{}{}:
+		default:
+		}
 	case events.TypeError:
 		var d events.ErrorData
 		_ = json.Unmarshal(ev.Data, &d)
 		fmt.Printf("[EVENT] Error (%s): %s\n", d.Phase, d.Message)
+	case events.TypeEventsDropped:
+		var d events.DroppedData
+		_ = json.Unmarshal(ev.Data, &d)
+		fmt.Printf("[EVENT] %d events were not displayed (output lagging)\n", d.Dropped)
 	}
 }
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"pcap_agent/internal/common"
+	"pcap_agent/internal/events"
+	"pcap_agent/internal/planner"
+)
+
+const reviewHelp = `Plan review commands:
+  y | accept                 run the plan (Enter also accepts)
+  n | reject                 discard the plan and return to the prompt
+  del N                      delete step N
+  mv N POS                   move step N to position POS
+  edit N <intent>            rewrite the intent of step N
+  deps N 1,2 | deps N -      set the steps N depends on (- for none)
+  add <intent>               add a step just before the final step
+  ins POS <intent>           insert a step at position POS
+  show                       print the plan again
+  help                       print this help`
+
+// stdinIsTerminal reports whether stdin is an interactive terminal. Plan review
+// is only on by default then: with piped input it would read EOF and reject
+// every plan.
+func stdinIsTerminal() bool {
+	fi, err := os.Stdin.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// reviewPlan lets the analyst accept, reject or edit the plan before execution.
+// It returns the (possibly edited) plan, a description of every edit made, and
+// whether the plan was accepted. The input plan is not modified.
+func reviewPlan(scanner *bufio.Scanner, plan common.Plan, cfg *planner.Config) (common.Plan, []string, bool) {
+	plan.Steps = clonePlanSteps(plan.Steps)
+	var edits []string
+
+	printPlan(plan)
+	fmt.Println("Review the plan: [y]es to run, [n]o to reject, 'help' for editing commands.")
+	for {
+		fmt.Print("review> ")
+		if !scanner.Scan() {
+			return plan, edits, false
+		}
+		line := strings.TrimSpace(scanner.Text())
+		cmd, rest, _ := strings.Cut(line, " ")
+		rest = strings.TrimSpace(rest)
+
+		var err error
+		var edit string
+		switch strings.ToLower(cmd) {
+		case "", "y", "yes", "accept":
+			if err := planner.ValidatePlan(plan, cfg); err != nil {
+				var verrs planner.ValidationErrors
+				if errors.As(err, &verrs) {
+					fmt.Printf("Cannot run this plan yet:\n%s", verrs.Feedback())
+				} else {
+					fmt.Printf("Cannot run this plan yet: %v\n", err)
+				}
+				continue
+			}
+			return plan, edits, true
+		case "n", "no", "reject":
+			return plan, edits, false
+		case "show":
+			printPlan(plan)
+			continue
+		case "help", "?":
+			fmt.Println(reviewHelp)
+			continue
+		case "del":
+			var id int
+			if id, err = strconv.Atoi(rest); err == nil {
+				intent := stepIntent(plan, id)
+				if err = planner.DeleteStep(&plan, id); err == nil {
+					edit = fmt.Sprintf("deleted step %d (%s)", id, intent)
+				}
+			}
+		case "mv":
+			var nums []int
+			if nums, err = parseInts(rest, 2); err == nil {
+				if err = planner.MoveStep(&plan, nums[0], nums[1]); err == nil {
+					edit = fmt.Sprintf("moved step %d to position %d", nums[0], nums[1])
+				}
+			}
+		case "edit":
+			idStr, intent, _ := strings.Cut(rest, " ")
+			var id int
+			if id, err = strconv.Atoi(idStr); err == nil {
+				if err = planner.SetIntent(&plan, id, intent); err == nil {
+					edit = fmt.Sprintf("rewrote step %d: %s", id, strings.TrimSpace(intent))
+				}
+			}
+		case "deps":
+			idStr, depsStr, _ := strings.Cut(rest, " ")
+			var id int
+			var deps []int
+			if id, err = strconv.Atoi(idStr); err == nil {
+				if depsStr = strings.TrimSpace(depsStr); depsStr != "-" {
+					deps, err = parseInts(strings.ReplaceAll(depsStr, ",", " "), -1)
+				}
+				if err == nil {
+					if err = planner.SetDependsOn(&plan, id, deps); err == nil {
+						edit = fmt.Sprintf("set step %d dependencies to %v", id, deps)
+					}
+				}
+			}
+		case "add":
+			if err = planner.AddStep(&plan, rest, 0, []int{}); err == nil {
+				edit = "added step: " + rest
+			}
+		case "ins":
+			posStr, intent, _ := strings.Cut(rest, " ")
+			var pos int
+			if pos, err = strconv.Atoi(posStr); err == nil {
+				if err = planner.AddStep(&plan, intent, pos, []int{}); err == nil {
+					edit = fmt.Sprintf("inserted step at position %d: %s", pos, strings.TrimSpace(intent))
+				}
+			}
+		default:
+			err = fmt.Errorf("unknown command %q (type 'help')", cmd)
+		}
+
+		if err != nil {
+			fmt.Printf("  error: %v\n", err)
+			continue
+		}
+		edits = append(edits, edit)
+		printPlan(plan)
+	}
+}
+
+// emitPlanModified records the analyst's edits as a plan.modified event.
+func emitPlanModified(ctx context.Context, emitter events.Emitter, plan common.Plan, edits []string) {
+	steps := make([]events.StepInfo, len(plan.Steps))
+	for i, s := range plan.Steps {
+		steps[i] = events.StepInfo{StepID: s.StepID, Intent: s.Intent, DependsOn: s.DependsOn}
+	}
+	emitter.Emit(events.NewCtxEvent(ctx, events.TypePlanModified, events.PlanModifiedData{
+		Edits:      edits,
+		TotalSteps: len(plan.Steps),
+		Steps:      steps,
+	}))
+}
+
+// printPlan prints the plan's steps and their dependencies.
+func printPlan(plan common.Plan) {
+	fmt.Printf("\nPlan: %d steps\n", len(plan.Steps))
+	for i, s := range plan.Steps {
+		deps := ""
+		switch {
+		case i == len(plan.Steps)-1:
+			deps = " (final)"
+		case len(s.DependsOn) > 0:
+			deps = fmt.Sprintf(" (after %s)", joinInts(s.DependsOn))
+		}
+		fmt.Printf("  Step %d%s: %s\n", s.StepID, deps, s.Intent)
+	}
+}
+
+func stepIntent(plan common.Plan, id int) string {
+	for _, s := range plan.Steps {
+		if s.StepID == id {
+			return common.TruncateStr(s.Intent, 60)
+		}
+	}
+	return ""
+}
+
+// parseInts parses whitespace-separated integers, requiring exactly n of them (n < 0: any number).
+func parseInts(s string, n int) ([]int, error) {
+	fields := strings.Fields(s)
+	if n >= 0 && len(fields) != n {
+		return nil, fmt.Errorf("expected %d numbers, got %d", n, len(fields))
+	}
+	out := make([]int, len(fields))
+	for i, f := range fields {
+		v, err := strconv.Atoi(f)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", f)
+		}
+		out[i] = v
+	}
+	return out, nil
+}
+
+func joinInts(ids []int) string {
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = strconv.Itoa(id)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// clonePlanSteps deep-copies steps so edits do not alias the planner's plan.
+func clonePlanSteps(steps []common.Step) []common.Step {
+	out := make([]common.Step, len(steps))
+	for i, s := range steps {
+		out[i] = s
+		if s.DependsOn != nil {
+			out[i].DependsOn = append([]int{}, s.DependsOn...)
+		}
+	}
+	return out
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"pcap_agent/internal/events"
+	"pcap_agent/internal/executor"
+	"pcap_agent/internal/planner"
+	"pcap_agent/internal/server"
+	"pcap_agent/internal/session"
+	"pcap_agent/pkg/logger"
+
+	"github.com/cloudwego/eino-ext/components/tool/commandline/sandbox"
+)
+
+const serveUsage = `Usage: pcap_agent serve [flags]
+
+Serves the HTTP API so a web front-end can drive the agent:
+
+  GET  /api/sessions                       list sessions
+  POST /api/sessions                       create a session from captures uploaded as multipart field "pcap"
+  GET  /api/sessions/{id}                  show a session, its captures and whether a round is running
+  POST /api/sessions/{id}/pcaps            add uploaded captures to a session
+  POST /api/sessions/{id}/queries          start a round: {"query": "..."}; returns 202 Accepted
+  GET  /api/sessions/{id}/rounds           list a session's rounds
+  GET  /api/sessions/{id}/rounds/{n}       show a round with its steps and indicators
+  GET  /api/sessions/{id}/rounds/{n}/report  a round's Markdown report
+  GET  /api/sessions/{id}/events           Server-Sent Events: replays the session's event log after
+                                           Last-Event-ID or ?after=N (default: all), then tails live events
+
+  GET  /debug/vars                         expvar metrics, including event delivery and drop counters
+
+Rounds run one at a time and plans are executed without review.
+
+With a token (-token or $PCAP_AGENT_TOKEN) every request must carry
+"Authorization: Bearer <token>". Listening on a non-loopback address requires
+a token unless -insecure is given.
+
+Flags:`
+
+// tokenEnv names the environment variable holding the default API token.
+const tokenEnv = "PCAP_AGENT_TOKEN"
+
+// isLoopback reports whether addr only listens on the loopback interface. An
+// empty host listens on every interface.
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// runServe runs serve mode and returns the process exit code.
+func runServe(args []string) int {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	var af agentFlags
+	af.register(fs)
+	addr := fs.String("addr", "127.0.0.1:8080", "Address to listen on")
+	token := fs.String("token", "", "Bearer token required on every request (default $"+tokenEnv+")")
+	insecure := fs.Bool("insecure", false, "Allow listening on a non-loopback address without a token")
+	uploadDir := fs.String("upload-dir", server.DefaultUploadDir, "Directory where uploaded captures are kept")
+	maxUploadMB := fs.Int64("max-upload-mb", server.DefaultMaxUploadBytes>>20, "Maximum size of one upload request, in MiB")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), serveUsage)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintf(os.Stderr, "unexpected arguments: %v\n", fs.Args())
+		return 2
+	}
+	if *token == "" {
+		*token = os.Getenv(tokenEnv)
+	}
+	if *token == "" && !*insecure && !isLoopback(*addr) {
+		fmt.Fprintf(os.Stderr, "refusing to serve %s without a token: set -token or $%s, or pass -insecure\n", *addr, tokenEnv)
+		return 2
+	}
+
+	ctx := context.Background()
+
+	store, err := session.Open(af.dbPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "open store: %v\n", err)
+		return 1
+	}
+	defer store.Close()
+
+	op, cleanup, err := startSandbox(ctx)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer cleanup()
+	dockerSandbox, ok := op.(*sandbox.DockerSandbox)
+	if !ok {
+		fmt.Fprintln(os.Stderr, "operator is not DockerSandbox, cannot copy PCAP")
+		return 1
+	}
+
+	arkModel, rAgent, err := newAgent(ctx, op, af.maxReactSteps)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	plannerCfg := af.plannerConfig(arkModel)
+	executorCfg := af.executorConfig(arkModel)
+	api, err := server.New(&server.Config{
+		Store: store,
+		// Each upload lands in its own directory; mirroring it in the container
+		// keeps captures of different sessions with the same name apart.
+		Stage: func(ctx context.Context, localPaths []string, existing []session.SessionPcap) ([]session.SessionPcap, error) {
+			containerDir := containerPcapDir + filepath.Base(filepath.Dir(localPaths[0])) + "/"
+			return stagePcaps(ctx, store, dockerSandbox, containerDir, localPaths, existing)
+		},
+		Round: func(ctx context.Context, emitter events.Emitter, in server.RoundInput) (*executor.Result, error) {
+			p, err := planner.NewPlanner(ctx, rAgent, emitter, plannerCfg)
+			if err != nil {
+				return nil, fmt.Errorf("create planner: %w", err)
+			}
+			plan, err := p.Run(ctx, planner.PlannerInput{
+				UserQuery: in.Query,
+				Captures:  in.Captures,
+				History:   in.History,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("planner: %w", err)
+			}
+			return executor.NewExecutor(rAgent, emitter, executorCfg).Run(ctx, plan, in.Query, in.Captures, in.Checkpoint)
+		},
+		History:        af.historyConfig(arkModel),
+		UploadDir:      *uploadDir,
+		MaxUploadBytes: *maxUploadMB << 20,
+		Token:          *token,
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	httpServer := &http.Server{
+		Addr:              *addr,
+		Handler:           api,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	// On SIGINT / SIGTERM, stop the rounds and event streams first so Shutdown
+	// does not wait for them, then drain the remaining requests.
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	shutdownDone := make(chan struct{})
+	go func() {
+		defer close(shutdownDone)
+		sig := <-sigCh
+		fmt.Printf("\nReceived %v, shutting down...\n", sig)
+		api.Close()
+		shutdownCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		defer cancel()
+		if err := httpServer.Shutdown(shutdownCtx); err != nil {
+			logger.Errorf("[Server] shutdown: %v", err)
+		}
+	}()
+
+	fmt.Printf("Serving the HTTP API on %s (store %s)\n", *addr, af.dbPath)
+	if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		fmt.Fprintf(os.Stderr, "serve: %v\n", err)
+		api.Close()
+		return 1
+	}
+	<-shutdownDone
+	return 0
+}
@@ -0,0 +1,528 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"pcap_agent/internal/bundle"
+	"pcap_agent/internal/common"
+	"pcap_agent/internal/session"
+)
+
+const subcommandUsage = `Usage:
+  pcap_agent [flags]                         start or resume an interactive session
+  pcap_agent serve [-addr :8080] [flags]     serve the HTTP API and per-session event streams (see serve -h)
+  pcap_agent sessions list                   list sessions
+  pcap_agent sessions show <id>              show a session and its rounds
+  pcap_agent sessions export <id> [-o path]  print a session as JSON, or write a JSON/Markdown/HTML bundle to a directory or .zip
+  pcap_agent sessions import <path> [-as id] recreate a session from a bundle directory, .zip or .json
+  pcap_agent sessions delete <id> [-yes]     delete a session and everything recorded under it
+  pcap_agent sessions fork <id> <round>      branch a session after round N into a new session
+  pcap_agent rounds show <session> <n>       show a round's plan, steps, indicators and report
+  pcap_agent pcaps list                      list registered captures and the sessions that analysed them
+  pcap_agent search <terms...>               search queries, findings and reports of every session
+
+Subcommand flags:
+  -db path    SQLite database path or postgres:// URL (default "pcap_agent.db")
+  -json       print JSON instead of tables (list, show, search)
+  -yes        do not ask for confirmation (delete)
+  -limit n    maximum number of hits (search, default 20)
+  -o path     bundle destination (export)
+  -as id      session ID to import as (import, default: the bundled ID)`
+
+// subcommandFlags holds the flags shared by all subcommands.
+type subcommandFlags struct {
+	json  bool
+	yes   bool
+	limit int
+	out   string
+	as    string
+}
+
+// subcommands maps "group action" (or a single-word command) to its handler.
+var subcommands = map[string]func(store session.Store, args []string, f subcommandFlags) error{
+	"sessions list":   cmdSessionsList,
+	"sessions show":   cmdSessionsShow,
+	"sessions export": cmdSessionsExport,
+	"sessions import": cmdSessionsImport,
+	"sessions delete": cmdSessionsDelete,
+	"sessions fork":   cmdSessionsFork,
+	"rounds show":     cmdRoundsShow,
+	"pcaps list":      cmdPcapsList,
+	"search":          cmdSearch,
+}
+
+// isSubcommand reports whether args (without the program name) start with a subcommand group.
+func isSubcommand(args []string) bool {
+	return len(args) > 0 && (args[0] == "sessions" || args[0] == "rounds" || args[0] == "pcaps" || args[0] == "search" || args[0] == "help")
+}
+
+// runSubcommand runs a management subcommand and returns the process exit code.
+func runSubcommand(args []string) int {
+	if args[0] == "help" || len(args) < 2 {
+		fmt.Fprintln(os.Stderr, subcommandUsage)
+		return 2
+	}
+	name, rest := args[0], args[1:]
+	handler, ok := subcommands[name]
+	if !ok {
+		name, rest = args[0]+" "+args[1], args[2:]
+		handler, ok = subcommands[name]
+	}
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s\n", name, subcommandUsage)
+		return 2
+	}
+
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	dbPath := fs.String("db", "pcap_agent.db", "Session store: SQLite database path or postgres:// URL")
+	asJSON := fs.Bool("json", false, "Print JSON")
+	yes := fs.Bool("yes", false, "Do not ask for confirmation")
+	limit := fs.Int("limit", session.DefaultSearchLimit, "Maximum number of hits")
+	out := fs.String("o", "", "Bundle destination directory or .zip")
+	as := fs.String("as", "", "Session ID to import as")
+	positional, err := parseInterspersed(fs, rest)
+	if err != nil {
+		return 2
+	}
+
+	open := session.OpenExisting
+	if name == "sessions import" {
+		open = session.Open
+	}
+	store, err := open(*dbPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "open store: %v\n", err)
+		return 1
+	}
+	defer store.Close()
+
+	if err := handler(store, positional, subcommandFlags{json: *asJSON, yes: *yes, limit: *limit, out: *out, as: *as}); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+		return 1
+	}
+	return 0
+}
+
+// parseInterspersed parses flags that may appear before, between or after positional arguments.
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+func cmdSessionsList(store session.Store, args []string, f subcommandFlags) error {
+	sessions, err := store.ListSessions()
+	if err != nil {
+		return err
+	}
+	if f.json {
+		return printJSON(sessions)
+	}
+	if len(sessions) == 0 {
+		fmt.Println("No sessions.")
+		return nil
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tROUNDS\tUPDATED\tPCAP\tLAST QUERY")
+	for _, s := range sessions {
+		pcap := s.PcapPath
+		if s.PcapCount > 1 {
+			pcap += fmt.Sprintf(" (+%d)", s.PcapCount-1)
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\n", s.ID, s.RoundCount, s.UpdatedAt, pcap, oneLine(s.LastQuery, 60))
+	}
+	return tw.Flush()
+}
+
+func cmdSessionsShow(store session.Store, args []string, f subcommandFlags) error {
+	info, rounds, err := loadSession(store, args)
+	if err != nil {
+		return err
+	}
+	pcaps, err := store.GetSessionPcaps(info.ID)
+	if err != nil {
+		return err
+	}
+	lineage, err := session.GetSessionLineage(store, info.ID)
+	if err != nil {
+		return err
+	}
+	forks, err := store.GetSessionForks(info.ID)
+	if err != nil {
+		return err
+	}
+	pending, err := store.GetLatestCheckpoint(info.ID)
+	if err != nil {
+		return err
+	}
+	if pending != nil && pending.RoundNum != info.RoundCount+1 {
+		pending = nil
+	}
+
+	if f.json {
+		summaries := make([]map[string]any, len(rounds))
+		for i, r := range rounds {
+			summaries[i] = map[string]any{
+				"round_num":  r.RoundNum,
+				"user_query": r.UserQuery,
+				"steps":      len(r.Plan.Steps),
+				"created_at": r.CreatedAt,
+			}
+		}
+		forkIDs := make([]string, len(forks))
+		for i, f := range forks {
+			forkIDs[i] = f.ID
+		}
+		out := map[string]any{"session": info, "pcaps": pcaps, "rounds": summaries, "forks": forkIDs}
+		if pending != nil {
+			out["pending_round"] = map[string]any{
+				"round_num":  pending.RoundNum,
+				"user_query": pending.UserQuery,
+				"status":     pending.Status,
+				"error":      pending.Error,
+			}
+		}
+		return printJSON(out)
+	}
+
+	fmt.Printf("Session:  %s\nCreated:  %s\nUpdated:  %s\nRounds:   %d\n",
+		info.ID, info.CreatedAt, info.UpdatedAt, info.RoundCount)
+	ptw := tabwriter.NewWriter(os.Stdout, 10, 0, 2, ' ', 0)
+	for i, p := range pcaps {
+		label := ""
+		if i == 0 {
+			label = "PCAPs:"
+		}
+		fmt.Fprintf(ptw, "%s\t%s\t%s\t%s\n", label, p.ContainerPath, formatBytes(p.FileSize), shortHash(p.FileHash))
+	}
+	ptw.Flush()
+	if info.ParentSessionID != "" {
+		fmt.Printf("Forked:   from %s after round %d\n", info.ParentSessionID, info.ParentRound)
+		if len(lineage) > 1 {
+			fmt.Printf("Lineage:  %s\n", formatLineage(lineage))
+		}
+	}
+	for i, f := range forks {
+		label := ""
+		if i == 0 {
+			label = "Forks:"
+		}
+		fmt.Printf("%-10s%s (after round %d, %d rounds)\n", label, f.ID, f.ParentRound, f.RoundCount)
+	}
+	if pending != nil {
+		fmt.Printf("Pending:  round %d (%s, %d/%d steps done) — resume with -session %s -resume-round\n",
+			pending.RoundNum, pending.Status, len(pending.State.Completed), len(pending.State.Plan.Steps), info.ID)
+	}
+	if len(rounds) == 0 {
+		return nil
+	}
+	fmt.Println()
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ROUND\tSTEPS\tCREATED\tQUERY")
+	for _, r := range rounds {
+		fmt.Fprintf(tw, "%d\t%d\t%s\t%s\n", r.RoundNum, len(r.Plan.Steps), r.CreatedAt, oneLine(r.UserQuery, 80))
+	}
+	return tw.Flush()
+}
+
+// roundDetail is the full record of one round, as printed by rounds show and sessions export.
+type roundDetail struct {
+	session.Round
+	Steps []common.StepRecord `json:"steps"`
+	IOCs  []common.IOC        `json:"iocs"`
+}
+
+func cmdSessionsExport(store session.Store, args []string, f subcommandFlags) error {
+	if len(args) != 1 {
+		return fmt.Errorf("expected exactly one session ID")
+	}
+	b, err := bundle.Load(store, args[0])
+	if err != nil {
+		return err
+	}
+	if f.out == "" {
+		return printJSON(b)
+	}
+	if err := bundle.Write(b, f.out); err != nil {
+		return err
+	}
+	fmt.Printf("Exported session %s (%d rounds) to %s.\n", b.Session.ID, len(b.Rounds), f.out)
+	return nil
+}
+
+func cmdSessionsImport(store session.Store, args []string, f subcommandFlags) error {
+	if len(args) != 1 {
+		return fmt.Errorf("expected a bundle directory, .zip or .json file")
+	}
+	b, err := bundle.Read(args[0])
+	if err != nil {
+		return err
+	}
+	id, err := bundle.Import(store, b, f.as)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Imported session %s (%d rounds, %d captures).\n", id, len(b.Rounds), len(b.Pcaps))
+	return nil
+}
+
+func cmdSessionsDelete(store session.Store, args []string, f subcommandFlags) error {
+	info, _, err := loadSession(store, args)
+	if err != nil {
+		return err
+	}
+	if !f.yes {
+		fmt.Printf("Delete session %s (%d rounds, pcap %s)? [y/N] ", info.ID, info.RoundCount, info.PcapPath)
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		if a := strings.ToLower(strings.TrimSpace(answer)); a != "y" && a != "yes" {
+			fmt.Println("Aborted.")
+			return nil
+		}
+	}
+	if _, err := store.DeleteSession(info.ID); err != nil {
+		return err
+	}
+	fmt.Printf("Deleted session %s.\n", info.ID)
+	return nil
+}
+
+func cmdSessionsFork(store session.Store, args []string, f subcommandFlags) error {
+	if len(args) != 2 {
+		return fmt.Errorf("expected <session> <round>")
+	}
+	n, err := strconv.Atoi(args[1])
+	if err != nil {
+		return fmt.Errorf("invalid round number %q", args[1])
+	}
+	sess, err := session.ForkSession(store, args[0], n)
+	if err != nil {
+		return err
+	}
+	if f.json {
+		info, err := store.GetSession(sess.ID)
+		if err != nil {
+			return err
+		}
+		return printJSON(info)
+	}
+	fmt.Printf("Forked %s after round %d into %s (continue it with -session %s).\n", args[0], n, sess.ID, sess.ID)
+	return nil
+}
+
+func cmdRoundsShow(store session.Store, args []string, f subcommandFlags) error {
+	if len(args) != 2 {
+		return fmt.Errorf("expected <session> <round>")
+	}
+	n, err := strconv.Atoi(args[1])
+	if err != nil {
+		return fmt.Errorf("invalid round number %q", args[1])
+	}
+	r, err := store.GetRound(args[0], n)
+	if err != nil {
+		return err
+	}
+	if r == nil {
+		return fmt.Errorf("session %s has no round %d", args[0], n)
+	}
+	d, err := loadRoundDetail(store, *r)
+	if err != nil {
+		return err
+	}
+	if f.json {
+		return printJSON(d)
+	}
+	printRoundDetail(os.Stdout, d)
+	return nil
+}
+
+func cmdPcapsList(store session.Store, args []string, f subcommandFlags) error {
+	files, err := store.ListPcapFiles()
+	if err != nil {
+		return err
+	}
+	if f.json {
+		return printJSON(files)
+	}
+	if len(files) == 0 {
+		fmt.Println("No captures registered.")
+		return nil
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tNAME\tSIZE\tSHA256\tREGISTERED\tSESSIONS")
+	for _, f := range files {
+		sessions := strings.Join(f.SessionIDs, ", ")
+		if sessions == "" {
+			sessions = "-"
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\n",
+			f.ID, f.FileName, formatBytes(f.FileSize), shortHash(f.FileHash), f.CreatedAt, sessions)
+	}
+	return tw.Flush()
+}
+
+func cmdSearch(store session.Store, args []string, f subcommandFlags) error {
+	if len(args) == 0 {
+		return fmt.Errorf("expected search terms")
+	}
+	hits, err := store.Search(strings.Join(args, " "), f.limit)
+	if err != nil {
+		return err
+	}
+	if f.json {
+		if hits == nil {
+			hits = []session.SearchHit{}
+		}
+		return printJSON(hits)
+	}
+	if len(hits) == 0 {
+		fmt.Println("No matches.")
+		return nil
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SESSION\tROUND\tSTEP\tFIELD\tSNIPPET")
+	for _, h := range hits {
+		step := "-"
+		if h.StepID > 0 {
+			step = strconv.Itoa(h.StepID)
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\n", h.SessionID, h.RoundNum, step, h.Field, oneLine(h.Snippet, 100))
+	}
+	return tw.Flush()
+}
+
+// loadSession resolves the single <id> argument to a session and its rounds.
+func loadSession(store session.Store, args []string) (*session.SessionInfo, []session.Round, error) {
+	if len(args) != 1 {
+		return nil, nil, fmt.Errorf("expected exactly one session ID")
+	}
+	info, err := store.GetSession(args[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	if info == nil {
+		return nil, nil, fmt.Errorf("session %s not found", args[0])
+	}
+	rounds, err := store.GetRounds(info.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return info, rounds, nil
+}
+
+func loadRoundDetail(store session.Store, r session.Round) (*roundDetail, error) {
+	steps, err := store.GetRoundSteps(r.SessionID, r.RoundNum)
+	if err != nil {
+		return nil, fmt.Errorf("load steps of round %d: %w", r.RoundNum, err)
+	}
+	all, err := store.GetSessionIOCs(r.SessionID)
+	if err != nil {
+		return nil, fmt.Errorf("load iocs of round %d: %w", r.RoundNum, err)
+	}
+	d := &roundDetail{Round: r, Steps: steps, IOCs: []common.IOC{}}
+	for _, ioc := range all {
+		if ioc.RoundNum == r.RoundNum {
+			d.IOCs = append(d.IOCs, ioc.IOC)
+		}
+	}
+	return d, nil
+}
+
+func printRoundDetail(w io.Writer, d *roundDetail) {
+	fmt.Fprintf(w, "Session:  %s\nRound:    %d\nCreated:  %s\nQuery:    %s\n\n",
+		d.SessionID, d.RoundNum, d.CreatedAt, d.UserQuery)
+
+	if d.Plan.Thought != "" {
+		fmt.Fprintf(w, "Planner thought: %s\n\n", d.Plan.Thought)
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "STEP\tSTATUS\tTOOLS\tTOKENS\tDURATION\tINTENT")
+	if len(d.Steps) > 0 {
+		for _, s := range d.Steps {
+			duration := "-"
+			if !s.StartedAt.IsZero() && !s.FinishedAt.IsZero() {
+				duration = s.FinishedAt.Sub(s.StartedAt).Round(time.Second).String()
+			}
+			fmt.Fprintf(tw, "%d\t%s\t%d\t%d\t%s\t%s\n",
+				s.StepID, s.Status, s.ToolCalls, s.TotalTokens, duration, oneLine(s.Intent, 80))
+		}
+	} else {
+		// Rounds saved before step records existed only have the plan.
+		for _, s := range d.Plan.Steps {
+			fmt.Fprintf(tw, "%d\t-\t-\t-\t-\t%s\n", s.StepID, oneLine(s.Intent, 80))
+		}
+	}
+	tw.Flush()
+
+	if len(d.IOCs) > 0 {
+		fmt.Fprintf(w, "\nIndicators: %d (listed at the end of the report)\n", len(d.IOCs))
+	}
+	fmt.Fprintf(w, "\n===== REPORT =====\n%s\n==================\n", d.Report)
+}
+
+// formatLineage renders a root-first chain of sessions as "a → b (after round 2) → c (after round 1)".
+func formatLineage(chain []session.SessionInfo) string {
+	parts := make([]string, len(chain))
+	for i, s := range chain {
+		parts[i] = s.ID
+		if i > 0 {
+			parts[i] += fmt.Sprintf(" (after round %d)", s.ParentRound)
+		}
+	}
+	return strings.Join(parts, " → ")
+}
+
+// formatBytes renders n in human-readable binary units.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+func shortHash(h string) string {
+	if len(h) > 12 {
+		return h[:12]
+	}
+	if h == "" {
+		return "-"
+	}
+	return h
+}
+
+func printJSON(v any) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// oneLine collapses whitespace and truncates s to at most n runes for table cells.
+func oneLine(s string, n int) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if r := []rune(s); len(r) > n {
+		return string(r[:n-1]) + "…"
+	}
+	return s
+}
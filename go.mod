@@ -8,8 +8,11 @@ require (
 	github.com/cloudwego/eino-examples v0.0.0-20260203133155-1f40592b7e48
 	github.com/cloudwego/eino-ext/components/model/openai v0.1.8
 	github.com/cloudwego/eino-ext/components/tool/commandline v0.0.0-20260204064123-1f91f547c77e
+	github.com/docker/docker v28.0.4+incompatible
 	github.com/elastic/go-elasticsearch/v7 v7.17.10
+	github.com/lib/pq v1.9.0
 	github.com/pkoukk/tiktoken-go v0.1.8
+	modernc.org/sqlite v1.46.1
 )
 
 require (
@@ -26,7 +29,6 @@ require (
 	github.com/cloudwego/eino-ext/libs/acl/openai v0.1.13 // indirect
 	github.com/distribution/reference v0.6.0 // indirect
 	github.com/dlclark/regexp2 v1.11.4 // indirect
-	github.com/docker/docker v28.0.4+incompatible // indirect
 	github.com/docker/go-connections v0.5.0 // indirect
 	github.com/docker/go-units v0.5.0 // indirect
 	github.com/dustin/go-humanize v1.0.1 // indirect
@@ -84,5 +86,4 @@ require (
 	modernc.org/libc v1.67.6 // indirect
 	modernc.org/mathutil v1.7.1 // indirect
 	modernc.org/memory v1.11.0 // indirect
-	modernc.org/sqlite v1.46.1 // indirect
 )
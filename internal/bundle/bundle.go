@@ -0,0 +1,200 @@
+// Package bundle exports a session as a self-contained investigation bundle
+// (JSON, Markdown and standalone HTML, in a directory or zip file) and imports
+// such bundles into another session store.
+package bundle
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"pcap_agent/internal/common"
+	"pcap_agent/internal/events"
+	"pcap_agent/internal/session"
+)
+
+// FormatVersion is the version of the bundle JSON written by this package.
+const FormatVersion = 1
+
+// Bundle file names.
+const (
+	JSONFile     = "session.json"
+	MarkdownFile = "report.md"
+	HTMLFile     = "report.html"
+)
+
+// Bundle is the machine-readable content of an investigation bundle.
+type Bundle struct {
+	FormatVersion int                   `json:"format_version"`
+	ExportedAt    string                `json:"exported_at"`
+	Session       session.SessionInfo   `json:"session"`
+	Pcaps         []session.SessionPcap `json:"pcaps"`
+	Rounds        []Round               `json:"rounds"`
+	Events        []events.Event        `json:"events"` // the session's event log, oldest first
+}
+
+// Round is a round with its step records and IOCs.
+type Round struct {
+	session.Round
+	Steps []common.StepRecord `json:"steps"`
+	IOCs  []common.IOC        `json:"iocs"`
+}
+
+// Load reads everything recorded under a session into a Bundle.
+func Load(store session.Store, sessionID string) (*Bundle, error) {
+	info, err := store.GetSession(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if info == nil {
+		return nil, fmt.Errorf("session %s not found", sessionID)
+	}
+	pcaps, err := store.GetSessionPcaps(sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("load captures: %w", err)
+	}
+	rounds, err := store.GetRounds(sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("load rounds: %w", err)
+	}
+	iocs, err := store.GetSessionIOCs(sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("load iocs: %w", err)
+	}
+	evs, err := store.GetEvents(sessionID, 0)
+	if err != nil {
+		return nil, fmt.Errorf("load events: %w", err)
+	}
+
+	b := &Bundle{
+		FormatVersion: FormatVersion,
+		ExportedAt:    time.Now().UTC().Format(time.RFC3339),
+		Session:       *info,
+		Pcaps:         pcaps,
+		Rounds:        make([]Round, len(rounds)),
+		Events:        evs,
+	}
+	if b.Pcaps == nil {
+		b.Pcaps = []session.SessionPcap{}
+	}
+	if b.Events == nil {
+		b.Events = []events.Event{}
+	}
+	for i, r := range rounds {
+		steps, err := store.GetRoundSteps(sessionID, r.RoundNum)
+		if err != nil {
+			return nil, fmt.Errorf("load steps of round %d: %w", r.RoundNum, err)
+		}
+		if steps == nil {
+			steps = []common.StepRecord{}
+		}
+		b.Rounds[i] = Round{Round: r, Steps: steps, IOCs: []common.IOC{}}
+		for _, ioc := range iocs {
+			if ioc.RoundNum == r.RoundNum {
+				b.Rounds[i].IOCs = append(b.Rounds[i].IOCs, ioc.IOC)
+			}
+		}
+	}
+	return b, nil
+}
+
+// Import recreates the bundled session in store under id (the bundled ID if
+// empty) and returns the ID used. Timestamps, fork linkage and the event log
+// (with its sequence numbers) are preserved, and captures are linked to the
+// store's registry by SHA-256, registering them if needed. Nothing is left
+// behind if import fails.
+func Import(store session.Store, b *Bundle, id string) (string, error) {
+	if id == "" {
+		id = b.Session.ID
+	}
+	if id == "" {
+		return "", fmt.Errorf("bundle has no session ID")
+	}
+	exists, err := store.SessionExists(id)
+	if err != nil {
+		return "", err
+	}
+	if exists {
+		return "", fmt.Errorf("session %s already exists", id)
+	}
+	if len(b.Pcaps) == 0 {
+		return "", fmt.Errorf("bundle has no captures")
+	}
+
+	pcaps := make([]session.SessionPcap, len(b.Pcaps))
+	for i, p := range b.Pcaps {
+		p.PcapFileID = 0
+		if p.FileHash != "" {
+			f, err := store.GetPcapFileByHash(p.FileHash)
+			if err != nil {
+				return "", fmt.Errorf("look up capture %s: %w", p.FileName, err)
+			}
+			if f != nil {
+				p.PcapFileID = f.ID
+			} else if p.PcapFileID, err = store.SavePcapFile(p.FileName, "", p.FileSize, p.FileHash); err != nil {
+				return "", fmt.Errorf("register capture %s: %w", p.FileName, err)
+			}
+		}
+		pcaps[i] = p
+	}
+	if err := store.CreateSession(id, pcaps); err != nil {
+		return "", fmt.Errorf("create session: %w", err)
+	}
+
+	rounds := append([]Round(nil), b.Rounds...)
+	sort.SliceStable(rounds, func(i, j int) bool { return rounds[i].RoundNum < rounds[j].RoundNum })
+	err = nil
+	for _, r := range rounds {
+		if err = importRound(store, id, r); err != nil {
+			break
+		}
+	}
+	if err == nil && len(b.Events) > 0 {
+		evs := make([]events.Event, len(b.Events))
+		for i, ev := range b.Events {
+			ev.SessionID = id
+			evs[i] = ev
+		}
+		if err = store.AppendEvents(id, evs); err != nil {
+			err = fmt.Errorf("import events: %w", err)
+		}
+	}
+	if err == nil {
+		info := b.Session
+		info.ID = id
+		if err = store.RestoreSessionMetadata(info); err != nil {
+			err = fmt.Errorf("restore session metadata: %w", err)
+		}
+	}
+	if err != nil {
+		if _, derr := store.DeleteSession(id); derr != nil {
+			err = fmt.Errorf("%w (cleanup failed: %v)", err, derr)
+		}
+		return "", err
+	}
+	return id, nil
+}
+
+func importRound(store session.Store, sessionID string, r Round) error {
+	roundID, err := store.SaveRound(sessionID, r.RoundNum, r.UserQuery, r.Plan, r.Report, r.Findings, r.OperationLog)
+	if err != nil {
+		return fmt.Errorf("import round %d: %w", r.RoundNum, err)
+	}
+	for _, rec := range r.Steps {
+		if err := store.SaveStep(roundID, rec); err != nil {
+			return fmt.Errorf("import step %d of round %d: %w", rec.StepID, r.RoundNum, err)
+		}
+	}
+	if err := store.SaveIOCs(roundID, r.IOCs); err != nil {
+		return fmt.Errorf("import iocs of round %d: %w", r.RoundNum, err)
+	}
+	if err := store.RestoreRoundCreatedAt(roundID, r.CreatedAt); err != nil {
+		return fmt.Errorf("import round %d: %w", r.RoundNum, err)
+	}
+	if r.Digest != "" {
+		if err := store.SaveRoundDigest(roundID, r.Digest); err != nil {
+			return fmt.Errorf("import digest of round %d: %w", r.RoundNum, err)
+		}
+	}
+	return nil
+}
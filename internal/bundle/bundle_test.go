@@ -0,0 +1,113 @@
+package bundle
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"pcap_agent/internal/common"
+	"pcap_agent/internal/events"
+	"pcap_agent/internal/session"
+)
+
+func openStore(t *testing.T) session.Store {
+	t.Helper()
+	s, err := session.OpenSQLite(filepath.Join(t.TempDir(), "sessions.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func must(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExportImportRoundTrip(t *testing.T) {
+	src := openStore(t)
+	fileID, err := src.SavePcapFile("a.pcap", "/data/a.pcap", 42, "aa11")
+	must(t, err)
+	must(t, src.CreateSession("parent", []session.SessionPcap{
+		{PcapFileID: fileID, ContainerPath: "/c/a.pcap", FileName: "a.pcap", FileSize: 42, FileHash: "aa11"},
+		{ContainerPath: "/c/b.pcap", FileName: "b.pcap"},
+	}))
+	for n, q := range []string{"who beacons?", "and over dns?"} {
+		plan := common.Plan{Thought: "think " + q, Steps: []common.Step{{StepID: 1, Intent: "look"}}}
+		id, err := src.SaveRound("parent", n+1, q, plan, "report "+q, "findings "+q, "log "+q)
+		must(t, err)
+		must(t, src.SaveStep(id, common.StepRecord{StepID: 1, Intent: "look", Findings: "found " + q, Status: common.StepCompleted, ToolCalls: 3, TotalTokens: 99}))
+		must(t, src.SaveIOCs(id, []common.IOC{{Type: common.IOCIP, Value: "192.0.2.1", Context: "c2", StepIDs: []int{1}}}))
+		must(t, src.SaveRoundDigest(id, "digest "+q))
+	}
+	must(t, src.ForkSession("parent", 2, "s"))
+	// Distinct timestamps show that import restores them rather than using now.
+	must(t, src.RestoreSessionMetadata(session.SessionInfo{ID: "s", CreatedAt: "2024-01-02 03:04:05", UpdatedAt: "2024-01-02 04:00:00", ParentSessionID: "parent", ParentRound: 2}))
+	for _, r := range []int{1, 2} {
+		round, err := src.GetRound("s", r)
+		must(t, err)
+		must(t, src.RestoreRoundCreatedAt(round.ID, fmt.Sprintf("2024-01-02 03:%02d:00", 4+r)))
+	}
+	var evs []events.Event
+	for i, typ := range []string{events.TypeInfo, events.TypeRoundCompleted} {
+		ev := events.NewEvent(typ, "s", events.InfoData{Message: typ})
+		ev.Seq, ev.Round, ev.RunID = int64(i+1), 2, "run"
+		ev.Timestamp = time.Date(2024, 1, 2, 3, 4, 5+i, 0, time.UTC)
+		evs = append(evs, ev)
+	}
+	must(t, src.AppendEvents("s", evs))
+
+	exported, err := Load(src, "s")
+	must(t, err)
+	dest := filepath.Join(t.TempDir(), "s.zip")
+	must(t, Write(exported, dest))
+	read, err := Read(dest)
+	must(t, err)
+
+	dst := openStore(t)
+	id, err := Import(dst, read, "copy")
+	must(t, err)
+	if id != "copy" {
+		t.Fatalf("Import returned %q", id)
+	}
+	if _, err := Import(dst, read, "copy"); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("second Import = %v; want already exists", err)
+	}
+	if f, _ := dst.GetPcapFileByHash("aa11"); f == nil || f.FileName != "a.pcap" {
+		t.Errorf("capture not registered by hash: %+v", f)
+	}
+
+	imported, err := Load(dst, "copy")
+	must(t, err)
+	if got := imported.Session; got.ParentSessionID != "parent" || got.ParentRound != 2 || got.CreatedAt != "2024-01-02 03:04:05" {
+		t.Errorf("imported session = %+v", got)
+	}
+	// Apart from IDs local to each store, the reloaded bundle matches the export.
+	for _, b := range []*Bundle{exported, imported} {
+		b.ExportedAt, b.Session.ID = "", ""
+		for i := range b.Pcaps {
+			b.Pcaps[i].PcapFileID = 0
+		}
+		for i := range b.Rounds {
+			b.Rounds[i].ID, b.Rounds[i].SessionID = 0, ""
+		}
+		for i := range b.Events {
+			b.Events[i].SessionID = ""
+		}
+	}
+	want, _ := json.MarshalIndent(exported, "", "  ")
+	got, _ := json.MarshalIndent(imported, "", "  ")
+	if string(got) != string(want) {
+		t.Errorf("imported bundle differs from the export:\n got %s\nwant %s", got, want)
+	}
+	if len(imported.Rounds) != 2 || len(imported.Rounds[1].Steps) != 1 || len(imported.Rounds[1].IOCs) != 1 ||
+		imported.Rounds[1].Digest == "" || len(imported.Events) != 2 || imported.Events[1].Seq != 2 {
+		t.Errorf("imported bundle is missing records: %s", got)
+	}
+}
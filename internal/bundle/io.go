@@ -0,0 +1,127 @@
+package bundle
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Write renders b and writes the bundle files to dest: a zip archive if dest
+// ends in ".zip", otherwise a directory (created if missing).
+func Write(b *Bundle, dest string) error {
+	files, err := render(b)
+	if err != nil {
+		return err
+	}
+	if strings.EqualFold(filepath.Ext(dest), ".zip") {
+		return writeZip(dest, files)
+	}
+	if err := os.MkdirAll(dest, 0o755); err != nil {
+		return err
+	}
+	for _, f := range files {
+		if err := os.WriteFile(filepath.Join(dest, f.name), f.data, 0o644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type file struct {
+	name string
+	data []byte
+}
+
+// render produces the bundle files in a stable order.
+func render(b *Bundle) ([]file, error) {
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("encode bundle: %w", err)
+	}
+	html, err := RenderHTML(b)
+	if err != nil {
+		return nil, fmt.Errorf("render html: %w", err)
+	}
+	return []file{
+		{JSONFile, append(data, '\n')},
+		{MarkdownFile, []byte(RenderMarkdown(b))},
+		{HTMLFile, []byte(html)},
+	}, nil
+}
+
+func writeZip(dest string, files []file) error {
+	out, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	zw := zip.NewWriter(out)
+	for _, f := range files {
+		w, err := zw.Create(f.name)
+		if err != nil {
+			out.Close()
+			return err
+		}
+		if _, err := w.Write(f.data); err != nil {
+			out.Close()
+			return err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// Read loads the bundle JSON from a bundle directory, zip archive or the JSON file itself.
+func Read(src string) (*Bundle, error) {
+	var data []byte
+	var err error
+	switch ext := filepath.Ext(src); {
+	case strings.EqualFold(ext, ".zip"):
+		data, err = readZipJSON(src)
+	case strings.EqualFold(ext, ".json"):
+		data, err = os.ReadFile(src)
+	default:
+		data, err = os.ReadFile(filepath.Join(src, JSONFile))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var b Bundle
+	if err := json.Unmarshal(data, &b); err != nil {
+		return nil, fmt.Errorf("decode %s: %w", JSONFile, err)
+	}
+	if b.FormatVersion < 1 || b.FormatVersion > FormatVersion {
+		return nil, fmt.Errorf("unsupported bundle format version %d (supported: 1..%d)", b.FormatVersion, FormatVersion)
+	}
+	return &b, nil
+}
+
+// readZipJSON returns the bundle JSON of a zip archive. The file may sit at the
+// archive root or inside a single top-level directory.
+func readZipJSON(src string) ([]byte, error) {
+	zr, err := zip.OpenReader(src)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	for _, f := range zr.File {
+		if path.Base(f.Name) != JSONFile || strings.Count(strings.TrimPrefix(f.Name, "./"), "/") > 1 {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		return io.ReadAll(rc)
+	}
+	return nil, fmt.Errorf("%s: no %s in archive", src, JSONFile)
+}
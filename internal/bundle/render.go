@@ -0,0 +1,204 @@
+package bundle
+
+import (
+	"fmt"
+	"html/template"
+	"strings"
+
+	"pcap_agent/internal/common"
+	"pcap_agent/internal/session"
+)
+
+// RenderMarkdown renders the bundle as a single Markdown document.
+func RenderMarkdown(b *Bundle) string {
+	var sb strings.Builder
+	s := b.Session
+	fmt.Fprintf(&sb, "# Investigation %s\n\n", s.ID)
+	sb.WriteString("| | |\n|---|---|\n")
+	fmt.Fprintf(&sb, "| Created | %s |\n| Updated | %s |\n| Rounds | %d |\n", s.CreatedAt, s.UpdatedAt, len(b.Rounds))
+	if s.ParentSessionID != "" {
+		fmt.Fprintf(&sb, "| Forked from | %s after round %d |\n", s.ParentSessionID, s.ParentRound)
+	}
+	fmt.Fprintf(&sb, "| Exported | %s |\n\n", b.ExportedAt)
+
+	sb.WriteString("## Captures\n\n")
+	sb.WriteString(common.FormatCaptures(captures(b.Pcaps)))
+
+	for _, r := range b.Rounds {
+		fmt.Fprintf(&sb, "\n## Round %d\n\n", r.RoundNum)
+		fmt.Fprintf(&sb, "**Query:** %s\n\n", r.UserQuery)
+		fmt.Fprintf(&sb, "_Created %s_\n\n", r.CreatedAt)
+
+		sb.WriteString("### Plan\n\n")
+		if r.Plan.Thought != "" {
+			fmt.Fprintf(&sb, "**Planner thought:** %s\n\n", r.Plan.Thought)
+		}
+		for _, st := range r.Plan.Steps {
+			fmt.Fprintf(&sb, "%d. %s\n", st.StepID, st.Intent)
+		}
+
+		if len(r.Steps) > 0 {
+			sb.WriteString("\n### Steps\n\n")
+			sb.WriteString("| Step | Status | Tool Calls | Tokens | Intent |\n")
+			sb.WriteString("|------|--------|------------|--------|--------|\n")
+			for _, st := range r.Steps {
+				fmt.Fprintf(&sb, "| %d | %s | %d | %d | %s |\n", st.StepID, st.Status, st.ToolCalls, st.TotalTokens, cell(st.Intent))
+			}
+			for _, st := range r.Steps {
+				fmt.Fprintf(&sb, "\n#### Step %d: %s\n\n", st.StepID, oneLine(st.Intent))
+				if st.Findings != "" {
+					fmt.Fprintf(&sb, "**Findings**\n\n%s\n\n", strings.TrimSpace(st.Findings))
+				}
+				if st.Actions != "" {
+					fmt.Fprintf(&sb, "**Actions**\n\n%s\n", codeBlock(st.Actions))
+				}
+			}
+		}
+
+		if len(r.IOCs) > 0 {
+			sb.WriteString("\n")
+			sb.WriteString(strings.Replace(common.FormatIOCSection(r.IOCs), "## ", "### ", 1))
+		}
+		if r.OperationLog != "" {
+			fmt.Fprintf(&sb, "\n### Operation Log\n\n%s", codeBlock(r.OperationLog))
+		}
+		fmt.Fprintf(&sb, "\n### Report\n\n%s\n", strings.TrimSpace(r.Report))
+	}
+	return sb.String()
+}
+
+// RenderHTML renders the bundle as a standalone HTML page. Free text (reports,
+// findings, logs) is shown as preformatted Markdown.
+func RenderHTML(b *Bundle) (string, error) {
+	var sb strings.Builder
+	err := htmlTemplate.Execute(&sb, struct {
+		*Bundle
+		Captures []common.Capture
+	}{b, captures(b.Pcaps)})
+	return sb.String(), err
+}
+
+var htmlTemplate = template.Must(template.New("bundle").Funcs(template.FuncMap{
+	"trim": strings.TrimSpace,
+	"inc":  func(i int) int { return i + 1 },
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Investigation {{.Session.ID}}</title>
+<style>
+body { font-family: system-ui, sans-serif; max-width: 72rem; margin: 2rem auto; padding: 0 1rem; color: #1d1d1f; }
+table { border-collapse: collapse; margin: .5rem 0 1rem; }
+th, td { border: 1px solid #ccc; padding: .25rem .5rem; text-align: left; vertical-align: top; }
+th { background: #f3f3f3; }
+pre { white-space: pre-wrap; word-break: break-word; background: #f7f7f7; padding: .75rem; border-radius: 4px; }
+code { font-family: ui-monospace, monospace; }
+section { border-top: 2px solid #ddd; margin-top: 2rem; }
+.muted { color: #666; }
+</style>
+</head>
+<body>
+<h1>Investigation {{.Session.ID}}</h1>
+<table>
+<tr><th>Created</th><td>{{.Session.CreatedAt}}</td></tr>
+<tr><th>Updated</th><td>{{.Session.UpdatedAt}}</td></tr>
+<tr><th>Rounds</th><td>{{len .Rounds}}</td></tr>
+{{- if .Session.ParentSessionID}}
+<tr><th>Forked from</th><td>{{.Session.ParentSessionID}} after round {{.Session.ParentRound}}</td></tr>
+{{- end}}
+<tr><th>Exported</th><td>{{.ExportedAt}}</td></tr>
+</table>
+
+<h2>Captures</h2>
+<table>
+<tr><th>#</th><th>File</th><th>Container Path</th><th>Size (bytes)</th><th>SHA-256</th></tr>
+{{- range $i, $c := .Captures}}
+<tr><td>{{inc $i}}</td><td>{{$c.Name}}</td><td><code>{{$c.Path}}</code></td><td>{{$c.Size}}</td><td><code>{{$c.SHA256}}</code></td></tr>
+{{- end}}
+</table>
+{{range .Rounds}}
+<section>
+<h2>Round {{.RoundNum}}</h2>
+<p><strong>Query:</strong> {{.UserQuery}}</p>
+<p class="muted">Created {{.CreatedAt}}</p>
+
+<h3>Plan</h3>
+{{- if .Plan.Thought}}
+<p><strong>Planner thought:</strong> {{.Plan.Thought}}</p>
+{{- end}}
+<ol>
+{{- range .Plan.Steps}}
+<li value="{{.StepID}}">{{.Intent}}</li>
+{{- end}}
+</ol>
+{{- if .Steps}}
+
+<h3>Steps</h3>
+<table>
+<tr><th>Step</th><th>Status</th><th>Tool Calls</th><th>Tokens</th><th>Intent</th></tr>
+{{- range .Steps}}
+<tr><td>{{.StepID}}</td><td>{{.Status}}</td><td>{{.ToolCalls}}</td><td>{{.TotalTokens}}</td><td>{{.Intent}}</td></tr>
+{{- end}}
+</table>
+{{- range .Steps}}
+<details>
+<summary>Step {{.StepID}}: {{.Intent}}</summary>
+{{- if .Findings}}
+<h4>Findings</h4>
+<pre>{{trim .Findings}}</pre>
+{{- end}}
+{{- if .Actions}}
+<h4>Actions</h4>
+<pre>{{trim .Actions}}</pre>
+{{- end}}
+</details>
+{{- end}}
+{{- end}}
+{{- if .IOCs}}
+
+<h3>Indicators of Compromise</h3>
+<table>
+<tr><th>Type</th><th>Value</th><th>Context</th><th>Steps</th></tr>
+{{- range .IOCs}}
+<tr><td>{{.Type}}</td><td><code>{{.Value}}</code></td><td>{{.Context}}</td><td>{{range $i, $id := .StepIDs}}{{if $i}}, {{end}}{{$id}}{{end}}</td></tr>
+{{- end}}
+</table>
+{{- end}}
+{{- if .OperationLog}}
+
+<h3>Operation Log</h3>
+<details><summary>Show</summary><pre>{{trim .OperationLog}}</pre></details>
+{{- end}}
+
+<h3>Report</h3>
+<pre>{{trim .Report}}</pre>
+</section>
+{{end}}
+</body>
+</html>
+`))
+
+func captures(pcaps []session.SessionPcap) []common.Capture {
+	out := make([]common.Capture, len(pcaps))
+	for i, p := range pcaps {
+		out[i] = p.Capture()
+	}
+	return out
+}
+
+// codeBlock fences s so that backticks inside it cannot close the block.
+func codeBlock(s string) string {
+	fence := "```"
+	for strings.Contains(s, fence) {
+		fence += "`"
+	}
+	return fence + "text\n" + strings.TrimSpace(s) + "\n" + fence + "\n"
+}
+
+func cell(s string) string {
+	return strings.ReplaceAll(oneLine(s), "|", "\\|")
+}
+
+func oneLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
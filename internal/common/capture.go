@@ -0,0 +1,45 @@
+package common
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Capture is a PCAP file available to the agents inside the sandbox.
+type Capture struct {
+	Name   string `json:"name"` // original file name
+	Path   string `json:"path"` // container-side path
+	Size   int64  `json:"size,omitempty"`
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// CapturePaths returns the container paths of captures separated by spaces,
+// ready to be passed to `pcapchu-scripts init`.
+func CapturePaths(captures []Capture) string {
+	paths := make([]string, len(captures))
+	for i, c := range captures {
+		paths[i] = c.Path
+	}
+	return strings.Join(paths, " ")
+}
+
+// FormatCaptures renders captures as a Markdown table for prompt templates.
+func FormatCaptures(captures []Capture) string {
+	if len(captures) == 0 {
+		return "(no captures)"
+	}
+	var sb strings.Builder
+	sb.WriteString("| # | File | Container Path | Size (bytes) | SHA-256 |\n")
+	sb.WriteString("|---|------|----------------|--------------|---------|\n")
+	for i, c := range captures {
+		size, hash := "-", "-"
+		if c.Size > 0 {
+			size = fmt.Sprintf("%d", c.Size)
+		}
+		if c.SHA256 != "" {
+			hash = "`" + c.SHA256 + "`"
+		}
+		sb.WriteString(fmt.Sprintf("| %d | %s | `%s` | %s | %s |\n", i+1, escapeTableCell(c.Name), c.Path, size, hash))
+	}
+	return sb.String()
+}
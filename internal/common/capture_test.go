@@ -0,0 +1,29 @@
+package common
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFormatCaptures(t *testing.T) {
+	captures := []Capture{
+		{Name: "dns|tunnel.pcap", Path: "/home/linuxbrew/1.pcap", Size: 2048, SHA256: "ab12"},
+		{Name: "web.pcap", Path: "/home/linuxbrew/2.pcap"},
+	}
+	if got := CapturePaths(captures); got != "/home/linuxbrew/1.pcap /home/linuxbrew/2.pcap" {
+		t.Errorf("CapturePaths = %q", got)
+	}
+
+	table := FormatCaptures(captures)
+	for _, row := range []string{
+		"| 1 | dns\\|tunnel.pcap | `/home/linuxbrew/1.pcap` | 2048 | `ab12` |",
+		"| 2 | web.pcap | `/home/linuxbrew/2.pcap` | - | - |",
+	} {
+		if !strings.Contains(table, row) {
+			t.Errorf("FormatCaptures is missing row %q:\n%s", row, table)
+		}
+	}
+	if got := FormatCaptures(nil); got != "(no captures)" {
+		t.Errorf("FormatCaptures(nil) = %q", got)
+	}
+}
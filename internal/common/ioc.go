@@ -0,0 +1,182 @@
+package common
+
+import (
+	"fmt"
+	"net/netip"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// IOC types reported by executors.
+const (
+	IOCIP        = "ip"
+	IOCDomain    = "domain"
+	IOCURL       = "url"
+	IOCHash      = "hash" // file hash (MD5, SHA-1 or SHA-256, hex)
+	IOCJA3       = "ja3"  // JA3/JA3S fingerprint (MD5, hex)
+	IOCUserAgent = "user_agent"
+	IOCPort      = "port"
+)
+
+// IOCTypes lists the accepted IOC types in display order.
+var IOCTypes = []string{IOCIP, IOCDomain, IOCURL, IOCHash, IOCJA3, IOCUserAgent, IOCPort}
+
+// IOC is a typed indicator of compromise extracted by an executor step.
+// StepIDs lists every step that reported it.
+type IOC struct {
+	Type    string `json:"type"`
+	Value   string `json:"value"`
+	Context string `json:"context,omitempty"` // why the indicator matters, e.g. "C2 beacon destination"
+	StepIDs []int  `json:"step_ids,omitempty"`
+}
+
+// Key identifies an IOC for deduplication.
+func (i IOC) Key() string {
+	return i.Type + "|" + i.Value
+}
+
+// NormalizeIOC validates an IOC and canonicalizes its type and value so that the
+// same indicator reported by different steps deduplicates: types are lower-cased,
+// IPs are printed in canonical form, domains, hashes and JA3 fingerprints are
+// lower-cased, and ports must be in 1..65535.
+func NormalizeIOC(ioc IOC) (IOC, error) {
+	ioc.Type = strings.ToLower(strings.TrimSpace(ioc.Type))
+	ioc.Value = strings.TrimSpace(ioc.Value)
+	ioc.Context = strings.TrimSpace(ioc.Context)
+	if ioc.Value == "" {
+		return ioc, fmt.Errorf("empty %s value", ioc.Type)
+	}
+
+	switch ioc.Type {
+	case IOCIP:
+		addr, err := netip.ParseAddr(ioc.Value)
+		if err != nil {
+			return ioc, fmt.Errorf("invalid ip %q", ioc.Value)
+		}
+		ioc.Value = addr.Unmap().String()
+	case IOCDomain:
+		ioc.Value = strings.TrimSuffix(strings.ToLower(ioc.Value), ".")
+		if strings.ContainsAny(ioc.Value, " /:") {
+			return ioc, fmt.Errorf("invalid domain %q", ioc.Value)
+		}
+	case IOCURL:
+		u, err := url.Parse(ioc.Value)
+		if err != nil || u.Host == "" {
+			return ioc, fmt.Errorf("invalid url %q", ioc.Value)
+		}
+	case IOCHash, IOCJA3:
+		ioc.Value = strings.ToLower(ioc.Value)
+		if !isHex(ioc.Value) {
+			return ioc, fmt.Errorf("invalid %s %q (expected hex)", ioc.Type, ioc.Value)
+		}
+		if ioc.Type == IOCHash && len(ioc.Value) != 32 && len(ioc.Value) != 40 && len(ioc.Value) != 64 {
+			return ioc, fmt.Errorf("invalid hash %q (expected MD5, SHA-1 or SHA-256)", ioc.Value)
+		}
+		if ioc.Type == IOCJA3 && len(ioc.Value) != 32 {
+			return ioc, fmt.Errorf("invalid ja3 %q (expected MD5)", ioc.Value)
+		}
+	case IOCUserAgent:
+	case IOCPort:
+		p, err := strconv.Atoi(ioc.Value)
+		if err != nil || p < 1 || p > 65535 {
+			return ioc, fmt.Errorf("invalid port %q", ioc.Value)
+		}
+		ioc.Value = strconv.Itoa(p)
+	default:
+		return ioc, fmt.Errorf("unknown ioc type %q", ioc.Type)
+	}
+	return ioc, nil
+}
+
+// MergeIOCs normalizes found (reported by stepID) and merges it into existing,
+// deduplicating by type and value. Merged entries gain stepID and keep their first
+// non-empty context. Invalid entries are skipped and returned as errors. existing
+// is not modified; the merged list is a copy.
+func MergeIOCs(existing []IOC, found []IOC, stepID int) ([]IOC, []error) {
+	merged := make([]IOC, len(existing), len(existing)+len(found))
+	index := make(map[string]int, len(existing))
+	for i, ioc := range existing {
+		ioc.StepIDs = append([]int(nil), ioc.StepIDs...)
+		merged[i] = ioc
+		index[ioc.Key()] = i
+	}
+	var errs []error
+	for _, raw := range found {
+		ioc, err := NormalizeIOC(raw)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if i, ok := index[ioc.Key()]; ok {
+			m := &merged[i]
+			if m.Context == "" {
+				m.Context = ioc.Context
+			}
+			if stepID > 0 && !containsInt(m.StepIDs, stepID) {
+				m.StepIDs = append(m.StepIDs, stepID)
+			}
+			continue
+		}
+		ioc.StepIDs = nil
+		if stepID > 0 {
+			ioc.StepIDs = []int{stepID}
+		}
+		index[ioc.Key()] = len(merged)
+		merged = append(merged, ioc)
+	}
+	return merged, errs
+}
+
+// FormatIOCSection renders IOCs as a Markdown section grouped by type, or "" if there are none.
+func FormatIOCSection(iocs []IOC) string {
+	if len(iocs) == 0 {
+		return ""
+	}
+	order := make(map[string]int, len(IOCTypes))
+	for i, t := range IOCTypes {
+		order[t] = i
+	}
+	sorted := append([]IOC(nil), iocs...)
+	sort.SliceStable(sorted, func(a, b int) bool {
+		return order[sorted[a].Type] < order[sorted[b].Type]
+	})
+
+	var sb strings.Builder
+	sb.WriteString("## Indicators of Compromise\n\n")
+	sb.WriteString("| Type | Value | Context | Steps |\n")
+	sb.WriteString("|------|-------|---------|-------|\n")
+	for _, ioc := range sorted {
+		steps := make([]string, len(ioc.StepIDs))
+		for i, id := range ioc.StepIDs {
+			steps[i] = strconv.Itoa(id)
+		}
+		sb.WriteString(fmt.Sprintf("| %s | `%s` | %s | %s |\n",
+			ioc.Type, escapeTableCell(ioc.Value), escapeTableCell(ioc.Context), strings.Join(steps, ", ")))
+	}
+	return sb.String()
+}
+
+func escapeTableCell(s string) string {
+	s = strings.ReplaceAll(s, "|", "\\|")
+	return strings.ReplaceAll(s, "\n", " ")
+}
+
+func isHex(s string) bool {
+	for _, c := range s {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return s != ""
+}
+
+func containsInt(ids []int, id int) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,82 @@
+package common
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeIOC(t *testing.T) {
+	for _, tt := range []struct {
+		in   IOC
+		want string // normalized value, or "error: " and part of the message
+	}{
+		{IOC{Type: " IP ", Value: "::ffff:10.0.0.1"}, "10.0.0.1"},
+		{IOC{Type: "ip", Value: "2001:DB8::1"}, "2001:db8::1"},
+		{IOC{Type: "domain", Value: "Evil.Example.COM."}, "evil.example.com"},
+		{IOC{Type: "hash", Value: strings.Repeat("AB", 32)}, strings.Repeat("ab", 32)},
+		{IOC{Type: "port", Value: "0443"}, "443"},
+		{IOC{Type: "user_agent", Value: " curl/8.0 "}, "curl/8.0"},
+		{IOC{Type: "ip", Value: "10.0.0.256"}, "error: invalid ip"},
+		{IOC{Type: "domain", Value: "http://evil.example"}, "error: invalid domain"},
+		{IOC{Type: "url", Value: "/x"}, "error: invalid url"},
+		{IOC{Type: "hash", Value: strings.Repeat("a", 33)}, "error: expected MD5, SHA-1 or SHA-256"},
+		{IOC{Type: "ja3", Value: strings.Repeat("g", 32)}, "error: expected hex"},
+		{IOC{Type: "port", Value: "65536"}, "error: invalid port"},
+		{IOC{Type: "email", Value: "a@b.c"}, "error: unknown ioc type"},
+	} {
+		got, err := NormalizeIOC(tt.in)
+		if err != nil {
+			if !strings.HasPrefix(tt.want, "error: ") || !strings.Contains(err.Error(), strings.TrimPrefix(tt.want, "error: ")) {
+				t.Errorf("NormalizeIOC(%+v): %v; want %q", tt.in, err, tt.want)
+			}
+		} else if got.Value != tt.want {
+			t.Errorf("NormalizeIOC(%+v) = %q; want %q", tt.in, got.Value, tt.want)
+		}
+	}
+}
+
+func TestMergeIOCs(t *testing.T) {
+	// Spare capacity would let an in-place append leak into the caller's slice.
+	steps := make([]int, 1, 4)
+	steps[0] = 1
+	existing := []IOC{
+		{Type: IOCIP, Value: "10.0.0.1", StepIDs: steps},
+		{Type: IOCDomain, Value: "evil.example", Context: "C2", StepIDs: []int{1}},
+	}
+	got, errs := MergeIOCs(existing, []IOC{
+		{Type: "IP", Value: "::ffff:10.0.0.1", Context: "beacon"},    // fills the empty context
+		{Type: "domain", Value: "EVIL.example", Context: "phishing"}, // keeps the first context
+		{Type: "port", Value: "4444"},
+		{Type: "port", Value: "4444"},
+		{Type: "port", Value: "nope"},
+	}, 2)
+
+	want := []IOC{
+		{Type: IOCIP, Value: "10.0.0.1", Context: "beacon", StepIDs: []int{1, 2}},
+		{Type: IOCDomain, Value: "evil.example", Context: "C2", StepIDs: []int{1, 2}},
+		{Type: IOCPort, Value: "4444", StepIDs: []int{2}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("MergeIOCs = %+v; want %+v", got, want)
+	}
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "invalid port") {
+		t.Errorf("errs = %v", errs)
+	}
+	if existing[0].Context != "" || !reflect.DeepEqual(steps[:2], []int{1, 0}) {
+		t.Errorf("existing modified: %+v, steps %v", existing[0], steps[:2])
+	}
+}
+
+func TestFormatIOCSection(t *testing.T) {
+	if s := FormatIOCSection(nil); s != "" {
+		t.Errorf("FormatIOCSection(nil) = %q", s)
+	}
+	s := FormatIOCSection([]IOC{
+		{Type: IOCPort, Value: "4444", StepIDs: []int{3}},
+		{Type: IOCIP, Value: "10.0.0.1", Context: "a|b\nc", StepIDs: []int{1, 2}},
+	})
+	if !strings.Contains(s, "| ip | `10.0.0.1` | a\\|b c | 1, 2 |") || strings.Index(s, "| ip |") > strings.Index(s, "| port |") {
+		t.Errorf("FormatIOCSection =\n%s", s)
+	}
+}
@@ -0,0 +1,81 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/cloudwego/eino/schema"
+)
+
+// Generator produces a model reply for the given messages (e.g. a ReAct agent's Generate).
+type Generator func(ctx context.Context, msgs []*schema.Message) (*schema.Message, error)
+
+// ParseJSON extracts the first JSON object from raw and unmarshals it into target.
+func ParseJSON(raw string, target any) error {
+	str, err := ExtractJSON(raw)
+	if err != nil {
+		return fmt.Errorf("extract json: %w", err)
+	}
+	if err := json.Unmarshal([]byte(str), target); err != nil {
+		return fmt.Errorf("unmarshal json: %w", err)
+	}
+	return nil
+}
+
+// RepairOptions configures ParseJSONWithRepair.
+type RepairOptions struct {
+	// SystemPrompt instructs the model how to repair malformed JSON.
+	SystemPrompt string
+	// SchemaHint describes the expected JSON shape, shown to the model on repair.
+	SchemaHint string
+	// MaxAttempts is the number of repair round-trips after the initial parse fails.
+	// Zero disables repair.
+	MaxAttempts int
+	// OnRetry is called before each repair attempt (1-based) with the error that triggered it.
+	OnRetry func(attempt int, err error)
+}
+
+// ParseJSONWithRepair parses raw into target. On failure it sends the malformed
+// output and the parse error back to the model via gen, asking for valid JSON,
+// up to opts.MaxAttempts times. It returns the last parse error if every attempt fails.
+// target must be a non-nil pointer; it is only written once a reply parses, so
+// fields decoded from a rejected reply never reach the result.
+func ParseJSONWithRepair(ctx context.Context, gen Generator, raw string, target any, opts RepairOptions) error {
+	ptr := reflect.ValueOf(target)
+	if ptr.Kind() != reflect.Pointer || ptr.IsNil() {
+		return fmt.Errorf("parse json: target must be a non-nil pointer, got %T", target)
+	}
+	parse := func(raw string) error {
+		fresh := reflect.New(ptr.Type().Elem())
+		if err := ParseJSON(raw, fresh.Interface()); err != nil {
+			return err
+		}
+		ptr.Elem().Set(fresh.Elem())
+		return nil
+	}
+
+	err := parse(raw)
+	for attempt := 1; err != nil && attempt <= opts.MaxAttempts; attempt++ {
+		if opts.OnRetry != nil {
+			opts.OnRetry(attempt, err)
+		}
+		msgs := []*schema.Message{
+			schema.SystemMessage(opts.SystemPrompt),
+			schema.UserMessage(fmt.Sprintf(
+				"## Parse Error\n\n%v\n\n## Expected Format\n\n%s\n\n## Malformed Reply\n\n%s",
+				err, opts.SchemaHint, raw)),
+		}
+		out, genErr := gen(ctx, msgs)
+		if genErr != nil {
+			return fmt.Errorf("repair attempt %d: %w", attempt, genErr)
+		}
+		raw = out.Content
+		err = parse(raw)
+	}
+	if err != nil && opts.MaxAttempts > 0 {
+		return fmt.Errorf("still invalid after %d repair attempts: %w", opts.MaxAttempts, err)
+	}
+	return err
+}
@@ -0,0 +1,98 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/cloudwego/eino/schema"
+)
+
+type answer struct {
+	Findings string `json:"findings"`
+}
+
+// scriptedGen replies with replies in order and records the prompts it was sent.
+func scriptedGen(replies ...string) (Generator, *[][]*schema.Message) {
+	var sent [][]*schema.Message
+	return func(_ context.Context, msgs []*schema.Message) (*schema.Message, error) {
+		sent = append(sent, msgs)
+		if len(sent) > len(replies) {
+			return nil, errors.New("model down")
+		}
+		return schema.AssistantMessage(replies[len(sent)-1], nil), nil
+	}, &sent
+}
+
+func TestParseJSON(t *testing.T) {
+	for _, raw := range []string{`{"findings": "x"}`, "```json\n{\"findings\": \"x\"}\n```", `see {"findings": "x"} above`} {
+		var a answer
+		if err := ParseJSON(raw, &a); err != nil || a.Findings != "x" {
+			t.Errorf("ParseJSON(%q) = %+v, %v", raw, a, err)
+		}
+	}
+	if err := ParseJSON("no json here", &answer{}); err == nil {
+		t.Error("ParseJSON without an object succeeded")
+	}
+}
+
+func TestParseJSONWithRepair(t *testing.T) {
+	ctx := context.Background()
+	gen, sent := scriptedGen(`{"findings": broken`, `{"findings":"fixed"}`)
+	var retries []int
+	var a answer
+	err := ParseJSONWithRepair(ctx, gen, `{"findings": "x",}`, &a, RepairOptions{
+		SystemPrompt: "repair it",
+		SchemaHint:   `{"findings": "..."}`,
+		MaxAttempts:  3,
+		OnRetry:      func(attempt int, _ error) { retries = append(retries, attempt) },
+	})
+	if err != nil || a.Findings != "fixed" || len(*sent) != 2 || len(retries) != 2 {
+		t.Fatalf("parsed %+v, %v after %d repairs, retries %v", a, err, len(*sent), retries)
+	}
+	if first := (*sent)[0]; first[0].Content != "repair it" || !strings.Contains(first[1].Content, `{"findings": "..."}`) {
+		t.Errorf("repair prompt = %q", first[1].Content)
+	}
+	// The second attempt shows the model its own broken reply.
+	if !strings.Contains((*sent)[1][1].Content, `{"findings": broken`) {
+		t.Errorf("second repair prompt = %q", (*sent)[1][1].Content)
+	}
+
+	gen, sent = scriptedGen()
+	if err := ParseJSONWithRepair(ctx, gen, `{"findings":"ok"}`, &a, RepairOptions{MaxAttempts: 2}); err != nil || len(*sent) != 0 {
+		t.Errorf("valid input: %v after %d repairs", err, len(*sent))
+	}
+	if err := ParseJSONWithRepair(ctx, gen, "garbage", &a, RepairOptions{}); err == nil || len(*sent) != 0 {
+		t.Errorf("repair disabled: %v after %d repairs", err, len(*sent))
+	}
+	gen, sent = scriptedGen("nope", "still nope")
+	if err := ParseJSONWithRepair(ctx, gen, "garbage", &a, RepairOptions{MaxAttempts: 2}); err == nil ||
+		!strings.Contains(err.Error(), "after 2 repair attempts") {
+		t.Errorf("exhausted: %v after %d repairs", err, len(*sent))
+	}
+	gen, _ = scriptedGen()
+	if err := ParseJSONWithRepair(ctx, gen, "garbage", &a, RepairOptions{MaxAttempts: 2}); err == nil ||
+		!strings.Contains(err.Error(), "repair attempt 1: model down") {
+		t.Errorf("generator error: %v", err)
+	}
+}
+
+func TestParseJSONWithRepairDiscardsRejectedReplies(t *testing.T) {
+	type result struct {
+		Findings string `json:"findings"`
+		Count    int    `json:"count"`
+	}
+	// The first reply fails on count but still decodes findings.
+	gen, _ := scriptedGen(`{"count": 2}`)
+	var r result
+	if err := ParseJSONWithRepair(context.Background(), gen, `{"findings": "stale", "count": "two"}`, &r, RepairOptions{MaxAttempts: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if r != (result{Count: 2}) {
+		t.Errorf("parsed %+v; want only the accepted reply's fields", r)
+	}
+	if err := ParseJSONWithRepair(context.Background(), gen, "{}", r, RepairOptions{}); err == nil {
+		t.Error("non-pointer target accepted")
+	}
+}
@@ -3,12 +3,68 @@ package common
 import (
 	"encoding/json"
 	"strings"
+	"time"
 )
 
 // Step represents a single execution step in the investigation plan.
+//
+// DependsOn lists the step IDs whose findings this step needs. A nil slice
+// (field omitted by the planner) means "depends on the previous step", which
+// keeps legacy sequential plans working; an explicit empty list means the step
+// only needs the PCAP and may run in parallel with other independent steps.
 type Step struct {
-	StepID int    `json:"step_id"`
-	Intent string `json:"intent"`
+	StepID    int     `json:"step_id"`
+	Intent    string  `json:"intent"`
+	DependsOn []int   `json:"depends_on"`
+	Budget    *Budget `json:"budget,omitempty"` // overrides the executor's default budget
+}
+
+// Budget limits the resources a single step may consume. Zero fields are unlimited
+// (or inherit the executor default when used as a per-step override).
+type Budget struct {
+	MaxToolCalls int `json:"max_tool_calls,omitempty"` // tool invocations within the step's ReAct loop
+	TimeoutSec   int `json:"timeout_sec,omitempty"`    // wall-clock deadline for the step
+	MaxTokens    int `json:"max_tokens,omitempty"`     // total model tokens (prompt + completion)
+}
+
+// Merge returns b with zero fields filled from defaults. A nil b yields defaults.
+func (b *Budget) Merge(defaults Budget) Budget {
+	if b == nil {
+		return defaults
+	}
+	out := *b
+	if out.MaxToolCalls <= 0 {
+		out.MaxToolCalls = defaults.MaxToolCalls
+	}
+	if out.TimeoutSec <= 0 {
+		out.TimeoutSec = defaults.TimeoutSec
+	}
+	if out.MaxTokens <= 0 {
+		out.MaxTokens = defaults.MaxTokens
+	}
+	return out
+}
+
+// Step statuses recorded for each executed step.
+const (
+	StepCompleted       = "completed"
+	StepBudgetExhausted = "budget_exhausted"
+	StepSkipped         = "skipped" // dropped by the replanner before it ran
+)
+
+// StepRecord is the audit record of one executed (or skipped) step.
+type StepRecord struct {
+	StepID           int       `json:"step_id"`
+	Intent           string    `json:"intent"`
+	Findings         string    `json:"findings"`
+	Actions          string    `json:"actions"`
+	Status           string    `json:"status"`
+	StartedAt        time.Time `json:"started_at"`
+	FinishedAt       time.Time `json:"finished_at"`
+	ToolCalls        int       `json:"tool_calls"`
+	PromptTokens     int       `json:"prompt_tokens"`
+	CompletionTokens int       `json:"completion_tokens"`
+	TotalTokens      int       `json:"total_tokens"`
 }
 
 // Plan is the top-level structure returned by the Planner LLM.
@@ -19,19 +75,25 @@ type Plan struct {
 }
 
 // PlanState is the mutable state carried through the executor graph loop.
+// It is JSON-serializable so it can be checkpointed and resumed.
 type PlanState struct {
-	Plan             Plan
-	TableSchema      string
-	CurrentStepIndex int
-	ResearchFindings string
-	OperationLog     []string
-	EndOutput        string
+	Plan             Plan         `json:"plan"`
+	TableSchema      string       `json:"table_schema"`
+	Completed        map[int]bool `json:"completed"`           // step IDs whose findings have been merged
+	Batch            []Step       `json:"batch,omitempty"`     // steps running in the current parallel wave
+	Revisions        int          `json:"revisions,omitempty"` // number of accepted replanner revisions
+	ResearchFindings string       `json:"research_findings"`
+	OperationLog     []string     `json:"operation_log"`
+	Records          []StepRecord `json:"records,omitempty"` // per-step audit records, in completion order
+	IOCs             []IOC        `json:"iocs,omitempty"`    // deduplicated indicators from all steps
+	EndOutput        string       `json:"end_output,omitempty"`
 }
 
 // NormalOutput is the parsed JSON output from a NormalExecutor step.
 type NormalOutput struct {
 	Findings  FlexString `json:"findings"`
 	MyActions FlexString `json:"my_actions"`
+	IOCs      []IOC      `json:"iocs,omitempty"`
 }
 
 // FlexString handles LLM returning either a string or []string, unifying to string.
@@ -57,9 +119,11 @@ func (f FlexString) String() string {
 }
 
 // SessionHistory holds accumulated context from previous rounds, fed into the next planner.
+// It is assembled within a token budget: recent rounds verbatim, older rounds as digests.
 type SessionHistory struct {
-	Findings       string   // Cumulative research findings from all previous rounds
-	OperationLog   string   // Concatenated operation logs from all previous rounds
-	PreviousReport string   // The report from the most recent round
-	AllReports     []string // All reports from all rounds (for reference)
+	Findings       string // Research findings of the recent rounds kept verbatim
+	OperationLog   string // Operation logs of the recent rounds kept verbatim
+	Digests        string // Digests of older rounds, oldest first
+	OmittedRounds  int    // Number of oldest rounds dropped because they did not fit the budget
+	PreviousReport string // The report from the most recent round (possibly truncated)
 }
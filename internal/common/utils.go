@@ -45,7 +45,15 @@ func FormatPlanOverview(p Plan) string {
 	sb.WriteString("## Investigation Plan\n\n")
 	sb.WriteString("**Planner Thought**: " + p.Thought + "\n\n")
 	for _, s := range p.Steps {
-		sb.WriteString(fmt.Sprintf("- **Step %d**: %s\n", s.StepID, s.Intent))
+		sb.WriteString(fmt.Sprintf("- **Step %d**: %s", s.StepID, s.Intent))
+		if len(s.DependsOn) > 0 {
+			deps := make([]string, len(s.DependsOn))
+			for i, d := range s.DependsOn {
+				deps[i] = fmt.Sprintf("%d", d)
+			}
+			sb.WriteString(" _(depends on: " + strings.Join(deps, ", ") + ")_")
+		}
+		sb.WriteString("\n")
 	}
 	return sb.String()
 }
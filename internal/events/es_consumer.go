@@ -11,6 +11,9 @@ import (
 type ESConsumer struct {
 	es    *elasticsearch.Client
 	index string
+
+	emitter Emitter
+	ch      <-chan Event
 }
 
 // NewESConsumer creates a consumer that forwards events to ES.
@@ -23,14 +26,25 @@ func NewESConsumer(es *elasticsearch.Client, index string) *ESConsumer {
 }
 
 // Start begins consuming events from the emitter in a background goroutine.
-// Returns immediately. The goroutine exits when the emitter is closed.
+// Returns immediately. The goroutine exits when the emitter is closed or Stop is
+// called. ES writes can lag behind a burst of events, so the subscription blocks
+// the emitter (up to the default timeout) rather than dropping findings.
 func (c *ESConsumer) Start(emitter Emitter) {
-	ch := emitter.Subscribe()
-	go func() {
+	c.emitter = emitter
+	c.ch = emitter.SubscribeWith(&SubscribeOptions{Name: "elasticsearch", Policy: Block})
+	go func(ch <-chan Event) {
 		for evt := range ch {
 			if err := logger.SendWrappedLog(c.es, c.index, evt.Type, evt); err != nil {
 				logger.Warnf("[ESConsumer] failed to write event (type=%s): %v", evt.Type, err)
 			}
 		}
-	}()
+	}(c.ch)
+}
+
+// Stop unsubscribes the consumer; events already received are still written.
+func (c *ESConsumer) Stop() {
+	if c.emitter != nil {
+		c.emitter.Unsubscribe(c.ch)
+		c.emitter = nil
+	}
 }
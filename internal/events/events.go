@@ -1,36 +1,60 @@
 package events
 
 import (
+	"context"
 	"encoding/json"
-	"sync"
+	"pcap_agent/internal/common"
+	"pcap_agent/pkg/logger"
 	"time"
 )
 
 // Event types for frontend consumption.
 const (
 	// Planner events
-	TypePlanCreated = "plan.created"
-	TypePlanError   = "plan.error"
+	TypePlanCreated  = "plan.created"
+	TypePlanRevised  = "plan.revised"
+	TypePlanModified = "plan.modified"
+	TypePlanError    = "plan.error"
 
 	// Executor events
 	TypeStepStarted   = "step.started"
 	TypeStepFindings  = "step.findings"
 	TypeStepCompleted = "step.completed"
 	TypeStepError     = "step.error"
+	TypeStepRetry     = "step.retry"
+	TypeStepBudget    = "step.budget_exhausted"
+
+	// Tool calls (emitted by the sandbox tools)
+	TypeToolStarted  = "tool.started"
+	TypeToolFinished = "tool.finished"
+
+	// Round lifecycle (emitted by the HTTP API server)
+	TypeRoundStarted   = "round.started"
+	TypeRoundCompleted = "round.completed"
+	TypeRoundFailed    = "round.failed"
 
 	// Final
+	TypeReportDelta     = "report.delta"
 	TypeReportGenerated = "report.generated"
 
 	// General
-	TypeInfo  = "info"
-	TypeError = "error"
+	TypeInfo          = "info"
+	TypeError         = "error"
+	TypeEventsDropped = "events.dropped" // sent only to the subscriber that lost events
 )
 
 // Event is the unified event structure sent to consumers (CLI printer, SSE endpoint, etc.).
 // Data is a json.RawMessage so consumers can decode it based on Type.
+// Seq is the event's position in its session's durable log, starting at 1; it is
+// 0 for events that were not persisted. Round, RunID and StepID correlate the
+// event with the round run and plan step that emitted it (see NewCtxEvent).
 type Event struct {
 	Type      string          `json:"type"`
 	SessionID string          `json:"session_id,omitempty"`
+	Seq       int64           `json:"seq,omitempty"`
+	Round     int             `json:"round,omitempty"`
+	RunID     string          `json:"run_id,omitempty"`
+	StepID    int             `json:"step_id,omitempty"`
 	Timestamp time.Time       `json:"timestamp"`
 	Data      json.RawMessage `json:"data"`
 }
@@ -49,6 +73,17 @@ func NewEvent(eventType string, sessionID string, data any) Event {
 	}
 }
 
+// NewCtxEvent is NewEvent with the session, round, run and step IDs taken from
+// ctx (see logger.WithRun and logger.WithStep).
+func NewCtxEvent(ctx context.Context, eventType string, data any) Event {
+	f := logger.FieldsFrom(ctx)
+	ev := NewEvent(eventType, f.SessionID, data)
+	ev.Round = f.Round
+	ev.RunID = f.RunID
+	ev.StepID = f.StepID
+	return ev
+}
+
 // --- Typed event data structs (frontend-friendly JSON) ---
 
 type PlanCreatedData struct {
@@ -58,8 +93,24 @@ type PlanCreatedData struct {
 }
 
 type StepInfo struct {
-	StepID int    `json:"step_id"`
-	Intent string `json:"intent"`
+	StepID    int    `json:"step_id"`
+	Intent    string `json:"intent"`
+	DependsOn []int  `json:"depends_on,omitempty"`
+}
+
+// PlanModifiedData records that an analyst edited the plan before execution.
+type PlanModifiedData struct {
+	Edits      []string   `json:"edits"`
+	TotalSteps int        `json:"total_steps"`
+	Steps      []StepInfo `json:"steps"`
+}
+
+type PlanRevisedData struct {
+	Revision int        `json:"revision"`
+	Thought  string     `json:"thought"`
+	Dropped  []int      `json:"dropped,omitempty"`
+	Added    []int      `json:"added,omitempty"`
+	Steps    []StepInfo `json:"steps"`
 }
 
 type StepStartedData struct {
@@ -69,17 +120,35 @@ type StepStartedData struct {
 }
 
 type StepFindingsData struct {
-	StepID   int    `json:"step_id"`
-	Intent   string `json:"intent"`
-	Findings string `json:"findings"`
-	Actions  string `json:"actions"`
+	StepID   int          `json:"step_id"`
+	Intent   string       `json:"intent"`
+	Findings string       `json:"findings"`
+	Actions  string       `json:"actions"`
+	IOCs     []common.IOC `json:"iocs,omitempty"` // indicators reported by this step, normalized
+}
+
+type BudgetExhaustedData struct {
+	StepID      int    `json:"step_id"`
+	Intent      string `json:"intent"`
+	Reason      string `json:"reason"`
+	ToolCalls   int    `json:"tool_calls"`
+	TotalTokens int    `json:"total_tokens"`
+	ElapsedMs   int64  `json:"elapsed_ms"`
+}
+
+// ReportDeltaData is one streamed chunk of the final report. Seq starts at 1 and
+// increases by one per chunk so consumers can detect dropped deltas.
+type ReportDeltaData struct {
+	Seq   int    `json:"seq"`
+	Delta string `json:"delta"`
 }
 
 type ReportData struct {
-	Report     string `json:"report"`
-	ContentLen int    `json:"content_length"`
-	TotalSteps int    `json:"total_steps"`
-	DurationMs int64  `json:"duration_ms"`
+	Report     string       `json:"report"`
+	ContentLen int          `json:"content_length"`
+	TotalSteps int          `json:"total_steps"`
+	DurationMs int64        `json:"duration_ms"`
+	IOCs       []common.IOC `json:"iocs,omitempty"` // deduplicated indicators from all steps
 }
 
 type ErrorData struct {
@@ -88,6 +157,54 @@ type ErrorData struct {
 	StepID  int    `json:"step_id,omitempty"`
 }
 
+type RetryData struct {
+	Phase       string `json:"phase"`
+	StepID      int    `json:"step_id,omitempty"`
+	Attempt     int    `json:"attempt"`
+	MaxAttempts int    `json:"max_attempts"`
+	Error       string `json:"error"`
+}
+
+// ToolStartedData announces a tool call. CallID pairs it with its tool.finished
+// event when several steps run tools concurrently.
+type ToolStartedData struct {
+	CallID  string `json:"call_id,omitempty"`
+	Tool    string `json:"tool"`
+	Command string `json:"command"` // shell command, or editor command and path
+}
+
+// ToolFinishedData reports how a tool call ended. ExitCode is the shell exit
+// status (for the editor, 0 on success and 1 on error); it is -1 when the
+// command could not be run at all, with the reason in Error. Preview is the
+// start of the output, truncated.
+type ToolFinishedData struct {
+	CallID      string `json:"call_id,omitempty"`
+	Tool        string `json:"tool"`
+	Command     string `json:"command"`
+	ExitCode    int    `json:"exit_code"`
+	Error       string `json:"error,omitempty"`
+	DurationMs  int64  `json:"duration_ms"`
+	StdoutBytes int    `json:"stdout_bytes"`
+	StderrBytes int    `json:"stderr_bytes"`
+	Preview     string `json:"preview,omitempty"`
+}
+
+// RoundData identifies a round submitted through the HTTP API. Round is the
+// expected number when started and the allocated one when completed.
+type RoundData struct {
+	Round int    `json:"round"`
+	Query string `json:"query"`
+	Error string `json:"error,omitempty"`
+}
+
+// DroppedData tells a subscriber that it lost events because its buffer was full.
+type DroppedData struct {
+	Subscriber   string `json:"subscriber"`
+	Policy       string `json:"policy"`
+	Dropped      uint64 `json:"dropped"`       // events lost since the previous notice
+	TotalDropped uint64 `json:"total_dropped"` // events lost since subscribing
+}
+
 type InfoData struct {
 	Message string `json:"message"`
 }
@@ -98,69 +215,80 @@ type InfoData struct {
 // write to ES, or stream via SSE.
 type Emitter interface {
 	Emit(event Event)
+	// Subscribe returns a channel that receives emitted events, with the default options.
 	Subscribe() <-chan Event
+	// SubscribeWith is Subscribe with a name, buffer size and overflow policy.
+	SubscribeWith(opts *SubscribeOptions) <-chan Event
+	// Unsubscribe stops delivery to a subscribed channel and closes it.
+	Unsubscribe(ch <-chan Event)
 	Close()
 }
 
 // ChannelEmitter is a buffered channel-based Emitter.
 type ChannelEmitter struct {
-	ch     chan Event
-	subs   []chan Event
-	mu     sync.RWMutex
-	closed bool
+	fanout *Fanout
 }
 
-// NewChannelEmitter creates a new emitter with the given buffer size.
+// NewChannelEmitter creates a new emitter whose subscribers get bufSize-slot
+// buffers unless they ask otherwise.
 func NewChannelEmitter(bufSize int) *ChannelEmitter {
-	if bufSize <= 0 {
-		bufSize = 256
-	}
-	return &ChannelEmitter{
-		ch: make(chan Event, bufSize),
-	}
+	return &ChannelEmitter{fanout: NewFanout(bufSize)}
 }
 
-// Emit publishes an event to all subscribers. Non-blocking: drops if subscriber is full.
+// Emit publishes an event to all subscribers, applying each subscriber's overflow
+// policy when its buffer is full.
 func (e *ChannelEmitter) Emit(event Event) {
-	e.mu.RLock()
-	defer e.mu.RUnlock()
-	if e.closed {
-		return
-	}
-	for _, sub := range e.subs {
-		select {
-		case sub <- event:
-		default:
-			// drop if subscriber can't keep up
-		}
-	}
+	e.fanout.Publish(event)
 }
 
-// Subscribe returns a channel that receives all emitted events.
+// Subscribe returns a channel that receives all emitted events. Events that do
+// not fit its buffer are dropped.
 func (e *ChannelEmitter) Subscribe() <-chan Event {
-	e.mu.Lock()
-	defer e.mu.Unlock()
-	ch := make(chan Event, 256)
-	e.subs = append(e.subs, ch)
-	return ch
+	return e.fanout.Subscribe(nil)
+}
+
+// SubscribeWith returns a channel that receives all emitted events, buffered and
+// handled on overflow as opts says.
+func (e *ChannelEmitter) SubscribeWith(opts *SubscribeOptions) <-chan Event {
+	return e.fanout.Subscribe(opts)
+}
+
+// Unsubscribe stops delivery to ch and closes it.
+func (e *ChannelEmitter) Unsubscribe(ch <-chan Event) {
+	e.fanout.Unsubscribe(ch)
+}
+
+// Stats returns the delivery counters of every subscriber.
+func (e *ChannelEmitter) Stats() []SubscriberStats {
+	return e.fanout.Stats()
 }
 
 // Close closes all subscriber channels.
 func (e *ChannelEmitter) Close() {
-	e.mu.Lock()
-	defer e.mu.Unlock()
-	if e.closed {
-		return
-	}
-	e.closed = true
-	for _, sub := range e.subs {
-		close(sub)
-	}
+	e.fanout.Close()
 }
 
 // NopEmitter is a no-op emitter for when event reporting is not needed.
 type NopEmitter struct{}
 
-func (NopEmitter) Emit(Event)              {}
-func (NopEmitter) Subscribe() <-chan Event { return make(chan Event) }
-func (NopEmitter) Close()                  {}
+func (NopEmitter) Emit(Event)                                   {}
+func (NopEmitter) Subscribe() <-chan Event                      { return make(chan Event) }
+func (NopEmitter) SubscribeWith(*SubscribeOptions) <-chan Event { return make(chan Event) }
+func (NopEmitter) Unsubscribe(<-chan Event)                     {}
+func (NopEmitter) Close()                                       {}
+
+type emitterKey struct{}
+
+// WithEmitter returns a context carrying em, so code that has no emitter of its
+// own, such as the sandbox tools, can report events for the current round.
+func WithEmitter(ctx context.Context, em Emitter) context.Context {
+	return context.WithValue(ctx, emitterKey{}, em)
+}
+
+// EmitterFrom returns the emitter carried by ctx, or a NopEmitter.
+func EmitterFrom(ctx context.Context) Emitter {
+	if em, ok := ctx.Value(emitterKey{}).(Emitter); ok && em != nil {
+		return em
+	}
+	return NopEmitter{}
+}
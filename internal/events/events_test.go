@@ -0,0 +1,32 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"pcap_agent/pkg/logger"
+)
+
+func TestNewCtxEvent(t *testing.T) {
+	ctx := logger.WithStep(logger.WithRun(context.Background(), "sess_1", 2, "abc"), 3)
+	ev := NewCtxEvent(ctx, TypeInfo, InfoData{Message: "hi"})
+	if ev.Type != TypeInfo || ev.SessionID != "sess_1" || ev.Round != 2 || ev.RunID != "abc" || ev.StepID != 3 {
+		t.Errorf("event = %+v", ev)
+	}
+	var d InfoData
+	if err := json.Unmarshal(ev.Data, &d); err != nil || d.Message != "hi" {
+		t.Errorf("data = %s, %v", ev.Data, err)
+	}
+
+	// Without fields the IDs stay empty, and are left out of the JSON.
+	ev = NewCtxEvent(context.Background(), TypeInfo, nil)
+	if ev.SessionID != "" || ev.Round != 0 || ev.RunID != "" || ev.StepID != 0 {
+		t.Errorf("event = %+v", ev)
+	}
+	b, _ := json.Marshal(ev)
+	if s := string(b); strings.Contains(s, "session_id") || strings.Contains(s, "run_id") || strings.Contains(s, "step_id") {
+		t.Errorf("JSON = %s", s)
+	}
+}
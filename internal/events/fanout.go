@@ -0,0 +1,343 @@
+package events
+
+import (
+	"expvar"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"pcap_agent/pkg/logger"
+)
+
+// OverflowPolicy decides what happens to an event when a subscriber's buffer is full.
+type OverflowPolicy int
+
+const (
+	// DropNewest discards the event that does not fit (the default).
+	DropNewest OverflowPolicy = iota
+	// DropOldest discards the oldest buffered event to make room.
+	DropOldest
+	// Block waits up to the subscriber's timeout for room, then discards the event.
+	// The Emit delivering the event is held up meanwhile (other subscribers already
+	// have it), so use it only for consumers that must not miss events.
+	Block
+)
+
+func (p OverflowPolicy) String() string {
+	switch p {
+	case DropNewest:
+		return "drop-newest"
+	case DropOldest:
+		return "drop-oldest"
+	case Block:
+		return "block"
+	}
+	return fmt.Sprintf("OverflowPolicy(%d)", int(p))
+}
+
+// ParseOverflowPolicy parses the String form of a policy.
+func ParseOverflowPolicy(s string) (OverflowPolicy, error) {
+	for _, p := range []OverflowPolicy{DropNewest, DropOldest, Block} {
+		if p.String() == s {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown overflow policy %q (want drop-newest, drop-oldest or block)", s)
+}
+
+const (
+	DefaultSubscriberBuffer = 256
+	DefaultBlockTimeout     = 5 * time.Second
+)
+
+// SubscribeOptions configures one subscriber.
+type SubscribeOptions struct {
+	Name    string         // identifies the subscriber in stats, metrics and events.dropped notices
+	Buffer  int            // channel buffer size (default 256, or the emitter's default)
+	Policy  OverflowPolicy // what to do when the buffer is full
+	Timeout time.Duration  // how long Block waits for room (default 5s)
+}
+
+func (o *SubscribeOptions) GetName() string {
+	if o == nil || o.Name == "" {
+		return "anonymous"
+	}
+	return o.Name
+}
+
+func (o *SubscribeOptions) GetBuffer() int {
+	if o == nil || o.Buffer <= 0 {
+		return DefaultSubscriberBuffer
+	}
+	return o.Buffer
+}
+
+func (o *SubscribeOptions) GetPolicy() OverflowPolicy {
+	if o == nil {
+		return DropNewest
+	}
+	return o.Policy
+}
+
+func (o *SubscribeOptions) GetTimeout() time.Duration {
+	if o == nil || o.Timeout <= 0 {
+		return DefaultBlockTimeout
+	}
+	return o.Timeout
+}
+
+// SubscriberStats are the delivery counters of one subscriber.
+type SubscriberStats struct {
+	Name      string `json:"name"`
+	Policy    string `json:"policy"`
+	Buffered  int    `json:"buffered"`  // events waiting in the channel
+	Delivered uint64 `json:"delivered"` // events (and drop notices) put in the channel
+	Dropped   uint64 `json:"dropped"`   // events discarded by the overflow policy
+}
+
+// metrics exposes process-wide delivery counters through expvar (GET /debug/vars
+// in serve mode): "delivered", "dropped", and "dropped.<subscriber name>".
+var metrics = expvar.NewMap("pcap_agent_events")
+
+// subscriber is one channel of a Fanout.
+type subscriber struct {
+	ch      chan Event
+	name    string
+	policy  OverflowPolicy
+	timeout time.Duration
+
+	done      chan struct{} // closed on unsubscribe to abandon a Block wait
+	closeOnce sync.Once
+
+	delivered atomic.Uint64
+	dropped   atomic.Uint64
+
+	mu      sync.Mutex // serializes deliveries and guards closed and pending
+	closed  bool
+	pending uint64 // drops not yet reported by an events.dropped notice
+}
+
+// Fanout delivers events to subscribers, each with its own buffer and overflow
+// policy. A subscriber that lost events receives an events.dropped notice before
+// its next event, as soon as its buffer has room for both. Fanout is the delivery
+// half of ChannelEmitter, exported so other Emitters share its semantics. It is
+// safe for concurrent use; each subscriber receives events in Publish order.
+//
+// Delivery happens outside the Fanout's lock, so a Block subscriber that is
+// waiting for room holds up only the Publish delivering to it: other subscribers,
+// Subscribe, Unsubscribe and Stats carry on.
+type Fanout struct {
+	mu         sync.Mutex
+	subs       []*subscriber // copied on removal so Publish can iterate a snapshot
+	defaultBuf int
+	closed     bool
+}
+
+// NewFanout creates a Fanout whose subscribers get defaultBuf-slot buffers
+// unless their options say otherwise (256 if defaultBuf <= 0).
+func NewFanout(defaultBuf int) *Fanout {
+	if defaultBuf <= 0 {
+		defaultBuf = DefaultSubscriberBuffer
+	}
+	return &Fanout{defaultBuf: defaultBuf}
+}
+
+// Subscribe adds a subscriber. nil options use the defaults (drop-newest). After
+// Close it returns a closed channel.
+func (f *Fanout) Subscribe(opts *SubscribeOptions) <-chan Event {
+	buf := f.defaultBuf
+	if opts != nil && opts.Buffer > 0 {
+		buf = opts.Buffer
+	}
+	sub := &subscriber{
+		ch:      make(chan Event, buf),
+		name:    opts.GetName(),
+		policy:  opts.GetPolicy(),
+		timeout: opts.GetTimeout(),
+		done:    make(chan struct{}),
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		sub.close()
+		return sub.ch
+	}
+	f.subs = append(f.subs, sub)
+	return sub.ch
+}
+
+// Unsubscribe stops delivery to ch and closes it, abandoning a delivery that is
+// waiting for room. Unknown channels are ignored.
+func (f *Fanout) Unsubscribe(ch <-chan Event) {
+	f.mu.Lock()
+	var found *subscriber
+	for i, sub := range f.subs {
+		if sub.ch == ch {
+			found = sub
+			f.subs = append(f.subs[:i:i], f.subs[i+1:]...)
+			break
+		}
+	}
+	f.mu.Unlock()
+	if found != nil {
+		found.close()
+	}
+}
+
+// Publish delivers ev to every subscriber according to its policy. Subscribers
+// that never wait get the event first; Block subscribers with a full buffer
+// then wait concurrently, and Publish returns when all of them are done.
+func (f *Fanout) Publish(ev Event) {
+	f.mu.Lock()
+	subs, closed := f.subs, f.closed
+	f.mu.Unlock()
+	if closed {
+		return
+	}
+
+	var blocking []*subscriber
+	for _, sub := range subs {
+		if sub.policy == Block {
+			blocking = append(blocking, sub)
+			continue
+		}
+		sub.deliver(ev)
+	}
+	switch len(blocking) {
+	case 0:
+	case 1:
+		blocking[0].deliver(ev)
+	default:
+		var wg sync.WaitGroup
+		for _, sub := range blocking {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				sub.deliver(ev)
+			}()
+		}
+		wg.Wait()
+	}
+}
+
+// Stats returns the counters of every current subscriber, in subscription order.
+func (f *Fanout) Stats() []SubscriberStats {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out := make([]SubscriberStats, len(f.subs))
+	for i, sub := range f.subs {
+		out[i] = SubscriberStats{
+			Name:      sub.name,
+			Policy:    sub.policy.String(),
+			Buffered:  len(sub.ch),
+			Delivered: sub.delivered.Load(),
+			Dropped:   sub.dropped.Load(),
+		}
+	}
+	return out
+}
+
+// Close closes every subscriber channel. Later Publish calls are ignored.
+func (f *Fanout) Close() {
+	f.mu.Lock()
+	if f.closed {
+		f.mu.Unlock()
+		return
+	}
+	f.closed = true
+	subs := f.subs
+	f.subs = nil
+	f.mu.Unlock()
+	for _, sub := range subs {
+		sub.close()
+	}
+}
+
+// close ends delivery and closes the channel, once any delivery in progress has
+// given up.
+func (s *subscriber) close() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.closed = true
+		close(s.ch)
+	})
+}
+
+// deliver puts ev in the subscriber's channel, preceded by a drop notice if
+// events were lost since the last one.
+func (s *subscriber) deliver(ev Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+
+	if s.pending > 0 && cap(s.ch)-len(s.ch) >= 2 {
+		notice := NewEvent(TypeEventsDropped, ev.SessionID, DroppedData{
+			Subscriber:   s.name,
+			Policy:       s.policy.String(),
+			Dropped:      s.pending,
+			TotalDropped: s.dropped.Load(),
+		})
+		notice.Round, notice.RunID = ev.Round, ev.RunID
+		select {
+		case s.ch <- notice:
+			s.delivered.Add(1)
+			s.pending = 0
+		default:
+		}
+	}
+
+	select {
+	case s.ch <- ev:
+		s.delivered.Add(1)
+		metrics.Add("delivered", 1)
+		return
+	default:
+	}
+
+	switch s.policy {
+	case DropOldest:
+		for {
+			select {
+			case <-s.ch:
+				s.drop()
+			default:
+			}
+			select {
+			case s.ch <- ev:
+				s.delivered.Add(1)
+				metrics.Add("delivered", 1)
+				return
+			default:
+			}
+		}
+	case Block:
+		timer := time.NewTimer(s.timeout)
+		defer timer.Stop()
+		select {
+		case s.ch <- ev:
+			s.delivered.Add(1)
+			metrics.Add("delivered", 1)
+			return
+		case <-s.done:
+			return
+		case <-timer.C:
+		}
+	}
+	s.drop()
+}
+
+// drop counts one discarded event, warning on the first of a burst.
+func (s *subscriber) drop() {
+	if s.pending == 0 {
+		logger.Warnf("[Events] subscriber %q (%s) is not keeping up; dropping events", s.name, s.policy)
+	}
+	s.dropped.Add(1)
+	s.pending++
+	metrics.Add("dropped", 1)
+	metrics.Add("dropped."+s.name, 1)
+}
@@ -0,0 +1,224 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// drain returns what is buffered in ch: event payloads, and "dropped:N" for
+// drop notices.
+func drain(t *testing.T, ch <-chan Event) []string {
+	t.Helper()
+	var out []string
+	for {
+		select {
+		case ev, ok := <-ch:
+			if !ok {
+				return out
+			}
+			if ev.Type == TypeEventsDropped {
+				var d DroppedData
+				if err := json.Unmarshal(ev.Data, &d); err != nil {
+					t.Fatal(err)
+				}
+				out = append(out, fmt.Sprintf("dropped:%d", d.Dropped))
+				continue
+			}
+			out = append(out, string(ev.Data))
+		default:
+			return out
+		}
+	}
+}
+
+func publish(f *Fanout, from, to int) {
+	for i := from; i <= to; i++ {
+		f.Publish(NewEvent(TypeInfo, "s", i))
+	}
+}
+
+// waitFor fails the test if done is not closed within a second.
+func waitFor(t *testing.T, done <-chan struct{}, what string) {
+	t.Helper()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("%s did not return", what)
+	}
+}
+
+func TestOverflowPolicies(t *testing.T) {
+	tests := []struct {
+		policy      OverflowPolicy
+		first, next []string
+		delivered   uint64 // includes the drop notice and evicted events
+	}{
+		{DropNewest, []string{"1", "2", "3"}, []string{"dropped:2", "6"}, 5},
+		{DropOldest, []string{"3", "4", "5"}, []string{"dropped:2", "6"}, 7},
+		{Block, []string{"1", "2", "3"}, []string{"dropped:2", "6"}, 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy.String(), func(t *testing.T) {
+			f := NewFanout(0)
+			ch := f.Subscribe(&SubscribeOptions{Name: "sub", Buffer: 3, Policy: tt.policy, Timeout: 10 * time.Millisecond})
+			publish(f, 1, 5)
+			if got := drain(t, ch); !reflect.DeepEqual(got, tt.first) {
+				t.Errorf("full buffer = %v; want %v", got, tt.first)
+			}
+			publish(f, 6, 6)
+			if got := drain(t, ch); !reflect.DeepEqual(got, tt.next) {
+				t.Errorf("after draining = %v; want %v", got, tt.next)
+			}
+			want := SubscriberStats{Name: "sub", Policy: tt.policy.String(), Delivered: tt.delivered, Dropped: 2}
+			if stats := f.Stats(); len(stats) != 1 || stats[0] != want {
+				t.Errorf("Stats() = %+v; want %+v", stats, want)
+			}
+		})
+	}
+}
+
+func TestBlockWaitsForReader(t *testing.T) {
+	f := NewFanout(0)
+	ch := f.Subscribe(&SubscribeOptions{Buffer: 1, Policy: Block, Timeout: time.Minute})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		publish(f, 1, 20)
+	}()
+	var got []string
+	for len(got) < 20 {
+		select {
+		case ev := <-ch:
+			got = append(got, string(ev.Data))
+		case <-time.After(time.Second):
+			t.Fatalf("received %v", got)
+		}
+	}
+	waitFor(t, done, "Publish")
+	if got[0] != "1" || got[19] != "20" || f.Stats()[0].Dropped != 0 {
+		t.Errorf("received %v, stats %+v", got, f.Stats())
+	}
+}
+
+// TestBlockedSubscriberIsolated checks that a Block subscriber waiting for room
+// holds up neither the other subscribers nor the Fanout itself.
+func TestBlockedSubscriberIsolated(t *testing.T) {
+	f := NewFanout(0)
+	blocked := f.Subscribe(&SubscribeOptions{Name: "slow", Buffer: 1, Policy: Block, Timeout: time.Hour})
+	other := f.Subscribe(&SubscribeOptions{Name: "fast"})
+	publish(f, 1, 1) // fills the slow subscriber's buffer
+
+	published := make(chan struct{})
+	go func() {
+		defer close(published)
+		publish(f, 2, 2)
+	}()
+	select {
+	case ev := <-other:
+		if string(ev.Data) != "1" {
+			t.Fatalf("fast subscriber got %s", ev.Data)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("fast subscriber got nothing")
+	}
+	select {
+	case ev := <-other:
+		if string(ev.Data) != "2" {
+			t.Fatalf("fast subscriber got %s", ev.Data)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("fast subscriber did not get the event the slow one is blocking")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		f.Stats()
+		f.Unsubscribe(f.Subscribe(nil))
+	}()
+	waitFor(t, done, "Stats, Subscribe and Unsubscribe")
+
+	// Unsubscribing the slow subscriber abandons the waiting delivery.
+	done = make(chan struct{})
+	go func() {
+		defer close(done)
+		f.Unsubscribe(blocked)
+	}()
+	waitFor(t, done, "Unsubscribe")
+	waitFor(t, published, "Publish")
+	if got := drain(t, blocked); !reflect.DeepEqual(got, []string{"1"}) {
+		t.Errorf("slow subscriber got %v", got)
+	}
+}
+
+func TestSeveralBlockSubscribersWaitTogether(t *testing.T) {
+	f := NewFanout(0)
+	const timeout = 50 * time.Millisecond
+	for range 4 {
+		f.Subscribe(&SubscribeOptions{Buffer: 1, Policy: Block, Timeout: timeout})
+	}
+	publish(f, 1, 1)
+	start := time.Now()
+	publish(f, 2, 2)
+	if elapsed := time.Since(start); elapsed >= 4*timeout {
+		t.Errorf("Publish took %v; Block subscribers waited one after another", elapsed)
+	}
+	for _, s := range f.Stats() {
+		if s.Dropped != 1 {
+			t.Errorf("stats = %+v", s)
+		}
+	}
+}
+
+func TestUnsubscribeAndClose(t *testing.T) {
+	f := NewFanout(0)
+	a, b := f.Subscribe(nil), f.Subscribe(nil)
+	f.Unsubscribe(a)
+	f.Unsubscribe(a) // unknown channels are ignored
+	publish(f, 1, 1)
+	if got := drain(t, a); got != nil {
+		t.Errorf("unsubscribed channel got %v", got)
+	}
+	f.Close()
+	f.Close()
+	publish(f, 2, 2)
+	if got := drain(t, b); !reflect.DeepEqual(got, []string{"1"}) {
+		t.Errorf("subscriber got %v", got)
+	}
+	if _, ok := <-f.Subscribe(nil); ok {
+		t.Error("Subscribe after Close returned an open channel")
+	}
+}
+
+func TestConcurrentPublishAndUnsubscribe(t *testing.T) {
+	f := NewFanout(0)
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			publish(f, 1, 200)
+		}()
+	}
+	for i := range 50 {
+		policy := OverflowPolicy(i % 3)
+		f.Unsubscribe(f.Subscribe(&SubscribeOptions{Buffer: 1, Policy: policy, Timeout: time.Millisecond}))
+	}
+	wg.Wait()
+	f.Close()
+}
+
+func TestParseOverflowPolicy(t *testing.T) {
+	for _, p := range []OverflowPolicy{DropNewest, DropOldest, Block} {
+		if got, err := ParseOverflowPolicy(p.String()); err != nil || got != p {
+			t.Errorf("ParseOverflowPolicy(%q) = %v, %v", p, got, err)
+		}
+	}
+	if _, err := ParseOverflowPolicy("drop-all"); err == nil {
+		t.Error("ParseOverflowPolicy accepted an unknown policy")
+	}
+}
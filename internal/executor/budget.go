@@ -0,0 +1,195 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"pcap_agent/internal/common"
+	"sync"
+	"time"
+
+	"github.com/cloudwego/eino/callbacks"
+	"github.com/cloudwego/eino/components/model"
+	"github.com/cloudwego/eino/components/tool"
+	"github.com/cloudwego/eino/schema"
+	ucb "github.com/cloudwego/eino/utils/callbacks"
+)
+
+// BudgetError is the cancellation cause recorded when a step exceeds its budget.
+type BudgetError struct {
+	Kind   string // "tool_calls", "deadline" or "tokens"
+	Limit  int
+	Actual int
+}
+
+func (e *BudgetError) Error() string {
+	switch e.Kind {
+	case "deadline":
+		return fmt.Sprintf("budget exhausted: wall-clock deadline of %ds reached", e.Limit)
+	case "tool_calls":
+		return fmt.Sprintf("budget exhausted: tool call limit of %d reached", e.Limit)
+	default:
+		return fmt.Sprintf("budget exhausted: %s limit of %d reached (used %d)", e.Kind, e.Limit, e.Actual)
+	}
+}
+
+// budgetTracker counts tool calls and token usage for one ReAct invocation and
+// cancels the invocation's context once a limit is crossed.
+type budgetTracker struct {
+	budget  common.Budget
+	cancel  context.CancelCauseFunc
+	start   time.Time
+	streams sync.WaitGroup // stream usage readers still running
+
+	mu               sync.Mutex
+	toolCalls        int
+	promptTokens     int
+	completionTokens int
+	totalTokens      int
+}
+
+// newBudgetTracker derives a context bounded by budget. The returned cancel func
+// must be called when the invocation finishes.
+func newBudgetTracker(ctx context.Context, budget common.Budget) (context.Context, *budgetTracker, func()) {
+	ctx, cancelCause := context.WithCancelCause(ctx)
+	cancel := func() { cancelCause(nil) }
+	if budget.TimeoutSec > 0 {
+		var cancelTimeout context.CancelFunc
+		ctx, cancelTimeout = context.WithTimeoutCause(ctx, time.Duration(budget.TimeoutSec)*time.Second,
+			&BudgetError{Kind: "deadline", Limit: budget.TimeoutSec})
+		cancel = func() { cancelTimeout(); cancelCause(nil) }
+	}
+	return ctx, &budgetTracker{budget: budget, cancel: cancelCause, start: time.Now()}, cancel
+}
+
+// exhausted returns the BudgetError that cancelled ctx, if any.
+func exhausted(ctx context.Context) *BudgetError {
+	var be *BudgetError
+	if errors.As(context.Cause(ctx), &be) {
+		return be
+	}
+	return nil
+}
+
+// Handler returns a callbacks.Handler that feeds tool and model events into the tracker.
+func (t *budgetTracker) Handler() callbacks.Handler {
+	return ucb.NewHandlerHelper().
+		Tool(&ucb.ToolCallbackHandler{
+			OnStart: func(ctx context.Context, _ *callbacks.RunInfo, _ *tool.CallbackInput) context.Context {
+				t.mu.Lock()
+				over := t.budget.MaxToolCalls > 0 && t.toolCalls >= t.budget.MaxToolCalls
+				if !over {
+					t.toolCalls++
+				}
+				t.mu.Unlock()
+				if over {
+					// WrapToolBudget refuses to run the call, so it is not counted.
+					t.cancel(&BudgetError{Kind: "tool_calls", Limit: t.budget.MaxToolCalls, Actual: t.budget.MaxToolCalls + 1})
+				}
+				return ctx
+			},
+		}).
+		ChatModel(&ucb.ModelCallbackHandler{
+			OnEnd: func(ctx context.Context, _ *callbacks.RunInfo, out *model.CallbackOutput) context.Context {
+				t.addUsage(out.TokenUsage)
+				return ctx
+			},
+			OnEndWithStreamOutput: func(ctx context.Context, _ *callbacks.RunInfo, out *schema.StreamReader[*model.CallbackOutput]) context.Context {
+				// Usage arrives with the last frame. The copy is read in the
+				// background so the caller keeps streaming; Record waits for it.
+				t.streams.Add(1)
+				go func() {
+					defer t.streams.Done()
+					defer out.Close()
+					var last *model.TokenUsage
+					for {
+						frame, err := out.Recv()
+						if err != nil {
+							if !errors.Is(err, io.EOF) {
+								return
+							}
+							break
+						}
+						if frame != nil && frame.TokenUsage != nil {
+							last = frame.TokenUsage
+						}
+					}
+					t.addUsage(last)
+				}()
+				return ctx
+			},
+		}).
+		Handler()
+}
+
+// WrapToolBudget wraps a tool so that a call made after the invocation's budget
+// ran out fails with the *BudgetError instead of running. It must be the
+// outermost wrapper, since a wrapper such as tools.WrapToolSafe would turn the
+// error into a result the agent carries on with.
+func WrapToolBudget(t tool.InvokableTool) tool.InvokableTool {
+	return &budgetToolWrapper{inner: t}
+}
+
+type budgetToolWrapper struct {
+	inner tool.InvokableTool
+}
+
+func (w *budgetToolWrapper) Info(ctx context.Context) (*schema.ToolInfo, error) {
+	return w.inner.Info(ctx)
+}
+
+func (w *budgetToolWrapper) InvokableRun(ctx context.Context, argumentsInJSON string, opts ...tool.Option) (string, error) {
+	if be := exhausted(ctx); be != nil {
+		return "", be
+	}
+	return w.inner.InvokableRun(ctx, argumentsInJSON, opts...)
+}
+
+func (t *budgetTracker) addUsage(u *model.TokenUsage) {
+	if u == nil {
+		return
+	}
+	t.mu.Lock()
+	t.promptTokens += u.PromptTokens
+	t.completionTokens += u.CompletionTokens
+	t.totalTokens += u.TotalTokens
+	total := t.totalTokens
+	t.mu.Unlock()
+	if t.budget.MaxTokens > 0 && total > t.budget.MaxTokens {
+		t.cancel(&BudgetError{Kind: "tokens", Limit: t.budget.MaxTokens, Actual: total})
+	}
+}
+
+// ToolCalls returns the number of tool invocations observed so far.
+func (t *budgetTracker) ToolCalls() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.toolCalls
+}
+
+// Tokens returns the accumulated prompt, completion and total token counts.
+func (t *budgetTracker) Tokens() (prompt, completion, total int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.promptTokens, t.completionTokens, t.totalTokens
+}
+
+// Record builds the audit record of step from the usage observed so far,
+// timed from the start of the invocation until now. It first waits for the
+// usage of streamed model output, so call it once the invocation has returned.
+func (t *budgetTracker) Record(step common.Step, status string) common.StepRecord {
+	t.streams.Wait()
+	prompt, completion, total := t.Tokens()
+	return common.StepRecord{
+		StepID:           step.StepID,
+		Intent:           step.Intent,
+		Status:           status,
+		StartedAt:        t.start.UTC(),
+		FinishedAt:       time.Now().UTC(),
+		ToolCalls:        t.ToolCalls(),
+		PromptTokens:     prompt,
+		CompletionTokens: completion,
+		TotalTokens:      total,
+	}
+}
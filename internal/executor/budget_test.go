@@ -0,0 +1,155 @@
+package executor
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"pcap_agent/internal/common"
+	"pcap_agent/internal/events"
+
+	"github.com/cloudwego/eino/callbacks"
+	"github.com/cloudwego/eino/components"
+	"github.com/cloudwego/eino/components/model"
+	"github.com/cloudwego/eino/components/tool"
+	"github.com/cloudwego/eino/schema"
+)
+
+// meteredModel reports usage tokens per call through callbacks, as real chat
+// models do. Step prompts call the probe tool until it has answered
+// answerAfter times (forever if zero), or block until cancelled if block is set.
+type meteredModel struct {
+	fakeModel
+	usage       int
+	block       bool
+	answerAfter int
+}
+
+func (m *meteredModel) IsCallbacksEnabled() bool { return true }
+
+func (m *meteredModel) Generate(ctx context.Context, in []*schema.Message, _ ...model.Option) (*schema.Message, error) {
+	step := strings.Contains(sysContent(in), "**Current Step:**")
+	if step && m.block {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	ctx = callbacks.OnStart(ctx, &model.CallbackInput{Messages: in})
+	results := 0
+	for _, msg := range in {
+		if msg.Role == schema.Tool {
+			results++
+		}
+	}
+	out := schema.AssistantMessage("final report", nil)
+	switch {
+	case step && m.answerAfter > 0 && results >= m.answerAfter:
+		out = schema.AssistantMessage(`{"findings":"found","my_actions":"did"}`, nil)
+	case step:
+		out = schema.AssistantMessage("", []schema.ToolCall{{ID: "call", Function: schema.FunctionCall{Name: "probe", Arguments: "{}"}}})
+	}
+	callbacks.OnEnd(ctx, &model.CallbackOutput{Message: out, TokenUsage: &model.TokenUsage{TotalTokens: m.usage}})
+	return out, nil
+}
+
+func (m *meteredModel) Stream(ctx context.Context, in []*schema.Message, opts ...model.Option) (*schema.StreamReader[*schema.Message], error) {
+	out, err := m.Generate(ctx, in, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return schema.StreamReaderFromArray([]*schema.Message{out}), nil
+}
+
+func (m *meteredModel) WithTools([]*schema.ToolInfo) (model.ToolCallingChatModel, error) {
+	return m, nil
+}
+
+// probeTool counts its runs.
+type probeTool struct{ runs atomic.Int32 }
+
+func (p *probeTool) Info(context.Context) (*schema.ToolInfo, error) {
+	return &schema.ToolInfo{Name: "probe", Desc: "probe"}, nil
+}
+
+func (p *probeTool) InvokableRun(context.Context, string, ...tool.Option) (string, error) {
+	p.runs.Add(1)
+	return "probed", nil
+}
+
+// eventRecorder keeps every emitted event.
+type eventRecorder struct {
+	events.NopEmitter
+	mu  sync.Mutex
+	evs []events.Event
+}
+
+func (r *eventRecorder) Emit(ev events.Event) {
+	r.mu.Lock()
+	r.evs = append(r.evs, ev)
+	r.mu.Unlock()
+}
+
+func TestBudgetExhaustion(t *testing.T) {
+	tests := []struct {
+		kind   string
+		model  *meteredModel
+		budget common.Budget
+		reason string
+	}{
+		{"tool_calls", &meteredModel{}, common.Budget{MaxToolCalls: 2}, "tool call limit of 2 reached"},
+		{"tokens", &meteredModel{usage: 60}, common.Budget{MaxTokens: 100}, "tokens limit of 100 reached (used 120)"},
+		{"deadline", &meteredModel{block: true}, common.Budget{TimeoutSec: 1}, "wall-clock deadline of 1s reached"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.kind, func(t *testing.T) {
+			probe := &probeTool{}
+			rec := &eventRecorder{}
+			e := NewExecutor(newFakeAgent(t, tt.model, WrapToolBudget(probe)), rec, nil)
+			plan := serialPlan(2)
+			plan.Steps[0].Budget = &tt.budget
+
+			res, err := e.Run(context.Background(), plan, "q", []common.Capture{{Name: "a.pcap", Path: "/a.pcap"}}, nil)
+			if err != nil {
+				t.Fatalf("Run: %v", err)
+			}
+			if s := res.Steps[0]; s.Status != common.StepBudgetExhausted || !strings.Contains(s.Findings, tt.reason) {
+				t.Errorf("step record = %+v", s)
+			}
+			if tt.kind == "tool_calls" && probe.runs.Load() != 2 {
+				t.Errorf("probe ran %d times; want 2", probe.runs.Load())
+			}
+			var reasons []string
+			for _, ev := range rec.evs {
+				if ev.Type == events.TypeStepBudget {
+					var d events.BudgetExhaustedData
+					if err := json.Unmarshal(ev.Data, &d); err != nil {
+						t.Fatal(err)
+					}
+					reasons = append(reasons, d.Reason)
+				}
+			}
+			if want := "budget exhausted: " + tt.reason; len(reasons) != 1 || reasons[0] != want {
+				t.Errorf("step.budget reasons = %q; want %q", reasons, want)
+			}
+		})
+	}
+}
+
+func TestRecordWaitsForStreamUsage(t *testing.T) {
+	_, tracker, cancel := newBudgetTracker(context.Background(), common.Budget{})
+	defer cancel()
+	sr, sw := schema.Pipe[callbacks.CallbackOutput](1)
+	info := &callbacks.RunInfo{Component: components.ComponentOfChatModel}
+	tracker.Handler().OnEndWithStreamOutput(context.Background(), info, sr)
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		sw.Send(&model.CallbackOutput{TokenUsage: &model.TokenUsage{PromptTokens: 7, CompletionTokens: 5, TotalTokens: 12}}, nil)
+		sw.Close()
+	}()
+	if r := tracker.Record(common.Step{StepID: 1}, common.StepCompleted); r.TotalTokens != 12 || r.PromptTokens != 7 {
+		t.Errorf("record = %+v; want the streamed usage", r)
+	}
+}
@@ -0,0 +1,125 @@
+package executor
+
+import (
+	"fmt"
+	"pcap_agent/internal/common"
+)
+
+// stepDeps returns the effective dependencies of the step at index idx.
+// A nil DependsOn means the step depends on its predecessor (legacy sequential plans).
+// The final step always depends on every other step.
+func stepDeps(plan common.Plan, idx int) []int {
+	step := plan.Steps[idx]
+	if idx == len(plan.Steps)-1 {
+		deps := make([]int, 0, idx)
+		for _, s := range plan.Steps[:idx] {
+			deps = append(deps, s.StepID)
+		}
+		return deps
+	}
+	if step.DependsOn == nil {
+		if idx == 0 {
+			return nil
+		}
+		return []int{plan.Steps[idx-1].StepID}
+	}
+	return step.DependsOn
+}
+
+// validateDAG checks that step IDs are unique, that every dependency refers to an
+// existing non-final step other than the step itself, and that the graph is acyclic.
+func validateDAG(plan common.Plan) error {
+	index := make(map[int]int, len(plan.Steps))
+	for i, s := range plan.Steps {
+		if _, dup := index[s.StepID]; dup {
+			return fmt.Errorf("duplicate step_id %d", s.StepID)
+		}
+		index[s.StepID] = i
+	}
+	finalID := plan.Steps[len(plan.Steps)-1].StepID
+
+	for i, s := range plan.Steps[:len(plan.Steps)-1] {
+		for _, d := range stepDeps(plan, i) {
+			if d == s.StepID {
+				return fmt.Errorf("step %d depends on itself", s.StepID)
+			}
+			if d == finalID {
+				return fmt.Errorf("step %d depends on the final step %d", s.StepID, finalID)
+			}
+			if _, ok := index[d]; !ok {
+				return fmt.Errorf("step %d depends on unknown step %d", s.StepID, d)
+			}
+		}
+	}
+
+	// Kahn's algorithm over the non-final steps.
+	n := len(plan.Steps) - 1
+	indegree := make([]int, n)
+	dependents := make([][]int, n)
+	for i := 0; i < n; i++ {
+		for _, d := range stepDeps(plan, i) {
+			j := index[d]
+			indegree[i]++
+			dependents[j] = append(dependents[j], i)
+		}
+	}
+	queue := make([]int, 0, n)
+	for i := 0; i < n; i++ {
+		if indegree[i] == 0 {
+			queue = append(queue, i)
+		}
+	}
+	visited := 0
+	for len(queue) > 0 {
+		i := queue[0]
+		queue = queue[1:]
+		visited++
+		for _, j := range dependents[i] {
+			indegree[j]--
+			if indegree[j] == 0 {
+				queue = append(queue, j)
+			}
+		}
+	}
+	if visited != n {
+		return fmt.Errorf("dependency cycle detected among steps")
+	}
+	return nil
+}
+
+// readySteps returns the non-final steps whose dependencies are all completed,
+// in plan order, capped at limit (<= 0 means no cap).
+func readySteps(plan common.Plan, completed map[int]bool, limit int) []common.Step {
+	var ready []common.Step
+	for i, s := range plan.Steps[:len(plan.Steps)-1] {
+		if completed[s.StepID] {
+			continue
+		}
+		ok := true
+		for _, d := range stepDeps(plan, i) {
+			if !completed[d] {
+				ok = false
+				break
+			}
+		}
+		if !ok {
+			continue
+		}
+		ready = append(ready, s)
+		if limit > 0 && len(ready) >= limit {
+			break
+		}
+	}
+	return ready
+}
+
+// pendingNormalSteps counts the non-final steps that have not completed yet.
+func pendingNormalSteps(plan common.Plan, completed map[int]bool) int {
+	n := 0
+	for _, s := range plan.Steps[:len(plan.Steps)-1] {
+		if !completed[s.StepID] {
+			n++
+		}
+	}
+	return n
+}
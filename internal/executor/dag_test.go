@@ -0,0 +1,86 @@
+package executor
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"pcap_agent/internal/common"
+)
+
+// step returns a step with explicit dependencies; legacy steps (id < 0)
+// leave depends_on unset and so depend on their predecessor.
+func step(id int, deps ...int) common.Step {
+	if id < 0 {
+		return common.Step{StepID: -id, Intent: "step"}
+	}
+	return common.Step{StepID: id, Intent: "step", DependsOn: append([]int{}, deps...)}
+}
+
+func plan(steps ...common.Step) common.Plan {
+	return common.Plan{Steps: steps}
+}
+
+func TestStepDeps(t *testing.T) {
+	p := plan(step(-1), step(-2), step(3, 1), step(4))
+	got := fmt.Sprint(stepDeps(p, 0), stepDeps(p, 1), stepDeps(p, 2), stepDeps(p, 3))
+	if want := "[] [1] [1] [1 2 3]"; got != want {
+		t.Errorf("stepDeps = %s; want %s", got, want)
+	}
+}
+
+func TestValidateDAG(t *testing.T) {
+	for _, p := range []common.Plan{
+		plan(step(1), step(2), step(3, 1, 2), step(4)),
+		plan(step(-1), step(-2), step(-3)),
+		plan(step(1)),
+	} {
+		if err := validateDAG(p); err != nil {
+			t.Errorf("validateDAG(%+v): %v", p.Steps, err)
+		}
+	}
+	for want, p := range map[string]common.Plan{
+		"duplicate step_id 1":       plan(step(1), step(1), step(2)),
+		"depends on itself":         plan(step(1, 1), step(2)),
+		"depends on the final step": plan(step(1, 3), step(2), step(3)),
+		"unknown step 9":            plan(step(1, 9), step(2)),
+		"cycle":                     plan(step(1, 2), step(2, 1), step(3)),
+	} {
+		if err := validateDAG(p); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("validateDAG(%+v) = %v; want %q", p.Steps, err, want)
+		}
+	}
+}
+
+func TestReadySteps(t *testing.T) {
+	p := plan(step(1), step(2), step(3, 1), step(4, 2, 3), step(5))
+	ready := func(limit int, done ...int) string {
+		completed := map[int]bool{}
+		for _, id := range done {
+			completed[id] = true
+		}
+		var ids []int
+		for _, s := range readySteps(p, completed, limit) {
+			ids = append(ids, s.StepID)
+		}
+		return fmt.Sprint(ids)
+	}
+	if got := ready(0); got != "[1 2]" {
+		t.Errorf("first wave = %s", got)
+	}
+	if got := ready(1); got != "[1]" {
+		t.Errorf("capped wave = %s", got)
+	}
+	if got := ready(0, 1); got != "[2 3]" {
+		t.Errorf("after step 1 = %s", got)
+	}
+	if got := ready(0, 1, 2, 3); got != "[4]" {
+		t.Errorf("join = %s", got)
+	}
+	if got := ready(0, 1, 2, 3, 4); got != "[]" {
+		t.Errorf("final step was scheduled: %s", got)
+	}
+	if n := pendingNormalSteps(p, map[int]bool{1: true, 3: true}); n != 2 {
+		t.Errorf("pendingNormalSteps = %d; want 2", n)
+	}
+}
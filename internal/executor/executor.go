@@ -2,15 +2,19 @@ package executor
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"pcap_agent/internal/common"
 	"pcap_agent/internal/events"
 	"pcap_agent/internal/prompts"
 	"pcap_agent/pkg/logger"
 	"strings"
 	"sync"
+	"time"
 
+	"github.com/cloudwego/eino/callbacks"
+	"github.com/cloudwego/eino/components/model"
 	"github.com/cloudwego/eino/components/prompt"
 	"github.com/cloudwego/eino/compose"
 	"github.com/cloudwego/eino/flow/agent"
@@ -25,43 +29,211 @@ const (
 	nodeNormalTemplate = "normal-template"
 	nodeNormalReact    = "normal-react"
 	nodeNormalParse    = "normal-parse"
+	nodeReplan         = "replan"
 	nodeFinalPrepare   = "final-prepare"
 	nodeFinalTemplate  = "final-template"
 	nodeFinalReact     = "final-react"
 	nodeFinalParse     = "final-parse"
 )
 
+// stepRun carries one step of a parallel wave through the normal-executor nodes.
+type stepRun struct {
+	Step   common.Step
+	Vars   map[string]any
+	Prompt []*schema.Message
+	Output *schema.Message
+	Parsed *common.NormalOutput
+	Record common.StepRecord // findings and actions are filled in when merged
+}
+
 // Result is the output of a full executor pipeline run.
 type Result struct {
+	Plan         common.Plan // the plan as executed, including replanner revisions
 	Report       string
 	Findings     string
 	OperationLog string
+	Steps        []common.StepRecord // per-step records, including the final step
+	IOCs         []common.IOC        // deduplicated indicators from all steps
+}
+
+// Defaults for executor behaviour.
+const (
+	// DefaultMaxParallelSteps is the default number of independent steps run concurrently.
+	DefaultMaxParallelSteps = 4
+
+	// DefaultMaxReplans is the default cap on accepted replanner revisions per run.
+	DefaultMaxReplans = 3
+
+	// DefaultMaxRepairAttempts is the default number of JSON repair round-trips per step.
+	DefaultMaxRepairAttempts = 2
+)
+
+// normalOutputHint is shown to the model when repairing a NormalExecutor reply.
+const normalOutputHint = `{"findings": "<what this step discovered>", "my_actions": "<what this step did>", "iocs": [{"type": "ip|domain|url|hash|ja3|user_agent|port", "value": "<indicator>", "context": "<why>"}]}`
+
+// replanOutputHint is shown to the model when repairing a replanner reply.
+const replanOutputHint = `{"action": "keep" | "revise", "thought": "<why>", "steps": [{"step_id": 5, "intent": "<intent>", "depends_on": [2]}]}`
+
+// maxPlanSteps bounds plans (including revisions) so the graph loop fits in
+// maxRunSteps. Every wave completes at least one step, so a plan needs at most
+// maxPlanSteps waves plus the final path.
+const (
+	maxPlanSteps   = 30
+	nodesPerWave   = 6 // is-last, normal-prepare, normal-template, normal-react, normal-parse, replan
+	finalPathNodes = 5 // is-last, final-prepare, final-template, final-react, final-parse
+	maxRunSteps    = nodesPerWave*maxPlanSteps + finalPathNodes
+)
+
+// Config tunes executor behaviour. A nil Config uses defaults.
+type Config struct {
+	// MaxParallelSteps caps how many ready steps run concurrently in one wave.
+	// Default: DefaultMaxParallelSteps. Set to 1 to force sequential execution.
+	MaxParallelSteps int
+
+	// Replan enables the replanner node, which may revise, insert, or drop the
+	// remaining steps after each wave based on the accumulated findings.
+	Replan bool
+
+	// MaxReplans caps accepted revisions per run. Default: DefaultMaxReplans.
+	MaxReplans int
+
+	// MaxRepairAttempts is how many times malformed step JSON is sent back to the
+	// model for repair before the step fails. Default: DefaultMaxRepairAttempts.
+	MaxRepairAttempts int
+
+	// DefaultBudget applies to every ReAct invocation (steps, replanner, final report).
+	// A step's own Budget overrides individual fields. Zero fields are unlimited.
+	DefaultBudget common.Budget
+
+	// RepairModel answers JSON repair requests. Pass the bare chat model: a
+	// repair is a single text-only call, and through the ReAct agent the model
+	// could run tools instead of answering. nil sends repairs through the agent.
+	RepairModel model.BaseChatModel
+}
+
+// GetMaxParallelSteps returns the effective parallelism, using default if not set.
+func (c *Config) GetMaxParallelSteps() int {
+	if c == nil || c.MaxParallelSteps <= 0 {
+		return DefaultMaxParallelSteps
+	}
+	return c.MaxParallelSteps
+}
+
+// GetDefaultBudget returns the budget applied to steps without their own limits.
+func (c *Config) GetDefaultBudget() common.Budget {
+	if c == nil {
+		return common.Budget{}
+	}
+	return c.DefaultBudget
+}
+
+// GetMaxRepairAttempts returns the effective repair attempt count, using default if not set.
+func (c *Config) GetMaxRepairAttempts() int {
+	if c == nil || c.MaxRepairAttempts <= 0 {
+		return DefaultMaxRepairAttempts
+	}
+	return c.MaxRepairAttempts
+}
+
+// GetMaxReplans returns the effective revision cap, or 0 if replanning is disabled.
+func (c *Config) GetMaxReplans() int {
+	if c == nil || !c.Replan {
+		return 0
+	}
+	if c.MaxReplans <= 0 {
+		return DefaultMaxReplans
+	}
+	return c.MaxReplans
 }
 
 // Executor wraps a compiled eino graph that executes all plan steps.
 type Executor struct {
 	rAgent  *react.Agent
 	emitter events.Emitter
+	cfg     *Config
 }
 
 // NewExecutor creates a new Executor. The graph is built on each Run() call
 // because it captures per-run closure state.
-func NewExecutor(rAgent *react.Agent, emitter events.Emitter) *Executor {
-	return &Executor{rAgent: rAgent, emitter: emitter}
+func NewExecutor(rAgent *react.Agent, emitter events.Emitter, cfg *Config) *Executor {
+	return &Executor{rAgent: rAgent, emitter: emitter, cfg: cfg}
 }
 
+// CheckpointFunc persists a snapshot of the executor state. It is called before
+// the first wave, after every completed wave (and replan), and when a step fails,
+// with the steps of its wave that did finish, so a failed or interrupted round
+// can be resumed from the last completed step.
+type CheckpointFunc func(ctx context.Context, state *common.PlanState) error
+
 // Run executes all steps in the plan and returns the final report plus captured state.
+// Non-final steps run in dependency order; steps whose dependencies are satisfied at
+// the same time form a wave and run concurrently, each with its own ReAct invocation.
+// Wave results are merged in plan order so findings are deterministic.
 // userQuery is the original user question, injected into executor prompts for context.
-// pcapPath is the container-side path to the target PCAP file.
-func (e *Executor) Run(ctx context.Context, plan common.Plan, userQuery string, pcapPath string) (*Result, error) {
+// captures are the target PCAP files, at their container-side paths.
+// checkpoint may be nil.
+func (e *Executor) Run(ctx context.Context, plan common.Plan, userQuery string, captures []common.Capture, checkpoint CheckpointFunc) (*Result, error) {
+	tableSchema := plan.TableSchema
+	if tableSchema == "" {
+		tableSchema = "(Table schema not available - run `pcapchu-scripts meta` if needed)"
+	}
+	return e.run(ctx, &common.PlanState{
+		Plan:             plan,
+		TableSchema:      tableSchema,
+		Completed:        make(map[int]bool, len(plan.Steps)),
+		ResearchFindings: "",
+		OperationLog:     []string{},
+		EndOutput:        "",
+	}, userQuery, captures, checkpoint)
+}
+
+// Resume continues a round from a checkpointed state. Completed steps are not
+// re-run; their findings and operation log are reused as-is.
+func (e *Executor) Resume(ctx context.Context, state *common.PlanState, userQuery string, captures []common.Capture, checkpoint CheckpointFunc) (*Result, error) {
+	if state == nil {
+		return nil, fmt.Errorf("no state to resume from")
+	}
+	resumed := *state
+	resumed.Batch = nil
+	resumed.Completed = make(map[int]bool, len(state.Completed))
+	for id, done := range state.Completed {
+		resumed.Completed[id] = done
+	}
+	resumed.OperationLog = append([]string(nil), state.OperationLog...)
+	resumed.Records = append([]common.StepRecord(nil), state.Records...)
+	resumed.IOCs = append([]common.IOC(nil), state.IOCs...)
+	logger.CtxInfof(ctx, "[Executor] resuming round: %d/%d steps already completed",
+		len(resumed.Completed), len(resumed.Plan.Steps))
+	return e.run(ctx, &resumed, userQuery, captures, checkpoint)
+}
+
+// run builds and invokes the executor graph starting from initial.
+func (e *Executor) run(ctx context.Context, initial *common.PlanState, userQuery string, captures []common.Capture, checkpoint CheckpointFunc) (*Result, error) {
+	plan := initial.Plan
 	if len(plan.Steps) == 0 {
 		return nil, fmt.Errorf("plan has no steps")
 	}
+	if len(plan.Steps) > maxPlanSteps {
+		return nil, fmt.Errorf("plan has %d steps (max %d)", len(plan.Steps), maxPlanSteps)
+	}
+	if err := validateDAG(plan); err != nil {
+		return nil, fmt.Errorf("invalid plan dependencies: %w", err)
+	}
+	// Tool calls made by the steps are reported on the executor's emitter
+	ctx = events.WithEmitter(ctx, e.emitter)
+	pcapPaths, pcapFiles := common.CapturePaths(captures), common.FormatCaptures(captures)
+	maxParallel := e.cfg.GetMaxParallelSteps()
+	maxReplans := e.cfg.GetMaxReplans()
 
 	// --- Closure state for capturing findings/oplog after Invoke ---
 	var (
-		capturedFindings string
-		capturedOpLog    []string
+		capturedPlan     = plan
+		capturedFindings = initial.ResearchFindings
+		capturedOpLog    = initial.OperationLog
+		capturedRecords  = initial.Records
+		capturedIOCs     = initial.IOCs
+		reportSeq        int // seq of the last report.delta event
+		finalRecord      *common.StepRecord
 		captureMu        sync.Mutex
 	)
 
@@ -73,59 +245,84 @@ func (e *Executor) Run(ctx context.Context, plan common.Plan, userQuery string,
 
 	// --- State initializer ---
 	prepareStateFunc := func(ctx context.Context) *common.PlanState {
-		tableSchema := plan.TableSchema
-		if tableSchema == "" {
-			tableSchema = "(Table schema not available - run `pcapchu-scripts meta` if needed)"
-		}
-		return &common.PlanState{
-			Plan:             plan,
-			TableSchema:      tableSchema,
-			CurrentStepIndex: 0,
-			ResearchFindings: "",
-			OperationLog:     []string{},
-			EndOutput:        "",
-		}
-	}
-
-	// --- ReAct wrapper with callback logging ---
-	reactWithLog := func(label string) *compose.Lambda {
-		return compose.InvokableLambda(func(ctx context.Context, in []*schema.Message) (*schema.Message, error) {
-			logger.Infof("[%s] input messages count: %d", label, len(in))
-			cb := &logger.PrettyLoggerCallback{}
-			timer := logger.NewTimer()
-			out, err := e.rAgent.Generate(ctx, in,
-				agent.WithComposeOptions(compose.WithCallbacks(cb)),
-			)
-			elapsed := timer.ElapsedMs()
-			if err != nil {
-				logger.Errorf("[%s] error after %dms: %v", label, elapsed, err)
-				return nil, err
-			}
-			logger.Infof("[%s] output (%dms) callback_events=%d content=%s",
-				label, elapsed, cb.Step, common.TruncateStr(out.Content, 500))
-			return out, nil
-		})
+		return initial
+	}
+
+	// saveCheckpoint persists state; a failure is logged and the run goes on.
+	saveCheckpoint := func(ctx context.Context, state *common.PlanState) {
+		if checkpoint == nil {
+			return
+		}
+		if err := checkpoint(ctx, state); err != nil {
+			logger.CtxWarnf(ctx, "[Executor] checkpoint failed: %v", err)
+		}
+	}
+
+	// mergeRun adds a finished step's findings, operation log, indicators and
+	// record to state and marks the step completed.
+	mergeRun := func(ctx context.Context, state *common.PlanState, run *stepRun) {
+		step, parsed := run.Step, run.Parsed
+		ctx = logger.WithStep(ctx, step.StepID)
+
+		// Append findings
+		if parsed.Findings.String() != "" {
+			state.ResearchFindings += fmt.Sprintf("\n\n### Step %d: %s\n%s", step.StepID, step.Intent, parsed.Findings)
+		}
+
+		// Append operation log
+		if parsed.MyActions.String() != "" {
+			state.OperationLog = append(state.OperationLog, fmt.Sprintf("[Step %d - %s]\n%s", step.StepID, step.Intent, parsed.MyActions))
+		}
+
+		// Merge indicators, deduplicated across steps
+		stepIOCs, invalid := common.MergeIOCs(nil, parsed.IOCs, step.StepID)
+		for _, err := range invalid {
+			logger.CtxWarnf(ctx, "[Executor] step %d: dropping invalid ioc: %v", step.StepID, err)
+		}
+		state.IOCs, _ = common.MergeIOCs(state.IOCs, stepIOCs, step.StepID)
+
+		// Emit step findings event
+		e.emitter.Emit(events.NewCtxEvent(ctx, events.TypeStepFindings, events.StepFindingsData{
+			StepID:   step.StepID,
+			Intent:   step.Intent,
+			Findings: common.TruncateStr(parsed.Findings.String(), 2000),
+			Actions:  common.TruncateStr(parsed.MyActions.String(), 2000),
+			IOCs:     stepIOCs,
+		}))
+
+		run.Record.Findings = parsed.Findings.String()
+		run.Record.Actions = parsed.MyActions.String()
+		state.Records = append(state.Records, run.Record)
+
+		state.Completed[step.StepID] = true
+		logger.CtxInfof(ctx, "[Executor] step %d %s (tool_calls=%d tokens=%d)",
+			step.StepID, run.Record.Status, run.Record.ToolCalls, run.Record.TotalTokens)
+	}
+
+	// captureState copies the accumulated results out of state for Result.
+	captureState := func(state *common.PlanState) {
+		captureMu.Lock()
+		defer captureMu.Unlock()
+		capturedFindings = state.ResearchFindings
+		capturedOpLog = make([]string, len(state.OperationLog))
+		copy(capturedOpLog, state.OperationLog)
+		capturedRecords = append([]common.StepRecord(nil), state.Records...)
+		capturedIOCs = append([]common.IOC(nil), state.IOCs...)
 	}
 
 	// ===================== NORMAL EXECUTOR NODES =====================
 
-	// normal-prepare: inject template variables from PlanState
-	normalPrepareLambda := compose.InvokableLambda(func(ctx context.Context, in any) (map[string]any, error) {
+	// normal-prepare: pick the next wave of ready steps and inject template variables
+	normalPrepareLambda := compose.InvokableLambda(func(ctx context.Context, in any) ([]*stepRun, error) {
 		return nil, nil
 	})
-	normalPreparePostHook := func(ctx context.Context, out map[string]any, state *common.PlanState) (map[string]any, error) {
-		idx := state.CurrentStepIndex
-		if idx >= len(state.Plan.Steps) {
-			return nil, fmt.Errorf("step index %d out of range (total %d)", idx, len(state.Plan.Steps))
+	normalPreparePostHook := func(ctx context.Context, out []*stepRun, state *common.PlanState) ([]*stepRun, error) {
+		batch := readySteps(state.Plan, state.Completed, maxParallel)
+		if len(batch) == 0 {
+			return nil, fmt.Errorf("no runnable steps: %d steps pending but none have their dependencies satisfied",
+				pendingNormalSteps(state.Plan, state.Completed))
 		}
-		step := state.Plan.Steps[idx]
-		logger.Infof("[Executor] step %d start: %s", step.StepID, step.Intent)
-
-		e.emitter.Emit(events.NewEvent(events.TypeStepStarted, "", events.StepStartedData{
-			StepID:     step.StepID,
-			Intent:     step.Intent,
-			TotalSteps: len(state.Plan.Steps),
-		}))
+		state.Batch = batch
 
 		planOverview := common.FormatPlanOverview(state.Plan)
 		opLog := strings.Join(state.OperationLog, "\n---\n")
@@ -137,78 +334,112 @@ func (e *Executor) Run(ctx context.Context, plan common.Plan, userQuery string,
 			findings = "(No research findings yet - you are the first executor)"
 		}
 
-		return map[string]any{
-			"user_query":        userQuery,
-			"pcap_path":         pcapPath,
-			"plan_overview":     planOverview,
-			"research_findings": findings,
-			"operation_log":     opLog,
-			"current_step":      fmt.Sprintf("Step %d: %s", step.StepID, step.Intent),
-			"table_schema":      state.TableSchema,
-		}, nil
+		runs := make([]*stepRun, len(batch))
+		for i, step := range batch {
+			ctx := logger.WithStep(ctx, step.StepID)
+			logger.CtxInfof(ctx, "[Executor] step %d start: %s", step.StepID, step.Intent)
+			e.emitter.Emit(events.NewCtxEvent(ctx, events.TypeStepStarted, events.StepStartedData{
+				StepID:     step.StepID,
+				Intent:     step.Intent,
+				TotalSteps: len(state.Plan.Steps),
+			}))
+			runs[i] = &stepRun{Step: step, Vars: map[string]any{
+				"user_query":        userQuery,
+				"pcap_path":         pcapPaths,
+				"pcap_files":        pcapFiles,
+				"plan_overview":     planOverview,
+				"research_findings": findings,
+				"operation_log":     opLog,
+				"current_step":      fmt.Sprintf("Step %d: %s", step.StepID, step.Intent),
+				"table_schema":      state.TableSchema,
+			}}
+		}
+		return runs, nil
 	}
 
-	// normal-template
+	// normal-template: render one prompt per step in the wave
 	normalTpl := prompt.FromMessages(schema.GoTemplate,
 		schema.SystemMessage(pMaps["normal_execulator"]),
 	)
-
-	// normal-parse: extract JSON, update state, capture for Result
-	normalParseLambda := compose.InvokableLambda(func(ctx context.Context, in *schema.Message) (any, error) {
-		return nil, nil
+	normalTemplateLambda := compose.InvokableLambda(func(ctx context.Context, in []*stepRun) ([]*stepRun, error) {
+		for _, run := range in {
+			msgs, err := normalTpl.Format(ctx, run.Vars)
+			if err != nil {
+				return nil, fmt.Errorf("format prompt for step %d: %w", run.Step.StepID, err)
+			}
+			run.Prompt = msgs
+		}
+		return in, nil
 	})
-	normalParsePreHook := func(ctx context.Context, in *schema.Message, state *common.PlanState) (*schema.Message, error) {
-		idx := state.CurrentStepIndex
-		step := state.Plan.Steps[idx]
 
-		str, err := common.ExtractJSON(in.Content)
-		if err != nil {
-			e.emitter.Emit(events.NewEvent(events.TypeStepError, "", events.ErrorData{
-				Phase:   "executor",
-				Message: fmt.Sprintf("extract json failed for step %d: %v", step.StepID, err),
-				StepID:  step.StepID,
-			}))
-			return nil, fmt.Errorf("extract JSON from executor output: %w", err)
+	// normal-react: run one ReAct invocation per step in the wave, concurrently
+	normalReactLambda := compose.InvokableLambda(func(ctx context.Context, in []*stepRun) ([]*stepRun, error) {
+		errs := make([]error, len(in))
+		var wg sync.WaitGroup
+		for i, run := range in {
+			wg.Add(1)
+			go func(i int, run *stepRun) {
+				defer wg.Done()
+				ctx := logger.WithStep(ctx, run.Step.StepID)
+				label := fmt.Sprintf("ReAct-NormalExecutor-step%d", run.Step.StepID)
+				budget := run.Step.Budget.Merge(e.cfg.GetDefaultBudget())
+				out, tracker, err := e.generateWithBudget(ctx, label, budget, run.Prompt)
+				var be *BudgetError
+				if errors.As(err, &be) {
+					run.Record = tracker.Record(run.Step, common.StepBudgetExhausted)
+					run.Parsed = e.budgetExhausted(ctx, run.Step, be, tracker)
+					return
+				}
+				if err != nil {
+					errs[i] = err
+					return
+				}
+				run.Output = out
+				run.Record = tracker.Record(run.Step, common.StepCompleted)
+				run.Parsed, errs[i] = e.parseNormalOutput(ctx, run.Step, run.Output)
+			}(i, run)
 		}
-
-		var parsed common.NormalOutput
-		if err := json.Unmarshal([]byte(str), &parsed); err != nil {
-			e.emitter.Emit(events.NewEvent(events.TypeStepError, "", events.ErrorData{
-				Phase:   "executor",
-				Message: fmt.Sprintf("unmarshal failed for step %d: %v", step.StepID, err),
-				StepID:  step.StepID,
-			}))
-			return nil, fmt.Errorf("unmarshal executor output: %w", err)
+		wg.Wait()
+		var failed error
+		for _, err := range errs {
+			if err != nil {
+				failed = err
+				break
+			}
 		}
-
-		// Append findings
-		if parsed.Findings.String() != "" {
-			state.ResearchFindings += fmt.Sprintf("\n\n### Step %d: %s\n%s", step.StepID, step.Intent, parsed.Findings)
+		if failed == nil {
+			return in, nil
 		}
 
-		// Append operation log
-		if parsed.MyActions.String() != "" {
-			state.OperationLog = append(state.OperationLog, fmt.Sprintf("[Step %d - %s]\n%s", step.StepID, step.Intent, parsed.MyActions))
+		// Keep the steps of the wave that did finish, so their findings survive
+		// and a resumed round does not run them again.
+		if err := compose.ProcessState(ctx, func(ctx context.Context, state *common.PlanState) error {
+			for i, run := range in {
+				if errs[i] == nil {
+					mergeRun(ctx, state, run)
+				}
+			}
+			state.Batch = nil
+			captureState(state)
+			saveCheckpoint(ctx, state)
+			return nil
+		}); err != nil {
+			logger.CtxWarnf(ctx, "[Executor] keep finished steps of failed wave: %v", err)
 		}
+		return nil, failed
+	})
 
-		// Emit step findings event
-		e.emitter.Emit(events.NewEvent(events.TypeStepFindings, "", events.StepFindingsData{
-			StepID:   step.StepID,
-			Intent:   step.Intent,
-			Findings: common.TruncateStr(parsed.Findings.String(), 2000),
-			Actions:  common.TruncateStr(parsed.MyActions.String(), 2000),
-		}))
-
-		// --- Capture state via closure for Result ---
-		captureMu.Lock()
-		capturedFindings = state.ResearchFindings
-		capturedOpLog = make([]string, len(state.OperationLog))
-		copy(capturedOpLog, state.OperationLog)
-		captureMu.Unlock()
-
-		// Advance step index
-		state.CurrentStepIndex++
-		logger.Infof("[Executor] step %d completed, advancing to index %d", step.StepID, state.CurrentStepIndex)
+	// normal-parse: merge parsed step outputs into state in plan order, capture for Result
+	normalParseLambda := compose.InvokableLambda(func(ctx context.Context, in []*stepRun) (map[string]any, error) {
+		return nil, nil
+	})
+	normalParsePreHook := func(ctx context.Context, in []*stepRun, state *common.PlanState) ([]*stepRun, error) {
+		// in is already in plan order (readySteps preserves it), so merging is deterministic.
+		for _, run := range in {
+			mergeRun(ctx, state, run)
+		}
+		state.Batch = nil
+		captureState(state)
 		return nil, nil
 	}
 
@@ -219,14 +450,11 @@ func (e *Executor) Run(ctx context.Context, plan common.Plan, userQuery string,
 		return nil, nil
 	})
 	finalPreparePostHook := func(ctx context.Context, out map[string]any, state *common.PlanState) (map[string]any, error) {
-		idx := state.CurrentStepIndex
-		if idx >= len(state.Plan.Steps) {
-			return nil, fmt.Errorf("step index %d out of range in FinalExecutor (total %d)", idx, len(state.Plan.Steps))
-		}
-		step := state.Plan.Steps[idx]
-		logger.Infof("[Executor] final step %d start: %s", step.StepID, step.Intent)
+		step := state.Plan.Steps[len(state.Plan.Steps)-1]
+		ctx = logger.WithStep(ctx, step.StepID)
+		logger.CtxInfof(ctx, "[Executor] final step %d start: %s", step.StepID, step.Intent)
 
-		e.emitter.Emit(events.NewEvent(events.TypeStepStarted, "", events.StepStartedData{
+		e.emitter.Emit(events.NewCtxEvent(ctx, events.TypeStepStarted, events.StepStartedData{
 			StepID:     step.StepID,
 			Intent:     step.Intent,
 			TotalSteps: len(state.Plan.Steps),
@@ -244,7 +472,8 @@ func (e *Executor) Run(ctx context.Context, plan common.Plan, userQuery string,
 
 		return map[string]any{
 			"user_query":        userQuery,
-			"pcap_path":         pcapPath,
+			"pcap_path":         pcapPaths,
+			"pcap_files":        pcapFiles,
 			"plan_overview":     planOverview,
 			"research_findings": findings,
 			"operation_log":     opLog,
@@ -257,22 +486,138 @@ func (e *Executor) Run(ctx context.Context, plan common.Plan, userQuery string,
 		schema.SystemMessage(pMaps["final_execulator"]),
 	)
 
+	// final-react: stream the report, emitting report.delta events as tokens arrive;
+	// on budget exhaustion fall back to the raw findings
+	finalReactLambda := compose.InvokableLambda(func(ctx context.Context, in []*schema.Message) (*schema.Message, error) {
+		captureMu.Lock()
+		final := capturedPlan.Steps[len(capturedPlan.Steps)-1]
+		captureMu.Unlock()
+		ctx = logger.WithStep(ctx, final.StepID)
+		budget := final.Budget.Merge(e.cfg.GetDefaultBudget())
+		onDelta := func(delta string) {
+			reportSeq++
+			e.emitter.Emit(events.NewCtxEvent(ctx, events.TypeReportDelta, events.ReportDeltaData{Seq: reportSeq, Delta: delta}))
+		}
+		out, tracker, err := e.runWithBudget(ctx, budget, func(ctx context.Context, h callbacks.Handler) (*schema.Message, error) {
+			return e.stream(ctx, "ReAct-FinalExecutor", in, onDelta, h)
+		})
+		var be *BudgetError
+		if errors.As(err, &be) {
+			e.budgetExhausted(ctx, final, be, tracker)
+			record := tracker.Record(final, common.StepBudgetExhausted)
+			captureMu.Lock()
+			findings := capturedFindings
+			finalRecord = &record
+			captureMu.Unlock()
+			return schema.AssistantMessage(fmt.Sprintf(
+				"**Report generation stopped — %s.**\n\nThe research findings collected before the budget ran out are reproduced below.\n%s",
+				be.Error(), findings), nil), nil
+		}
+		if err == nil {
+			record := tracker.Record(final, common.StepCompleted)
+			captureMu.Lock()
+			finalRecord = &record
+			captureMu.Unlock()
+		}
+		return out, err
+	})
+
 	// final-parse: extract the final report content
 	finalParseLambda := compose.InvokableLambda(func(ctx context.Context, in *schema.Message) (string, error) {
-		logger.Infof("[FinalExecutor] output (length=%d):\n%s", len(in.Content), common.TruncateStr(in.Content, 1000))
+		logger.CtxInfof(ctx, "[FinalExecutor] output (length=%d):\n%s", len(in.Content), common.TruncateStr(in.Content, 1000))
 		return in.Content, nil
 	})
 
+	// ===================== REPLAN NODE =====================
+
+	// replan: after each wave, let the replanner revise the remaining steps.
+	// Replanning is advisory — any failure keeps the current plan.
+	replanTpl := prompt.FromMessages(schema.GoTemplate,
+		schema.SystemMessage(pMaps["replanner"]),
+	)
+	replanPreHook := func(ctx context.Context, in map[string]any, state *common.PlanState) (map[string]any, error) {
+		if state.Revisions >= maxReplans {
+			return nil, nil
+		}
+		return replanVars(state, userQuery), nil
+	}
+	replanLambda := compose.InvokableLambda(func(ctx context.Context, in map[string]any) (*planRevision, error) {
+		if in == nil {
+			return nil, nil
+		}
+		msgs, err := replanTpl.Format(ctx, in)
+		if err != nil {
+			return nil, fmt.Errorf("format replanner prompt: %w", err)
+		}
+		out, _, err := e.generateWithBudget(ctx, "ReAct-Replanner", e.cfg.GetDefaultBudget(), msgs)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, err
+			}
+			e.replanFailed(ctx, fmt.Errorf("replanner invoke: %w", err))
+			return nil, nil
+		}
+		var rev planRevision
+		if err := e.parseWithRepair(ctx, "replanner", 0, out.Content, &rev, replanOutputHint); err != nil {
+			if ctx.Err() != nil {
+				return nil, err
+			}
+			e.replanFailed(ctx, fmt.Errorf("parse replanner output: %w", err))
+			return nil, nil
+		}
+		return &rev, nil
+	})
+	replanPostHook := func(ctx context.Context, out *planRevision, state *common.PlanState) (*planRevision, error) {
+		if out == nil {
+			return nil, nil
+		}
+		if out.Action != replanRevise {
+			logger.CtxInfof(ctx, "[Replanner] keeping plan: %s", common.TruncateStr(out.Thought, 300))
+			return out, nil
+		}
+		if len(state.Plan.Steps)-len(remainingSteps(state.Plan, state.Completed))+len(out.Steps) > maxPlanSteps {
+			e.replanFailed(ctx, fmt.Errorf("revision would exceed %d steps", maxPlanSteps))
+			return out, nil
+		}
+		prev := state.Plan
+		dropped, added, err := applyRevision(state, out)
+		if err != nil {
+			e.replanFailed(ctx, fmt.Errorf("reject revision: %w", err))
+			return out, nil
+		}
+		logger.CtxInfof(ctx, "[Replanner] revision %d applied: dropped=%v added=%v", state.Revisions, dropped, added)
+		state.Records = append(state.Records, skippedRecords(prev, dropped)...)
+
+		steps := make([]events.StepInfo, len(state.Plan.Steps))
+		for i, s := range state.Plan.Steps {
+			steps[i] = events.StepInfo{StepID: s.StepID, Intent: s.Intent, DependsOn: s.DependsOn}
+		}
+		e.emitter.Emit(events.NewCtxEvent(ctx, events.TypePlanRevised, events.PlanRevisedData{
+			Revision: state.Revisions,
+			Thought:  out.Thought,
+			Dropped:  dropped,
+			Added:    added,
+			Steps:    steps,
+		}))
+
+		captureMu.Lock()
+		capturedPlan = state.Plan
+		capturedRecords = append([]common.StepRecord(nil), state.Records...)
+		captureMu.Unlock()
+		return out, nil
+	}
+
 	// ===================== IS-LAST NODE =====================
 
 	isLastLambda := compose.InvokableLambda(func(ctx context.Context, in any) (bool, error) {
 		return false, nil
 	})
 	isLastPostHook := func(ctx context.Context, out bool, state *common.PlanState) (bool, error) {
-		remaining := len(state.Plan.Steps) - state.CurrentStepIndex
-		isLast := remaining == 1
-		logger.Infof("[Executor] loop check: currentIndex=%d, remaining=%d, isLast=%v",
-			state.CurrentStepIndex, remaining, isLast)
+		pending := pendingNormalSteps(state.Plan, state.Completed)
+		isLast := pending == 0
+		logger.CtxInfof(ctx, "[Executor] loop check: completed=%d, pending=%d, isLast=%v",
+			len(state.Completed), pending, isLast)
+		saveCheckpoint(ctx, state)
 		return isLast, nil
 	}
 
@@ -284,13 +629,15 @@ func (e *Executor) Run(ctx context.Context, plan common.Plan, userQuery string,
 	_ = g.AddLambdaNode(nodeIsLast, isLastLambda, compose.WithStatePostHandler(isLastPostHook))
 
 	_ = g.AddLambdaNode(nodeNormalPrepare, normalPrepareLambda, compose.WithStatePostHandler(normalPreparePostHook))
-	_ = g.AddChatTemplateNode(nodeNormalTemplate, normalTpl)
-	_ = g.AddLambdaNode(nodeNormalReact, reactWithLog("ReAct-NormalExecutor"))
+	_ = g.AddLambdaNode(nodeNormalTemplate, normalTemplateLambda)
+	_ = g.AddLambdaNode(nodeNormalReact, normalReactLambda)
 	_ = g.AddLambdaNode(nodeNormalParse, normalParseLambda, compose.WithStatePreHandler(normalParsePreHook))
+	_ = g.AddLambdaNode(nodeReplan, replanLambda,
+		compose.WithStatePreHandler(replanPreHook), compose.WithStatePostHandler(replanPostHook))
 
 	_ = g.AddLambdaNode(nodeFinalPrepare, finalPrepareLambda, compose.WithStatePostHandler(finalPreparePostHook))
 	_ = g.AddChatTemplateNode(nodeFinalTemplate, finalTpl)
-	_ = g.AddLambdaNode(nodeFinalReact, reactWithLog("ReAct-FinalExecutor"))
+	_ = g.AddLambdaNode(nodeFinalReact, finalReactLambda)
 	_ = g.AddLambdaNode(nodeFinalParse, finalParseLambda)
 
 	// Branch: is-last decides normal vs final path
@@ -312,15 +659,15 @@ func (e *Executor) Run(ctx context.Context, plan common.Plan, userQuery string,
 	_ = g.AddEdge(nodeNormalPrepare, nodeNormalTemplate)
 	_ = g.AddEdge(nodeNormalTemplate, nodeNormalReact)
 	_ = g.AddEdge(nodeNormalReact, nodeNormalParse)
-	_ = g.AddEdge(nodeNormalParse, nodeIsLast) // loop back
+	_ = g.AddEdge(nodeNormalParse, nodeReplan)
+	_ = g.AddEdge(nodeReplan, nodeIsLast) // loop back
 
 	_ = g.AddEdge(nodeFinalPrepare, nodeFinalTemplate)
 	_ = g.AddEdge(nodeFinalTemplate, nodeFinalReact)
 	_ = g.AddEdge(nodeFinalReact, nodeFinalParse)
 	_ = g.AddEdge(nodeFinalParse, compose.END)
 
-	// Compile with generous step limit (5 nodes per loop × max 20 steps)
-	compiled, err := g.Compile(ctx, compose.WithMaxRunSteps(100))
+	compiled, err := g.Compile(ctx, compose.WithMaxRunSteps(maxRunSteps))
 	if err != nil {
 		return nil, fmt.Errorf("compile executor graph: %w", err)
 	}
@@ -332,30 +679,221 @@ func (e *Executor) Run(ctx context.Context, plan common.Plan, userQuery string,
 	elapsed := timer.ElapsedMs()
 
 	if err != nil {
-		e.emitter.Emit(events.NewEvent(events.TypeError, "", events.ErrorData{
+		e.emitter.Emit(events.NewCtxEvent(ctx, events.TypeError, events.ErrorData{
 			Phase:   "executor",
 			Message: err.Error(),
 		}))
 		return nil, fmt.Errorf("executor invoke: %w (after %dms)", err, elapsed)
 	}
 
+	// Append the indicator table, streamed as a final delta
+	captureMu.Lock()
+	totalSteps := len(capturedPlan.Steps)
+	iocs := capturedIOCs
+	captureMu.Unlock()
+	if section := common.FormatIOCSection(iocs); section != "" {
+		section = "\n\n" + section
+		report += section
+		reportSeq++
+		e.emitter.Emit(events.NewCtxEvent(ctx, events.TypeReportDelta, events.ReportDeltaData{Seq: reportSeq, Delta: section}))
+	}
+
 	// Emit report event
-	e.emitter.Emit(events.NewEvent(events.TypeReportGenerated, "", events.ReportData{
-		Report:     common.TruncateStr(report, 5000),
+	e.emitter.Emit(events.NewCtxEvent(ctx, events.TypeReportGenerated, events.ReportData{
+		Report:     report,
 		ContentLen: len(report),
-		TotalSteps: len(plan.Steps),
+		TotalSteps: totalSteps,
 		DurationMs: elapsed,
+		IOCs:       iocs,
 	}))
 
 	// Build result from captured closure state
 	captureMu.Lock()
 	result := &Result{
+		Plan:         capturedPlan,
 		Report:       report,
 		Findings:     capturedFindings,
 		OperationLog: strings.Join(capturedOpLog, "\n---\n"),
+		Steps:        capturedRecords,
+		IOCs:         capturedIOCs,
+	}
+	if finalRecord != nil {
+		finalRecord.Findings = report
+		result.Steps = append(result.Steps, *finalRecord)
 	}
 	captureMu.Unlock()
 
-	logger.Infof("[Executor] completed in %dms, report length=%d", elapsed, len(report))
+	logger.CtxInfof(ctx, "[Executor] completed in %dms, report length=%d", elapsed, len(report))
 	return result, nil
 }
+
+// generate runs the shared ReAct agent with callback logging plus any extra handlers.
+func (e *Executor) generate(ctx context.Context, label string, in []*schema.Message, handlers ...callbacks.Handler) (*schema.Message, error) {
+	logger.CtxInfof(ctx, "[%s] input messages count: %d", label, len(in))
+	cb := &logger.PrettyLoggerCallback{}
+	timer := logger.NewTimer()
+	out, err := e.rAgent.Generate(ctx, in,
+		agent.WithComposeOptions(compose.WithCallbacks(append([]callbacks.Handler{cb}, handlers...)...)),
+	)
+	elapsed := timer.ElapsedMs()
+	if err != nil {
+		logger.CtxErrorf(ctx, "[%s] error after %dms: %v", label, elapsed, err)
+		return nil, err
+	}
+	logger.CtxInfof(ctx, "[%s] output (%dms) callback_events=%d content=%s",
+		label, elapsed, cb.Step, common.TruncateStr(out.Content, 500))
+	return out, nil
+}
+
+// stream runs the shared ReAct agent in streaming mode, calling onDelta with the
+// content of each chunk of the final answer as it arrives, and returns the
+// concatenated message. Intermediate tool-calling turns are not streamed; the
+// agent's StreamToolCallChecker decides which model turn is the answer.
+func (e *Executor) stream(ctx context.Context, label string, in []*schema.Message, onDelta func(string), handlers ...callbacks.Handler) (*schema.Message, error) {
+	logger.CtxInfof(ctx, "[%s] input messages count: %d (streaming)", label, len(in))
+	cb := &logger.PrettyLoggerCallback{}
+	timer := logger.NewTimer()
+	sr, err := e.rAgent.Stream(ctx, in,
+		agent.WithComposeOptions(compose.WithCallbacks(append([]callbacks.Handler{cb}, handlers...)...)),
+	)
+	if err != nil {
+		logger.CtxErrorf(ctx, "[%s] error after %dms: %v", label, timer.ElapsedMs(), err)
+		return nil, err
+	}
+	defer sr.Close()
+
+	var chunks []*schema.Message
+	for {
+		chunk, err := sr.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			logger.CtxErrorf(ctx, "[%s] stream error after %dms: %v", label, timer.ElapsedMs(), err)
+			return nil, err
+		}
+		chunks = append(chunks, chunk)
+		if chunk.Content != "" {
+			onDelta(chunk.Content)
+		}
+	}
+	if len(chunks) == 0 {
+		return nil, fmt.Errorf("empty stream")
+	}
+	out, err := schema.ConcatMessages(chunks)
+	if err != nil {
+		return nil, fmt.Errorf("concat stream chunks: %w", err)
+	}
+	logger.CtxInfof(ctx, "[%s] output (%dms) chunks=%d content=%s",
+		label, timer.ElapsedMs(), len(chunks), common.TruncateStr(out.Content, 500))
+	return out, nil
+}
+
+// generateWithBudget runs generate under budget. If the budget is exhausted the
+// returned error is a *BudgetError; the tracker is always returned for accounting.
+func (e *Executor) generateWithBudget(ctx context.Context, label string, budget common.Budget, in []*schema.Message) (*schema.Message, *budgetTracker, error) {
+	return e.runWithBudget(ctx, budget, func(ctx context.Context, h callbacks.Handler) (*schema.Message, error) {
+		return e.generate(ctx, label, in, h)
+	})
+}
+
+// runWithBudget runs call under budget, passing it the context and callback
+// handler that enforce the limits. Errors are mapped as in generateWithBudget.
+func (e *Executor) runWithBudget(ctx context.Context, budget common.Budget, call func(ctx context.Context, h callbacks.Handler) (*schema.Message, error)) (*schema.Message, *budgetTracker, error) {
+	bctx, tracker, cancel := newBudgetTracker(ctx, budget)
+	defer cancel()
+	out, err := call(bctx, tracker.Handler())
+	if err != nil && ctx.Err() == nil {
+		if be := exhausted(bctx); be != nil {
+			return nil, tracker, be
+		}
+	}
+	return out, tracker, err
+}
+
+// budgetExhausted reports a step that ran out of budget and returns the finding
+// recorded in its place so dependent steps know the data is missing.
+func (e *Executor) budgetExhausted(ctx context.Context, step common.Step, be *BudgetError, tracker *budgetTracker) *common.NormalOutput {
+	_, _, tokens := tracker.Tokens()
+	elapsed := time.Since(tracker.start).Milliseconds()
+	logger.CtxWarnf(ctx, "[Executor] step %d %v (tool_calls=%d tokens=%d elapsed=%dms)",
+		step.StepID, be, tracker.ToolCalls(), tokens, elapsed)
+	e.emitter.Emit(events.NewCtxEvent(ctx, events.TypeStepBudget, events.BudgetExhaustedData{
+		StepID:      step.StepID,
+		Intent:      step.Intent,
+		Reason:      be.Error(),
+		ToolCalls:   tracker.ToolCalls(),
+		TotalTokens: tokens,
+		ElapsedMs:   elapsed,
+	}))
+	return &common.NormalOutput{
+		Findings: common.FlexString(fmt.Sprintf(
+			"[Budget exhausted] This step was stopped before completing (%v). Its question remains unanswered; treat any dependent conclusions as incomplete.", be)),
+		MyActions: common.FlexString(fmt.Sprintf(
+			"Stopped after %d tool calls, %d tokens and %dms (%v).", tracker.ToolCalls(), tokens, elapsed, be)),
+	}
+}
+
+// parseNormalOutput parses a step's final message into a NormalOutput, asking the
+// model to repair malformed JSON up to the configured number of attempts.
+func (e *Executor) parseNormalOutput(ctx context.Context, step common.Step, msg *schema.Message) (*common.NormalOutput, error) {
+	var parsed common.NormalOutput
+	if err := e.parseWithRepair(ctx, "executor", step.StepID, msg.Content, &parsed, normalOutputHint); err != nil {
+		e.emitter.Emit(events.NewCtxEvent(ctx, events.TypeStepError, events.ErrorData{
+			Phase:   "executor",
+			Message: fmt.Sprintf("parse output failed for step %d: %v", step.StepID, err),
+			StepID:  step.StepID,
+		}))
+		return nil, fmt.Errorf("parse executor output for step %d: %w", step.StepID, err)
+	}
+	return &parsed, nil
+}
+
+// parseWithRepair parses raw JSON into target via common.ParseJSONWithRepair,
+// emitting a step.retry event before each repair attempt.
+func (e *Executor) parseWithRepair(ctx context.Context, phase string, stepID int, raw string, target any, hint string) error {
+	repairPrompt, err := prompts.GetSinglePrompt("json_repair")
+	if err != nil {
+		return fmt.Errorf("load repair prompt: %w", err)
+	}
+	maxAttempts := e.cfg.GetMaxRepairAttempts()
+	label := fmt.Sprintf("JSONRepair-%s-step%d", phase, stepID)
+	return common.ParseJSONWithRepair(ctx, e.repairGenerator(label), raw, target, common.RepairOptions{
+		SystemPrompt: repairPrompt,
+		SchemaHint:   hint,
+		MaxAttempts:  maxAttempts,
+		OnRetry: func(attempt int, err error) {
+			logger.CtxWarnf(ctx, "[%s] step %d output invalid, repair attempt %d/%d: %v", phase, stepID, attempt, maxAttempts, err)
+			e.emitter.Emit(events.NewCtxEvent(ctx, events.TypeStepRetry, events.RetryData{
+				Phase:       phase,
+				StepID:      stepID,
+				Attempt:     attempt,
+				MaxAttempts: maxAttempts,
+				Error:       err.Error(),
+			}))
+		},
+	})
+}
+
+// repairGenerator returns the generator used for JSON repair: the configured
+// RepairModel, or the ReAct agent if there is none.
+func (e *Executor) repairGenerator(label string) common.Generator {
+	if e.cfg == nil || e.cfg.RepairModel == nil {
+		return func(ctx context.Context, msgs []*schema.Message) (*schema.Message, error) {
+			return e.generate(ctx, label, msgs)
+		}
+	}
+	return func(ctx context.Context, msgs []*schema.Message) (*schema.Message, error) {
+		logger.CtxInfof(ctx, "[%s] input messages count: %d", label, len(msgs))
+		return e.cfg.RepairModel.Generate(ctx, msgs)
+	}
+}
+
+// replanFailed logs and reports a replanner failure. The current plan is kept.
+func (e *Executor) replanFailed(ctx context.Context, err error) {
+	logger.CtxWarnf(ctx, "[Replanner] %v — keeping current plan", err)
+	e.emitter.Emit(events.NewCtxEvent(ctx, events.TypeStepError, events.ErrorData{
+		Phase:   "replanner",
+		Message: err.Error(),
+	}))
+}
@@ -0,0 +1,253 @@
+package executor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"pcap_agent/internal/common"
+	"pcap_agent/internal/events"
+
+	"github.com/cloudwego/eino/components/model"
+	"github.com/cloudwego/eino/components/tool"
+	"github.com/cloudwego/eino/compose"
+	"github.com/cloudwego/eino/flow/agent/react"
+	"github.com/cloudwego/eino/schema"
+)
+
+// fakeModel answers every call with respond(n, messages), n counting from 1.
+type fakeModel struct {
+	mu      sync.Mutex
+	calls   int
+	respond func(n int, in []*schema.Message) *schema.Message
+}
+
+func (f *fakeModel) Generate(ctx context.Context, in []*schema.Message, _ ...model.Option) (*schema.Message, error) {
+	f.mu.Lock()
+	f.calls++
+	n := f.calls
+	f.mu.Unlock()
+	return f.respond(n, in), nil
+}
+
+func (f *fakeModel) Stream(ctx context.Context, in []*schema.Message, opts ...model.Option) (*schema.StreamReader[*schema.Message], error) {
+	m, _ := f.Generate(ctx, in, opts...)
+	parts := strings.SplitAfter(m.Content, " ")
+	chunks := make([]*schema.Message, 0, len(parts))
+	for _, p := range parts {
+		chunks = append(chunks, &schema.Message{Role: schema.Assistant, Content: p, ToolCalls: m.ToolCalls})
+	}
+	return schema.StreamReaderFromArray(chunks), nil
+}
+
+func (f *fakeModel) WithTools(_ []*schema.ToolInfo) (model.ToolCallingChatModel, error) {
+	return f, nil
+}
+
+func newFakeAgent(t *testing.T, f model.ToolCallingChatModel, tools ...tool.BaseTool) *react.Agent {
+	t.Helper()
+	a, err := react.NewAgent(context.Background(), &react.AgentConfig{
+		ToolCallingModel: f,
+		ToolsConfig:      compose.ToolsNodeConfig{Tools: tools},
+		MaxStep:          50,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+// sysContent concatenates the contents of the messages.
+func sysContent(in []*schema.Message) string {
+	var sb strings.Builder
+	for _, m := range in {
+		sb.WriteString(m.Content)
+	}
+	return sb.String()
+}
+
+// stepAnswers answers step prompts with findings, the replanner with "keep" and
+// anything else with a report.
+func stepAnswers(_ int, in []*schema.Message) *schema.Message {
+	c := sysContent(in)
+	switch {
+	case strings.Contains(c, "Replanner"):
+		return schema.AssistantMessage(`{"action":"keep","thought":"fine"}`, nil)
+	case strings.Contains(c, "**Current Step:**"):
+		return schema.AssistantMessage(`{"findings":"found","my_actions":"did"}`, nil)
+	}
+	return schema.AssistantMessage("final report", nil)
+}
+
+// serialPlan returns a plan of n steps where each step depends on the previous one.
+func serialPlan(n int) common.Plan {
+	plan := common.Plan{Thought: "serial"}
+	for i := 1; i <= n; i++ {
+		deps := []int{}
+		if i > 1 {
+			deps = []int{i - 1}
+		}
+		plan.Steps = append(plan.Steps, common.Step{StepID: i, Intent: fmt.Sprintf("step %d", i), DependsOn: deps})
+	}
+	return plan
+}
+
+func TestRunLongestSerialPlan(t *testing.T) {
+	for _, replan := range []bool{false, true} {
+		t.Run(fmt.Sprintf("replan=%v", replan), func(t *testing.T) {
+			e := NewExecutor(newFakeAgent(t, &fakeModel{respond: stepAnswers}), events.NopEmitter{}, &Config{Replan: replan})
+			res, err := e.Run(context.Background(), serialPlan(maxPlanSteps), "q", []common.Capture{{Name: "a.pcap", Path: "/a.pcap"}}, nil)
+			if err != nil {
+				t.Fatalf("Run: %v", err)
+			}
+			if len(res.Steps) != maxPlanSteps {
+				t.Errorf("got %d step records; want %d", len(res.Steps), maxPlanSteps)
+			}
+			if !strings.HasPrefix(res.Report, "final report") {
+				t.Errorf("report = %q", res.Report)
+			}
+		})
+	}
+}
+
+func TestRepairUsesRepairModel(t *testing.T) {
+	agentModel := &fakeModel{respond: func(n int, in []*schema.Message) *schema.Message {
+		if strings.Contains(sysContent(in), "**Current Step:**") {
+			return schema.AssistantMessage(`{"findings": "found", "my_actions": `, nil)
+		}
+		return stepAnswers(n, in)
+	}}
+	repairModel := &fakeModel{respond: func(int, []*schema.Message) *schema.Message {
+		return schema.AssistantMessage(`{"findings":"repaired","my_actions":"did"}`, nil)
+	}}
+	e := NewExecutor(newFakeAgent(t, agentModel), events.NopEmitter{}, &Config{RepairModel: repairModel})
+	res, err := e.Run(context.Background(), serialPlan(2), "q", []common.Capture{{Name: "a.pcap", Path: "/a.pcap"}}, nil)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if len(res.Steps) != 2 || res.Steps[0].Findings != "repaired" {
+		t.Errorf("steps = %+v", res.Steps)
+	}
+	// The agent ran the step and the final report; the repair went to the bare model.
+	if agentModel.calls != 2 || repairModel.calls != 1 {
+		t.Errorf("agent calls = %d, repair calls = %d; want 2, 1", agentModel.calls, repairModel.calls)
+	}
+}
+
+func TestFailedWaveKeepsFinishedSteps(t *testing.T) {
+	// Steps 1 and 2 run in one wave; step 2's output cannot be parsed.
+	plan := common.Plan{Steps: []common.Step{
+		{StepID: 1, Intent: "dns", DependsOn: []int{}},
+		{StepID: 2, Intent: "http", DependsOn: []int{}},
+		{StepID: 3, Intent: "report", DependsOn: []int{}},
+	}}
+	broken := true
+	agentModel := &fakeModel{respond: func(n int, in []*schema.Message) *schema.Message {
+		c := sysContent(in)
+		switch {
+		case strings.Contains(c, "**Current Step:** Step 1"):
+			return schema.AssistantMessage(`{"findings":"dns findings","my_actions":"queried dns","iocs":[{"type":"domain","value":"evil.example"}]}`, nil)
+		case strings.Contains(c, "**Current Step:** Step 2") && broken:
+			return schema.AssistantMessage("not json", nil)
+		case strings.Contains(c, "**Current Step:** Step 2"):
+			return schema.AssistantMessage(`{"findings":"http findings","my_actions":"read http"}`, nil)
+		}
+		return stepAnswers(n, in)
+	}}
+	repairModel := &fakeModel{respond: func(int, []*schema.Message) *schema.Message {
+		return schema.AssistantMessage("still not json", nil)
+	}}
+	e := NewExecutor(newFakeAgent(t, agentModel), events.NopEmitter{}, &Config{RepairModel: repairModel, MaxRepairAttempts: 1})
+
+	var last *common.PlanState
+	checkpoint := func(_ context.Context, state *common.PlanState) error {
+		cp := *state
+		cp.Completed = make(map[int]bool)
+		for id, done := range state.Completed {
+			cp.Completed[id] = done
+		}
+		last = &cp
+		return nil
+	}
+	captures := []common.Capture{{Name: "a.pcap", Path: "/a.pcap"}}
+	if _, err := e.Run(context.Background(), plan, "q", captures, checkpoint); err == nil {
+		t.Fatal("Run succeeded with an unparseable step")
+	}
+	if last == nil || !last.Completed[1] || last.Completed[2] {
+		t.Fatalf("checkpoint = %+v", last)
+	}
+	if !strings.Contains(last.ResearchFindings, "dns findings") || len(last.Records) != 1 ||
+		len(last.IOCs) != 1 || len(last.OperationLog) != 1 {
+		t.Errorf("checkpoint lost step 1: %+v", last)
+	}
+
+	// Resuming runs only the failed step.
+	broken = false
+	res, err := e.Resume(context.Background(), last, "q", captures, nil)
+	if err != nil {
+		t.Fatalf("Resume: %v", err)
+	}
+	if len(res.Steps) != 3 || res.Steps[0].StepID != 1 || res.Steps[1].StepID != 2 ||
+		!strings.Contains(res.Findings, "dns findings") || !strings.Contains(res.Findings, "http findings") {
+		t.Errorf("resumed result: steps %+v, findings %q", res.Steps, res.Findings)
+	}
+	if strings.Count(res.Findings, "dns findings") != 1 {
+		t.Errorf("step 1 ran again: %q", res.Findings)
+	}
+}
+
+func TestStepRecords(t *testing.T) {
+	probe := &probeTool{}
+	m := &meteredModel{usage: 10, answerAfter: 2}
+	e := NewExecutor(newFakeAgent(t, m, probe), events.NopEmitter{}, nil)
+	res, err := e.Run(context.Background(), serialPlan(2), "q", []common.Capture{{Name: "a.pcap", Path: "/a.pcap"}}, nil)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if len(res.Steps) != 2 {
+		t.Fatalf("steps = %+v", res.Steps)
+	}
+	// Two tool calls and an answer: three model calls of 10 tokens each.
+	s := res.Steps[0]
+	if s.StepID != 1 || s.Intent != "step 1" || s.Status != common.StepCompleted || s.Findings != "found" ||
+		s.Actions != "did" || s.ToolCalls != 2 || s.TotalTokens != 30 {
+		t.Errorf("step record = %+v", s)
+	}
+	if s.StartedAt.IsZero() || s.FinishedAt.Before(s.StartedAt) {
+		t.Errorf("step timing = %v .. %v", s.StartedAt, s.FinishedAt)
+	}
+	if f := res.Steps[1]; f.StepID != 2 || f.Status != common.StepCompleted || f.TotalTokens != 10 {
+		t.Errorf("final step record = %+v", f)
+	}
+}
+
+func TestReportStreamsDeltas(t *testing.T) {
+	rec := &eventRecorder{}
+	e := NewExecutor(newFakeAgent(t, &fakeModel{respond: stepAnswers}), rec, nil)
+	res, err := e.Run(context.Background(), serialPlan(2), "q", []common.Capture{{Name: "a.pcap", Path: "/a.pcap"}}, nil)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	var report strings.Builder
+	seq := 0
+	for _, ev := range rec.evs {
+		if ev.Type != events.TypeReportDelta {
+			continue
+		}
+		var d events.ReportDeltaData
+		if err := json.Unmarshal(ev.Data, &d); err != nil {
+			t.Fatal(err)
+		}
+		if seq++; d.Seq != seq || ev.StepID != 2 {
+			t.Errorf("delta %d: seq %d, step %d", seq, d.Seq, ev.StepID)
+		}
+		report.WriteString(d.Delta)
+	}
+	// The fake model streams "final report" word by word.
+	if seq != 2 || report.String() != "final report" || !strings.HasPrefix(res.Report, "final report") {
+		t.Errorf("%d deltas %q; report %q", seq, report.String(), res.Report)
+	}
+}
@@ -0,0 +1,138 @@
+package executor
+
+import (
+	"encoding/json"
+	"fmt"
+	"pcap_agent/internal/common"
+	"strings"
+	"time"
+)
+
+// Replanner actions.
+const (
+	replanKeep   = "keep"
+	replanRevise = "revise"
+)
+
+// planRevision is the parsed JSON output of the replanner.
+type planRevision struct {
+	Action  string        `json:"action"`
+	Thought string        `json:"thought"`
+	Steps   []common.Step `json:"steps"`
+}
+
+// remainingSteps returns the steps that have not completed yet, in plan order.
+// The final synthesis step is always included.
+func remainingSteps(plan common.Plan, completed map[int]bool) []common.Step {
+	var out []common.Step
+	for _, s := range plan.Steps {
+		if !completed[s.StepID] {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// replanVars builds the replanner template variables from the current state.
+func replanVars(state *common.PlanState, userQuery string) map[string]any {
+	var done []string
+	for _, s := range state.Plan.Steps {
+		if state.Completed[s.StepID] {
+			done = append(done, fmt.Sprintf("%d", s.StepID))
+		}
+	}
+	remaining, _ := json.MarshalIndent(remainingSteps(state.Plan, state.Completed), "", "  ")
+
+	findings := state.ResearchFindings
+	if findings == "" {
+		findings = "(No research findings recorded)"
+	}
+	return map[string]any{
+		"user_query":        userQuery,
+		"plan_overview":     common.FormatPlanOverview(state.Plan),
+		"completed_steps":   strings.Join(done, ", "),
+		"research_findings": findings,
+		"remaining_steps":   string(remaining),
+	}
+}
+
+// applyRevision replaces the remaining steps of state.Plan with rev.Steps.
+// Completed steps are kept in their original order. The revision must end with
+// the plan's final synthesis step, unchanged, and the revised plan must pass
+// validateDAG; otherwise state is left untouched and an error is returned.
+// It returns the IDs of dropped and newly added steps. A step whose ID is
+// reused with a different intent counts as both dropped and added.
+func applyRevision(state *common.PlanState, rev *planRevision) (dropped, added []int, err error) {
+	if len(rev.Steps) == 0 {
+		return nil, nil, fmt.Errorf("revision has no steps (the final synthesis step is required)")
+	}
+	if len(state.Plan.Steps) > 0 {
+		final, last := state.Plan.Steps[len(state.Plan.Steps)-1], rev.Steps[len(rev.Steps)-1]
+		if last.StepID != final.StepID {
+			return nil, nil, fmt.Errorf("revision must end with the final synthesis step %d", final.StepID)
+		}
+		if strings.TrimSpace(last.Intent) != strings.TrimSpace(final.Intent) {
+			return nil, nil, fmt.Errorf("revision changes the intent of the final synthesis step %d", final.StepID)
+		}
+	}
+
+	before := make(map[int]string)
+	newPlan := state.Plan
+	newPlan.Steps = nil
+	for _, s := range state.Plan.Steps {
+		if state.Completed[s.StepID] {
+			newPlan.Steps = append(newPlan.Steps, s)
+		} else {
+			before[s.StepID] = strings.TrimSpace(s.Intent)
+		}
+	}
+
+	kept := make(map[int]bool, len(rev.Steps))
+	for _, s := range rev.Steps {
+		if state.Completed[s.StepID] {
+			return nil, nil, fmt.Errorf("revised step %d reuses the ID of a completed step", s.StepID)
+		}
+		if strings.TrimSpace(s.Intent) == "" {
+			return nil, nil, fmt.Errorf("revised step %d has an empty intent", s.StepID)
+		}
+		if intent, ok := before[s.StepID]; ok && intent == strings.TrimSpace(s.Intent) {
+			kept[s.StepID] = true
+		} else {
+			added = append(added, s.StepID)
+		}
+		newPlan.Steps = append(newPlan.Steps, s)
+	}
+	if err := validateDAG(newPlan); err != nil {
+		return nil, nil, err
+	}
+	for _, s := range state.Plan.Steps {
+		if _, ok := before[s.StepID]; ok && !kept[s.StepID] {
+			dropped = append(dropped, s.StepID)
+		}
+	}
+
+	state.Plan = newPlan
+	state.Revisions++
+	return dropped, added, nil
+}
+
+// skippedRecords returns a skipped-status record for each dropped step of prev.
+func skippedRecords(prev common.Plan, dropped []int) []common.StepRecord {
+	ids := make(map[int]bool, len(dropped))
+	for _, id := range dropped {
+		ids[id] = true
+	}
+	now := time.Now().UTC()
+	var out []common.StepRecord
+	for _, s := range prev.Steps {
+		if ids[s.StepID] {
+			out = append(out, common.StepRecord{
+				StepID:     s.StepID,
+				Intent:     s.Intent,
+				Status:     common.StepSkipped,
+				FinishedAt: now,
+			})
+		}
+	}
+	return out
+}
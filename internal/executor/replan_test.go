@@ -0,0 +1,56 @@
+package executor
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"pcap_agent/internal/common"
+)
+
+func TestApplyRevision(t *testing.T) {
+	newState := func() *common.PlanState {
+		return &common.PlanState{
+			Plan: common.Plan{Steps: []common.Step{
+				{StepID: 1, Intent: "a", DependsOn: []int{}},
+				{StepID: 2, Intent: "b", DependsOn: []int{}},
+				{StepID: 3, Intent: "c", DependsOn: []int{2}},
+				{StepID: 4, Intent: "report", DependsOn: []int{}},
+			}},
+			Completed: map[int]bool{1: true},
+		}
+	}
+	final := common.Step{StepID: 4, Intent: "report", DependsOn: []int{}}
+
+	state := newState()
+	dropped, added, err := applyRevision(state, &planRevision{Steps: []common.Step{
+		{StepID: 2, Intent: "b", DependsOn: []int{1}},
+		{StepID: 3, Intent: "something else", DependsOn: []int{}},
+		{StepID: 5, Intent: "e", DependsOn: []int{3}},
+		final,
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(dropped, added, state.Revisions, len(state.Plan.Steps)); got != "[3] [3 5] 1 5" {
+		t.Errorf("dropped, added, revisions, steps = %s", got)
+	}
+
+	for want, steps := range map[string][]common.Step{
+		"final synthesis step 4": {{StepID: 2, Intent: "b"}},
+		"intent of the final":    {{StepID: 4, Intent: "answer something else"}},
+		"completed step":         {{StepID: 1, Intent: "a"}, final},
+		"empty intent":           {{StepID: 2, Intent: " "}, final},
+		"depends on the final":   {{StepID: 2, Intent: "b", DependsOn: []int{4}}, final},
+		"has no steps":           nil,
+	} {
+		state := newState()
+		_, _, err := applyRevision(state, &planRevision{Steps: steps})
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("revision %+v: err = %v; want %q", steps, err, want)
+		}
+		if state.Revisions != 0 || len(state.Plan.Steps) != 4 {
+			t.Errorf("rejected revision changed the plan: %+v", state.Plan.Steps)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package planner
+
+import (
+	"fmt"
+	"pcap_agent/internal/common"
+	"strings"
+)
+
+// Plan editing helpers used by the interactive review gate. Step IDs refer to the
+// plan as currently numbered; every helper renumbers the plan (via NormalizePlan)
+// afterwards, so IDs are always 1..N in display order. The final synthesis step
+// cannot be moved or deleted, only rewritten.
+
+// DeleteStep removes step id and drops it from other steps' dependencies.
+func DeleteStep(plan *common.Plan, id int) error {
+	idx, err := editableIndex(plan, id)
+	if err != nil {
+		return err
+	}
+	explicitDeps(plan)
+	plan.Steps = append(plan.Steps[:idx], plan.Steps[idx+1:]...)
+	for i := range plan.Steps {
+		plan.Steps[i].DependsOn = without(plan.Steps[i].DependsOn, id)
+	}
+	NormalizePlan(plan)
+	return nil
+}
+
+// MoveStep moves step id to 1-based position pos. The final step stays last.
+func MoveStep(plan *common.Plan, id, pos int) error {
+	idx, err := editableIndex(plan, id)
+	if err != nil {
+		return err
+	}
+	if pos < 1 || pos > len(plan.Steps)-1 {
+		return fmt.Errorf("position must be between 1 and %d", len(plan.Steps)-1)
+	}
+	explicitDeps(plan)
+	step := plan.Steps[idx]
+	rest := append(plan.Steps[:idx:idx], plan.Steps[idx+1:]...)
+	plan.Steps = append(rest[:pos-1:pos-1], append([]common.Step{step}, rest[pos-1:]...)...)
+	NormalizePlan(plan)
+	return nil
+}
+
+// SetIntent rewrites the intent of step id. The final step may be rewritten.
+func SetIntent(plan *common.Plan, id int, intent string) error {
+	idx, err := stepIndex(plan, id)
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(intent) == "" {
+		return fmt.Errorf("intent must not be empty")
+	}
+	plan.Steps[idx].Intent = intent
+	NormalizePlan(plan)
+	return nil
+}
+
+// SetDependsOn replaces the dependencies of step id.
+func SetDependsOn(plan *common.Plan, id int, deps []int) error {
+	idx, err := editableIndex(plan, id)
+	if err != nil {
+		return err
+	}
+	for _, d := range deps {
+		if _, err := stepIndex(plan, d); err != nil {
+			return err
+		}
+	}
+	explicitDeps(plan)
+	plan.Steps[idx].DependsOn = append([]int{}, deps...)
+	NormalizePlan(plan)
+	return nil
+}
+
+// AddStep inserts a new step with the given intent and dependencies at 1-based
+// position pos (0 means just before the final step).
+func AddStep(plan *common.Plan, intent string, pos int, deps []int) error {
+	if strings.TrimSpace(intent) == "" {
+		return fmt.Errorf("intent must not be empty")
+	}
+	if len(plan.Steps) == 0 {
+		return fmt.Errorf("plan has no final step")
+	}
+	if pos == 0 {
+		pos = len(plan.Steps)
+	}
+	if pos < 1 || pos > len(plan.Steps) {
+		return fmt.Errorf("position must be between 1 and %d", len(plan.Steps))
+	}
+	for _, d := range deps {
+		if _, err := stepIndex(plan, d); err != nil {
+			return err
+		}
+	}
+	explicitDeps(plan)
+	newID := 0
+	for _, s := range plan.Steps {
+		newID = max(newID, s.StepID)
+	}
+	step := common.Step{StepID: newID + 1, Intent: intent, DependsOn: append([]int{}, deps...)}
+	plan.Steps = append(plan.Steps[:pos-1:pos-1], append([]common.Step{step}, plan.Steps[pos-1:]...)...)
+	NormalizePlan(plan)
+	return nil
+}
+
+// stepIndex returns the index of step id.
+func stepIndex(plan *common.Plan, id int) (int, error) {
+	for i, s := range plan.Steps {
+		if s.StepID == id {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("no step %d", id)
+}
+
+// editableIndex returns the index of step id, rejecting the final step.
+func editableIndex(plan *common.Plan, id int) (int, error) {
+	idx, err := stepIndex(plan, id)
+	if err != nil {
+		return 0, err
+	}
+	if idx == len(plan.Steps)-1 {
+		return 0, fmt.Errorf("step %d is the final synthesis step and must stay last", id)
+	}
+	return idx, nil
+}
+
+// explicitDeps replaces implicit "previous step" dependencies (nil DependsOn) with
+// the explicit predecessor ID, so that reordering does not silently change them.
+func explicitDeps(plan *common.Plan) {
+	for i := range plan.Steps {
+		if plan.Steps[i].DependsOn == nil {
+			if i == 0 {
+				plan.Steps[i].DependsOn = []int{}
+			} else {
+				plan.Steps[i].DependsOn = []int{plan.Steps[i-1].StepID}
+			}
+		}
+	}
+}
+
+// without returns ids with every occurrence of id removed.
+func without(ids []int, id int) []int {
+	if ids == nil {
+		return nil
+	}
+	out := make([]int, 0, len(ids))
+	for _, v := range ids {
+		if v != id {
+			out = append(out, v)
+		}
+	}
+	return out
+}
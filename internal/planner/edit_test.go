@@ -0,0 +1,76 @@
+package planner
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"pcap_agent/internal/common"
+)
+
+// editPlan returns a → b → c(a, b) → final, with b depending on a implicitly.
+func editPlan() common.Plan {
+	return common.Plan{TableSchema: "conn", Steps: []common.Step{
+		{StepID: 1, Intent: "a", DependsOn: []int{}},
+		{StepID: 2, Intent: "b"},
+		{StepID: 3, Intent: "c", DependsOn: []int{1, 2}},
+		{StepID: 4, Intent: "final", DependsOn: []int{}},
+	}}
+}
+
+// summary renders each step as "id:intent:deps" for compact comparison.
+func summary(p common.Plan) string {
+	out := make([]string, len(p.Steps))
+	for i, s := range p.Steps {
+		out[i] = fmt.Sprintf("%d:%s:%v", s.StepID, s.Intent, s.DependsOn)
+	}
+	return strings.Join(out, " ")
+}
+
+func TestPlanEdits(t *testing.T) {
+	check := func(edit func(p *common.Plan) error, want string) {
+		t.Helper()
+		p := editPlan()
+		if err := edit(&p); err != nil || summary(p) != want {
+			t.Errorf("plan = %s, %v; want %s", summary(p), err, want)
+		}
+	}
+	fails := func(edit func(p *common.Plan) error, want string) {
+		t.Helper()
+		p := editPlan()
+		if err := edit(&p); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("error = %v; want it to contain %q", err, want)
+		} else if p.Steps != nil && summary(p) != summary(editPlan()) {
+			t.Errorf("failed edit changed the plan: %s", summary(p))
+		}
+	}
+	check(func(p *common.Plan) error { return DeleteStep(p, 1) }, "1:b:[] 2:c:[1] 3:final:[]")
+	check(func(p *common.Plan) error { return MoveStep(p, 3, 1) }, "1:c:[2 3] 2:a:[] 3:b:[2] 4:final:[]")
+	check(func(p *common.Plan) error { return SetIntent(p, 4, " report ") }, "1:a:[] 2:b:[] 3:c:[1 2] 4:report:[]")
+	check(func(p *common.Plan) error { return SetDependsOn(p, 3, []int{2}) }, "1:a:[] 2:b:[1] 3:c:[2] 4:final:[]")
+	check(func(p *common.Plan) error { return AddStep(p, "d", 0, []int{3}) }, "1:a:[] 2:b:[1] 3:c:[1 2] 4:d:[3] 5:final:[]")
+
+	fails(func(p *common.Plan) error { return DeleteStep(p, 4) }, "final synthesis step")
+	fails(func(p *common.Plan) error { return MoveStep(p, 1, 4) }, "between 1 and 3")
+	fails(func(p *common.Plan) error { return DeleteStep(p, 9) }, "no step 9")
+	fails(func(p *common.Plan) error { return SetIntent(p, 1, "  ") }, "must not be empty")
+	fails(func(p *common.Plan) error { return SetDependsOn(p, 2, []int{7}) }, "no step 7")
+	fails(func(p *common.Plan) error { p.Steps = nil; return AddStep(p, "x", 0, nil) }, "no final step")
+}
+
+func TestEditedPlanStaysValid(t *testing.T) {
+	p := editPlan()
+	NormalizePlan(&p)
+	for _, edit := range []func() error{
+		func() error { return AddStep(&p, "d", 0, []int{1}) },
+		func() error { return MoveStep(&p, 4, 2) },
+		func() error { return DeleteStep(&p, 1) },
+	} {
+		if err := edit(); err != nil {
+			t.Fatal(err)
+		}
+		if err := ValidatePlan(p, nil); err != nil {
+			t.Fatalf("plan %s: %v", summary(p), err)
+		}
+	}
+}
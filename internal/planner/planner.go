@@ -2,7 +2,7 @@ package planner
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"pcap_agent/internal/common"
 	"pcap_agent/internal/events"
@@ -10,6 +10,7 @@ import (
 	"pcap_agent/pkg/logger"
 	"strings"
 
+	"github.com/cloudwego/eino/components/model"
 	"github.com/cloudwego/eino/components/prompt"
 	"github.com/cloudwego/eino/compose"
 	"github.com/cloudwego/eino/flow/agent"
@@ -20,23 +21,116 @@ import (
 // PlannerInput is the input to a planner invocation.
 type PlannerInput struct {
 	UserQuery string
-	PcapPath  string                 // container-side path to the target PCAP
+	Captures  []common.Capture       // target PCAPs at their container-side paths
 	History   *common.SessionHistory // nil on first round
 }
 
+// Defaults for planner behaviour.
+const (
+	// DefaultMaxRepairAttempts is the default number of JSON repair round-trips for a plan.
+	DefaultMaxRepairAttempts = 2
+
+	// DefaultMinSteps is the default minimum plan length (the final synthesis step).
+	DefaultMinSteps = 1
+
+	// DefaultMaxSteps is the default maximum plan length. It must stay below the
+	// executor's own limit so replanner revisions still have room.
+	DefaultMaxSteps = 15
+
+	// DefaultMaxIntentLen is the default maximum length of a step intent, in characters.
+	DefaultMaxIntentLen = 2000
+
+	// DefaultMaxPlanRetries is the default number of times planning is re-run after
+	// the plan fails validation.
+	DefaultMaxPlanRetries = 2
+)
+
+// planOutputHint is shown to the model when repairing a planner reply.
+const planOutputHint = `{"thought": "<reasoning>", "table_schema": "<verbatim pcapchu-scripts meta output>", "steps": [{"step_id": 1, "intent": "<intent>", "depends_on": []}]}`
+
+// Config tunes planner behaviour. A nil Config uses defaults.
+type Config struct {
+	// MaxRepairAttempts is how many times malformed plan JSON is sent back to the
+	// model for repair before planning fails. Default: DefaultMaxRepairAttempts.
+	MaxRepairAttempts int
+
+	// MinSteps and MaxSteps bound the number of steps, including the final step.
+	// Defaults: DefaultMinSteps, DefaultMaxSteps.
+	MinSteps int
+	MaxSteps int
+
+	// MaxIntentLen caps each step's intent, in characters. Default: DefaultMaxIntentLen.
+	MaxIntentLen int
+
+	// MaxPlanRetries is how many times planning is re-run, with the validation
+	// errors as feedback, before Run fails. Default: DefaultMaxPlanRetries.
+	MaxPlanRetries int
+
+	// RepairModel answers JSON repair requests. Pass the bare chat model: a
+	// repair is a single text-only call, and through the ReAct agent the model
+	// could run tools instead of answering. nil sends repairs through the agent.
+	RepairModel model.BaseChatModel
+}
+
+// GetMaxRepairAttempts returns the effective repair attempt count, using default if not set.
+func (c *Config) GetMaxRepairAttempts() int {
+	if c == nil || c.MaxRepairAttempts <= 0 {
+		return DefaultMaxRepairAttempts
+	}
+	return c.MaxRepairAttempts
+}
+
+// GetMinSteps returns the effective minimum step count, using default if not set.
+func (c *Config) GetMinSteps() int {
+	if c == nil || c.MinSteps <= 0 {
+		return DefaultMinSteps
+	}
+	return c.MinSteps
+}
+
+// GetMaxSteps returns the effective maximum step count, using default if not set.
+func (c *Config) GetMaxSteps() int {
+	if c == nil || c.MaxSteps <= 0 {
+		return DefaultMaxSteps
+	}
+	return c.MaxSteps
+}
+
+// GetMaxIntentLen returns the effective intent length cap, using default if not set.
+func (c *Config) GetMaxIntentLen() int {
+	if c == nil || c.MaxIntentLen <= 0 {
+		return DefaultMaxIntentLen
+	}
+	return c.MaxIntentLen
+}
+
+// GetMaxPlanRetries returns the effective validation retry count, using default if not set.
+func (c *Config) GetMaxPlanRetries() int {
+	if c == nil || c.MaxPlanRetries <= 0 {
+		return DefaultMaxPlanRetries
+	}
+	return c.MaxPlanRetries
+}
+
 // Planner wraps a compiled eino graph that produces a Plan from a user query.
 type Planner struct {
 	graph   compose.Runnable[map[string]any, common.Plan]
 	emitter events.Emitter
+	cfg     *Config
 }
 
 // NewPlanner builds the planner graph: prompt → react → parse.
 // rAgent is the shared ReAct agent (with tools for pcapchu-scripts meta etc.).
-func NewPlanner(ctx context.Context, rAgent *react.Agent, emitter events.Emitter) (*Planner, error) {
+func NewPlanner(ctx context.Context, rAgent *react.Agent, emitter events.Emitter, cfg *Config) (*Planner, error) {
 	plannerPrompt, err := prompts.GetSinglePrompt("planner")
 	if err != nil {
 		return nil, fmt.Errorf("load planner prompt: %w", err)
 	}
+	repairPrompt, err := prompts.GetSinglePrompt("json_repair")
+	if err != nil {
+		return nil, fmt.Errorf("load repair prompt: %w", err)
+	}
+	maxRepair := cfg.GetMaxRepairAttempts()
 
 	tpl := prompt.FromMessages(schema.GoTemplate,
 		schema.SystemMessage(plannerPrompt),
@@ -45,7 +139,7 @@ func NewPlanner(ctx context.Context, rAgent *react.Agent, emitter events.Emitter
 
 	// Planner ReAct with callback-based logging
 	plannerReActLambda := compose.InvokableLambda(func(ctx context.Context, in []*schema.Message) (*schema.Message, error) {
-		logger.Infof("[Planner-ReAct] input messages count: %d", len(in))
+		logger.CtxInfof(ctx, "[Planner-ReAct] input messages count: %d", len(in))
 		cb := &logger.PrettyLoggerCallback{}
 		timer := logger.NewTimer()
 		out, err := rAgent.Generate(ctx, in,
@@ -53,26 +147,42 @@ func NewPlanner(ctx context.Context, rAgent *react.Agent, emitter events.Emitter
 		)
 		elapsed := timer.ElapsedMs()
 		if err != nil {
-			logger.Errorf("[Planner-ReAct] error after %dms: %v", elapsed, err)
+			logger.CtxErrorf(ctx, "[Planner-ReAct] error after %dms: %v", elapsed, err)
 			return nil, err
 		}
-		logger.Infof("[Planner-ReAct] output (%dms) callback_events=%d content=%s",
+		logger.CtxInfof(ctx, "[Planner-ReAct] output (%dms) callback_events=%d content=%s",
 			elapsed, cb.Step, common.TruncateStr(out.Content, 500))
 		return out, nil
 	})
 
-	// Parse LLM output → Plan
+	// Parse LLM output → Plan, asking the model to repair malformed JSON
+	repairGen := func(ctx context.Context, msgs []*schema.Message) (*schema.Message, error) {
+		logger.CtxInfof(ctx, "[Planner-Repair] input messages count: %d", len(msgs))
+		if cfg != nil && cfg.RepairModel != nil {
+			return cfg.RepairModel.Generate(ctx, msgs)
+		}
+		return rAgent.Generate(ctx, msgs)
+	}
 	parseLambda := compose.InvokableLambda(func(ctx context.Context, input *schema.Message) (common.Plan, error) {
 		var plan common.Plan
-		str, err := common.ExtractJSON(input.Content)
+		err := common.ParseJSONWithRepair(ctx, repairGen, input.Content, &plan, common.RepairOptions{
+			SystemPrompt: repairPrompt,
+			SchemaHint:   planOutputHint,
+			MaxAttempts:  maxRepair,
+			OnRetry: func(attempt int, err error) {
+				logger.CtxWarnf(ctx, "[Planner] output invalid, repair attempt %d/%d: %v", attempt, maxRepair, err)
+				emitter.Emit(events.NewCtxEvent(ctx, events.TypeStepRetry, events.RetryData{
+					Phase:       "planner",
+					Attempt:     attempt,
+					MaxAttempts: maxRepair,
+					Error:       err.Error(),
+				}))
+			},
+		})
 		if err != nil {
-			return common.Plan{}, fmt.Errorf("extract json from planner output: %w", err)
-		}
-		logger.Infof("[Planner] extracted JSON: %s", common.TruncateStr(str, 500))
-		if err := json.Unmarshal([]byte(str), &plan); err != nil {
-			return common.Plan{}, fmt.Errorf("unmarshal plan: %w | content: %s", err, common.TruncateStr(input.Content, 1000))
+			return common.Plan{}, fmt.Errorf("parse plan: %w | content: %s", err, common.TruncateStr(input.Content, 1000))
 		}
-		logger.Infof("[Planner] plan parsed: %d steps", len(plan.Steps))
+		logger.CtxInfof(ctx, "[Planner] plan parsed: %d steps", len(plan.Steps))
 		return plan, nil
 	})
 
@@ -90,15 +200,21 @@ func NewPlanner(ctx context.Context, rAgent *react.Agent, emitter events.Emitter
 		return nil, fmt.Errorf("compile planner graph: %w", err)
 	}
 
-	return &Planner{graph: compiled, emitter: emitter}, nil
+	return &Planner{graph: compiled, emitter: emitter, cfg: cfg}, nil
 }
 
-// Run executes the planner graph and returns a Plan.
+// Run executes the planner graph and returns a normalized, validated Plan.
 // If input.History is non-nil, session history is injected as a user message before the query.
+// A plan that fails validation is rejected and planning is re-run with the
+// problems appended to the query, up to the configured number of retries.
 func (p *Planner) Run(ctx context.Context, input PlannerInput) (common.Plan, error) {
+	// Tool calls made while planning are reported on the planner's emitter
+	ctx = events.WithEmitter(ctx, p.emitter)
+
 	templateVars := map[string]any{
 		"user_input": input.UserQuery,
-		"pcap_path":  input.PcapPath,
+		"pcap_path":  common.CapturePaths(input.Captures),
+		"pcap_files": common.FormatCaptures(input.Captures),
 	}
 
 	// If we have session history, prepend it to the user input
@@ -109,21 +225,51 @@ func (p *Planner) Run(ctx context.Context, input PlannerInput) (common.Plan, err
 		}
 	}
 
-	plan, err := p.graph.Invoke(ctx, templateVars)
-	if err != nil {
-		p.emitter.Emit(events.NewEvent(events.TypePlanError, "", events.ErrorData{
-			Phase:   "planner",
-			Message: err.Error(),
+	userInput := templateVars["user_input"].(string)
+	maxRetries := p.cfg.GetMaxPlanRetries()
+
+	var plan common.Plan
+	for attempt := 0; ; attempt++ {
+		var err error
+		plan, err = p.graph.Invoke(ctx, templateVars)
+		if err != nil {
+			p.emitter.Emit(events.NewCtxEvent(ctx, events.TypePlanError, events.ErrorData{
+				Phase:   "planner",
+				Message: err.Error(),
+			}))
+			return common.Plan{}, fmt.Errorf("planner invoke: %w", err)
+		}
+
+		NormalizePlan(&plan)
+		err = ValidatePlan(plan, p.cfg)
+		if err == nil {
+			break
+		}
+		var verrs ValidationErrors
+		if !errors.As(err, &verrs) || attempt >= maxRetries {
+			p.emitter.Emit(events.NewCtxEvent(ctx, events.TypePlanError, events.ErrorData{
+				Phase:   "planner",
+				Message: err.Error(),
+			}))
+			return common.Plan{}, fmt.Errorf("planner: %w (after %d attempts)", err, attempt+1)
+		}
+
+		logger.CtxWarnf(ctx, "[Planner] plan rejected, retry %d/%d: %v", attempt+1, maxRetries, err)
+		p.emitter.Emit(events.NewCtxEvent(ctx, events.TypeStepRetry, events.RetryData{
+			Phase:       "planner",
+			Attempt:     attempt + 1,
+			MaxAttempts: maxRetries,
+			Error:       err.Error(),
 		}))
-		return common.Plan{}, fmt.Errorf("planner invoke: %w", err)
+		templateVars["user_input"] = userInput + "\n\n---\n\n**Your previous plan was rejected.** Produce a new plan that fixes these problems:\n" + verrs.Feedback()
 	}
 
 	// Emit plan created event
 	steps := make([]events.StepInfo, len(plan.Steps))
 	for i, s := range plan.Steps {
-		steps[i] = events.StepInfo{StepID: s.StepID, Intent: s.Intent}
+		steps[i] = events.StepInfo{StepID: s.StepID, Intent: s.Intent, DependsOn: s.DependsOn}
 	}
-	p.emitter.Emit(events.NewEvent(events.TypePlanCreated, "", events.PlanCreatedData{
+	p.emitter.Emit(events.NewCtxEvent(ctx, events.TypePlanCreated, events.PlanCreatedData{
 		Thought:    plan.Thought,
 		TotalSteps: len(plan.Steps),
 		Steps:      steps,
@@ -140,6 +286,12 @@ func buildHistorySection(h *common.SessionHistory) string {
 
 	var parts []string
 
+	if h.OmittedRounds > 0 {
+		parts = append(parts, fmt.Sprintf("_The %d earliest round(s) are omitted to fit the context budget._", h.OmittedRounds))
+	}
+	if h.Digests != "" {
+		parts = append(parts, "## Earlier Rounds (Digests)\n\n"+h.Digests)
+	}
 	if h.Findings != "" {
 		parts = append(parts, "## Previous Research Findings\n\n"+h.Findings)
 	}
@@ -0,0 +1,152 @@
+package planner
+
+import (
+	"fmt"
+	"pcap_agent/internal/common"
+	"strings"
+	"unicode/utf8"
+)
+
+// Validation error codes.
+const (
+	CodeNoSteps            = "no_steps"
+	CodeTooFewSteps        = "too_few_steps"
+	CodeTooManySteps       = "too_many_steps"
+	CodeEmptyIntent        = "empty_intent"
+	CodeIntentTooLong      = "intent_too_long"
+	CodeMissingTableSchema = "missing_table_schema"
+	CodeBadDependency      = "bad_dependency"
+)
+
+// ValidationError describes one problem with a plan. StepID is the normalized
+// step ID the problem refers to, or 0 for plan-level problems.
+type ValidationError struct {
+	Code    string
+	StepID  int
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	if e.StepID > 0 {
+		return fmt.Sprintf("step %d: %s", e.StepID, e.Message)
+	}
+	return e.Message
+}
+
+// ValidationErrors is the list of problems found in a plan. It is returned as a
+// single error so callers can use errors.As to retrieve every problem at once.
+type ValidationErrors []*ValidationError
+
+func (es ValidationErrors) Error() string {
+	msgs := make([]string, len(es))
+	for i, e := range es {
+		msgs[i] = e.Error()
+	}
+	return "invalid plan: " + strings.Join(msgs, "; ")
+}
+
+// Feedback formats the problems as a bullet list for the planner's retry prompt.
+func (es ValidationErrors) Feedback() string {
+	var sb strings.Builder
+	for _, e := range es {
+		sb.WriteString("- ")
+		sb.WriteString(e.Error())
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+// NormalizePlan rewrites plan in place into the canonical form the executor expects:
+// intents and table schema are trimmed, step IDs are renumbered 1..N in plan order
+// (with depends_on remapped accordingly), duplicate dependencies are removed, and
+// the final step's depends_on is cleared since it implicitly depends on every step.
+//
+// A dependency on a duplicated ID resolves to the closest preceding step with that
+// ID. Dependencies that cannot be resolved are kept as -1 so ValidatePlan reports them.
+func NormalizePlan(plan *common.Plan) {
+	plan.TableSchema = strings.TrimSpace(plan.TableSchema)
+
+	// Resolve old IDs to new ones as seen from each position.
+	latest := make(map[int]int, len(plan.Steps)) // old ID -> most recent new ID
+	first := make(map[int]int, len(plan.Steps))  // old ID -> first new ID (forward references)
+	for i, s := range plan.Steps {
+		if _, ok := first[s.StepID]; !ok {
+			first[s.StepID] = i + 1
+		}
+	}
+	for i := range plan.Steps {
+		s := &plan.Steps[i]
+		newID := i + 1
+		s.Intent = strings.TrimSpace(s.Intent)
+
+		if s.DependsOn != nil {
+			deps := make([]int, 0, len(s.DependsOn))
+			seen := make(map[int]bool, len(s.DependsOn))
+			for _, d := range s.DependsOn {
+				nd, ok := latest[d]
+				if !ok {
+					if nd, ok = first[d]; !ok {
+						nd = -1
+					}
+				}
+				if !seen[nd] {
+					seen[nd] = true
+					deps = append(deps, nd)
+				}
+			}
+			s.DependsOn = deps
+		}
+		latest[s.StepID] = newID
+		s.StepID = newID
+	}
+	if n := len(plan.Steps); n > 0 {
+		plan.Steps[n-1].DependsOn = []int{}
+	}
+}
+
+// ValidatePlan checks a normalized plan against cfg's limits. It returns nil or
+// a ValidationErrors listing every problem found.
+func ValidatePlan(plan common.Plan, cfg *Config) error {
+	var errs ValidationErrors
+	add := func(code string, stepID int, format string, args ...any) {
+		errs = append(errs, &ValidationError{Code: code, StepID: stepID, Message: fmt.Sprintf(format, args...)})
+	}
+
+	n := len(plan.Steps)
+	minSteps, maxSteps := cfg.GetMinSteps(), cfg.GetMaxSteps()
+	switch {
+	case n == 0:
+		add(CodeNoSteps, 0, "plan has no steps (at least the final synthesis step is required)")
+	case n < minSteps:
+		add(CodeTooFewSteps, 0, "plan has %d steps, minimum is %d", n, minSteps)
+	case n > maxSteps:
+		add(CodeTooManySteps, 0, "plan has %d steps, maximum is %d — merge related steps", n, maxSteps)
+	}
+	if plan.TableSchema == "" {
+		add(CodeMissingTableSchema, 0, "table_schema is empty — include the verbatim output of `pcapchu-scripts meta`")
+	}
+
+	maxIntent := cfg.GetMaxIntentLen()
+	for _, s := range plan.Steps {
+		if s.Intent == "" {
+			add(CodeEmptyIntent, s.StepID, "intent is empty")
+		} else if l := utf8.RuneCountInString(s.Intent); l > maxIntent {
+			add(CodeIntentTooLong, s.StepID, "intent is %d characters, maximum is %d", l, maxIntent)
+		}
+		for _, d := range s.DependsOn {
+			switch {
+			case d == -1:
+				add(CodeBadDependency, s.StepID, "depends_on references a step that does not exist")
+			case d == s.StepID:
+				add(CodeBadDependency, s.StepID, "depends_on references the step itself")
+			case d > s.StepID:
+				add(CodeBadDependency, s.StepID, "depends_on references later step %d (only earlier steps are allowed)", d)
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package planner
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"pcap_agent/internal/common"
+)
+
+func TestNormalizePlan(t *testing.T) {
+	p := common.Plan{TableSchema: " conn ", Steps: []common.Step{
+		{StepID: 3, Intent: " a ", DependsOn: []int{}},
+		{StepID: 3, Intent: "b", DependsOn: []int{3, 3}},
+		{StepID: 7, Intent: "c"},
+		{StepID: 8, Intent: "d", DependsOn: []int{9, 42}},
+		{StepID: 9, Intent: "final", DependsOn: []int{3}},
+	}}
+	NormalizePlan(&p)
+
+	if p.TableSchema != "conn" || p.Steps[0].Intent != "a" {
+		t.Errorf("not trimmed: %q, %q", p.TableSchema, p.Steps[0].Intent)
+	}
+	// IDs are renumbered, duplicate dependencies dropped, forward references
+	// resolved, unknown ones kept as -1 and the final step's cleared.
+	var got []string
+	for _, s := range p.Steps {
+		got = append(got, fmt.Sprintf("%d%v", s.StepID, s.DependsOn))
+	}
+	if want := "1[] 2[1] 3[] 4[5 -1] 5[]"; strings.Join(got, " ") != want {
+		t.Errorf("normalized = %s; want %s", strings.Join(got, " "), want)
+	}
+	if p.Steps[2].DependsOn != nil {
+		t.Error("implicit dependency made explicit")
+	}
+}
+
+func TestValidatePlan(t *testing.T) {
+	check := func(cfg *Config, edit func(p *common.Plan), want string) {
+		t.Helper()
+		p := common.Plan{TableSchema: "conn", Steps: []common.Step{
+			{StepID: 1, Intent: "a", DependsOn: []int{}},
+			{StepID: 2, Intent: "b", DependsOn: []int{1}},
+			{StepID: 3, Intent: "final", DependsOn: []int{}},
+		}}
+		edit(&p)
+		var errs ValidationErrors
+		if err := ValidatePlan(p, cfg); err != nil && !errors.As(err, &errs) {
+			t.Fatalf("error = %v; want ValidationErrors", err)
+		}
+		var codes []string
+		for _, e := range errs {
+			codes = append(codes, fmt.Sprintf("%s@%d", e.Code, e.StepID))
+		}
+		if got := strings.Join(codes, " "); got != want {
+			t.Errorf("codes = %q; want %q", got, want)
+		}
+	}
+	check(nil, func(*common.Plan) {}, "")
+	check(nil, func(p *common.Plan) { p.Steps = nil }, CodeNoSteps+"@0")
+	check(&Config{MinSteps: 4}, func(*common.Plan) {}, CodeTooFewSteps+"@0")
+	check(&Config{MaxSteps: 2}, func(*common.Plan) {}, CodeTooManySteps+"@0")
+	check(&Config{MaxIntentLen: 10}, func(p *common.Plan) { p.Steps[0].Intent = strings.Repeat("é", 11) }, CodeIntentTooLong+"@1")
+	check(nil, func(p *common.Plan) { p.Steps[1].DependsOn = []int{-1} }, CodeBadDependency+"@2")
+	check(nil, func(p *common.Plan) { p.Steps[0].DependsOn = []int{2} }, CodeBadDependency+"@1")
+	check(nil, func(p *common.Plan) {
+		p.TableSchema = ""
+		p.Steps[0].Intent = ""
+		p.Steps[1].DependsOn = []int{2}
+	}, CodeMissingTableSchema+"@0 "+CodeEmptyIntent+"@1 "+CodeBadDependency+"@2")
+
+	errs := ValidationErrors{
+		{Code: CodeMissingTableSchema, Message: "table_schema is empty"},
+		{Code: CodeEmptyIntent, StepID: 2, Message: "intent is empty"},
+	}
+	if got, want := errs.Feedback(), "- table_schema is empty\n- step 2: intent is empty\n"; got != want {
+		t.Errorf("Feedback() = %q; want %q", got, want)
+	}
+}
@@ -0,0 +1,622 @@
+// Package server exposes the agent over HTTP: REST endpoints to create sessions
+// from uploaded captures, submit queries and read rounds and reports, and a
+// Server-Sent Events stream per session that replays its event log and then
+// relays live events.
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"pcap_agent/internal/bundle"
+	"pcap_agent/internal/common"
+	"pcap_agent/internal/events"
+	"pcap_agent/internal/executor"
+	"pcap_agent/internal/session"
+	"pcap_agent/pkg/logger"
+)
+
+const (
+	DefaultUploadDir      = "uploads"
+	DefaultMaxUploadBytes = 2 << 30 // 2 GiB per request
+	DefaultHeartbeat      = 15 * time.Second
+
+	// uploadField is the multipart form field holding capture files.
+	uploadField = "pcap"
+	// maxQueryBytes bounds the JSON body of a query submission.
+	maxQueryBytes = 1 << 20
+)
+
+// StageFunc registers uploaded captures and copies them into the sandbox,
+// returning the captures to attach to the session. existing are the captures
+// the session already has. Every path of one call lives in the same freshly
+// created directory, so its name may be used to keep container paths distinct.
+type StageFunc func(ctx context.Context, localPaths []string, existing []session.SessionPcap) ([]session.SessionPcap, error)
+
+// RoundInput is everything a RoundFunc needs to plan and execute one round.
+type RoundInput struct {
+	Query      string
+	Captures   []common.Capture
+	History    *common.SessionHistory // nil for the first round
+	Checkpoint executor.CheckpointFunc
+}
+
+// RoundFunc plans and executes one round, emitting its progress to emitter.
+type RoundFunc func(ctx context.Context, emitter events.Emitter, in RoundInput) (*executor.Result, error)
+
+// Config configures the HTTP API.
+type Config struct {
+	Store   session.Store
+	Stage   StageFunc
+	Round   RoundFunc
+	History *session.HistoryConfig // previous-round context given to the planner; nil uses the defaults
+
+	UploadDir      string        // where uploaded captures are kept (default "uploads")
+	MaxUploadBytes int64         // max size of one upload request (default 2 GiB)
+	Heartbeat      time.Duration // interval of SSE keep-alive comments (default 15s)
+
+	// Token, if set, must be presented as "Authorization: Bearer <token>" on
+	// every request; others are answered 401.
+	Token string
+}
+
+func (c *Config) GetUploadDir() string {
+	if c == nil || c.UploadDir == "" {
+		return DefaultUploadDir
+	}
+	return c.UploadDir
+}
+
+func (c *Config) GetMaxUploadBytes() int64 {
+	if c == nil || c.MaxUploadBytes <= 0 {
+		return DefaultMaxUploadBytes
+	}
+	return c.MaxUploadBytes
+}
+
+func (c *Config) GetHeartbeat() time.Duration {
+	if c == nil || c.Heartbeat <= 0 {
+		return DefaultHeartbeat
+	}
+	return c.Heartbeat
+}
+
+// Server serves the HTTP API. Rounds run in the background, one at a time,
+// because every session shares the same sandbox; a session accepts a new query
+// only once its previous round has finished. Plans are executed without review.
+type Server struct {
+	cfg    *Config
+	mux    *http.ServeMux
+	ctx    context.Context // cancelled by Close; parent of every round
+	cancel context.CancelFunc
+
+	mu     sync.Mutex
+	live   map[string]*liveSession
+	closed bool
+
+	runMu  sync.Mutex // serializes rounds across sessions
+	rounds sync.WaitGroup
+}
+
+// New creates a Server. Store, Stage and Round are required.
+func New(cfg *Config) (*Server, error) {
+	if cfg == nil || cfg.Store == nil || cfg.Stage == nil || cfg.Round == nil {
+		return nil, fmt.Errorf("server config requires a store, a stage function and a round function")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Server{
+		cfg:    cfg,
+		mux:    http.NewServeMux(),
+		ctx:    ctx,
+		cancel: cancel,
+		live:   map[string]*liveSession{},
+	}
+	s.mux.HandleFunc("GET /api/sessions", s.handleListSessions)
+	s.mux.HandleFunc("POST /api/sessions", s.handleCreateSession)
+	s.mux.HandleFunc("GET /api/sessions/{id}", s.handleGetSession)
+	s.mux.HandleFunc("POST /api/sessions/{id}/pcaps", s.handleAddPcaps)
+	s.mux.HandleFunc("POST /api/sessions/{id}/queries", s.handleSubmitQuery)
+	s.mux.HandleFunc("GET /api/sessions/{id}/rounds", s.handleListRounds)
+	s.mux.HandleFunc("GET /api/sessions/{id}/rounds/{n}", s.handleGetRound)
+	s.mux.HandleFunc("GET /api/sessions/{id}/rounds/{n}/report", s.handleGetReport)
+	s.mux.HandleFunc("GET /api/sessions/{id}/events", s.handleEvents)
+	s.mux.Handle("GET /debug/vars", expvar.Handler())
+	return s, nil
+}
+
+// ServeHTTP implements http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.cfg.Token != "" && !s.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="pcap_agent"`)
+		writeError(w, http.StatusUnauthorized, "missing or invalid bearer token")
+		return
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) authorized(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(s.cfg.Token)) == 1
+}
+
+// Close cancels in-flight rounds, waits for them to record their failure (their
+// checkpoints stay resumable) and ends every event stream. Call it before
+// shutting down the http.Server, which otherwise waits for the streams.
+func (s *Server) Close() {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	s.mu.Unlock()
+
+	s.cancel()
+	s.rounds.Wait()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, ls := range s.live {
+		ls.events.Close()
+	}
+}
+
+// --- Sessions ---
+
+// sessionDetail is a session with its captures and whether a round is running.
+type sessionDetail struct {
+	session.SessionInfo
+	Pcaps   []session.SessionPcap `json:"pcaps"`
+	Running bool                  `json:"running"`
+}
+
+func (s *Server) handleListSessions(w http.ResponseWriter, r *http.Request) {
+	sessions, err := s.cfg.Store.ListSessions()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "list sessions: %v", err)
+		return
+	}
+	if sessions == nil {
+		sessions = []session.SessionInfo{}
+	}
+	writeJSON(w, http.StatusOK, sessions)
+}
+
+// handleCreateSession creates a session over the captures uploaded in the
+// multipart field "pcap" (repeatable).
+func (s *Server) handleCreateSession(w http.ResponseWriter, r *http.Request) {
+	pcaps, dir, ok := s.receivePcaps(w, r, nil)
+	if !ok {
+		return
+	}
+	if len(pcaps) == 0 {
+		os.RemoveAll(dir)
+		writeError(w, http.StatusBadRequest, "the uploaded captures are duplicates of each other")
+		return
+	}
+	sess, err := session.NewSession(s.cfg.Store, pcaps)
+	if err != nil {
+		os.RemoveAll(dir)
+		writeError(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+	logger.Infof("[Server] created session %s with %d captures", sess.ID, len(pcaps))
+	detail, err := s.sessionDetail(sess.ID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+	w.Header().Set("Location", "/api/sessions/"+sess.ID)
+	writeJSON(w, http.StatusCreated, detail)
+}
+
+func (s *Server) handleGetSession(w http.ResponseWriter, r *http.Request) {
+	detail, err := s.sessionDetail(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+	if detail == nil {
+		writeError(w, http.StatusNotFound, "session %s not found", r.PathValue("id"))
+		return
+	}
+	writeJSON(w, http.StatusOK, detail)
+}
+
+// handleAddPcaps attaches more uploaded captures to an existing session.
+// Captures whose contents the session already has are skipped.
+func (s *Server) handleAddPcaps(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if !s.requireSession(w, id) {
+		return
+	}
+	if s.running(id) {
+		writeError(w, http.StatusConflict, "session %s is running a round", id)
+		return
+	}
+	existing, err := s.cfg.Store.GetSessionPcaps(id)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "load session captures: %v", err)
+		return
+	}
+	pcaps, dir, ok := s.receivePcaps(w, r, existing)
+	if !ok {
+		return
+	}
+	for _, p := range pcaps {
+		if err := s.cfg.Store.AddSessionPcap(id, p); err != nil {
+			os.RemoveAll(dir)
+			writeError(w, http.StatusInternalServerError, "add capture to session: %v", err)
+			return
+		}
+	}
+	detail, err := s.sessionDetail(id)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, detail)
+}
+
+// sessionDetail loads a session's detail, or nil if it does not exist.
+func (s *Server) sessionDetail(id string) (*sessionDetail, error) {
+	info, err := s.cfg.Store.GetSession(id)
+	if err != nil || info == nil {
+		return nil, err
+	}
+	pcaps, err := s.cfg.Store.GetSessionPcaps(id)
+	if err != nil {
+		return nil, fmt.Errorf("load session captures: %w", err)
+	}
+	if pcaps == nil {
+		pcaps = []session.SessionPcap{}
+	}
+	return &sessionDetail{SessionInfo: *info, Pcaps: pcaps, Running: s.running(id)}, nil
+}
+
+// receivePcaps saves the uploaded captures under a new directory of the upload
+// dir and stages them, returning the staged captures and that directory. Files
+// the store does not reference afterwards (duplicates of registered captures)
+// are removed. It writes the error response itself and reports whether the
+// request may continue; a caller that fails later must remove the directory.
+func (s *Server) receivePcaps(w http.ResponseWriter, r *http.Request, existing []session.SessionPcap) ([]session.SessionPcap, string, bool) {
+	r.Body = http.MaxBytesReader(w, r.Body, s.cfg.GetMaxUploadBytes())
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, http.StatusRequestEntityTooLarge, "upload exceeds %d bytes", tooLarge.Limit)
+		} else {
+			writeError(w, http.StatusBadRequest, "expected a multipart/form-data upload: %v", err)
+		}
+		return nil, "", false
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	files := r.MultipartForm.File[uploadField]
+	if len(files) == 0 {
+		writeError(w, http.StatusBadRequest, "no capture uploaded in form field %q", uploadField)
+		return nil, "", false
+	}
+	if err := os.MkdirAll(s.cfg.GetUploadDir(), 0o755); err != nil {
+		writeError(w, http.StatusInternalServerError, "create upload dir: %v", err)
+		return nil, "", false
+	}
+	dir, err := os.MkdirTemp(s.cfg.GetUploadDir(), "upload-")
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "create upload dir: %v", err)
+		return nil, "", false
+	}
+	dir, err = filepath.Abs(dir)
+	if err != nil {
+		os.RemoveAll(dir)
+		writeError(w, http.StatusInternalServerError, "%v", err)
+		return nil, "", false
+	}
+
+	var paths []string
+	used := map[string]bool{}
+	for _, fh := range files {
+		name := filepath.Base(strings.ReplaceAll(fh.Filename, `\`, "/"))
+		if name == "." || name == "/" || name == "" {
+			name = "capture.pcap"
+		}
+		base := name
+		for n := 2; used[name]; n++ {
+			name = fmt.Sprintf("%d_%s", n, base)
+		}
+		used[name] = true
+
+		path := filepath.Join(dir, name)
+		if err := saveUpload(fh, path); err != nil {
+			os.RemoveAll(dir)
+			writeError(w, http.StatusInternalServerError, "save upload %s: %v", fh.Filename, err)
+			return nil, "", false
+		}
+		paths = append(paths, path)
+	}
+
+	pcaps, err := s.cfg.Stage(r.Context(), paths, existing)
+	if err != nil {
+		os.RemoveAll(dir)
+		writeError(w, http.StatusInternalServerError, "stage captures: %v", err)
+		return nil, "", false
+	}
+	s.pruneUploads(dir, paths, pcaps)
+	return pcaps, dir, true
+}
+
+// pruneUploads removes the uploaded files at paths that no registered capture
+// of pcaps points to, and dir itself once it is empty.
+func (s *Server) pruneUploads(dir string, paths []string, pcaps []session.SessionPcap) {
+	keep := map[string]bool{}
+	for _, p := range pcaps {
+		if p.FileHash == "" {
+			continue
+		}
+		f, err := s.cfg.Store.GetPcapFileByHash(p.FileHash)
+		if err != nil {
+			// Without the registration, keep everything rather than guess.
+			logger.Warnf("[Server] look up capture %s: %v", p.FileHash, err)
+			return
+		}
+		if f != nil {
+			keep[f.FilePath] = true
+		}
+	}
+	for _, path := range paths {
+		if !keep[path] {
+			os.Remove(path)
+		}
+	}
+	os.Remove(dir) // fails, harmlessly, while files remain
+}
+
+// saveUpload copies an uploaded file to path.
+func saveUpload(fh *multipart.FileHeader, path string) error {
+	src, err := fh.Open()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
+
+// --- Rounds ---
+
+// queryRequest is the body of a query submission.
+type queryRequest struct {
+	Query string `json:"query"`
+}
+
+// queryAccepted is returned when a round has been started in the background.
+type queryAccepted struct {
+	SessionID string `json:"session_id"`
+	Round     int    `json:"round"`  // expected round number; see the round.completed event for the final one
+	RunID     string `json:"run_id"` // carried by every event and log line of the round
+	Events    string `json:"events"` // SSE stream reporting the round's progress
+}
+
+// handleSubmitQuery starts a round for the query in the background and returns
+// 202 Accepted. Progress is reported on the session's event stream.
+func (s *Server) handleSubmitQuery(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	var req queryRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxQueryBytes)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body: %v", err)
+		return
+	}
+	req.Query = strings.TrimSpace(req.Query)
+	if req.Query == "" {
+		writeError(w, http.StatusBadRequest, "query is empty")
+		return
+	}
+	if !s.requireSession(w, id) {
+		return
+	}
+
+	ls := s.liveSession(id)
+	sess, err := session.ResumeSession(s.cfg.Store, id)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+	sess.SetHistoryConfig(s.cfg.History)
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		writeError(w, http.StatusServiceUnavailable, "server is shutting down")
+		return
+	}
+	if !ls.start() {
+		s.mu.Unlock()
+		writeError(w, http.StatusConflict, "session %s is already running a round", id)
+		return
+	}
+	s.rounds.Add(1)
+	s.mu.Unlock()
+
+	runID := logger.NewRunID()
+	go s.runRound(ls, sess, req.Query, runID)
+	writeJSON(w, http.StatusAccepted, queryAccepted{
+		SessionID: id,
+		Round:     sess.RoundNum + 1,
+		RunID:     runID,
+		Events:    "/api/sessions/" + id + "/events",
+	})
+}
+
+// runRound plans, executes and saves one round, like an interactive REPL turn.
+// A failed round keeps its checkpoint so it can be resumed from the CLI.
+func (s *Server) runRound(ls *liveSession, sess *session.Session, query, runID string) {
+	defer s.rounds.Done()
+	defer ls.finish()
+	defer ls.events.Flush() // the round's events are in the store once it is no longer running
+
+	s.runMu.Lock()
+	defer s.runMu.Unlock()
+
+	roundNum := sess.RoundNum + 1
+	ctx := logger.WithRun(s.ctx, sess.ID, roundNum, runID)
+	logger.CtxInfof(ctx, "[Server] starting round")
+	ls.events.Emit(events.NewCtxEvent(ctx, events.TypeRoundStarted, events.RoundData{Round: roundNum, Query: query}))
+
+	fail := func(err error) {
+		logger.CtxErrorf(ctx, "[Server] round failed: %v", err)
+		ls.events.Emit(events.NewCtxEvent(ctx, events.TypeRoundFailed, events.RoundData{Round: roundNum, Query: query, Error: err.Error()}))
+	}
+	if err := ctx.Err(); err != nil {
+		fail(err)
+		return
+	}
+
+	history, err := sess.History(ctx)
+	if err != nil {
+		logger.CtxErrorf(ctx, "[Server] load session history: %v", err)
+	}
+	result, err := s.cfg.Round(ctx, ls.events, RoundInput{
+		Query:      query,
+		Captures:   sess.Captures(),
+		History:    history,
+		Checkpoint: sess.Checkpoint(query),
+	})
+	if err != nil {
+		if ferr := sess.FailRound(err); ferr != nil {
+			logger.CtxErrorf(ctx, "[Server] record failed round: %v", ferr)
+		}
+		fail(err)
+		return
+	}
+	if err := sess.SaveRound(query, result.Plan, result.Report, result.Findings, result.OperationLog, result.Steps, result.IOCs); err != nil {
+		fail(err)
+		return
+	}
+	logger.CtxInfof(ctx, "[Server] saved round %d", sess.RoundNum)
+	ls.events.Emit(events.NewCtxEvent(ctx, events.TypeRoundCompleted, events.RoundData{Round: sess.RoundNum, Query: query}))
+}
+
+func (s *Server) handleListRounds(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if !s.requireSession(w, id) {
+		return
+	}
+	rounds, err := s.cfg.Store.GetRounds(id)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "load rounds: %v", err)
+		return
+	}
+	if rounds == nil {
+		rounds = []session.Round{}
+	}
+	writeJSON(w, http.StatusOK, rounds)
+}
+
+// handleGetRound returns a round with its step records and indicators.
+func (s *Server) handleGetRound(w http.ResponseWriter, r *http.Request) {
+	round, ok := s.round(w, r)
+	if !ok {
+		return
+	}
+	steps, err := s.cfg.Store.GetRoundSteps(round.SessionID, round.RoundNum)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "load steps: %v", err)
+		return
+	}
+	iocs, err := s.cfg.Store.GetSessionIOCs(round.SessionID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "load iocs: %v", err)
+		return
+	}
+	out := bundle.Round{Round: *round, Steps: steps, IOCs: []common.IOC{}}
+	if out.Steps == nil {
+		out.Steps = []common.StepRecord{}
+	}
+	for _, ioc := range iocs {
+		if ioc.RoundNum == round.RoundNum {
+			out.IOCs = append(out.IOCs, ioc.IOC)
+		}
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// handleGetReport returns a round's Markdown report.
+func (s *Server) handleGetReport(w http.ResponseWriter, r *http.Request) {
+	round, ok := s.round(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+	io.WriteString(w, round.Report)
+}
+
+// round loads the round named by the {id} and {n} path values. It writes the
+// error response itself and reports whether the round was found.
+func (s *Server) round(w http.ResponseWriter, r *http.Request) (*session.Round, bool) {
+	id := r.PathValue("id")
+	n, err := strconv.Atoi(r.PathValue("n"))
+	if err != nil || n < 1 {
+		writeError(w, http.StatusBadRequest, "invalid round number %q", r.PathValue("n"))
+		return nil, false
+	}
+	round, err := s.cfg.Store.GetRound(id, n)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "load round: %v", err)
+		return nil, false
+	}
+	if round == nil {
+		writeError(w, http.StatusNotFound, "session %s has no round %d", id, n)
+		return nil, false
+	}
+	return round, true
+}
+
+// --- Helpers ---
+
+// requireSession writes 404 and returns false if session id does not exist.
+func (s *Server) requireSession(w http.ResponseWriter, id string) bool {
+	exists, err := s.cfg.Store.SessionExists(id)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "%v", err)
+		return false
+	}
+	if !exists {
+		writeError(w, http.StatusNotFound, "session %s not found", id)
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		logger.Errorf("[Server] write response: %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, format string, args ...any) {
+	writeJSON(w, status, map[string]string{"error": fmt.Sprintf(format, args...)})
+}
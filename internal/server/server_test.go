@@ -0,0 +1,130 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"pcap_agent/internal/events"
+	"pcap_agent/internal/executor"
+	"pcap_agent/internal/session"
+)
+
+func TestBearerToken(t *testing.T) {
+	srv, err := New(&Config{
+		Store: session.NewMemoryStore(),
+		Stage: func(context.Context, []string, []session.SessionPcap) ([]session.SessionPcap, error) { return nil, nil },
+		Round: func(context.Context, events.Emitter, RoundInput) (*executor.Result, error) { return nil, nil },
+		Token: "secret",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	for auth, want := range map[string]int{
+		"":              http.StatusUnauthorized,
+		"Bearer wrong":  http.StatusUnauthorized,
+		"secret":        http.StatusUnauthorized,
+		"Bearer secret": http.StatusOK,
+	} {
+		req := httptest.NewRequest("GET", "/api/sessions", nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		if rec.Code != want {
+			t.Errorf("Authorization %q: status %d; want %d", auth, rec.Code, want)
+		}
+	}
+}
+
+// TestUploadCleanup checks that the upload dir keeps only files that a
+// registered capture points to.
+func TestUploadCleanup(t *testing.T) {
+	store := session.NewMemoryStore()
+	uploadDir := t.TempDir()
+	failStage := false
+	srv, err := New(&Config{
+		Store:     store,
+		UploadDir: uploadDir,
+		Stage: func(_ context.Context, paths []string, _ []session.SessionPcap) ([]session.SessionPcap, error) {
+			if failStage {
+				return nil, errors.New("sandbox is down")
+			}
+			var staged []session.SessionPcap
+			seen := map[string]bool{}
+			for _, path := range paths {
+				f, _, err := session.RegisterPcapFile(store, path)
+				if err != nil {
+					return nil, err
+				}
+				if !seen[f.FileHash] {
+					seen[f.FileHash] = true
+					staged = append(staged, session.SessionPcap{PcapFileID: f.ID, ContainerPath: "/c/" + filepath.Base(path), FileHash: f.FileHash})
+				}
+			}
+			return staged, nil
+		},
+		Round: func(context.Context, events.Emitter, RoundInput) (*executor.Result, error) { return nil, nil },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+
+	upload := func(want int, files ...string) {
+		t.Helper()
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		for i := 0; i < len(files); i += 2 {
+			fw, err := mw.CreateFormFile(uploadField, files[i])
+			if err != nil {
+				t.Fatal(err)
+			}
+			fw.Write([]byte(files[i+1]))
+		}
+		mw.Close()
+		req := httptest.NewRequest("POST", "/api/sessions", &body)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		if rec.Code != want {
+			t.Fatalf("upload %q: status %d; want %d: %s", files, rec.Code, want, rec.Body)
+		}
+	}
+	kept := func() []string {
+		t.Helper()
+		var names []string
+		err := filepath.WalkDir(uploadDir, func(path string, d fs.DirEntry, err error) error {
+			if err == nil && path != uploadDir {
+				names = append(names, d.Name())
+			}
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		slices.Sort(names)
+		return names
+	}
+
+	upload(http.StatusCreated, "a.pcap", "AAA")
+	// Contents registered before, whether by an earlier request or by the same
+	// one, are not kept twice.
+	upload(http.StatusCreated, "copy.pcap", "AAA", "b.pcap", "BBB", "b2.pcap", "BBB")
+	failStage = true
+	upload(http.StatusInternalServerError, "c.pcap", "CCC")
+
+	got := kept()
+	if len(got) != 4 || got[0] != "a.pcap" || got[1] != "b.pcap" {
+		t.Errorf("upload dir holds %q; want a.pcap and b.pcap in their own directories", got)
+	}
+}
@@ -0,0 +1,155 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"pcap_agent/internal/events"
+	"pcap_agent/internal/session"
+)
+
+// liveSession is the in-process state of a session served by the API: the
+// event log its rounds emit to and whether a round is running.
+type liveSession struct {
+	events *session.EventLog
+
+	mu      sync.Mutex
+	running bool
+}
+
+// liveSession returns the live state of session id, creating it on first use.
+func (s *Server) liveSession(id string) *liveSession {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ls, ok := s.live[id]
+	if !ok {
+		ls = &liveSession{events: session.NewEventLog(s.cfg.Store, id)}
+		if s.closed {
+			ls.events.Close()
+		}
+		s.live[id] = ls
+	}
+	return ls
+}
+
+// running reports whether session id has a round in progress.
+func (s *Server) running(id string) bool {
+	s.mu.Lock()
+	ls, ok := s.live[id]
+	s.mu.Unlock()
+	return ok && ls.isRunning()
+}
+
+// start marks a round as running and returns false if one already is.
+func (ls *liveSession) start() bool {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	if ls.running {
+		return false
+	}
+	ls.running = true
+	return true
+}
+
+func (ls *liveSession) finish() {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	ls.running = false
+}
+
+func (ls *liveSession) isRunning() bool {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	return ls.running
+}
+
+// handleEvents streams the session's events as Server-Sent Events: each event
+// is sent with its log sequence number as the SSE id, its type as the event
+// name and the JSON-encoded events.Event as data. The stream starts with the
+// logged events after the Last-Event-ID header, or the "after" query parameter
+// (default 0, the whole log), then tails live events. A client too slow for the
+// live events gets an events.dropped notice and can reconnect to fill the gap.
+// A comment line is written every heartbeat interval to keep proxies from
+// closing an idle stream.
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming is not supported")
+		return
+	}
+	after := r.Header.Get("Last-Event-ID")
+	if after == "" {
+		after = r.URL.Query().Get("after")
+	}
+	var afterSeq int64
+	if after != "" {
+		n, err := strconv.ParseInt(after, 10, 64)
+		if err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, "invalid event offset %q", after)
+			return
+		}
+		afterSeq = n
+	}
+	if !s.requireSession(w, id) {
+		return
+	}
+	ch, cancel, err := s.liveSession(id).events.SubscribeFrom(afterSeq, &events.SubscribeOptions{Name: "sse"})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "load events: %v", err)
+		return
+	}
+	defer cancel()
+
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("Connection", "keep-alive")
+	h.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, ": session %s\n\n", id)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(s.cfg.GetHeartbeat())
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case ev, ok := <-ch:
+			if !ok {
+				return
+			}
+			if err := writeSSE(w, ev); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// writeSSE writes ev as one SSE message. The JSON encoding has no newlines, so
+// it fits a single data line. Events that were not persisted carry no id, so a
+// reconnecting client resumes after the last logged one.
+func writeSSE(w http.ResponseWriter, ev events.Event) error {
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	if ev.Seq > 0 {
+		if _, err := fmt.Fprintf(w, "id: %d\n", ev.Seq); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, data)
+	return err
+}
@@ -0,0 +1,226 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"pcap_agent/internal/common"
+	"pcap_agent/internal/events"
+	"pcap_agent/internal/executor"
+	"pcap_agent/internal/session"
+)
+
+// newTestServer serves a memory store holding session "s". Each round emits
+// three info events and succeeds.
+func newTestServer(t *testing.T) (*httptest.Server, session.Store) {
+	t.Helper()
+	store := session.NewMemoryStore()
+	if err := store.CreateSession("s", []session.SessionPcap{{ContainerPath: "/c/a.pcap"}}); err != nil {
+		t.Fatal(err)
+	}
+	srv, err := New(&Config{
+		Store:     store,
+		UploadDir: t.TempDir(),
+		Heartbeat: time.Hour,
+		Stage: func(context.Context, []string, []session.SessionPcap) ([]session.SessionPcap, error) {
+			return nil, fmt.Errorf("no uploads in this test")
+		},
+		Round: func(ctx context.Context, em events.Emitter, in RoundInput) (*executor.Result, error) {
+			for i := 1; i <= 3; i++ {
+				em.Emit(events.NewCtxEvent(ctx, events.TypeInfo, events.InfoData{Message: fmt.Sprintf("%s %d", in.Query, i)}))
+			}
+			return &executor.Result{Plan: common.Plan{Thought: "t"}, Report: "report " + in.Query}, nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(srv)
+	t.Cleanup(func() {
+		srv.Close()
+		ts.Close()
+	})
+	return ts, store
+}
+
+// sseMessage is one parsed SSE message.
+type sseMessage struct {
+	id    int64
+	event string
+	data  events.Event
+}
+
+// openEvents opens the event stream of session "s" and returns its messages.
+// header, if set, is sent as Last-Event-ID; query is appended to the URL.
+func openEvents(t *testing.T, ts *httptest.Server, header, query string) <-chan sseMessage {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	req, err := http.NewRequestWithContext(ctx, "GET", ts.URL+"/api/sessions/s/events"+query, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header != "" {
+		req.Header.Set("Last-Event-ID", header)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("events: %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	out := make(chan sseMessage, 64)
+	go func() {
+		defer close(out)
+		defer resp.Body.Close()
+		var msg sseMessage
+		sc := bufio.NewScanner(resp.Body)
+		for sc.Scan() {
+			line := sc.Text()
+			switch {
+			case strings.HasPrefix(line, "id: "):
+				msg.id, _ = strconv.ParseInt(strings.TrimPrefix(line, "id: "), 10, 64)
+			case strings.HasPrefix(line, "event: "):
+				msg.event = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &msg.data)
+			case line == "" && msg.event != "":
+				out <- msg
+				msg = sseMessage{}
+			}
+		}
+	}()
+	return out
+}
+
+// readUntil reads messages up to and including the first of type typ.
+func readUntil(t *testing.T, ch <-chan sseMessage, typ string) []sseMessage {
+	t.Helper()
+	var got []sseMessage
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case msg, ok := <-ch:
+			if !ok {
+				t.Fatalf("stream closed after %d messages", len(got))
+			}
+			got = append(got, msg)
+			if msg.event == typ {
+				return got
+			}
+		case <-timeout:
+			t.Fatalf("no %s event after %d messages", typ, len(got))
+		}
+	}
+}
+
+func submitQuery(t *testing.T, ts *httptest.Server, query string) {
+	t.Helper()
+	resp, err := http.Post(ts.URL+"/api/sessions/s/queries", "application/json", strings.NewReader(`{"query":"`+query+`"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("submit %q: %d", query, resp.StatusCode)
+	}
+}
+
+// waitIdle waits until session "s" has no round running.
+func waitIdle(t *testing.T, ts *httptest.Server) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		resp, err := http.Get(ts.URL + "/api/sessions/s")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var d sessionDetail
+		err = json.NewDecoder(resp.Body).Decode(&d)
+		resp.Body.Close()
+		if err == nil && !d.Running {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal("round still running")
+}
+
+func ids(msgs []sseMessage) []int64 {
+	out := make([]int64, len(msgs))
+	for i, m := range msgs {
+		out[i] = m.id
+	}
+	return out
+}
+
+func TestEventsReplay(t *testing.T) {
+	ts, store := newTestServer(t)
+
+	live := openEvents(t, ts, "", "")
+	submitQuery(t, ts, "first")
+	got := readUntil(t, live, events.TypeRoundCompleted)
+	// round.started, three info events, round.completed
+	if want := []int64{1, 2, 3, 4, 5}; fmt.Sprint(ids(got)) != fmt.Sprint(want) {
+		t.Fatalf("live ids = %v; want %v", ids(got), want)
+	}
+	for _, m := range got {
+		if m.event != m.data.Type || m.data.Seq != m.id || m.data.SessionID != "s" || m.data.Round != 1 {
+			t.Errorf("message %d = %s %+v", m.id, m.event, m.data)
+		}
+	}
+
+	// The round's events are in the store once it has finished.
+	waitIdle(t, ts)
+	if logged, err := store.GetEvents("s", 0); err != nil || len(logged) != 5 {
+		t.Errorf("logged %d events, %v; want 5", len(logged), err)
+	}
+
+	// A reconnecting client resumes after Last-Event-ID, which wins over ?after.
+	replay := openEvents(t, ts, "2", "?after=4")
+	if got := readUntil(t, replay, events.TypeRoundCompleted); fmt.Sprint(ids(got)) != "[3 4 5]" {
+		t.Errorf("replay after 2 = %v", ids(got))
+	}
+	replay = openEvents(t, ts, "", "?after=4")
+	if got := readUntil(t, replay, events.TypeRoundCompleted); fmt.Sprint(ids(got)) != "[5]" {
+		t.Errorf("replay after 4 = %v", ids(got))
+	}
+
+	// A caught-up client tails the next round without a gap.
+	tail := openEvents(t, ts, "5", "")
+	submitQuery(t, ts, "second")
+	got = readUntil(t, tail, events.TypeRoundCompleted)
+	if fmt.Sprint(ids(got)) != "[6 7 8 9 10]" || got[0].data.Round != 2 {
+		t.Errorf("second round ids = %v (round %d)", ids(got), got[0].data.Round)
+	}
+}
+
+func TestEventsErrors(t *testing.T) {
+	ts, _ := newTestServer(t)
+	for _, tt := range []struct {
+		path string
+		want int
+	}{
+		{"/api/sessions/s/events?after=x", http.StatusBadRequest},
+		{"/api/sessions/s/events?after=-1", http.StatusBadRequest},
+		{"/api/sessions/missing/events", http.StatusNotFound},
+	} {
+		resp, err := http.Get(ts.URL + tt.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.want {
+			t.Errorf("GET %s = %d; want %d", tt.path, resp.StatusCode, tt.want)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package session
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"pcap_agent/internal/common"
+	"pcap_agent/internal/prompts"
+
+	"github.com/cloudwego/eino/components/model"
+	"github.com/cloudwego/eino/schema"
+)
+
+// digestInputChars bounds each part of a round sent to the digest model.
+const digestInputChars = 24000
+
+// NewModelDigest returns a DigestFunc that asks m to compact a round.
+func NewModelDigest(m model.BaseChatModel) DigestFunc {
+	return func(ctx context.Context, r Round) (string, error) {
+		system, err := prompts.GetSinglePrompt("round_digest")
+		if err != nil {
+			return "", fmt.Errorf("load round_digest prompt: %w", err)
+		}
+		var sb strings.Builder
+		fmt.Fprintf(&sb, "<query>\n%s\n</query>\n\n", r.UserQuery)
+		fmt.Fprintf(&sb, "<findings>\n%s\n</findings>\n\n", common.TruncateStr(r.Findings, digestInputChars))
+		fmt.Fprintf(&sb, "<report>\n%s\n</report>\n", common.TruncateStr(r.Report, digestInputChars))
+
+		out, err := m.Generate(ctx, []*schema.Message{
+			schema.SystemMessage(system),
+			schema.UserMessage(sb.String()),
+		})
+		if err != nil {
+			return "", err
+		}
+		return out.Content, nil
+	}
+}
@@ -0,0 +1,314 @@
+package session
+
+import (
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+
+	"pcap_agent/internal/events"
+	"pcap_agent/pkg/logger"
+)
+
+// DefaultEventFlushInterval is how long an EventLog buffers events before
+// writing them to the store.
+const DefaultEventFlushInterval = 250 * time.Millisecond
+
+// eventFlushBatch is the number of buffered events that triggers a write
+// without waiting for the timer.
+const eventFlushBatch = 256
+
+// EventLog is an Emitter for one session that persists every event to the
+// store's event log and delivers it to live subscribers. Events get the
+// session's ID and their sequence number in the log, assigned in memory as they
+// are emitted; the log is written in batches, when the flush interval elapses,
+// when a batch fills up, on Flush and on Close. One EventLog should write a
+// session's log at a time. SubscribeFrom lets a client that connects late replay
+// what it missed, including events not yet written, and then tail live events.
+// Live delivery happens in Seq order on a background goroutine, so a slow
+// subscriber holds up other subscribers but never Emit. It follows each
+// subscriber's overflow policy, as in events.ChannelEmitter; a subscriber that
+// misses events sees the gap in Seq and can subscribe again from the last Seq
+// it received.
+type EventLog struct {
+	store     Store
+	sessionID string
+	fanout    *events.Fanout
+	interval  time.Duration
+
+	// mu is held while an event is numbered and queued for delivery, so live
+	// order matches Seq and SubscribeFrom can switch from replay to live without
+	// a gap. It is not held while delivering.
+	mu          sync.Mutex
+	seq         int64          // last assigned sequence number
+	seqErr      error          // why seq could not be loaded from the store
+	loaded      bool           // seq has been loaded from the store
+	pending     []events.Event // emitted, not yet being written
+	flushing    []events.Event // being written by Flush
+	timer       *time.Timer    // pending flush, nil if none
+	outbox      []events.Event // emitted, not yet delivered
+	dispatching bool           // a dispatch goroutine is draining outbox
+	drained     *sync.Cond     // signalled, with mu, when dispatching stops
+	closed      bool
+
+	flushMu sync.Mutex // serializes writes so batches reach the store in order
+}
+
+var _ events.Emitter = (*EventLog)(nil)
+
+// NewEventLog returns the event log of session sessionID.
+func NewEventLog(store Store, sessionID string) *EventLog {
+	l := &EventLog{
+		store:     store,
+		sessionID: sessionID,
+		fanout:    events.NewFanout(0),
+		interval:  DefaultEventFlushInterval,
+	}
+	l.drained = sync.NewCond(&l.mu)
+	return l
+}
+
+// Emit numbers the event, buffers it for the store and queues it for delivery
+// to all subscribers. If the log's last sequence number cannot be read from the
+// store, the event is delivered with Seq 0 and not persisted.
+func (l *EventLog) Emit(ev events.Event) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return
+	}
+	ev.SessionID = l.sessionID
+	if !l.loaded {
+		l.seq, l.seqErr = l.store.LastEventSeq(l.sessionID)
+		l.loaded = l.seqErr == nil
+	}
+	if !l.loaded {
+		logger.Warnf("[EventLog] session %s: read last event seq, %s event not persisted: %v", l.sessionID, ev.Type, l.seqErr)
+		l.publish(ev)
+		return
+	}
+
+	l.seq++
+	ev.Seq = l.seq
+	l.pending = append(l.pending, ev)
+	switch {
+	case len(l.pending) == eventFlushBatch:
+		if l.timer != nil {
+			l.timer.Stop()
+		}
+		l.timer = time.AfterFunc(0, l.Flush)
+	case l.timer == nil:
+		l.timer = time.AfterFunc(l.interval, l.Flush)
+	}
+	l.publish(ev)
+}
+
+// publish queues ev for delivery, starting a dispatch goroutine if none is
+// running. l.mu must be held.
+func (l *EventLog) publish(ev events.Event) {
+	l.outbox = append(l.outbox, ev)
+	if !l.dispatching {
+		l.dispatching = true
+		go l.dispatch()
+	}
+}
+
+// dispatch delivers queued events in order until the outbox is empty.
+func (l *EventLog) dispatch() {
+	for {
+		l.mu.Lock()
+		batch := l.outbox
+		l.outbox = nil
+		if len(batch) == 0 {
+			l.dispatching = false
+			l.drained.Broadcast()
+			l.mu.Unlock()
+			return
+		}
+		l.mu.Unlock()
+		for _, ev := range batch {
+			l.fanout.Publish(ev)
+		}
+	}
+}
+
+// Flush writes the buffered events to the store. Call it at the end of a round
+// so the log is complete for readers of the store.
+func (l *EventLog) Flush() {
+	l.flushMu.Lock()
+	defer l.flushMu.Unlock()
+
+	l.mu.Lock()
+	if l.timer != nil {
+		l.timer.Stop()
+		l.timer = nil
+	}
+	batch := l.pending
+	l.pending, l.flushing = nil, batch
+	l.mu.Unlock()
+	if len(batch) == 0 {
+		return
+	}
+
+	if err := l.store.AppendEvents(l.sessionID, batch); err != nil {
+		logger.Warnf("[EventLog] session %s: persist %d events (seq %d-%d): %v",
+			l.sessionID, len(batch), batch[0].Seq, batch[len(batch)-1].Seq, err)
+	}
+	l.mu.Lock()
+	l.flushing = nil
+	l.mu.Unlock()
+}
+
+// Subscribe returns a channel that receives events emitted from now on.
+func (l *EventLog) Subscribe() <-chan events.Event {
+	return l.fanout.Subscribe(nil)
+}
+
+// SubscribeWith is Subscribe with a name, buffer size and overflow policy.
+func (l *EventLog) SubscribeWith(opts *events.SubscribeOptions) <-chan events.Event {
+	return l.fanout.Subscribe(opts)
+}
+
+// Unsubscribe stops live delivery to ch and closes it.
+func (l *EventLog) Unsubscribe(ch <-chan events.Event) {
+	l.fanout.Unsubscribe(ch)
+}
+
+// Stats returns the delivery counters of every live subscriber.
+func (l *EventLog) Stats() []events.SubscriberStats {
+	return l.fanout.Stats()
+}
+
+// SubscribeFrom returns a channel that first receives the logged events after
+// afterSeq (0 replays the whole log) and then every event emitted later, with no
+// gap or duplicate in between. opts apply to the live part, as in SubscribeWith.
+// The channel is closed when the log is closed or cancel is called; cancel must
+// be called once the caller stops reading.
+func (l *EventLog) SubscribeFrom(afterSeq int64, opts *events.SubscribeOptions) (<-chan events.Event, func(), error) {
+	l.mu.Lock()
+	past, err := l.store.GetEvents(l.sessionID, afterSeq)
+	if err != nil {
+		l.mu.Unlock()
+		return nil, nil, err
+	}
+	// Events not yet written follow the logged ones. A batch being written may
+	// already be in the store.
+	last := afterSeq
+	if n := len(past); n > 0 {
+		last = past[n-1].Seq
+	}
+	for _, buf := range [][]events.Event{l.flushing, l.pending} {
+		for _, ev := range buf {
+			if ev.Seq > last {
+				past = append(past, ev)
+			}
+		}
+	}
+	// Events numbered so far that are still queued for delivery reach the live
+	// subscription too; they were replayed or precede afterSeq, so skip them.
+	replayed := max(l.seq, afterSeq)
+	live := l.fanout.Subscribe(opts)
+	l.mu.Unlock()
+
+	out := make(chan events.Event)
+	done := make(chan struct{})
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			close(done)
+			l.fanout.Unsubscribe(live)
+		})
+	}
+	go func() {
+		defer close(out)
+		for _, ev := range past {
+			select {
+			case out <- ev:
+			case <-done:
+				return
+			}
+		}
+		for {
+			select {
+			case ev, ok := <-live:
+				if !ok {
+					return
+				}
+				if ev.Seq != 0 && ev.Seq <= replayed {
+					continue
+				}
+				select {
+				case out <- ev:
+				case <-done:
+					return
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return out, cancel, nil
+}
+
+// Close writes the buffered events, stops logging, waits for queued events to
+// be delivered and closes all subscriber channels.
+func (l *EventLog) Close() {
+	l.mu.Lock()
+	l.closed = true
+	for l.dispatching {
+		l.drained.Wait()
+	}
+	l.mu.Unlock()
+	l.Flush()
+	l.fanout.Close()
+}
+
+// checkEventSeqs checks that evs can be appended after last, the highest
+// sequence number logged for sessionID.
+func checkEventSeqs(sessionID string, last int64, evs []events.Event) error {
+	for _, ev := range evs {
+		if ev.Seq <= last {
+			return fmt.Errorf("session %s: event seq %d is not after %d", sessionID, ev.Seq, last)
+		}
+		last = ev.Seq
+	}
+	return nil
+}
+
+// eventData returns the JSON data of ev as stored in the log.
+func eventData(ev events.Event) string {
+	if len(ev.Data) == 0 {
+		return "null"
+	}
+	return string(ev.Data)
+}
+
+// eventTime returns the timestamp of ev as stored in the log.
+func eventTime(ev events.Event) string {
+	t := ev.Timestamp
+	if t.IsZero() {
+		t = time.Now()
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// scanEvents reads (seq, type, round_num, run_id, step_id, data, created_at)
+// rows into events of sessionID.
+func scanEvents(rows *sql.Rows, sessionID string) ([]events.Event, error) {
+	defer rows.Close()
+	var out []events.Event
+	for rows.Next() {
+		var (
+			ev       events.Event
+			data, ts string
+		)
+		if err := rows.Scan(&ev.Seq, &ev.Type, &ev.Round, &ev.RunID, &ev.StepID, &data, &ts); err != nil {
+			return nil, err
+		}
+		ev.SessionID = sessionID
+		ev.Data = []byte(data)
+		ev.Timestamp, _ = time.Parse(time.RFC3339Nano, ts)
+		out = append(out, ev)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,251 @@
+package session
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"pcap_agent/internal/events"
+)
+
+// batchStore counts the AppendEvents transactions of a MemoryStore.
+type batchStore struct {
+	*MemoryStore
+	mu      sync.Mutex
+	batches []int
+	fail    error // returned by AppendEvents
+	seqErr  error // returned by LastEventSeq
+}
+
+func (s *batchStore) AppendEvents(sessionID string, evs []events.Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fail != nil {
+		return s.fail
+	}
+	s.batches = append(s.batches, len(evs))
+	return s.MemoryStore.AppendEvents(sessionID, evs)
+}
+
+func (s *batchStore) LastEventSeq(sessionID string) (int64, error) {
+	if s.seqErr != nil {
+		return 0, s.seqErr
+	}
+	return s.MemoryStore.LastEventSeq(sessionID)
+}
+
+func (s *batchStore) batchCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.batches)
+}
+
+func newBatchStore(t *testing.T) *batchStore {
+	t.Helper()
+	s := &batchStore{MemoryStore: NewMemoryStore()}
+	if err := s.CreateSession("s", []SessionPcap{{ContainerPath: "/p"}}); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func emitN(l *EventLog, n int) {
+	for i := 0; i < n; i++ {
+		l.Emit(events.NewEvent(events.TypeReportDelta, "", events.ReportDeltaData{Seq: i}))
+	}
+}
+
+func TestEventLogBatchesWrites(t *testing.T) {
+	s := newBatchStore(t)
+	l := NewEventLog(s, "s")
+	l.interval = time.Hour
+	defer l.Close()
+
+	live := l.Subscribe()
+	emitN(l, 10)
+	if n := s.batchCount(); n != 0 {
+		t.Fatalf("%d writes before Flush", n)
+	}
+	// Seq is assigned at Emit, before the event is written.
+	for want := int64(1); want <= 10; want++ {
+		if ev := <-live; ev.Seq != want || ev.SessionID != "s" {
+			t.Fatalf("live event seq %d session %q; want seq %d", ev.Seq, ev.SessionID, want)
+		}
+	}
+
+	l.Flush()
+	l.Flush()
+	if len(s.batches) != 1 || s.batches[0] != 10 {
+		t.Errorf("batches = %v; want one of 10", s.batches)
+	}
+	if evs, _ := s.GetEvents("s", 0); len(evs) != 10 || evs[9].Seq != 10 {
+		t.Errorf("logged %d events", len(evs))
+	}
+}
+
+func TestEventLogFlushTriggers(t *testing.T) {
+	t.Run("timer", func(t *testing.T) {
+		s := newBatchStore(t)
+		l := NewEventLog(s, "s")
+		l.interval = 10 * time.Millisecond
+		defer l.Close()
+		emitN(l, 3)
+		deadline := time.Now().Add(time.Second)
+		for s.batchCount() == 0 && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		if s.batchCount() != 1 || s.batches[0] != 3 {
+			t.Errorf("batches = %v; want one of 3", s.batches)
+		}
+	})
+
+	t.Run("full batch", func(t *testing.T) {
+		s := newBatchStore(t)
+		l := NewEventLog(s, "s")
+		l.interval = time.Hour
+		defer l.Close()
+		emitN(l, eventFlushBatch)
+		deadline := time.Now().Add(time.Second)
+		for s.batchCount() == 0 && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		if s.batchCount() != 1 || s.batches[0] != eventFlushBatch {
+			t.Errorf("batches = %v; want one of %d", s.batches, eventFlushBatch)
+		}
+	})
+
+	t.Run("close", func(t *testing.T) {
+		s := newBatchStore(t)
+		l := NewEventLog(s, "s")
+		l.interval = time.Hour
+		emitN(l, 5)
+		l.Close()
+		emitN(l, 1) // ignored after Close
+		if evs, _ := s.GetEvents("s", 0); len(evs) != 5 {
+			t.Errorf("logged %d events after Close; want 5", len(evs))
+		}
+	})
+}
+
+func TestEventLogReplaysUnwrittenEvents(t *testing.T) {
+	s := newBatchStore(t)
+	l := NewEventLog(s, "s")
+	l.interval = time.Hour
+	defer l.Close()
+
+	emitN(l, 4)
+	l.Flush()
+	emitN(l, 3) // seq 5-7 are only in memory
+
+	ch, cancel, err := l.SubscribeFrom(2, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancel()
+	emitN(l, 1)
+	for want := int64(3); want <= 8; want++ {
+		select {
+		case ev := <-ch:
+			if ev.Seq != want {
+				t.Fatalf("got seq %d; want %d", ev.Seq, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for seq %d", want)
+		}
+	}
+}
+
+// TestEventLogSlowSubscriber checks that a Block subscriber that stops reading
+// holds up neither Emit nor replay, and that delivery keeps Seq order.
+func TestEventLogSlowSubscriber(t *testing.T) {
+	s := newBatchStore(t)
+	l := NewEventLog(s, "s")
+	l.interval = time.Hour
+	defer l.Close()
+	stuck := l.SubscribeWith(&events.SubscribeOptions{Name: "stuck", Buffer: 1, Policy: events.Block, Timeout: time.Hour})
+	live := l.SubscribeWith(&events.SubscribeOptions{Buffer: 200})
+
+	emitted := make(chan struct{})
+	go func() {
+		var wg sync.WaitGroup
+		for range 4 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				emitN(l, 25)
+			}()
+		}
+		wg.Wait()
+		close(emitted)
+	}()
+	select {
+	case <-emitted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Emit is held up by a subscriber that does not read")
+	}
+
+	// Replay covers the events still queued behind the stuck subscriber,
+	// without repeating them once they are delivered.
+	replay, cancel, err := l.SubscribeFrom(0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancel()
+	emitN(l, 1)
+	l.Unsubscribe(stuck)
+	for name, ch := range map[string]<-chan events.Event{"live": live, "replay": replay} {
+		for want := int64(1); want <= 101; want++ {
+			select {
+			case ev := <-ch:
+				if ev.Seq != want {
+					t.Fatalf("%s: got seq %d; want %d", name, ev.Seq, want)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("%s: timed out waiting for seq %d", name, want)
+			}
+		}
+	}
+}
+
+func TestEventLogStoreErrors(t *testing.T) {
+	s := newBatchStore(t)
+	l := NewEventLog(s, "s")
+	l.interval = time.Hour
+	defer l.Close()
+	live := l.Subscribe()
+
+	// A failed write loses the batch but not the numbering.
+	s.fail = errors.New("disk full")
+	emitN(l, 2)
+	l.Flush()
+	s.fail = nil
+	emitN(l, 1)
+	l.Flush()
+	if evs, _ := s.GetEvents("s", 0); len(evs) != 1 || evs[0].Seq != 3 {
+		t.Errorf("logged %+v; want seq 3 only", evs)
+	}
+	for want := int64(1); want <= 3; want++ {
+		if ev := <-live; ev.Seq != want {
+			t.Errorf("live seq %d; want %d", ev.Seq, want)
+		}
+	}
+
+	// Without the last logged seq the log cannot be numbered; events are still
+	// delivered, and numbering starts once the store answers.
+	s2 := newBatchStore(t)
+	s2.seqErr = errors.New("connection refused")
+	l2 := NewEventLog(s2, "s")
+	defer l2.Close()
+	live2 := l2.Subscribe()
+	emitN(l2, 1)
+	s2.seqErr = nil
+	emitN(l2, 1)
+	l2.Flush()
+	if a, b := <-live2, <-live2; a.Seq != 0 || b.Seq != 1 || a.SessionID != "s" {
+		t.Errorf("live events = seq %d (session %q), seq %d; want 0, 1", a.Seq, a.SessionID, b.Seq)
+	}
+	if evs, _ := s2.GetEvents("s", 0); len(evs) != 1 {
+		t.Errorf("logged %d events; want 1", len(evs))
+	}
+}
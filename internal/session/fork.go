@@ -0,0 +1,144 @@
+package session
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// ForkSession creates session newID as a branch of srcID that inherits rounds
+// 1..roundNum (queries, plans, findings, reports, digests, steps and IOCs) and
+// the same captures. The new session records srcID and roundNum as its parent.
+// Checkpoints of unfinished rounds are not inherited.
+func (s *SQLiteStore) ForkSession(srcID string, roundNum int, newID string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var rounds int
+	err = tx.QueryRow("SELECT (SELECT COUNT(*) FROM rounds WHERE session_id = ?) FROM sessions WHERE id = ?", srcID, srcID).Scan(&rounds)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("session %s not found", srcID)
+	}
+	if err != nil {
+		return err
+	}
+	if roundNum < 0 || roundNum > rounds {
+		return fmt.Errorf("session %s has %d rounds, cannot fork at round %d", srcID, rounds, roundNum)
+	}
+
+	if _, err := tx.Exec(
+		`INSERT INTO sessions (id, pcap_path, pcap_file_id, parent_session_id, parent_round)
+		 SELECT ?, pcap_path, pcap_file_id, id, ? FROM sessions WHERE id = ?`,
+		newID, roundNum, srcID,
+	); err != nil {
+		return fmt.Errorf("create fork: %w", err)
+	}
+	if _, err := tx.Exec(
+		`INSERT INTO session_pcaps (session_id, pcap_file_id, container_path, position)
+		 SELECT ?, pcap_file_id, container_path, position FROM session_pcaps WHERE session_id = ?`,
+		newID, srcID,
+	); err != nil {
+		return fmt.Errorf("copy captures: %w", err)
+	}
+
+	rows, err := tx.Query("SELECT id FROM rounds WHERE session_id = ? AND round_num <= ? ORDER BY round_num ASC", srcID, roundNum)
+	if err != nil {
+		return err
+	}
+	var roundIDs []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		roundIDs = append(roundIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, oldID := range roundIDs {
+		if err := copyRound(tx, oldID, newID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// copyRound copies round oldID, with its steps, IOCs and search entries, into session sessionID.
+func copyRound(tx *sql.Tx, oldID int64, sessionID string) error {
+	res, err := tx.Exec(
+		`INSERT INTO rounds (session_id, round_num, user_query, plan_json, table_schema, report, findings, operation_log, digest, created_at)
+		 SELECT ?, round_num, user_query, plan_json, table_schema, report, findings, operation_log, digest, created_at
+		 FROM rounds WHERE id = ?`,
+		sessionID, oldID,
+	)
+	if err != nil {
+		return fmt.Errorf("copy round: %w", err)
+	}
+	newID, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	stmts := []string{
+		`INSERT INTO steps (round_id, step_id, intent, findings, actions, status, created_at,
+			started_at, finished_at, tool_calls, prompt_tokens, completion_tokens, total_tokens)
+		 SELECT ?, step_id, intent, findings, actions, status, created_at,
+			started_at, finished_at, tool_calls, prompt_tokens, completion_tokens, total_tokens
+		 FROM steps WHERE round_id = ? ORDER BY id`,
+		`INSERT INTO iocs (round_id, type, value, context, step_ids, created_at)
+		 SELECT ?, type, value, context, step_ids, created_at FROM iocs WHERE round_id = ? ORDER BY id`,
+		`INSERT INTO search_index (body, field, round_id, step_id)
+		 SELECT body, field, ?, step_id FROM search_index WHERE round_id = ?`,
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt, newID, oldID); err != nil {
+			return fmt.Errorf("copy round %d: %w", oldID, err)
+		}
+	}
+	return nil
+}
+
+// GetSessionLineage returns the ancestors of a session, root first, ending with
+// the session itself. Ancestors that were deleted end the chain.
+func GetSessionLineage(store Store, id string) ([]SessionInfo, error) {
+	var chain []SessionInfo
+	seen := map[string]bool{}
+	for id != "" && !seen[id] {
+		seen[id] = true
+		info, err := store.GetSession(id)
+		if err != nil {
+			return nil, err
+		}
+		if info == nil {
+			break
+		}
+		chain = append([]SessionInfo{*info}, chain...)
+		id = info.ParentSessionID
+	}
+	return chain, nil
+}
+
+// GetSessionForks returns the sessions forked directly from id, oldest first.
+func (s *SQLiteStore) GetSessionForks(id string) ([]SessionInfo, error) {
+	rows, err := s.db.Query(sessionInfoQuery+" WHERE s.parent_session_id = ? ORDER BY s.created_at ASC, s.id ASC", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []SessionInfo
+	for rows.Next() {
+		info, err := scanSessionInfo(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, *info)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,207 @@
+package session
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"pcap_agent/internal/common"
+	"pcap_agent/pkg/logger"
+)
+
+// Defaults for session history assembly.
+const (
+	DefaultHistoryMaxTokens    = 12 * 1024
+	DefaultHistoryRecentRounds = 2
+
+	// digestMaxChars bounds the fallback digest built without a model.
+	digestMaxChars = 1500
+)
+
+// DigestFunc compacts a finished round into a short digest for later planning.
+type DigestFunc func(ctx context.Context, r Round) (string, error)
+
+// HistoryConfig controls how much of a session's history is fed to the planner.
+type HistoryConfig struct {
+	// MaxTokens is the approximate token budget for the whole history section.
+	MaxTokens int
+	// RecentRounds is how many of the most recent rounds are kept verbatim
+	// (findings and operation log) when they fit in the budget. Older rounds,
+	// and recent ones that do not fit, are represented by their digest.
+	RecentRounds int
+	// Digest generates a round's digest the first time it is needed; the result
+	// is cached in the database. nil uses DefaultDigest.
+	Digest DigestFunc
+}
+
+// GetMaxTokens returns the effective token budget, using the default if not set.
+func (c *HistoryConfig) GetMaxTokens() int {
+	if c == nil || c.MaxTokens <= 0 {
+		return DefaultHistoryMaxTokens
+	}
+	return c.MaxTokens
+}
+
+// GetRecentRounds returns the number of verbatim rounds, using the default if not set.
+func (c *HistoryConfig) GetRecentRounds() int {
+	if c == nil || c.RecentRounds <= 0 {
+		return DefaultHistoryRecentRounds
+	}
+	return c.RecentRounds
+}
+
+// EstimateTokens approximates the token count of s: about four bytes per
+// token for ASCII text and one token per non-ASCII character.
+func EstimateTokens(s string) int {
+	ascii, other := 0, 0
+	for _, r := range s {
+		if r < utf8.RuneSelf {
+			ascii++
+		} else {
+			other++
+		}
+	}
+	return (ascii+3)/4 + other
+}
+
+// truncateTokens cuts s to roughly maxTokens tokens, at a line boundary when possible.
+func truncateTokens(s string, maxTokens int) string {
+	if EstimateTokens(s) <= maxTokens {
+		return s
+	}
+	runes := []rune(s)
+	cut := len(runes)
+	for cut > 0 && EstimateTokens(string(runes[:cut])) > maxTokens {
+		cut = cut * 3 / 4
+	}
+	out := string(runes[:cut])
+	if i := strings.LastIndex(out, "\n"); i > len(out)/2 {
+		out = out[:i]
+	}
+	return out + "\n…(truncated)"
+}
+
+// DefaultDigest builds a digest without a model: the query followed by the
+// beginning of the round's report (or findings if there is no report).
+func DefaultDigest(r Round) string {
+	body := strings.TrimSpace(r.Report)
+	if body == "" {
+		body = strings.TrimSpace(r.Findings)
+	}
+	if runes := []rune(body); len(runes) > digestMaxChars {
+		body = string(runes[:digestMaxChars])
+		if i := strings.LastIndex(body, "\n"); i > len(body)/2 {
+			body = body[:i]
+		}
+		body += "\n…"
+	}
+	return fmt.Sprintf("Query: %s\n%s", r.UserQuery, body)
+}
+
+// roundDigest returns the cached digest of r, generating and caching it if missing.
+// The default digest is cheap and is never cached, so a configured digester can
+// still replace it later; if the digester fails, the default is used for now.
+func roundDigest(ctx context.Context, store Store, r Round, cfg *HistoryConfig) (string, error) {
+	if r.Digest != "" {
+		return r.Digest, nil
+	}
+	if cfg == nil || cfg.Digest == nil {
+		return DefaultDigest(r), nil
+	}
+	digest, err := cfg.Digest(ctx, r)
+	if err != nil || strings.TrimSpace(digest) == "" {
+		logger.CtxWarnf(ctx, "[Session] digest round %d of %s failed, using excerpt: %v", r.RoundNum, r.SessionID, err)
+		return DefaultDigest(r), nil
+	}
+	digest = strings.TrimSpace(digest)
+	return digest, store.SaveRoundDigest(r.ID, digest)
+}
+
+// GetSessionHistory assembles the context of a session's previous rounds within
+// the configured token budget. The most recent report is always included
+// (truncated to at most half the budget). The most recent rounds are kept
+// verbatim when they fit; older rounds are represented by cached digests, newest
+// first, and rounds that no longer fit are dropped and counted in OmittedRounds.
+// Digests are generated with cfg.Digest on first use and stored with the round.
+func GetSessionHistory(ctx context.Context, store Store, sessionID string, cfg *HistoryConfig) (*common.SessionHistory, error) {
+	rounds, err := store.GetRounds(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	history := &common.SessionHistory{}
+	if len(rounds) == 0 {
+		return history, nil
+	}
+
+	budget := cfg.GetMaxTokens()
+	if report := rounds[len(rounds)-1].Report; report != "" {
+		history.PreviousReport = truncateTokens(report, budget/2)
+		budget -= EstimateTokens(history.PreviousReport)
+	}
+
+	var findings, opLogs, digests []string // newest first
+	recent := cfg.GetRecentRounds()
+	for i := len(rounds) - 1; i >= 0; i-- {
+		r := rounds[i]
+		if len(rounds)-i <= recent {
+			f := roundSection(r.RoundNum, r.Findings)
+			o := roundSection(r.RoundNum, r.OperationLog)
+			if cost := EstimateTokens(f) + EstimateTokens(o); cost <= budget {
+				findings, opLogs = appendNonEmpty(findings, f), appendNonEmpty(opLogs, o)
+				budget -= cost
+				continue
+			}
+			if cost := EstimateTokens(f); f != "" && cost <= budget {
+				findings = append(findings, f)
+				budget -= cost
+				continue
+			}
+			if i == len(rounds)-1 && history.PreviousReport != "" {
+				continue // the latest round is already represented by its report
+			}
+		}
+
+		digest, err := roundDigest(ctx, store, r, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("digest round %d: %w", r.RoundNum, err)
+		}
+		d := roundSection(r.RoundNum, digest)
+		cost := EstimateTokens(d)
+		if cost > budget {
+			history.OmittedRounds = i + 1
+			break
+		}
+		digests = append(digests, d)
+		budget -= cost
+	}
+
+	history.Findings = joinOldestFirst(findings)
+	history.OperationLog = joinOldestFirst(opLogs)
+	history.Digests = joinOldestFirst(digests)
+	return history, nil
+}
+
+func roundSection(roundNum int, text string) string {
+	if strings.TrimSpace(text) == "" {
+		return ""
+	}
+	return fmt.Sprintf("## Round %d\n%s", roundNum, text)
+}
+
+func appendNonEmpty(list []string, s string) []string {
+	if s == "" {
+		return list
+	}
+	return append(list, s)
+}
+
+// joinOldestFirst joins sections collected newest first in chronological order.
+func joinOldestFirst(sections []string) string {
+	var sb strings.Builder
+	for i := len(sections) - 1; i >= 0; i-- {
+		sb.WriteString(sections[i])
+		sb.WriteString("\n\n")
+	}
+	return sb.String()
+}
@@ -0,0 +1,182 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"pcap_agent/internal/common"
+)
+
+func TestEstimateTokens(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int
+	}{
+		{"", 0},
+		{"abcd", 1},
+		{"abcde", 2},
+		{"日本語", 3},
+		{"ab日本", 3},
+	}
+	for _, tt := range tests {
+		if got := EstimateTokens(tt.in); got != tt.want {
+			t.Errorf("EstimateTokens(%q) = %d; want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestTruncateTokens(t *testing.T) {
+	if got := truncateTokens("short", 10); got != "short" {
+		t.Errorf("truncateTokens kept %q", got)
+	}
+	long := strings.Repeat("line of text\n", 1000)
+	got := truncateTokens(long, 100)
+	if !strings.HasSuffix(got, "\n…(truncated)") || EstimateTokens(got) > 110 {
+		t.Errorf("truncated to %d tokens: %q…", EstimateTokens(got), got[:40])
+	}
+	// The cut lands on a line boundary.
+	if body := strings.TrimSuffix(got, "\n…(truncated)"); !strings.HasSuffix(body, "line of text") {
+		t.Errorf("cut mid-line: %q", body[len(body)-20:])
+	}
+}
+
+func TestDefaultDigest(t *testing.T) {
+	if got := DefaultDigest(Round{UserQuery: "q", Report: " report ", Findings: "findings"}); got != "Query: q\nreport" {
+		t.Errorf("digest = %q", got)
+	}
+	if got := DefaultDigest(Round{UserQuery: "q", Findings: "findings"}); got != "Query: q\nfindings" {
+		t.Errorf("digest without report = %q", got)
+	}
+	got := DefaultDigest(Round{UserQuery: "q", Report: strings.Repeat("x", 3*digestMaxChars)})
+	if n := len([]rune(got)); n > digestMaxChars+20 || !strings.HasSuffix(got, "\n…") {
+		t.Errorf("long digest is %d characters", n)
+	}
+}
+
+// historyStore returns a store with n rounds whose findings, operation log and
+// report are each about size tokens.
+func historyStore(t *testing.T, n, size int) Store {
+	t.Helper()
+	s := NewMemoryStore()
+	if err := s.CreateSession("s", []SessionPcap{{ContainerPath: "/p"}}); err != nil {
+		t.Fatal(err)
+	}
+	text := func(kind string, i int) string {
+		return fmt.Sprintf("%s %d\n", kind, i) + strings.Repeat("x", 4*size)
+	}
+	for i := 1; i <= n; i++ {
+		if _, err := s.SaveRound("s", i, fmt.Sprintf("query %d", i), common.Plan{},
+			text("report", i), text("findings", i), text("oplog", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s
+}
+
+func historyTokens(h *common.SessionHistory) int {
+	return EstimateTokens(h.PreviousReport) + EstimateTokens(h.Findings) +
+		EstimateTokens(h.OperationLog) + EstimateTokens(h.Digests)
+}
+
+func TestGetSessionHistoryBudget(t *testing.T) {
+	ctx := context.Background()
+	digest := func(_ context.Context, r Round) (string, error) {
+		return "digest of " + r.UserQuery + "\n" + strings.Repeat("d", 400), nil
+	}
+
+	t.Run("recent rounds verbatim", func(t *testing.T) {
+		s := historyStore(t, 4, 100)
+		h, err := GetSessionHistory(ctx, s, "s", &HistoryConfig{RecentRounds: 2, Digest: digest})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, want := range []string{"findings 3", "findings 4"} {
+			if !strings.Contains(h.Findings, want) {
+				t.Errorf("findings missing %q", want)
+			}
+		}
+		if !strings.Contains(h.OperationLog, "oplog 3") || strings.Contains(h.Findings, "findings 2") {
+			t.Errorf("findings = %q\noplog = %q", h.Findings, h.OperationLog)
+		}
+		if !strings.Contains(h.Digests, "digest of query 1") || !strings.Contains(h.Digests, "digest of query 2") ||
+			strings.Index(h.Digests, "query 1") > strings.Index(h.Digests, "query 2") {
+			t.Errorf("digests not oldest first: %q", h.Digests)
+		}
+		if h.OmittedRounds != 0 || !strings.HasPrefix(h.PreviousReport, "report 4") {
+			t.Errorf("omitted = %d, report = %.10q", h.OmittedRounds, h.PreviousReport)
+		}
+	})
+
+	t.Run("findings without operation log", func(t *testing.T) {
+		// Room for round 2's findings but not its operation log as well.
+		s := historyStore(t, 2, 1000)
+		h, err := GetSessionHistory(ctx, s, "s", &HistoryConfig{MaxTokens: 2800, RecentRounds: 2, Digest: digest})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(h.Findings, "findings 2") || h.OperationLog != "" {
+			t.Errorf("findings = %.20q, oplog = %.20q", h.Findings, h.OperationLog)
+		}
+	})
+
+	t.Run("old rounds omitted over budget", func(t *testing.T) {
+		s := historyStore(t, 12, 1000)
+		const budget = 1500
+		h, err := GetSessionHistory(ctx, s, "s", &HistoryConfig{MaxTokens: budget, Digest: digest})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := historyTokens(h); got > budget {
+			t.Errorf("history is %d tokens; budget %d", got, budget)
+		}
+		// The report is capped at half the budget and the latest round is not repeated.
+		if !strings.HasSuffix(h.PreviousReport, "…(truncated)") || EstimateTokens(h.PreviousReport) > budget/2+10 {
+			t.Errorf("report is %d tokens", EstimateTokens(h.PreviousReport))
+		}
+		if h.OmittedRounds == 0 || !strings.Contains(h.Digests, "digest of query 11") ||
+			strings.Contains(h.Digests, fmt.Sprintf("digest of query %d\n", h.OmittedRounds)) {
+			t.Errorf("omitted = %d, digests = %q", h.OmittedRounds, h.Digests)
+		}
+	})
+}
+
+func TestGetSessionHistoryDigestCache(t *testing.T) {
+	ctx := context.Background()
+	s := historyStore(t, 4, 10)
+	calls := map[int]int{}
+	cfg := &HistoryConfig{RecentRounds: 1, Digest: func(_ context.Context, r Round) (string, error) {
+		calls[r.RoundNum]++
+		if r.RoundNum == 1 {
+			return "", errors.New("model down")
+		}
+		return " digest of " + r.UserQuery + " ", nil
+	}}
+
+	for range 2 {
+		h, err := GetSessionHistory(ctx, s, "s", cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// A failed digest falls back to the excerpt.
+		if !strings.Contains(h.Digests, "Query: query 1\nreport 1") || !strings.Contains(h.Digests, "digest of query 3") {
+			t.Errorf("digests = %q", h.Digests)
+		}
+	}
+	// Generated digests are cached and trimmed; the fallback is not cached.
+	if calls[1] != 2 || calls[2] != 1 || calls[3] != 1 || calls[4] != 0 {
+		t.Errorf("digest calls = %v", calls)
+	}
+	r, err := s.GetRound("s", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Digest != "digest of query 2" {
+		t.Errorf("cached digest = %q", r.Digest)
+	}
+	if r, _ := s.GetRound("s", 1); r.Digest != "" {
+		t.Errorf("fallback digest cached: %q", r.Digest)
+	}
+}
@@ -0,0 +1,1033 @@
+package session
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"pcap_agent/internal/common"
+	"pcap_agent/internal/events"
+)
+
+// MemoryStore is a Store that keeps everything in process. It follows the same
+// ordering, uniqueness and timestamp conventions as SQLiteStore and is meant for
+// tests and throwaway runs; nothing survives Close.
+type MemoryStore struct {
+	mu         sync.Mutex
+	seq        int64 // insertion order, used to break timestamp ties
+	pcapFiles  []*PcapFile
+	sessions   map[string]*memSession
+	rounds     map[int64]*memRound
+	nextPcapID int64
+	nextRound  int64
+}
+
+var _ Store = (*MemoryStore)(nil)
+
+type memSession struct {
+	info        SessionInfo // PcapCount, RoundCount and LastQuery are derived on read
+	seq         int64
+	pcaps       []memSessionPcap
+	roundIDs    []int64
+	checkpoints map[int]memCheckpoint
+	events      []events.Event
+}
+
+type memSessionPcap struct {
+	pcapFileID    int64
+	containerPath string
+}
+
+type memRound struct {
+	Round
+	planJSON string
+	steps    []common.StepRecord
+	iocs     []common.IOC
+	search   []memSearchEntry
+}
+
+type memCheckpoint struct {
+	Checkpoint
+	stateJSON string
+}
+
+type memSearchEntry struct {
+	field  string
+	stepID int
+	body   string
+}
+
+// NewMemoryStore returns an empty in-process store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		sessions: map[string]*memSession{},
+		rounds:   map[int64]*memRound{},
+	}
+}
+
+// Close is a no-op; the data lives as long as the store itself.
+func (m *MemoryStore) Close() error {
+	return nil
+}
+
+// memNow renders the current time like SQLite's datetime('now').
+func memNow() string {
+	return time.Now().UTC().Format("2006-01-02 15:04:05")
+}
+
+func (m *MemoryStore) nextSeq() int64 {
+	m.seq++
+	return m.seq
+}
+
+// SavePcapFile records a PCAP file for future reference.
+func (m *MemoryStore) SavePcapFile(fileName, filePath string, fileSize int64, fileHash string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if fileHash != "" && m.pcapFileByHash(fileHash) != nil {
+		return 0, fmt.Errorf("pcap file with hash %s already registered", fileHash)
+	}
+	m.nextPcapID++
+	m.pcapFiles = append(m.pcapFiles, &PcapFile{
+		ID:        m.nextPcapID,
+		FileName:  fileName,
+		FilePath:  filePath,
+		FileSize:  fileSize,
+		FileHash:  fileHash,
+		CreatedAt: memNow(),
+	})
+	return m.nextPcapID, nil
+}
+
+func (m *MemoryStore) pcapFileByHash(hash string) *PcapFile {
+	for _, f := range m.pcapFiles {
+		if f.FileHash == hash {
+			return f
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) pcapFileByID(id int64) *PcapFile {
+	for _, f := range m.pcapFiles {
+		if f.ID == id {
+			return f
+		}
+	}
+	return nil
+}
+
+// GetPcapFileByHash returns the registered file with the given SHA-256, or nil if none.
+func (m *MemoryStore) GetPcapFileByHash(hash string) (*PcapFile, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if f := m.pcapFileByHash(hash); f != nil {
+		out := *f
+		return &out, nil
+	}
+	return nil, nil
+}
+
+// ListPcapFiles returns all registered captures, newest first, each with the
+// IDs of the sessions linked to it (oldest first).
+func (m *MemoryStore) ListPcapFiles() ([]PcapFileUsage, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	files := make([]*PcapFile, len(m.pcapFiles))
+	copy(files, m.pcapFiles)
+	sort.SliceStable(files, func(i, j int) bool {
+		if files[i].CreatedAt != files[j].CreatedAt {
+			return files[i].CreatedAt > files[j].CreatedAt
+		}
+		return files[i].ID > files[j].ID
+	})
+
+	var out []PcapFileUsage
+	for _, f := range files {
+		out = append(out, PcapFileUsage{PcapFile: *f, SessionIDs: m.pcapFileSessions(f.ID)})
+	}
+	return out, nil
+}
+
+// GetPcapFileSessions returns the IDs of the sessions linked to a registered capture, oldest first.
+func (m *MemoryStore) GetPcapFileSessions(fileID int64) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ids := m.pcapFileSessions(fileID)
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	return ids, nil
+}
+
+// pcapFileSessions returns the sessions linked to fileID, oldest first, once
+// per link like the SQLite join.
+func (m *MemoryStore) pcapFileSessions(fileID int64) []string {
+	var linked []*memSession
+	for _, s := range m.sessions {
+		for _, p := range s.pcaps {
+			if p.pcapFileID == fileID {
+				linked = append(linked, s)
+			}
+		}
+	}
+	m.sortOldestFirst(linked)
+	ids := []string{}
+	for _, s := range linked {
+		ids = append(ids, s.info.ID)
+	}
+	return ids
+}
+
+func (m *MemoryStore) sortOldestFirst(list []*memSession) {
+	sort.SliceStable(list, func(i, j int) bool {
+		if list[i].info.CreatedAt != list[j].info.CreatedAt {
+			return list[i].info.CreatedAt < list[j].info.CreatedAt
+		}
+		return list[i].seq < list[j].seq
+	})
+}
+
+// CreateSession creates a new session record with its captures, in order.
+func (m *MemoryStore) CreateSession(id string, pcaps []SessionPcap) error {
+	if len(pcaps) == 0 {
+		return fmt.Errorf("session %s has no captures", id)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.sessions[id]; ok {
+		return fmt.Errorf("session %s already exists", id)
+	}
+	now := memNow()
+	s := &memSession{
+		info: SessionInfo{
+			ID:         id,
+			PcapPath:   pcaps[0].ContainerPath,
+			PcapFileID: pcaps[0].PcapFileID,
+			CreatedAt:  now,
+			UpdatedAt:  now,
+		},
+		seq:         m.nextSeq(),
+		checkpoints: map[int]memCheckpoint{},
+	}
+	for _, p := range pcaps {
+		s.addPcap(p)
+	}
+	m.sessions[id] = s
+	return nil
+}
+
+func (s *memSession) addPcap(p SessionPcap) {
+	for _, existing := range s.pcaps {
+		if existing.containerPath == p.ContainerPath {
+			return
+		}
+	}
+	s.pcaps = append(s.pcaps, memSessionPcap{pcapFileID: p.PcapFileID, containerPath: p.ContainerPath})
+}
+
+// AddSessionPcap attaches another capture to a session. Adding a container path
+// the session already has is a no-op.
+func (m *MemoryStore) AddSessionPcap(sessionID string, p SessionPcap) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.sessions[sessionID]
+	if !ok {
+		return fmt.Errorf("add capture %s: session %s not found", p.ContainerPath, sessionID)
+	}
+	s.addPcap(p)
+	return nil
+}
+
+// GetSessionPcaps returns the captures of a session in the order they were added.
+func (m *MemoryStore) GetSessionPcaps(sessionID string) ([]SessionPcap, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.sessions[sessionID]
+	if !ok {
+		return nil, nil
+	}
+	var out []SessionPcap
+	for _, sp := range s.pcaps {
+		p := SessionPcap{PcapFileID: sp.pcapFileID, ContainerPath: sp.containerPath}
+		if f := m.pcapFileByID(sp.pcapFileID); f != nil {
+			p.FileName, p.FileSize, p.FileHash = f.FileName, f.FileSize, f.FileHash
+		}
+		if p.FileName == "" {
+			p.FileName = path.Base(p.ContainerPath)
+		}
+		out = append(out, p)
+	}
+	return out, nil
+}
+
+// TouchSession updates the session's updated_at timestamp.
+func (m *MemoryStore) TouchSession(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if s, ok := m.sessions[id]; ok {
+		s.info.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+	}
+	return nil
+}
+
+// SessionExists checks if a session with the given ID exists.
+func (m *MemoryStore) SessionExists(id string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, ok := m.sessions[id]
+	return ok, nil
+}
+
+// sessionInfo returns the summary of s with its derived fields filled in.
+func (m *MemoryStore) sessionInfo(s *memSession) SessionInfo {
+	info := s.info
+	info.PcapCount = len(s.pcaps)
+	info.RoundCount = len(s.roundIDs)
+	last := 0
+	for _, id := range s.roundIDs {
+		if r := m.rounds[id]; r.RoundNum >= last {
+			last, info.LastQuery = r.RoundNum, r.UserQuery
+		}
+	}
+	return info
+}
+
+// GetSession returns a session's summary, or nil if it does not exist.
+func (m *MemoryStore) GetSession(id string) (*SessionInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.sessions[id]
+	if !ok {
+		return nil, nil
+	}
+	info := m.sessionInfo(s)
+	return &info, nil
+}
+
+// ListSessions returns all sessions, most recently updated first.
+func (m *MemoryStore) ListSessions() ([]SessionInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	list := make([]*memSession, 0, len(m.sessions))
+	for _, s := range m.sessions {
+		list = append(list, s)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		a, b := list[i].info, list[j].info
+		if a.UpdatedAt != b.UpdatedAt {
+			return a.UpdatedAt > b.UpdatedAt
+		}
+		if a.CreatedAt != b.CreatedAt {
+			return a.CreatedAt > b.CreatedAt
+		}
+		return list[i].seq > list[j].seq
+	})
+
+	var out []SessionInfo
+	for _, s := range list {
+		out = append(out, m.sessionInfo(s))
+	}
+	return out, nil
+}
+
+// GetSessionForks returns the sessions forked directly from id, oldest first.
+func (m *MemoryStore) GetSessionForks(id string) ([]SessionInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var forks []*memSession
+	for _, s := range m.sessions {
+		if s.info.ParentSessionID == id {
+			forks = append(forks, s)
+		}
+	}
+	m.sortOldestFirst(forks)
+
+	var out []SessionInfo
+	for _, s := range forks {
+		out = append(out, m.sessionInfo(s))
+	}
+	return out, nil
+}
+
+// ForkSession creates session newID as a branch of srcID that inherits rounds
+// 1..roundNum and the same captures. Checkpoints are not inherited.
+func (m *MemoryStore) ForkSession(srcID string, roundNum int, newID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	src, ok := m.sessions[srcID]
+	if !ok {
+		return fmt.Errorf("session %s not found", srcID)
+	}
+	if roundNum < 0 || roundNum > len(src.roundIDs) {
+		return fmt.Errorf("session %s has %d rounds, cannot fork at round %d", srcID, len(src.roundIDs), roundNum)
+	}
+	if _, ok := m.sessions[newID]; ok {
+		return fmt.Errorf("create fork: session %s already exists", newID)
+	}
+
+	now := memNow()
+	fork := &memSession{
+		info: SessionInfo{
+			ID:              newID,
+			PcapPath:        src.info.PcapPath,
+			PcapFileID:      src.info.PcapFileID,
+			CreatedAt:       now,
+			UpdatedAt:       now,
+			ParentSessionID: srcID,
+			ParentRound:     roundNum,
+		},
+		seq:         m.nextSeq(),
+		pcaps:       append([]memSessionPcap(nil), src.pcaps...),
+		checkpoints: map[int]memCheckpoint{},
+	}
+	for _, id := range m.sortedRoundIDs(src) {
+		r := m.rounds[id]
+		if r.RoundNum > roundNum {
+			continue
+		}
+		m.nextRound++
+		c := &memRound{
+			Round:    r.Round,
+			planJSON: r.planJSON,
+			steps:    append([]common.StepRecord(nil), r.steps...),
+			iocs:     copyIOCs(r.iocs),
+			search:   append([]memSearchEntry(nil), r.search...),
+		}
+		c.ID, c.SessionID = m.nextRound, newID
+		m.rounds[c.ID] = c
+		fork.roundIDs = append(fork.roundIDs, c.ID)
+	}
+	m.sessions[newID] = fork
+	return nil
+}
+
+// RestoreSessionMetadata sets the timestamps and fork linkage of a session
+// recreated from an export. Empty timestamps are left unchanged.
+func (m *MemoryStore) RestoreSessionMetadata(info SessionInfo) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.sessions[info.ID]
+	if !ok {
+		return nil
+	}
+	if info.CreatedAt != "" {
+		s.info.CreatedAt = info.CreatedAt
+	}
+	if info.UpdatedAt != "" {
+		s.info.UpdatedAt = info.UpdatedAt
+	}
+	s.info.ParentSessionID = info.ParentSessionID
+	s.info.ParentRound = info.ParentRound
+	return nil
+}
+
+// DeleteSession removes a session and everything recorded under it.
+// Its forks keep their own copies of the inherited rounds and are detached:
+// their parent link is cleared. It returns false if the session does not exist.
+func (m *MemoryStore) DeleteSession(id string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.sessions[id]
+	if !ok {
+		return false, nil
+	}
+	for _, rid := range s.roundIDs {
+		delete(m.rounds, rid)
+	}
+	delete(m.sessions, id)
+	for _, child := range m.sessions {
+		if child.info.ParentSessionID == id {
+			child.info.ParentSessionID = ""
+			child.info.ParentRound = 0
+		}
+	}
+	return true, nil
+}
+
+// AppendRound saves a completed round with its steps and indicators as the
+// session's next round, touches the session and deletes the checkpoint the
+// round completes, all under one lock.
+func (m *MemoryStore) AppendRound(rec RoundRecord) (int64, int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.sessions[rec.SessionID]
+	if !ok {
+		return 0, 0, fmt.Errorf("session %s not found", rec.SessionID)
+	}
+	roundNum := 1
+	for _, id := range s.roundIDs {
+		if n := m.rounds[id].RoundNum; n >= roundNum {
+			roundNum = n + 1
+		}
+	}
+	r := m.addRound(s, roundNum, rec.UserQuery, rec.Plan, rec.Report, rec.Findings, rec.OperationLog)
+	for _, step := range rec.Steps {
+		r.addStep(step)
+	}
+	r.addIOCs(rec.IOCs)
+	s.info.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+	if rec.Checkpoint > 0 {
+		delete(s.checkpoints, rec.Checkpoint)
+	}
+	return r.ID, roundNum, nil
+}
+
+// SaveRound saves a round with the given number and indexes its text for search.
+func (m *MemoryStore) SaveRound(sessionID string, roundNum int, userQuery string, plan common.Plan, report, findings, opLog string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.sessions[sessionID]
+	if !ok {
+		return 0, fmt.Errorf("session %s not found", sessionID)
+	}
+	for _, id := range s.roundIDs {
+		if m.rounds[id].RoundNum == roundNum {
+			return 0, fmt.Errorf("session %s already has round %d", sessionID, roundNum)
+		}
+	}
+	return m.addRound(s, roundNum, userQuery, plan, report, findings, opLog).ID, nil
+}
+
+func (m *MemoryStore) addRound(s *memSession, roundNum int, userQuery string, plan common.Plan, report, findings, opLog string) *memRound {
+	planJSON, _ := json.Marshal(plan)
+	m.nextRound++
+	r := &memRound{
+		Round: Round{
+			ID:           m.nextRound,
+			SessionID:    s.info.ID,
+			RoundNum:     roundNum,
+			UserQuery:    userQuery,
+			Report:       report,
+			Findings:     findings,
+			OperationLog: opLog,
+			CreatedAt:    memNow(),
+		},
+		planJSON: string(planJSON),
+	}
+	r.index(SearchFieldQuery, 0, userQuery)
+	r.index(SearchFieldFindings, 0, findings)
+	r.index(SearchFieldReport, 0, report)
+	m.rounds[r.ID] = r
+	s.roundIDs = append(s.roundIDs, r.ID)
+	return r
+}
+
+func (r *memRound) index(field string, stepID int, body string) {
+	if strings.TrimSpace(body) == "" {
+		return
+	}
+	r.search = append(r.search, memSearchEntry{field: field, stepID: stepID, body: body})
+}
+
+// round returns a copy of r with its plan decoded, as a database read would.
+func (r *memRound) round() (Round, error) {
+	out := r.Round
+	out.Plan = common.Plan{}
+	if r.planJSON != "" {
+		if err := json.Unmarshal([]byte(r.planJSON), &out.Plan); err != nil {
+			return Round{}, fmt.Errorf("unmarshal plan of round %d: %w", r.RoundNum, err)
+		}
+	}
+	return out, nil
+}
+
+// SaveRoundDigest caches the digest of a round.
+func (m *MemoryStore) SaveRoundDigest(roundID int64, digest string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if r, ok := m.rounds[roundID]; ok {
+		r.Digest = digest
+	}
+	return nil
+}
+
+// RestoreRoundCreatedAt sets the creation time of a round recreated from an export.
+func (m *MemoryStore) RestoreRoundCreatedAt(roundID int64, createdAt string) error {
+	if createdAt == "" {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if r, ok := m.rounds[roundID]; ok {
+		r.CreatedAt = createdAt
+	}
+	return nil
+}
+
+// GetRoundCount returns the number of rounds in a session.
+func (m *MemoryStore) GetRoundCount(sessionID string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if s, ok := m.sessions[sessionID]; ok {
+		return len(s.roundIDs), nil
+	}
+	return 0, nil
+}
+
+// sortedRoundIDs returns the rounds of s ordered by round number.
+func (m *MemoryStore) sortedRoundIDs(s *memSession) []int64 {
+	ids := append([]int64(nil), s.roundIDs...)
+	sort.SliceStable(ids, func(i, j int) bool {
+		return m.rounds[ids[i]].RoundNum < m.rounds[ids[j]].RoundNum
+	})
+	return ids
+}
+
+// GetRounds returns all rounds of a session in order.
+func (m *MemoryStore) GetRounds(sessionID string) ([]Round, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.sessions[sessionID]
+	if !ok {
+		return nil, nil
+	}
+	var out []Round
+	for _, id := range m.sortedRoundIDs(s) {
+		r, err := m.rounds[id].round()
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	return out, nil
+}
+
+// GetRound returns round roundNum of a session, or nil if it does not exist.
+func (m *MemoryStore) GetRound(sessionID string, roundNum int) (*Round, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	r := m.findRound(sessionID, roundNum)
+	if r == nil {
+		return nil, nil
+	}
+	out, err := r.round()
+	if err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+func (m *MemoryStore) findRound(sessionID string, roundNum int) *memRound {
+	s, ok := m.sessions[sessionID]
+	if !ok {
+		return nil
+	}
+	for _, id := range s.roundIDs {
+		if r := m.rounds[id]; r.RoundNum == roundNum {
+			return r
+		}
+	}
+	return nil
+}
+
+// SaveStep saves the record of an executed (or skipped) step within a round and
+// indexes its findings for search.
+func (m *MemoryStore) SaveStep(roundID int64, rec common.StepRecord) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	r, ok := m.rounds[roundID]
+	if !ok {
+		return fmt.Errorf("round %d not found", roundID)
+	}
+	r.addStep(rec)
+	return nil
+}
+
+func (r *memRound) addStep(rec common.StepRecord) {
+	// Normalise the timestamps the way a database round trip would.
+	rec.StartedAt = parseTime(formatTime(rec.StartedAt))
+	rec.FinishedAt = parseTime(formatTime(rec.FinishedAt))
+	r.steps = append(r.steps, rec)
+	r.index(SearchFieldStep, rec.StepID, rec.Findings)
+}
+
+// GetRoundSteps returns the step records of a session's round, in execution order.
+func (m *MemoryStore) GetRoundSteps(sessionID string, roundNum int) ([]common.StepRecord, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	r := m.findRound(sessionID, roundNum)
+	if r == nil || len(r.steps) == 0 {
+		return nil, nil
+	}
+	return append([]common.StepRecord(nil), r.steps...), nil
+}
+
+// SaveIOCs saves the deduplicated indicators of a round.
+func (m *MemoryStore) SaveIOCs(roundID int64, iocs []common.IOC) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	r, ok := m.rounds[roundID]
+	if !ok {
+		return fmt.Errorf("round %d not found", roundID)
+	}
+	r.addIOCs(iocs)
+	return nil
+}
+
+func (r *memRound) addIOCs(iocs []common.IOC) {
+next:
+	for _, ioc := range iocs {
+		for _, existing := range r.iocs {
+			if existing.Type == ioc.Type && existing.Value == ioc.Value {
+				continue next
+			}
+		}
+		r.iocs = append(r.iocs, copyIOCs([]common.IOC{ioc})...)
+	}
+}
+
+func copyIOCs(iocs []common.IOC) []common.IOC {
+	out := make([]common.IOC, len(iocs))
+	for i, ioc := range iocs {
+		ioc.StepIDs = append([]int(nil), ioc.StepIDs...)
+		out[i] = ioc
+	}
+	return out
+}
+
+// GetSessionIOCs returns every indicator recorded in a session, ordered by round.
+// An indicator seen in several rounds appears once per round.
+func (m *MemoryStore) GetSessionIOCs(sessionID string) ([]SessionIOC, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.sessions[sessionID]
+	if !ok {
+		return nil, nil
+	}
+	var out []SessionIOC
+	for _, id := range m.sortedRoundIDs(s) {
+		r := m.rounds[id]
+		for _, ioc := range copyIOCs(r.iocs) {
+			out = append(out, SessionIOC{RoundNum: r.RoundNum, IOC: ioc})
+		}
+	}
+	return out, nil
+}
+
+// Search returns the best matches for text across every session's queries,
+// findings, reports and step findings. Every whitespace-separated term must
+// match as a phrase of whole words, case-insensitively; hits with more matches
+// rank first.
+func (m *MemoryStore) Search(text string, limit int) ([]SearchHit, error) {
+	var phrases [][]string
+	for _, term := range strings.Fields(text) {
+		if words := searchWords(term); len(words) > 0 {
+			phrases = append(phrases, wordTexts(term, words))
+		}
+	}
+	if len(phrases) == 0 {
+		return nil, fmt.Errorf("empty search query")
+	}
+	if limit <= 0 {
+		limit = DefaultSearchLimit
+	}
+
+	m.mu.Lock()
+	var hits []SearchHit
+	for _, r := range m.rounds {
+		for _, e := range r.search {
+			if snippet, matches, ok := matchPhrases(e.body, phrases); ok {
+				hits = append(hits, SearchHit{
+					SessionID: r.SessionID,
+					RoundNum:  r.RoundNum,
+					StepID:    e.stepID,
+					Field:     e.field,
+					Snippet:   snippet,
+					Score:     -float64(matches),
+				})
+			}
+		}
+	}
+	m.mu.Unlock()
+
+	sort.Slice(hits, func(i, j int) bool {
+		a, b := hits[i], hits[j]
+		switch {
+		case a.Score != b.Score:
+			return a.Score < b.Score
+		case a.SessionID != b.SessionID:
+			return a.SessionID < b.SessionID
+		case a.RoundNum != b.RoundNum:
+			return a.RoundNum < b.RoundNum
+		case a.StepID != b.StepID:
+			return a.StepID < b.StepID
+		default:
+			return a.Field < b.Field
+		}
+	})
+	if len(hits) > limit {
+		hits = hits[:limit]
+	}
+	return hits, nil
+}
+
+// searchWord is the byte range of a word in a text.
+type searchWord struct{ start, end int }
+
+// searchWords splits s into runs of letters and digits, the way the SQLite
+// full-text tokenizer does.
+func searchWords(s string) []searchWord {
+	var words []searchWord
+	start := -1
+	for i, r := range s {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 {
+			words = append(words, searchWord{start, i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		words = append(words, searchWord{start, len(s)})
+	}
+	return words
+}
+
+func wordTexts(s string, words []searchWord) []string {
+	out := make([]string, len(words))
+	for i, w := range words {
+		out[i] = strings.ToLower(s[w.start:w.end])
+	}
+	return out
+}
+
+// snippetWords is the size of the window of words returned as a snippet.
+const snippetWords = 16
+
+// matchPhrases reports whether every phrase occurs in body and, if so, returns
+// a snippet around the first match with matches wrapped in [ ] and the total
+// number of matches.
+func matchPhrases(body string, phrases [][]string) (string, int, bool) {
+	words := searchWords(body)
+	texts := wordTexts(body, words)
+	matched := make([]int, len(words)) // length of the phrase match starting at each word
+	total := 0
+	for _, phrase := range phrases {
+		found := false
+		for i := 0; i+len(phrase) <= len(texts); i++ {
+			ok := true
+			for k, w := range phrase {
+				if texts[i+k] != w {
+					ok = false
+					break
+				}
+			}
+			if ok {
+				found = true
+				total++
+				if len(phrase) > matched[i] {
+					matched[i] = len(phrase)
+				}
+			}
+		}
+		if !found {
+			return "", 0, false
+		}
+	}
+
+	first := 0
+	for first < len(matched) && matched[first] == 0 {
+		first++
+	}
+	from := first - snippetWords/4
+	if from < 0 {
+		from = 0
+	}
+	to := from + snippetWords
+	if to > len(words) {
+		to = len(words)
+	}
+
+	var sb strings.Builder
+	if from > 0 {
+		sb.WriteString("…")
+	}
+	pos := words[from].start
+	for i := from; i < to; {
+		if matched[i] == 0 {
+			i++
+			continue
+		}
+		end := i + matched[i]
+		if end > to {
+			end = to
+		}
+		sb.WriteString(body[pos:words[i].start])
+		sb.WriteString("[" + body[words[i].start:words[end-1].end] + "]")
+		pos = words[end-1].end
+		i = end
+	}
+	if to < len(words) {
+		sb.WriteString(body[pos:words[to-1].end])
+		sb.WriteString("…")
+	} else {
+		sb.WriteString(body[pos:])
+	}
+	return sb.String(), total, true
+}
+
+// SaveCheckpoint upserts the executor state of an in-flight round.
+func (m *MemoryStore) SaveCheckpoint(sessionID string, roundNum int, userQuery string, state *common.PlanState) error {
+	stateJSON, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("marshal plan state: %w", err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.sessions[sessionID]
+	if !ok {
+		return fmt.Errorf("session %s not found", sessionID)
+	}
+	s.checkpoints[roundNum] = memCheckpoint{
+		Checkpoint: Checkpoint{
+			SessionID: sessionID,
+			RoundNum:  roundNum,
+			UserQuery: userQuery,
+			Status:    CheckpointRunning,
+			UpdatedAt: time.Now().UTC().Format(time.RFC3339),
+		},
+		stateJSON: string(stateJSON),
+	}
+	return nil
+}
+
+// FailCheckpoint marks an in-flight round's checkpoint as failed with the given error.
+func (m *MemoryStore) FailCheckpoint(sessionID string, roundNum int, errMsg string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.sessions[sessionID]
+	if !ok {
+		return nil
+	}
+	if cp, ok := s.checkpoints[roundNum]; ok {
+		cp.Status, cp.Error = CheckpointFailed, errMsg
+		cp.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+		s.checkpoints[roundNum] = cp
+	}
+	return nil
+}
+
+// GetLatestCheckpoint returns the most recent unfinished round of a session, or nil if none.
+func (m *MemoryStore) GetLatestCheckpoint(sessionID string) (*Checkpoint, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.sessions[sessionID]
+	if !ok || len(s.checkpoints) == 0 {
+		return nil, nil
+	}
+	latest := -1
+	for n := range s.checkpoints {
+		if n > latest {
+			latest = n
+		}
+	}
+	stored := s.checkpoints[latest]
+	cp := stored.Checkpoint
+	if err := json.Unmarshal([]byte(stored.stateJSON), &cp.State); err != nil {
+		return nil, fmt.Errorf("unmarshal plan state: %w", err)
+	}
+	return &cp, nil
+}
+
+// AppendEvents appends evs, numbered by the caller, to the session's event log.
+func (m *MemoryStore) AppendEvents(sessionID string, evs []events.Event) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.sessions[sessionID]
+	if !ok {
+		return fmt.Errorf("session %s not found", sessionID)
+	}
+	var last int64
+	if n := len(s.events); n > 0 {
+		last = s.events[n-1].Seq
+	}
+	if err := checkEventSeqs(sessionID, last, evs); err != nil {
+		return err
+	}
+	for _, ev := range evs {
+		ev.SessionID = sessionID
+		ev.Data = []byte(eventData(ev))
+		ev.Timestamp, _ = time.Parse(time.RFC3339Nano, eventTime(ev))
+		s.events = append(s.events, ev)
+	}
+	return nil
+}
+
+// LastEventSeq returns the highest logged sequence number of a session.
+func (m *MemoryStore) LastEventSeq(sessionID string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.sessions[sessionID]
+	if !ok || len(s.events) == 0 {
+		return 0, nil
+	}
+	return s.events[len(s.events)-1].Seq, nil
+}
+
+// GetEvents returns the logged events of a session after afterSeq, in order.
+func (m *MemoryStore) GetEvents(sessionID string, afterSeq int64) ([]events.Event, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.sessions[sessionID]
+	if !ok {
+		return nil, nil
+	}
+	i := sort.Search(len(s.events), func(i int) bool { return s.events[i].Seq > afterSeq })
+	if i == len(s.events) {
+		return nil, nil
+	}
+	return append([]events.Event(nil), s.events[i:]...), nil
+}
+
+// DeleteCheckpoint removes a round's checkpoint once the round has been saved.
+func (m *MemoryStore) DeleteCheckpoint(sessionID string, roundNum int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if s, ok := m.sessions[sessionID]; ok {
+		delete(s.checkpoints, roundNum)
+	}
+	return nil
+}
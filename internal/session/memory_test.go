@@ -0,0 +1,14 @@
+package session_test
+
+import (
+	"testing"
+
+	"pcap_agent/internal/session"
+	"pcap_agent/internal/session/storetest"
+)
+
+func TestMemoryStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) session.Store {
+		return session.NewMemoryStore()
+	})
+}
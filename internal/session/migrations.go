@@ -0,0 +1,315 @@
+package session
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrSchemaTooNew is returned when opening a database that was written by a
+// newer version of the program than this one.
+var ErrSchemaTooNew = errors.New("database schema is newer than this program supports")
+
+// migration is one ordered, transactional schema change. Versions start at 1 and
+// must be contiguous. Never edit a migration once released; append a new one.
+type migration struct {
+	version int
+	name    string
+	up      func(tx *sql.Tx) error
+}
+
+// execSQL returns a migration step that executes ddl.
+func execSQL(ddl string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		_, err := tx.Exec(ddl)
+		return err
+	}
+}
+
+// migrations is the ordered SQLite schema history. Statements are written to be
+// idempotent so that databases created before versioning (version 0) can be
+// brought up to date regardless of which tables they already have.
+var migrations = []migration{
+	{1, "baseline", execSQL(`
+	CREATE TABLE IF NOT EXISTS pcap_files (
+		id          INTEGER PRIMARY KEY AUTOINCREMENT,
+		file_name   TEXT NOT NULL,
+		file_path   TEXT NOT NULL,
+		file_size   INTEGER DEFAULT 0,
+		file_hash   TEXT DEFAULT '',
+		created_at  TEXT NOT NULL DEFAULT (datetime('now'))
+	);
+	CREATE TABLE IF NOT EXISTS sessions (
+		id          TEXT PRIMARY KEY,
+		pcap_path   TEXT NOT NULL,
+		pcap_file_id INTEGER REFERENCES pcap_files(id),
+		created_at  TEXT NOT NULL DEFAULT (datetime('now')),
+		updated_at  TEXT NOT NULL DEFAULT (datetime('now'))
+	);
+	CREATE TABLE IF NOT EXISTS rounds (
+		id          INTEGER PRIMARY KEY AUTOINCREMENT,
+		session_id  TEXT NOT NULL REFERENCES sessions(id),
+		round_num   INTEGER NOT NULL,
+		user_query  TEXT NOT NULL,
+		plan_json   TEXT DEFAULT '',
+		table_schema TEXT DEFAULT '',
+		report      TEXT DEFAULT '',
+		findings    TEXT DEFAULT '',
+		operation_log TEXT DEFAULT '',
+		created_at  TEXT NOT NULL DEFAULT (datetime('now'))
+	);
+	CREATE TABLE IF NOT EXISTS steps (
+		id          INTEGER PRIMARY KEY AUTOINCREMENT,
+		round_id    INTEGER NOT NULL REFERENCES rounds(id),
+		step_id     INTEGER NOT NULL,
+		intent      TEXT NOT NULL,
+		findings    TEXT DEFAULT '',
+		actions     TEXT DEFAULT '',
+		status      TEXT DEFAULT 'pending',
+		created_at  TEXT NOT NULL DEFAULT (datetime('now'))
+	);`)},
+
+	{2, "round checkpoints", execSQL(`
+	CREATE TABLE IF NOT EXISTS checkpoints (
+		session_id  TEXT NOT NULL REFERENCES sessions(id),
+		round_num   INTEGER NOT NULL,
+		user_query  TEXT NOT NULL,
+		state_json  TEXT NOT NULL,
+		status      TEXT NOT NULL DEFAULT 'running',
+		error       TEXT DEFAULT '',
+		updated_at  TEXT NOT NULL DEFAULT (datetime('now')),
+		PRIMARY KEY (session_id, round_num)
+	);`)},
+
+	{3, "step timing and usage", func(tx *sql.Tx) error {
+		for _, c := range []struct{ name, decl string }{
+			{"started_at", "TEXT DEFAULT ''"},
+			{"finished_at", "TEXT DEFAULT ''"},
+			{"tool_calls", "INTEGER DEFAULT 0"},
+			{"prompt_tokens", "INTEGER DEFAULT 0"},
+			{"completion_tokens", "INTEGER DEFAULT 0"},
+			{"total_tokens", "INTEGER DEFAULT 0"},
+		} {
+			if err := addColumnIfMissing(tx, "steps", c.name, c.decl); err != nil {
+				return err
+			}
+		}
+		return nil
+	}},
+
+	{4, "iocs", execSQL(`
+	CREATE TABLE IF NOT EXISTS iocs (
+		id          INTEGER PRIMARY KEY AUTOINCREMENT,
+		round_id    INTEGER NOT NULL REFERENCES rounds(id),
+		type        TEXT NOT NULL,
+		value       TEXT NOT NULL,
+		context     TEXT DEFAULT '',
+		step_ids    TEXT DEFAULT '[]',
+		created_at  TEXT NOT NULL DEFAULT (datetime('now')),
+		UNIQUE (round_id, type, value)
+	);
+	CREATE INDEX IF NOT EXISTS idx_iocs_type_value ON iocs(type, value);`)},
+
+	{5, "unique pcap hashes", execSQL(`
+	CREATE UNIQUE INDEX IF NOT EXISTS idx_pcap_files_hash ON pcap_files(file_hash) WHERE file_hash != '';`)},
+
+	{6, "full-text search", execSQL(`
+	CREATE VIRTUAL TABLE IF NOT EXISTS search_index USING fts5(
+		body,
+		field UNINDEXED,
+		round_id UNINDEXED,
+		step_id UNINDEXED
+	);
+	INSERT INTO search_index (body, field, round_id, step_id)
+		SELECT user_query, 'query', id, 0 FROM rounds WHERE user_query != '';
+	INSERT INTO search_index (body, field, round_id, step_id)
+		SELECT findings, 'findings', id, 0 FROM rounds WHERE findings != '';
+	INSERT INTO search_index (body, field, round_id, step_id)
+		SELECT report, 'report', id, 0 FROM rounds WHERE report != '';
+	INSERT INTO search_index (body, field, round_id, step_id)
+		SELECT findings, 'step', round_id, step_id FROM steps WHERE findings != '';`)},
+
+	{7, "round digests", func(tx *sql.Tx) error {
+		return addColumnIfMissing(tx, "rounds", "digest", "TEXT DEFAULT ''")
+	}},
+
+	{8, "multiple captures per session", execSQL(`
+	CREATE TABLE IF NOT EXISTS session_pcaps (
+		session_id     TEXT NOT NULL REFERENCES sessions(id),
+		pcap_file_id   INTEGER REFERENCES pcap_files(id),
+		container_path TEXT NOT NULL,
+		position       INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (session_id, container_path)
+	);
+	CREATE INDEX IF NOT EXISTS idx_session_pcaps_file ON session_pcaps(pcap_file_id);
+	INSERT OR IGNORE INTO session_pcaps (session_id, pcap_file_id, container_path, position)
+		SELECT id, pcap_file_id, pcap_path, 0 FROM sessions WHERE pcap_path != '';`)},
+
+	{9, "session forks", func(tx *sql.Tx) error {
+		if err := addColumnIfMissing(tx, "sessions", "parent_session_id", "TEXT DEFAULT NULL"); err != nil {
+			return err
+		}
+		if err := addColumnIfMissing(tx, "sessions", "parent_round", "INTEGER DEFAULT 0"); err != nil {
+			return err
+		}
+		_, err := tx.Exec("CREATE INDEX IF NOT EXISTS idx_sessions_parent ON sessions(parent_session_id)")
+		return err
+	}},
+
+	// Sessions that concurrent writers left with duplicate round numbers are
+	// renumbered in insertion order before the numbers are made unique. Their
+	// checkpoints move past the last renumbered round, keeping their order;
+	// they pass through negative numbers so no row collides mid-update.
+	{10, "unique round numbers", execSQL(`
+	CREATE TEMP TABLE round_renumber AS
+		SELECT r.id, (
+			SELECT COUNT(*) FROM rounds r2
+			WHERE r2.session_id = r.session_id
+				AND (r2.round_num < r.round_num OR (r2.round_num = r.round_num AND r2.id <= r.id))
+		) AS round_num
+		FROM rounds r
+		WHERE r.session_id IN (SELECT session_id FROM rounds GROUP BY session_id, round_num HAVING COUNT(*) > 1);
+	UPDATE rounds SET round_num = (SELECT n.round_num FROM round_renumber n WHERE n.id = rounds.id)
+		WHERE id IN (SELECT id FROM round_renumber);
+	CREATE TEMP TABLE checkpoint_renumber AS
+		SELECT c.session_id, c.round_num AS old_num,
+			(SELECT COUNT(*) FROM rounds r WHERE r.session_id = c.session_id) + (
+				SELECT COUNT(*) FROM checkpoints c2
+				WHERE c2.session_id = c.session_id AND c2.round_num <= c.round_num
+			) AS round_num
+		FROM checkpoints c
+		WHERE c.session_id IN (SELECT r.session_id FROM rounds r JOIN round_renumber n ON n.id = r.id);
+	UPDATE checkpoints SET round_num = -(
+			SELECT n.round_num FROM checkpoint_renumber n
+			WHERE n.session_id = checkpoints.session_id AND n.old_num = checkpoints.round_num)
+		WHERE session_id IN (SELECT session_id FROM checkpoint_renumber);
+	UPDATE checkpoints SET round_num = -round_num WHERE round_num < 0;
+	DROP TABLE checkpoint_renumber;
+	DROP TABLE round_renumber;
+	CREATE UNIQUE INDEX IF NOT EXISTS idx_rounds_session_num ON rounds(session_id, round_num);`)},
+
+	{11, "event log", execSQL(`
+	CREATE TABLE IF NOT EXISTS events (
+		id          INTEGER PRIMARY KEY AUTOINCREMENT,
+		session_id  TEXT NOT NULL REFERENCES sessions(id),
+		seq         INTEGER NOT NULL,
+		type        TEXT NOT NULL,
+		data        TEXT NOT NULL DEFAULT 'null',
+		created_at  TEXT NOT NULL,
+		UNIQUE(session_id, seq)
+	);`)},
+
+	{12, "event correlation", func(tx *sql.Tx) error {
+		for _, c := range []struct{ name, decl string }{
+			{"round_num", "INTEGER DEFAULT 0"},
+			{"run_id", "TEXT DEFAULT ''"},
+			{"step_id", "INTEGER DEFAULT 0"},
+		} {
+			if err := addColumnIfMissing(tx, "events", c.name, c.decl); err != nil {
+				return err
+			}
+		}
+		return nil
+	}},
+}
+
+// migrate brings the database up to the latest schema version.
+func (s *SQLiteStore) migrate() error {
+	return runMigrations(s.db, migrations,
+		"INSERT INTO schema_version (version, name, applied_at) VALUES (?, ?, ?)")
+}
+
+// SchemaVersion returns the highest applied migration version (0 for an unversioned database).
+func (s *SQLiteStore) SchemaVersion() (int, error) {
+	return schemaVersion(s.db)
+}
+
+// runMigrations applies the pending entries of ms, which must be ordered. Each
+// migration runs in its own transaction together with its schema_version row
+// (written with insertVersion), so a failure leaves the database at the last
+// fully applied version. A database newer than ms is refused.
+func runMigrations(db *sql.DB, ms []migration, insertVersion string) error {
+	if _, err := db.Exec(`
+	CREATE TABLE IF NOT EXISTS schema_version (
+		version     INTEGER PRIMARY KEY,
+		name        TEXT NOT NULL,
+		applied_at  TEXT NOT NULL
+	);`); err != nil {
+		return fmt.Errorf("create schema_version: %w", err)
+	}
+
+	current, err := schemaVersion(db)
+	if err != nil {
+		return err
+	}
+	if latest := ms[len(ms)-1].version; current > latest {
+		return fmt.Errorf("%w (database version %d, supported up to %d)", ErrSchemaTooNew, current, latest)
+	}
+
+	for _, m := range ms {
+		if m.version <= current {
+			continue
+		}
+		if err := applyMigration(db, m, insertVersion); err != nil {
+			return fmt.Errorf("migration %d (%s): %w", m.version, m.name, err)
+		}
+	}
+	return nil
+}
+
+func applyMigration(db *sql.DB, m migration, insertVersion string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := m.up(tx); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(insertVersion, m.version, m.name, time.Now().UTC().Format(time.RFC3339)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func schemaVersion(db *sql.DB) (int, error) {
+	var v int
+	err := db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_version").Scan(&v)
+	return v, err
+}
+
+// addColumnIfMissing adds column to table unless it already exists.
+func addColumnIfMissing(tx *sql.Tx, table, column, decl string) error {
+	rows, err := tx.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+	found := false
+	for rows.Next() {
+		var (
+			cid, notNull, pk int
+			name, typ        string
+			dflt             sql.NullString
+		)
+		if err := rows.Scan(&cid, &name, &typ, &notNull, &dflt, &pk); err != nil {
+			rows.Close()
+			return err
+		}
+		if name == column {
+			found = true
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if found {
+		return nil
+	}
+	if _, err := tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, decl)); err != nil {
+		return fmt.Errorf("add column %s.%s: %w", table, column, err)
+	}
+	return nil
+}
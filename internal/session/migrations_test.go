@@ -0,0 +1,209 @@
+package session
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"pcap_agent/internal/common"
+)
+
+func TestMigrationVersions(t *testing.T) {
+	for name, ms := range map[string][]migration{"sqlite": migrations, "postgres": pgMigrations} {
+		for i, m := range ms {
+			if m.version != i+1 || m.name == "" || m.up == nil {
+				t.Errorf("%s migration %d = {%d %q}; versions must be contiguous from 1", name, i, m.version, m.name)
+			}
+		}
+	}
+}
+
+func latestVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+func TestMigrateFresh(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.db")
+	for range 2 { // reopening an up-to-date database applies nothing
+		s, err := OpenSQLite(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v, err := s.SchemaVersion(); err != nil || v != latestVersion() {
+			t.Errorf("SchemaVersion() = %d, %v; want %d", v, err, latestVersion())
+		}
+		var n int
+		if err := s.db.QueryRow("SELECT COUNT(*) FROM schema_version").Scan(&n); err != nil || n != latestVersion() {
+			t.Errorf("schema_version has %d rows, %v", n, err)
+		}
+		s.Close()
+	}
+}
+
+// TestMigrateUnversioned upgrades a database created before schema versioning,
+// keeping its data.
+func TestMigrateUnversioned(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.db")
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := migrations[0].up(tx); err != nil {
+		t.Fatal(err)
+	}
+	for _, stmt := range []string{
+		`INSERT INTO sessions (id, pcap_path) VALUES ('old', '/data/old.pcap')`,
+		`INSERT INTO rounds (session_id, round_num, user_query, report, findings) VALUES ('old', 1, 'who beaconed', 'host 10.0.0.5 beaconed', 'f')`,
+		// Duplicate round numbers left by concurrent writers are renumbered.
+		`INSERT INTO rounds (session_id, round_num, user_query) VALUES ('old', 1, 'second')`,
+		`INSERT INTO steps (round_id, step_id, intent, findings) VALUES (1, 1, 'dns', 'evil.example resolved')`,
+	} {
+		if _, err := tx.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	s, err := OpenSQLite(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if v, _ := s.SchemaVersion(); v != latestVersion() {
+		t.Errorf("SchemaVersion() = %d; want %d", v, latestVersion())
+	}
+	pcaps, err := s.GetSessionPcaps("old")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pcaps) != 1 || pcaps[0].ContainerPath != "/data/old.pcap" {
+		t.Errorf("pcaps = %+v", pcaps)
+	}
+	rounds, err := s.GetRounds("old")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rounds) != 2 || rounds[0].RoundNum != 1 || rounds[1].RoundNum != 2 {
+		t.Errorf("rounds = %+v", rounds)
+	}
+	// Existing rows are backfilled into the search index.
+	if hits, err := s.Search("evil.example", 0); err != nil || len(hits) != 1 {
+		t.Errorf("Search = %+v, %v", hits, err)
+	}
+	// The upgraded database accepts new writes.
+	if _, err := s.SaveRound("old", 3, "q", common.Plan{}, "r", "f", ""); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestMigrateRenumbersCheckpoints checks that renumbering duplicate rounds
+// moves the session's checkpoints past its last round.
+func TestMigrateRenumbersCheckpoints(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.db")
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const insert = "INSERT INTO schema_version (version, name, applied_at) VALUES (?, ?, ?)"
+	if err := runMigrations(db, migrations[:9], insert); err != nil {
+		t.Fatal(err)
+	}
+	for _, stmt := range []string{
+		`INSERT INTO sessions (id, pcap_path) VALUES ('dup', ''), ('ok', '')`,
+		`INSERT INTO rounds (session_id, round_num, user_query) VALUES ('dup', 1, 'a'), ('dup', 1, 'b'), ('dup', 2, 'c'), ('ok', 1, 'd')`,
+		`INSERT INTO checkpoints (session_id, round_num, user_query, state_json) VALUES
+			('dup', 2, 'stale', '{}'), ('dup', 3, 'running', '{}'), ('ok', 2, 'running', '{}')`,
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.Close()
+
+	s, err := OpenSQLite(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	rows, err := s.db.Query("SELECT session_id, round_num, user_query FROM checkpoints ORDER BY session_id, round_num")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var got []string
+	for rows.Next() {
+		var id, query string
+		var n int
+		if err := rows.Scan(&id, &n, &query); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, fmt.Sprintf("%s/%d/%s", id, n, query))
+	}
+	want := []string{"dup/4/stale", "dup/5/running", "ok/2/running"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("checkpoints = %q; want %q", got, want)
+	}
+}
+
+func TestMigrateRefusesNewerSchema(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.db")
+	s, err := OpenSQLite(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.db.Exec("INSERT INTO schema_version VALUES (?, 'future', '')", latestVersion()+1); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	if _, err := OpenSQLite(path); !errors.Is(err, ErrSchemaTooNew) {
+		t.Errorf("OpenSQLite = %v; want ErrSchemaTooNew", err)
+	}
+}
+
+func TestMigrationFailureRollsBack(t *testing.T) {
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "m.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	const insert = "INSERT INTO schema_version (version, name, applied_at) VALUES (?, ?, ?)"
+	ms := []migration{
+		{1, "a", execSQL("CREATE TABLE a (x INTEGER)")},
+		{2, "b", func(tx *sql.Tx) error {
+			if _, err := tx.Exec("CREATE TABLE b (x INTEGER)"); err != nil {
+				return err
+			}
+			return errors.New("boom")
+		}},
+	}
+	err = runMigrations(db, ms, insert)
+	if err == nil || !strings.Contains(err.Error(), "migration 2 (b): boom") {
+		t.Fatalf("runMigrations = %v", err)
+	}
+	if v, _ := schemaVersion(db); v != 1 {
+		t.Errorf("version = %d; want 1", v)
+	}
+	if _, err := db.Exec("SELECT * FROM b"); err == nil {
+		t.Error("failed migration was not rolled back")
+	}
+
+	// Fixing the migration resumes from the last applied version.
+	ms[1].up = execSQL("CREATE TABLE b (x INTEGER)")
+	if err := runMigrations(db, ms, insert); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := schemaVersion(db); v != 2 {
+		t.Errorf("version = %d; want 2", v)
+	}
+}
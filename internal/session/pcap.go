@@ -0,0 +1,54 @@
+package session
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// HashFile returns the hex SHA-256 and size of the file at path.
+func HashFile(path string) (string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return "", 0, fmt.Errorf("hash %s: %w", path, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
+
+// RegisterPcapFile hashes the capture at localPath and registers it in store.
+// If a file with the same SHA-256 is already registered, that row is returned with
+// duplicate set to true and nothing is inserted.
+func RegisterPcapFile(store Store, localPath string) (file *PcapFile, duplicate bool, err error) {
+	hash, size, err := HashFile(localPath)
+	if err != nil {
+		return nil, false, err
+	}
+	existing, err := store.GetPcapFileByHash(hash)
+	if err != nil {
+		return nil, false, fmt.Errorf("look up pcap by hash: %w", err)
+	}
+	if existing != nil {
+		return existing, true, nil
+	}
+
+	absPath, err := filepath.Abs(localPath)
+	if err != nil {
+		absPath = localPath
+	}
+	id, err := store.SavePcapFile(filepath.Base(localPath), absPath, size, hash)
+	if err != nil {
+		return nil, false, fmt.Errorf("save pcap file: %w", err)
+	}
+	file = &PcapFile{ID: id, FileName: filepath.Base(localPath), FilePath: absPath, FileSize: size, FileHash: hash}
+	return file, false, nil
+}
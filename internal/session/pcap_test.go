@@ -0,0 +1,40 @@
+package session
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRegisterPcapFile(t *testing.T) {
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a.pcap"), filepath.Join(dir, "copy of a.pcap")
+	for _, p := range []string{a, b} {
+		if err := os.WriteFile(p, []byte("abc"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	store := NewMemoryStore()
+
+	f, dup, err := RegisterPcapFile(store, a)
+	if err != nil || dup {
+		t.Fatalf("RegisterPcapFile(a) = %+v, %v, %v", f, dup, err)
+	}
+	// SHA-256 of "abc".
+	if f.FileHash != "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad" || f.FileSize != 3 ||
+		f.FileName != "a.pcap" || f.FilePath != a {
+		t.Errorf("registered %+v", f)
+	}
+
+	// The same bytes under another name are the same capture.
+	g, dup, err := RegisterPcapFile(store, b)
+	if err != nil || !dup || g.ID != f.ID || g.FileName != "a.pcap" {
+		t.Errorf("RegisterPcapFile(copy) = %+v, %v, %v; want the first registration", g, dup, err)
+	}
+	if files, _ := store.ListPcapFiles(); len(files) != 1 {
+		t.Errorf("registered %d files; want 1", len(files))
+	}
+	if _, _, err := RegisterPcapFile(store, filepath.Join(dir, "missing.pcap")); err == nil {
+		t.Error("RegisterPcapFile of a missing file succeeded")
+	}
+}
@@ -0,0 +1,1007 @@
+package session
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"pcap_agent/internal/common"
+	"pcap_agent/internal/events"
+
+	_ "github.com/lib/pq"
+)
+
+// PostgresStore is the Store backed by a shared PostgreSQL database (version 12
+// or later). Timestamps are kept as text in the same formats as SQLiteStore, so
+// listings and exports look the same whichever backend wrote them.
+type PostgresStore struct {
+	db *sql.DB
+}
+
+var _ Store = (*PostgresStore)(nil)
+
+// pgNow is the column default for creation timestamps, matching SQLite's datetime('now').
+const pgNow = `to_char(now() AT TIME ZONE 'UTC', 'YYYY-MM-DD HH24:MI:SS')`
+
+// pgMigrations is the ordered PostgreSQL schema history. It starts from the
+// current SQLite schema; later changes are appended here as well.
+var pgMigrations = []migration{
+	{1, "baseline", execSQL(strings.ReplaceAll(`
+	CREATE TABLE IF NOT EXISTS pcap_files (
+		id          BIGSERIAL PRIMARY KEY,
+		file_name   TEXT NOT NULL,
+		file_path   TEXT NOT NULL,
+		file_size   BIGINT DEFAULT 0,
+		file_hash   TEXT DEFAULT '',
+		created_at  TEXT NOT NULL DEFAULT {now}
+	);
+	CREATE UNIQUE INDEX IF NOT EXISTS idx_pcap_files_hash ON pcap_files(file_hash) WHERE file_hash != '';
+	CREATE TABLE IF NOT EXISTS sessions (
+		id                TEXT PRIMARY KEY,
+		pcap_path         TEXT NOT NULL,
+		pcap_file_id      BIGINT REFERENCES pcap_files(id),
+		created_at        TEXT NOT NULL DEFAULT {now},
+		updated_at        TEXT NOT NULL DEFAULT {now},
+		parent_session_id TEXT DEFAULT NULL,
+		parent_round      INTEGER DEFAULT 0
+	);
+	CREATE INDEX IF NOT EXISTS idx_sessions_parent ON sessions(parent_session_id);
+	CREATE TABLE IF NOT EXISTS session_pcaps (
+		session_id     TEXT NOT NULL REFERENCES sessions(id),
+		pcap_file_id   BIGINT REFERENCES pcap_files(id),
+		container_path TEXT NOT NULL,
+		position       INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (session_id, container_path)
+	);
+	CREATE INDEX IF NOT EXISTS idx_session_pcaps_file ON session_pcaps(pcap_file_id);
+	CREATE TABLE IF NOT EXISTS rounds (
+		id            BIGSERIAL PRIMARY KEY,
+		session_id    TEXT NOT NULL REFERENCES sessions(id),
+		round_num     INTEGER NOT NULL,
+		user_query    TEXT NOT NULL,
+		plan_json     TEXT DEFAULT '',
+		table_schema  TEXT DEFAULT '',
+		report        TEXT DEFAULT '',
+		findings      TEXT DEFAULT '',
+		operation_log TEXT DEFAULT '',
+		digest        TEXT DEFAULT '',
+		created_at    TEXT NOT NULL DEFAULT {now}
+	);
+	CREATE INDEX IF NOT EXISTS idx_rounds_session ON rounds(session_id, round_num);
+	CREATE TABLE IF NOT EXISTS steps (
+		id                BIGSERIAL PRIMARY KEY,
+		round_id          BIGINT NOT NULL REFERENCES rounds(id),
+		step_id           INTEGER NOT NULL,
+		intent            TEXT NOT NULL,
+		findings          TEXT DEFAULT '',
+		actions           TEXT DEFAULT '',
+		status            TEXT DEFAULT 'pending',
+		started_at        TEXT DEFAULT '',
+		finished_at       TEXT DEFAULT '',
+		tool_calls        INTEGER DEFAULT 0,
+		prompt_tokens     INTEGER DEFAULT 0,
+		completion_tokens INTEGER DEFAULT 0,
+		total_tokens      INTEGER DEFAULT 0,
+		created_at        TEXT NOT NULL DEFAULT {now}
+	);
+	CREATE INDEX IF NOT EXISTS idx_steps_round ON steps(round_id);
+	CREATE TABLE IF NOT EXISTS iocs (
+		id          BIGSERIAL PRIMARY KEY,
+		round_id    BIGINT NOT NULL REFERENCES rounds(id),
+		type        TEXT NOT NULL,
+		value       TEXT NOT NULL,
+		context     TEXT DEFAULT '',
+		step_ids    TEXT DEFAULT '[]',
+		created_at  TEXT NOT NULL DEFAULT {now},
+		UNIQUE (round_id, type, value)
+	);
+	CREATE INDEX IF NOT EXISTS idx_iocs_type_value ON iocs(type, value);
+	CREATE TABLE IF NOT EXISTS checkpoints (
+		session_id  TEXT NOT NULL REFERENCES sessions(id),
+		round_num   INTEGER NOT NULL,
+		user_query  TEXT NOT NULL,
+		state_json  TEXT NOT NULL,
+		status      TEXT NOT NULL DEFAULT 'running',
+		error       TEXT DEFAULT '',
+		updated_at  TEXT NOT NULL DEFAULT {now},
+		PRIMARY KEY (session_id, round_num)
+	);
+	CREATE TABLE IF NOT EXISTS search_index (
+		id          BIGSERIAL PRIMARY KEY,
+		round_id    BIGINT NOT NULL REFERENCES rounds(id),
+		step_id     INTEGER NOT NULL DEFAULT 0,
+		field       TEXT NOT NULL,
+		body        TEXT NOT NULL,
+		tsv         TSVECTOR GENERATED ALWAYS AS (to_tsvector('simple', body)) STORED
+	);
+	CREATE INDEX IF NOT EXISTS idx_search_index_tsv ON search_index USING GIN (tsv);
+	CREATE INDEX IF NOT EXISTS idx_search_index_round ON search_index(round_id);`, "{now}", "("+pgNow+")"))},
+
+	{2, "unique round numbers", execSQL(`
+	UPDATE checkpoints SET round_num = -n.round_num
+	FROM (
+		SELECT session_id, round_num AS old_num,
+			(SELECT COUNT(*) FROM rounds r WHERE r.session_id = c.session_id)
+				+ ROW_NUMBER() OVER (PARTITION BY session_id ORDER BY round_num) AS round_num
+		FROM checkpoints c
+		WHERE session_id IN (SELECT session_id FROM rounds GROUP BY session_id, round_num HAVING COUNT(*) > 1)
+	) n
+	WHERE checkpoints.session_id = n.session_id AND checkpoints.round_num = n.old_num;
+	UPDATE checkpoints SET round_num = -round_num WHERE round_num < 0;
+	UPDATE rounds SET round_num = n.round_num
+	FROM (
+		SELECT id, ROW_NUMBER() OVER (PARTITION BY session_id ORDER BY round_num, id) AS round_num
+		FROM rounds
+		WHERE session_id IN (SELECT session_id FROM rounds GROUP BY session_id, round_num HAVING COUNT(*) > 1)
+	) n
+	WHERE rounds.id = n.id;
+	CREATE UNIQUE INDEX IF NOT EXISTS idx_rounds_session_num ON rounds(session_id, round_num);`)},
+
+	{3, "event log", execSQL(`
+	CREATE TABLE IF NOT EXISTS events (
+		id          BIGSERIAL PRIMARY KEY,
+		session_id  TEXT NOT NULL REFERENCES sessions(id),
+		seq         BIGINT NOT NULL,
+		type        TEXT NOT NULL,
+		data        TEXT NOT NULL DEFAULT 'null',
+		created_at  TEXT NOT NULL,
+		UNIQUE(session_id, seq)
+	);`)},
+
+	{4, "event correlation", execSQL(`
+	ALTER TABLE events ADD COLUMN IF NOT EXISTS round_num INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE events ADD COLUMN IF NOT EXISTS run_id TEXT NOT NULL DEFAULT '';
+	ALTER TABLE events ADD COLUMN IF NOT EXISTS step_id INTEGER NOT NULL DEFAULT 0;`)},
+}
+
+// pgExecer is satisfied by both *sql.DB and *sql.Tx.
+type pgExecer interface {
+	execer
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// OpenPostgres connects to the PostgreSQL database at dsn (a postgres:// URL)
+// and runs migrations.
+func OpenPostgres(dsn string) (*PostgresStore, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("open postgres: %w", err)
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("connect postgres: %w", err)
+	}
+	s := &PostgresStore{db: db}
+	if err := s.migrate(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("migrate: %w", err)
+	}
+	return s, nil
+}
+
+// pgMigrationLock is the advisory lock key ("pcapagnt") held while migrating.
+const pgMigrationLock int64 = 0x7063617061676e74
+
+// migrate brings the database up to the latest schema version. Processes that
+// start together against a shared database take turns through an advisory lock,
+// so only the first applies the pending migrations and the others find the
+// schema current.
+func (s *PostgresStore) migrate() error {
+	ctx := context.Background()
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", pgMigrationLock); err != nil {
+		return fmt.Errorf("acquire migration lock: %w", err)
+	}
+	defer conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", pgMigrationLock)
+
+	return runMigrations(s.db, pgMigrations,
+		"INSERT INTO schema_version (version, name, applied_at) VALUES ($1, $2, $3)")
+}
+
+// Close closes the database connection.
+func (s *PostgresStore) Close() error {
+	return s.db.Close()
+}
+
+// SchemaVersion returns the highest applied migration version.
+func (s *PostgresStore) SchemaVersion() (int, error) {
+	return schemaVersion(s.db)
+}
+
+// SavePcapFile records a PCAP file for future reference.
+func (s *PostgresStore) SavePcapFile(fileName, filePath string, fileSize int64, fileHash string) (int64, error) {
+	var id int64
+	err := s.db.QueryRow(
+		"INSERT INTO pcap_files (file_name, file_path, file_size, file_hash) VALUES ($1, $2, $3, $4) RETURNING id",
+		fileName, filePath, fileSize, fileHash,
+	).Scan(&id)
+	return id, err
+}
+
+// GetPcapFileByHash returns the registered file with the given SHA-256, or nil if none.
+func (s *PostgresStore) GetPcapFileByHash(hash string) (*PcapFile, error) {
+	var f PcapFile
+	err := s.db.QueryRow(
+		"SELECT id, file_name, file_path, file_size, file_hash, created_at FROM pcap_files WHERE file_hash = $1",
+		hash,
+	).Scan(&f.ID, &f.FileName, &f.FilePath, &f.FileSize, &f.FileHash, &f.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+// ListPcapFiles returns all registered captures, newest first, each with the
+// IDs of the sessions linked to it (oldest first).
+func (s *PostgresStore) ListPcapFiles() ([]PcapFileUsage, error) {
+	rows, err := s.db.Query(
+		`SELECT f.id, f.file_name, f.file_path, f.file_size, f.file_hash, f.created_at, COALESCE(s.id, '')
+		 FROM pcap_files f
+		 LEFT JOIN session_pcaps sp ON sp.pcap_file_id = f.id
+		 LEFT JOIN sessions s ON s.id = sp.session_id
+		 ORDER BY f.created_at DESC, f.id DESC, s.created_at ASC, s.id ASC`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []PcapFileUsage
+	for rows.Next() {
+		var f PcapFile
+		var sessionID string
+		if err := rows.Scan(&f.ID, &f.FileName, &f.FilePath, &f.FileSize, &f.FileHash, &f.CreatedAt, &sessionID); err != nil {
+			return nil, err
+		}
+		if n := len(out); n == 0 || out[n-1].ID != f.ID {
+			out = append(out, PcapFileUsage{PcapFile: f, SessionIDs: []string{}})
+		}
+		if sessionID != "" {
+			last := &out[len(out)-1]
+			last.SessionIDs = append(last.SessionIDs, sessionID)
+		}
+	}
+	return out, rows.Err()
+}
+
+// GetPcapFileSessions returns the IDs of the sessions linked to a registered capture, oldest first.
+func (s *PostgresStore) GetPcapFileSessions(fileID int64) ([]string, error) {
+	rows, err := s.db.Query(
+		`SELECT s.id FROM sessions s JOIN session_pcaps sp ON sp.session_id = s.id
+		 WHERE sp.pcap_file_id = $1 ORDER BY s.created_at ASC, s.id ASC`,
+		fileID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// CreateSession creates a new session record with its captures, in order.
+func (s *PostgresStore) CreateSession(id string, pcaps []SessionPcap) error {
+	if len(pcaps) == 0 {
+		return fmt.Errorf("session %s has no captures", id)
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(
+		"INSERT INTO sessions (id, pcap_path, pcap_file_id) VALUES ($1, $2, $3)",
+		id, pcaps[0].ContainerPath, nullableID(pcaps[0].PcapFileID),
+	); err != nil {
+		return err
+	}
+	for _, p := range pcaps {
+		if err := pgAddSessionPcap(tx, id, p); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// AddSessionPcap attaches another capture to a session. Adding a container path
+// the session already has is a no-op.
+func (s *PostgresStore) AddSessionPcap(sessionID string, p SessionPcap) error {
+	return pgAddSessionPcap(s.db, sessionID, p)
+}
+
+func pgAddSessionPcap(db execer, sessionID string, p SessionPcap) error {
+	_, err := db.Exec(
+		`INSERT INTO session_pcaps (session_id, pcap_file_id, container_path, position)
+		 VALUES ($1, $2, $3, (SELECT COALESCE(MAX(position) + 1, 0) FROM session_pcaps WHERE session_id = $1))
+		 ON CONFLICT (session_id, container_path) DO NOTHING`,
+		sessionID, nullableID(p.PcapFileID), p.ContainerPath,
+	)
+	if err != nil {
+		return fmt.Errorf("add capture %s: %w", p.ContainerPath, err)
+	}
+	return nil
+}
+
+// GetSessionPcaps returns the captures of a session in the order they were added.
+func (s *PostgresStore) GetSessionPcaps(sessionID string) ([]SessionPcap, error) {
+	rows, err := s.db.Query(
+		`SELECT COALESCE(sp.pcap_file_id, 0), sp.container_path,
+			COALESCE(f.file_name, ''), COALESCE(f.file_size, 0), COALESCE(f.file_hash, '')
+		 FROM session_pcaps sp LEFT JOIN pcap_files f ON f.id = sp.pcap_file_id
+		 WHERE sp.session_id = $1
+		 ORDER BY sp.position ASC`,
+		sessionID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []SessionPcap
+	for rows.Next() {
+		var p SessionPcap
+		if err := rows.Scan(&p.PcapFileID, &p.ContainerPath, &p.FileName, &p.FileSize, &p.FileHash); err != nil {
+			return nil, err
+		}
+		if p.FileName == "" {
+			p.FileName = path.Base(p.ContainerPath)
+		}
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
+
+// TouchSession updates the session's updated_at timestamp.
+func (s *PostgresStore) TouchSession(id string) error {
+	_, err := s.db.Exec(
+		"UPDATE sessions SET updated_at = $1 WHERE id = $2",
+		time.Now().UTC().Format(time.RFC3339), id,
+	)
+	return err
+}
+
+// SessionExists checks if a session with the given ID exists.
+func (s *PostgresStore) SessionExists(id string) (bool, error) {
+	var count int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM sessions WHERE id = $1", id).Scan(&count)
+	return count > 0, err
+}
+
+// GetSession returns a session's summary, or nil if it does not exist.
+func (s *PostgresStore) GetSession(id string) (*SessionInfo, error) {
+	info, err := scanSessionInfo(s.db.QueryRow(sessionInfoQuery+" WHERE s.id = $1", id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return info, err
+}
+
+// ListSessions returns all sessions, most recently updated first.
+func (s *PostgresStore) ListSessions() ([]SessionInfo, error) {
+	return s.querySessionInfos(sessionInfoQuery + " ORDER BY s.updated_at DESC, s.created_at DESC, s.id DESC")
+}
+
+// GetSessionForks returns the sessions forked directly from id, oldest first.
+func (s *PostgresStore) GetSessionForks(id string) ([]SessionInfo, error) {
+	return s.querySessionInfos(sessionInfoQuery+" WHERE s.parent_session_id = $1 ORDER BY s.created_at ASC, s.id ASC", id)
+}
+
+func (s *PostgresStore) querySessionInfos(query string, args ...any) ([]SessionInfo, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []SessionInfo
+	for rows.Next() {
+		info, err := scanSessionInfo(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, *info)
+	}
+	return out, rows.Err()
+}
+
+// ForkSession creates session newID as a branch of srcID that inherits rounds
+// 1..roundNum and the same captures. Checkpoints are not inherited.
+func (s *PostgresStore) ForkSession(srcID string, roundNum int, newID string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var rounds int
+	err = tx.QueryRow("SELECT (SELECT COUNT(*) FROM rounds WHERE session_id = $1) FROM sessions WHERE id = $1", srcID).Scan(&rounds)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("session %s not found", srcID)
+	}
+	if err != nil {
+		return err
+	}
+	if roundNum < 0 || roundNum > rounds {
+		return fmt.Errorf("session %s has %d rounds, cannot fork at round %d", srcID, rounds, roundNum)
+	}
+
+	if _, err := tx.Exec(
+		`INSERT INTO sessions (id, pcap_path, pcap_file_id, parent_session_id, parent_round)
+		 SELECT $1, pcap_path, pcap_file_id, id, $2 FROM sessions WHERE id = $3`,
+		newID, roundNum, srcID,
+	); err != nil {
+		return fmt.Errorf("create fork: %w", err)
+	}
+	if _, err := tx.Exec(
+		`INSERT INTO session_pcaps (session_id, pcap_file_id, container_path, position)
+		 SELECT $1, pcap_file_id, container_path, position FROM session_pcaps WHERE session_id = $2`,
+		newID, srcID,
+	); err != nil {
+		return fmt.Errorf("copy captures: %w", err)
+	}
+
+	rows, err := tx.Query("SELECT id FROM rounds WHERE session_id = $1 AND round_num <= $2 ORDER BY round_num ASC", srcID, roundNum)
+	if err != nil {
+		return err
+	}
+	var roundIDs []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		roundIDs = append(roundIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, oldID := range roundIDs {
+		if err := pgCopyRound(tx, oldID, newID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// pgCopyRound copies round oldID, with its steps, IOCs and search entries, into session sessionID.
+func pgCopyRound(tx *sql.Tx, oldID int64, sessionID string) error {
+	var newID int64
+	err := tx.QueryRow(
+		`INSERT INTO rounds (session_id, round_num, user_query, plan_json, table_schema, report, findings, operation_log, digest, created_at)
+		 SELECT $1, round_num, user_query, plan_json, table_schema, report, findings, operation_log, digest, created_at
+		 FROM rounds WHERE id = $2
+		 RETURNING id`,
+		sessionID, oldID,
+	).Scan(&newID)
+	if err != nil {
+		return fmt.Errorf("copy round: %w", err)
+	}
+
+	stmts := []string{
+		`INSERT INTO steps (round_id, step_id, intent, findings, actions, status, created_at,
+			started_at, finished_at, tool_calls, prompt_tokens, completion_tokens, total_tokens)
+		 SELECT $1, step_id, intent, findings, actions, status, created_at,
+			started_at, finished_at, tool_calls, prompt_tokens, completion_tokens, total_tokens
+		 FROM steps WHERE round_id = $2 ORDER BY id`,
+		`INSERT INTO iocs (round_id, type, value, context, step_ids, created_at)
+		 SELECT $1, type, value, context, step_ids, created_at FROM iocs WHERE round_id = $2 ORDER BY id`,
+		`INSERT INTO search_index (body, field, round_id, step_id)
+		 SELECT body, field, $1, step_id FROM search_index WHERE round_id = $2 ORDER BY id`,
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt, newID, oldID); err != nil {
+			return fmt.Errorf("copy round %d: %w", oldID, err)
+		}
+	}
+	return nil
+}
+
+// RestoreSessionMetadata sets the timestamps and fork linkage of a session
+// recreated from an export. Empty timestamps are left unchanged.
+func (s *PostgresStore) RestoreSessionMetadata(info SessionInfo) error {
+	_, err := s.db.Exec(
+		`UPDATE sessions SET
+			created_at = COALESCE(NULLIF($1, ''), created_at),
+			updated_at = COALESCE(NULLIF($2, ''), updated_at),
+			parent_session_id = NULLIF($3, ''),
+			parent_round = $4
+		 WHERE id = $5`,
+		info.CreatedAt, info.UpdatedAt, info.ParentSessionID, info.ParentRound, info.ID,
+	)
+	return err
+}
+
+// DeleteSession removes a session and everything recorded under it.
+// Its forks keep their own copies of the inherited rounds and are detached:
+// their parent link is cleared. It returns false if the session does not exist.
+func (s *PostgresStore) DeleteSession(id string) (bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	stmts := []string{
+		"DELETE FROM search_index WHERE round_id IN (SELECT id FROM rounds WHERE session_id = $1)",
+		"DELETE FROM iocs WHERE round_id IN (SELECT id FROM rounds WHERE session_id = $1)",
+		"DELETE FROM steps WHERE round_id IN (SELECT id FROM rounds WHERE session_id = $1)",
+		"DELETE FROM rounds WHERE session_id = $1",
+		"DELETE FROM checkpoints WHERE session_id = $1",
+		"DELETE FROM events WHERE session_id = $1",
+		"DELETE FROM session_pcaps WHERE session_id = $1",
+		"UPDATE sessions SET parent_session_id = NULL, parent_round = 0 WHERE parent_session_id = $1",
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt, id); err != nil {
+			return false, err
+		}
+	}
+	res, err := tx.Exec("DELETE FROM sessions WHERE id = $1", id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if n == 0 {
+		return false, nil
+	}
+	return true, tx.Commit()
+}
+
+// AppendRound saves a completed round with its steps and indicators as the
+// session's next round, touches the session and deletes the checkpoint the
+// round completes, in one transaction. The session row is locked first, so
+// concurrent writers to the same session are serialized.
+func (s *PostgresStore) AppendRound(rec RoundRecord) (int64, int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	var locked string
+	err = tx.QueryRow("SELECT id FROM sessions WHERE id = $1 FOR UPDATE", rec.SessionID).Scan(&locked)
+	if err == sql.ErrNoRows {
+		return 0, 0, fmt.Errorf("session %s not found", rec.SessionID)
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	var roundNum int
+	if err := tx.QueryRow(
+		"SELECT COALESCE(MAX(round_num), 0) + 1 FROM rounds WHERE session_id = $1", rec.SessionID,
+	).Scan(&roundNum); err != nil {
+		return 0, 0, err
+	}
+
+	roundID, err := pgInsertRound(tx, rec.SessionID, roundNum, rec.UserQuery, rec.Plan, rec.Report, rec.Findings, rec.OperationLog)
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, step := range rec.Steps {
+		if err := pgInsertStep(tx, roundID, step); err != nil {
+			return 0, 0, fmt.Errorf("save step %d: %w", step.StepID, err)
+		}
+	}
+	if err := pgInsertIOCs(tx, roundID, rec.IOCs); err != nil {
+		return 0, 0, fmt.Errorf("save iocs: %w", err)
+	}
+	if _, err := tx.Exec(
+		"UPDATE sessions SET updated_at = $1 WHERE id = $2",
+		time.Now().UTC().Format(time.RFC3339), rec.SessionID,
+	); err != nil {
+		return 0, 0, fmt.Errorf("touch session: %w", err)
+	}
+	if rec.Checkpoint > 0 {
+		if _, err := tx.Exec("DELETE FROM checkpoints WHERE session_id = $1 AND round_num = $2", rec.SessionID, rec.Checkpoint); err != nil {
+			return 0, 0, fmt.Errorf("delete checkpoint: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, 0, err
+	}
+	return roundID, roundNum, nil
+}
+
+// SaveRound saves a round with the given number and indexes its text for search.
+func (s *PostgresStore) SaveRound(sessionID string, roundNum int, userQuery string, plan common.Plan, report, findings, opLog string) (int64, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	roundID, err := pgInsertRound(tx, sessionID, roundNum, userQuery, plan, report, findings, opLog)
+	if err != nil {
+		return 0, err
+	}
+	return roundID, tx.Commit()
+}
+
+// pgInsertRound inserts a round and indexes its text.
+func pgInsertRound(db pgExecer, sessionID string, roundNum int, userQuery string, plan common.Plan, report, findings, opLog string) (int64, error) {
+	planJSON, _ := json.Marshal(plan)
+	var roundID int64
+	err := db.QueryRow(
+		`INSERT INTO rounds (session_id, round_num, user_query, plan_json, table_schema, report, findings, operation_log)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id`,
+		sessionID, roundNum, userQuery, string(planJSON), plan.TableSchema, report, findings, opLog,
+	).Scan(&roundID)
+	if err != nil {
+		return 0, err
+	}
+	for _, f := range []struct{ field, body string }{
+		{SearchFieldQuery, userQuery},
+		{SearchFieldFindings, findings},
+		{SearchFieldReport, report},
+	} {
+		if err := pgIndexText(db, roundID, 0, f.field, f.body); err != nil {
+			return 0, err
+		}
+	}
+	return roundID, nil
+}
+
+// pgIndexText adds body to the full-text index. Empty text is not indexed.
+func pgIndexText(db execer, roundID int64, stepID int, field, body string) error {
+	if strings.TrimSpace(body) == "" {
+		return nil
+	}
+	_, err := db.Exec(
+		"INSERT INTO search_index (body, field, round_id, step_id) VALUES ($1, $2, $3, $4)",
+		body, field, roundID, stepID,
+	)
+	if err != nil {
+		return fmt.Errorf("index %s: %w", field, err)
+	}
+	return nil
+}
+
+// SaveRoundDigest caches the digest of a round.
+func (s *PostgresStore) SaveRoundDigest(roundID int64, digest string) error {
+	_, err := s.db.Exec("UPDATE rounds SET digest = $1 WHERE id = $2", digest, roundID)
+	return err
+}
+
+// RestoreRoundCreatedAt sets the creation time of a round recreated from an export.
+func (s *PostgresStore) RestoreRoundCreatedAt(roundID int64, createdAt string) error {
+	if createdAt == "" {
+		return nil
+	}
+	_, err := s.db.Exec("UPDATE rounds SET created_at = $1 WHERE id = $2", createdAt, roundID)
+	return err
+}
+
+// GetRoundCount returns the number of rounds in a session.
+func (s *PostgresStore) GetRoundCount(sessionID string) (int, error) {
+	var count int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM rounds WHERE session_id = $1", sessionID).Scan(&count)
+	return count, err
+}
+
+// GetRounds returns all rounds of a session in order.
+func (s *PostgresStore) GetRounds(sessionID string) ([]Round, error) {
+	rows, err := s.db.Query(roundQuery+" WHERE session_id = $1 ORDER BY round_num ASC", sessionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []Round
+	for rows.Next() {
+		r, err := scanRound(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, *r)
+	}
+	return out, rows.Err()
+}
+
+// GetRound returns round roundNum of a session, or nil if it does not exist.
+func (s *PostgresStore) GetRound(sessionID string, roundNum int) (*Round, error) {
+	r, err := scanRound(s.db.QueryRow(roundQuery+" WHERE session_id = $1 AND round_num = $2", sessionID, roundNum))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return r, err
+}
+
+// SaveStep saves the record of an executed (or skipped) step within a round and
+// indexes its findings for search.
+func (s *PostgresStore) SaveStep(roundID int64, rec common.StepRecord) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := pgInsertStep(tx, roundID, rec); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func pgInsertStep(db execer, roundID int64, rec common.StepRecord) error {
+	_, err := db.Exec(
+		`INSERT INTO steps (round_id, step_id, intent, findings, actions, status,
+			started_at, finished_at, tool_calls, prompt_tokens, completion_tokens, total_tokens)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`,
+		roundID, rec.StepID, rec.Intent, rec.Findings, rec.Actions, rec.Status,
+		formatTime(rec.StartedAt), formatTime(rec.FinishedAt),
+		rec.ToolCalls, rec.PromptTokens, rec.CompletionTokens, rec.TotalTokens,
+	)
+	if err != nil {
+		return err
+	}
+	return pgIndexText(db, roundID, rec.StepID, SearchFieldStep, rec.Findings)
+}
+
+// GetRoundSteps returns the step records of a session's round, in execution order.
+func (s *PostgresStore) GetRoundSteps(sessionID string, roundNum int) ([]common.StepRecord, error) {
+	rows, err := s.db.Query(
+		`SELECT st.step_id, st.intent, st.findings, st.actions, st.status, st.started_at, st.finished_at,
+			st.tool_calls, st.prompt_tokens, st.completion_tokens, st.total_tokens
+		 FROM steps st JOIN rounds r ON r.id = st.round_id
+		 WHERE r.session_id = $1 AND r.round_num = $2
+		 ORDER BY st.id ASC`,
+		sessionID, roundNum,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []common.StepRecord
+	for rows.Next() {
+		var rec common.StepRecord
+		var startedAt, finishedAt string
+		if err := rows.Scan(&rec.StepID, &rec.Intent, &rec.Findings, &rec.Actions, &rec.Status,
+			&startedAt, &finishedAt, &rec.ToolCalls, &rec.PromptTokens, &rec.CompletionTokens, &rec.TotalTokens); err != nil {
+			return nil, err
+		}
+		rec.StartedAt = parseTime(startedAt)
+		rec.FinishedAt = parseTime(finishedAt)
+		records = append(records, rec)
+	}
+	return records, rows.Err()
+}
+
+// SaveIOCs saves the deduplicated indicators of a round.
+func (s *PostgresStore) SaveIOCs(roundID int64, iocs []common.IOC) error {
+	return pgInsertIOCs(s.db, roundID, iocs)
+}
+
+func pgInsertIOCs(db execer, roundID int64, iocs []common.IOC) error {
+	for _, ioc := range iocs {
+		stepIDs, _ := json.Marshal(ioc.StepIDs)
+		if _, err := db.Exec(
+			`INSERT INTO iocs (round_id, type, value, context, step_ids) VALUES ($1, $2, $3, $4, $5)
+			 ON CONFLICT (round_id, type, value) DO NOTHING`,
+			roundID, ioc.Type, ioc.Value, ioc.Context, string(stepIDs),
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetSessionIOCs returns every indicator recorded in a session, ordered by round.
+// An indicator seen in several rounds appears once per round.
+func (s *PostgresStore) GetSessionIOCs(sessionID string) ([]SessionIOC, error) {
+	rows, err := s.db.Query(
+		`SELECT r.round_num, i.type, i.value, i.context, i.step_ids
+		 FROM iocs i JOIN rounds r ON r.id = i.round_id
+		 WHERE r.session_id = $1
+		 ORDER BY r.round_num ASC, i.id ASC`,
+		sessionID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []SessionIOC
+	for rows.Next() {
+		var ioc SessionIOC
+		var stepIDs string
+		if err := rows.Scan(&ioc.RoundNum, &ioc.Type, &ioc.Value, &ioc.Context, &stepIDs); err != nil {
+			return nil, err
+		}
+		_ = json.Unmarshal([]byte(stepIDs), &ioc.StepIDs)
+		out = append(out, ioc)
+	}
+	return out, rows.Err()
+}
+
+// Search returns the best matches for text across every session's queries,
+// findings, reports and step findings. Each whitespace-separated term becomes a
+// phrase query over the 'simple' text search configuration and all of them
+// must match. Scores are negated ts_rank values, so lower is better as with SQLite.
+func (s *PostgresStore) Search(text string, limit int) ([]SearchHit, error) {
+	terms := strings.Fields(text)
+	if len(terms) == 0 {
+		return nil, fmt.Errorf("empty search query")
+	}
+	if limit <= 0 {
+		limit = DefaultSearchLimit
+	}
+
+	parts := make([]string, len(terms))
+	args := make([]any, 0, len(terms)+1)
+	for i, t := range terms {
+		parts[i] = fmt.Sprintf("phraseto_tsquery('simple', $%d)", i+1)
+		args = append(args, t)
+	}
+	args = append(args, limit)
+	tsquery := strings.Join(parts, " && ")
+
+	rows, err := s.db.Query(fmt.Sprintf(
+		`SELECT r.session_id, r.round_num, si.step_id, si.field,
+			ts_headline('simple', si.body, q.query, 'StartSel=[, StopSel=], MaxWords=16, MinWords=8, MaxFragments=1, FragmentDelimiter=…'),
+			-ts_rank(si.tsv, q.query) AS score
+		 FROM search_index si
+		 JOIN rounds r ON r.id = si.round_id
+		 CROSS JOIN (SELECT %s AS query) q
+		 WHERE si.tsv @@ q.query
+		 ORDER BY score, r.session_id, r.round_num, si.step_id
+		 LIMIT $%d`, tsquery, len(args)),
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hits []SearchHit
+	for rows.Next() {
+		var h SearchHit
+		if err := rows.Scan(&h.SessionID, &h.RoundNum, &h.StepID, &h.Field, &h.Snippet, &h.Score); err != nil {
+			return nil, err
+		}
+		hits = append(hits, h)
+	}
+	return hits, rows.Err()
+}
+
+// SaveCheckpoint upserts the executor state of an in-flight round.
+func (s *PostgresStore) SaveCheckpoint(sessionID string, roundNum int, userQuery string, state *common.PlanState) error {
+	stateJSON, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("marshal plan state: %w", err)
+	}
+	_, err = s.db.Exec(
+		`INSERT INTO checkpoints (session_id, round_num, user_query, state_json, status, error, updated_at)
+		 VALUES ($1, $2, $3, $4, $5, '', $6)
+		 ON CONFLICT (session_id, round_num) DO UPDATE SET
+			user_query = excluded.user_query,
+			state_json = excluded.state_json,
+			status     = excluded.status,
+			error      = '',
+			updated_at = excluded.updated_at`,
+		sessionID, roundNum, userQuery, string(stateJSON), CheckpointRunning, time.Now().UTC().Format(time.RFC3339),
+	)
+	return err
+}
+
+// FailCheckpoint marks an in-flight round's checkpoint as failed with the given error.
+func (s *PostgresStore) FailCheckpoint(sessionID string, roundNum int, errMsg string) error {
+	_, err := s.db.Exec(
+		"UPDATE checkpoints SET status = $1, error = $2, updated_at = $3 WHERE session_id = $4 AND round_num = $5",
+		CheckpointFailed, errMsg, time.Now().UTC().Format(time.RFC3339), sessionID, roundNum,
+	)
+	return err
+}
+
+// GetLatestCheckpoint returns the most recent unfinished round of a session, or nil if none.
+func (s *PostgresStore) GetLatestCheckpoint(sessionID string) (*Checkpoint, error) {
+	var cp Checkpoint
+	var stateJSON string
+	err := s.db.QueryRow(
+		`SELECT session_id, round_num, user_query, state_json, status, error, updated_at
+		 FROM checkpoints WHERE session_id = $1 ORDER BY round_num DESC LIMIT 1`,
+		sessionID,
+	).Scan(&cp.SessionID, &cp.RoundNum, &cp.UserQuery, &stateJSON, &cp.Status, &cp.Error, &cp.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(stateJSON), &cp.State); err != nil {
+		return nil, fmt.Errorf("unmarshal plan state: %w", err)
+	}
+	return &cp, nil
+}
+
+// AppendEvents appends evs, numbered by the caller, to the session's event log.
+// The session row is locked so concurrent appends are checked against each other.
+func (s *PostgresStore) AppendEvents(sessionID string, evs []events.Event) error {
+	if len(evs) == 0 {
+		return nil
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var locked string
+	err = tx.QueryRow("SELECT id FROM sessions WHERE id = $1 FOR UPDATE", sessionID).Scan(&locked)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("session %s not found", sessionID)
+	}
+	if err != nil {
+		return err
+	}
+	var last int64
+	if err := tx.QueryRow(
+		"SELECT COALESCE(MAX(seq), 0) FROM events WHERE session_id = $1", sessionID,
+	).Scan(&last); err != nil {
+		return err
+	}
+	if err := checkEventSeqs(sessionID, last, evs); err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare("INSERT INTO events (session_id, seq, type, round_num, run_id, step_id, data, created_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, ev := range evs {
+		if _, err := stmt.Exec(sessionID, ev.Seq, ev.Type, ev.Round, ev.RunID, ev.StepID, eventData(ev), eventTime(ev)); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// LastEventSeq returns the highest logged sequence number of a session.
+func (s *PostgresStore) LastEventSeq(sessionID string) (int64, error) {
+	var seq int64
+	err := s.db.QueryRow("SELECT COALESCE(MAX(seq), 0) FROM events WHERE session_id = $1", sessionID).Scan(&seq)
+	return seq, err
+}
+
+// GetEvents returns the logged events of a session after afterSeq, in order.
+func (s *PostgresStore) GetEvents(sessionID string, afterSeq int64) ([]events.Event, error) {
+	rows, err := s.db.Query(
+		"SELECT seq, type, round_num, run_id, step_id, data, created_at FROM events WHERE session_id = $1 AND seq > $2 ORDER BY seq",
+		sessionID, afterSeq,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return scanEvents(rows, sessionID)
+}
+
+// DeleteCheckpoint removes a round's checkpoint once the round has been saved.
+func (s *PostgresStore) DeleteCheckpoint(sessionID string, roundNum int) error {
+	_, err := s.db.Exec("DELETE FROM checkpoints WHERE session_id = $1 AND round_num = $2", sessionID, roundNum)
+	return err
+}
@@ -0,0 +1,95 @@
+package session
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// Fields of the full-text index. Round-level text is indexed with step_id 0.
+const (
+	SearchFieldQuery    = "query"
+	SearchFieldFindings = "findings"
+	SearchFieldReport   = "report"
+	SearchFieldStep     = "step" // findings of a single executor step
+)
+
+// DefaultSearchLimit is the number of hits Search returns when limit <= 0.
+const DefaultSearchLimit = 20
+
+// SearchHit is one ranked match from the full-text index.
+type SearchHit struct {
+	SessionID string  `json:"session_id"`
+	RoundNum  int     `json:"round_num"`
+	StepID    int     `json:"step_id,omitempty"` // set for step findings
+	Field     string  `json:"field"`
+	Snippet   string  `json:"snippet"` // matched terms are wrapped in [ ]
+	Score     float64 `json:"score"`   // bm25; lower is a better match
+}
+
+// execer is satisfied by both *sql.DB and *sql.Tx.
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// indexText adds body to the full-text index. Empty text is not indexed.
+func indexText(db execer, roundID int64, stepID int, field, body string) error {
+	if strings.TrimSpace(body) == "" {
+		return nil
+	}
+	_, err := db.Exec(
+		"INSERT INTO search_index (body, field, round_id, step_id) VALUES (?, ?, ?, ?)",
+		body, field, roundID, stepID,
+	)
+	if err != nil {
+		return fmt.Errorf("index %s: %w", field, err)
+	}
+	return nil
+}
+
+// Search returns the best matches for text across every session's queries,
+// findings, reports and step findings. Every whitespace-separated term must
+// match; each term is matched literally, so IPs, domains and hashes can be
+// given as-is.
+func (s *SQLiteStore) Search(text string, limit int) ([]SearchHit, error) {
+	query := ftsQuery(text)
+	if query == "" {
+		return nil, fmt.Errorf("empty search query")
+	}
+	if limit <= 0 {
+		limit = DefaultSearchLimit
+	}
+	rows, err := s.db.Query(
+		`SELECT r.session_id, r.round_num, search_index.step_id, search_index.field,
+			snippet(search_index, 0, '[', ']', '…', 16), bm25(search_index)
+		 FROM search_index JOIN rounds r ON r.id = search_index.round_id
+		 WHERE search_index MATCH ?
+		 ORDER BY bm25(search_index), r.session_id, r.round_num, search_index.step_id
+		 LIMIT ?`,
+		query, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hits []SearchHit
+	for rows.Next() {
+		var h SearchHit
+		if err := rows.Scan(&h.SessionID, &h.RoundNum, &h.StepID, &h.Field, &h.Snippet, &h.Score); err != nil {
+			return nil, err
+		}
+		hits = append(hits, h)
+	}
+	return hits, rows.Err()
+}
+
+// ftsQuery turns free text into an FTS5 query that ANDs every term as a quoted
+// phrase, so punctuation in indicators is not parsed as query syntax.
+func ftsQuery(text string) string {
+	terms := strings.Fields(text)
+	for i, t := range terms {
+		terms[i] = `"` + strings.ReplaceAll(t, `"`, `""`) + `"`
+	}
+	return strings.Join(terms, " ")
+}
@@ -0,0 +1,37 @@
+package session
+
+import (
+	"path/filepath"
+	"testing"
+
+	"pcap_agent/internal/common"
+)
+
+func TestSearchMatchesTermsLiterally(t *testing.T) {
+	s, err := OpenSQLite(filepath.Join(t.TempDir(), "sessions.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err := s.CreateSession("a", []SessionPcap{{ContainerPath: "/c/a.pcap"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.SaveRound("a", 1, `is "evil-c2.example" OR NEAR(x) reachable?`, common.Plan{}, "", "", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	// FTS5 operators and punctuation in the input are matched as text.
+	for _, q := range []string{"evil-c2.example", `"evil-c2.example"`, "OR", "NEAR(x)", "reach*", `a"b`} {
+		hits, err := s.Search(q, 0)
+		if err != nil {
+			t.Errorf("Search(%q): %v", q, err)
+			continue
+		}
+		if want := q != "reach*" && q != `a"b`; (len(hits) == 1) != want {
+			t.Errorf("Search(%q) = %+v; want match %v", q, hits, want)
+		}
+	}
+	if got, want := ftsQuery(`  a"b  c `), `"a""b" "c"`; got != want {
+		t.Errorf("ftsQuery = %s; want %s", got, want)
+	}
+}
@@ -1,38 +1,51 @@
 package session
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"pcap_agent/internal/common"
-	"pcap_agent/internal/events"
+	"pcap_agent/pkg/logger"
 	"time"
 )
 
 // Session manages a single analysis session with multi-round conversations.
 type Session struct {
-	ID       string
-	PcapPath string
-	RoundNum int
-	store    *Store
-	emitter  events.Emitter
+	ID         string
+	Pcaps      []SessionPcap // captures analysed together, in order
+	RoundNum   int
+	store      Store
+	historyCfg *HistoryConfig
 }
 
-// NewSession creates a new session and persists it to the store.
-func NewSession(store *Store, emitter events.Emitter, pcapPath string) (*Session, error) {
-	id := fmt.Sprintf("sess_%d", time.Now().UnixMilli())
-	if err := store.CreateSession(id, pcapPath); err != nil {
+// NewSession creates a new session over one or more captures and persists it to the store.
+func NewSession(store Store, pcaps []SessionPcap) (*Session, error) {
+	id := newSessionID()
+	if err := store.CreateSession(id, pcaps); err != nil {
 		return nil, fmt.Errorf("create session: %w", err)
 	}
 	return &Session{
 		ID:       id,
-		PcapPath: pcapPath,
+		Pcaps:    append([]SessionPcap(nil), pcaps...),
 		RoundNum: 0,
 		store:    store,
-		emitter:  emitter,
 	}, nil
 }
 
+// ForkSession branches session srcID at round roundNum into a new session that
+// inherits rounds 1..roundNum and the same captures, and returns it ready for
+// the next round.
+func ForkSession(store Store, srcID string, roundNum int) (*Session, error) {
+	id := newSessionID()
+	if err := store.ForkSession(srcID, roundNum, id); err != nil {
+		return nil, fmt.Errorf("fork session: %w", err)
+	}
+	return ResumeSession(store, id)
+}
+
 // ResumeSession loads an existing session from the store.
-func ResumeSession(store *Store, emitter events.Emitter, sessionID string) (*Session, error) {
+func ResumeSession(store Store, sessionID string) (*Session, error) {
 	exists, err := store.SessionExists(sessionID)
 	if err != nil {
 		return nil, err
@@ -40,7 +53,7 @@ func ResumeSession(store *Store, emitter events.Emitter, sessionID string) (*Ses
 	if !exists {
 		return nil, fmt.Errorf("session %s not found", sessionID)
 	}
-	pcapPath, err := store.GetSessionPcapPath(sessionID)
+	pcaps, err := store.GetSessionPcaps(sessionID)
 	if err != nil {
 		return nil, err
 	}
@@ -50,40 +63,109 @@ func ResumeSession(store *Store, emitter events.Emitter, sessionID string) (*Ses
 	}
 	return &Session{
 		ID:       sessionID,
-		PcapPath: pcapPath,
+		Pcaps:    pcaps,
 		RoundNum: roundCount,
 		store:    store,
-		emitter:  emitter,
 	}, nil
 }
 
-// History loads accumulated context from all previous rounds.
+// newSessionID returns a session ID made of the creation time and a random
+// suffix, so sessions created in the same millisecond do not collide.
+func newSessionID() string {
+	var b [4]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(fmt.Sprintf("session: read random session id: %v", err))
+	}
+	return fmt.Sprintf("sess_%d_%s", time.Now().UnixMilli(), hex.EncodeToString(b[:]))
+}
+
+// AddPcap attaches another capture to the session. Captures already attached
+// (same container path) are ignored.
+func (s *Session) AddPcap(p SessionPcap) error {
+	for _, existing := range s.Pcaps {
+		if existing.ContainerPath == p.ContainerPath {
+			return nil
+		}
+	}
+	if err := s.store.AddSessionPcap(s.ID, p); err != nil {
+		return err
+	}
+	s.Pcaps = append(s.Pcaps, p)
+	return nil
+}
+
+// Captures describes the session's captures for the planner and executors.
+func (s *Session) Captures() []common.Capture {
+	out := make([]common.Capture, len(s.Pcaps))
+	for i, p := range s.Pcaps {
+		out[i] = p.Capture()
+	}
+	return out
+}
+
+// SetHistoryConfig sets the budget and digester used by History. nil uses the defaults.
+func (s *Session) SetHistoryConfig(cfg *HistoryConfig) {
+	s.historyCfg = cfg
+}
+
+// History loads the context of previous rounds within the history token budget.
 // Returns nil if this is the first round.
-func (s *Session) History() (*common.SessionHistory, error) {
+func (s *Session) History(ctx context.Context) (*common.SessionHistory, error) {
 	if s.RoundNum == 0 {
 		return nil, nil
 	}
-	return s.store.GetSessionHistory(s.ID)
+	return GetSessionHistory(ctx, s.store, s.ID, s.historyCfg)
 }
 
-// SaveRound persists a completed round (planner + all executor steps + report).
-func (s *Session) SaveRound(userQuery string, plan common.Plan, report, findings, opLog string) error {
-	s.RoundNum++
-	roundID, err := s.store.SaveRound(s.ID, s.RoundNum, userQuery, plan, report, findings, opLog)
-	if err != nil {
-		return fmt.Errorf("save round: %w", err)
+// Checkpoint returns an executor checkpoint hook that snapshots the in-flight
+// (next) round after every completed step.
+func (s *Session) Checkpoint(userQuery string) func(ctx context.Context, state *common.PlanState) error {
+	roundNum := s.RoundNum + 1
+	return func(ctx context.Context, state *common.PlanState) error {
+		return s.store.SaveCheckpoint(s.ID, roundNum, userQuery, state)
 	}
+}
 
-	// Save individual steps
-	for _, step := range plan.Steps {
-		if err := s.store.SaveStep(roundID, step.StepID, step.Intent, "", "", "completed"); err != nil {
-			return fmt.Errorf("save step %d: %w", step.StepID, err)
-		}
-	}
+// FailRound records that the in-flight round failed, keeping its checkpoint for resumption.
+func (s *Session) FailRound(cause error) error {
+	return s.store.FailCheckpoint(s.ID, s.RoundNum+1, cause.Error())
+}
 
-	if err := s.store.TouchSession(s.ID); err != nil {
-		return fmt.Errorf("touch session: %w", err)
+// PendingRound returns the checkpoint of the unfinished next round, or nil if there is none.
+func (s *Session) PendingRound() (*Checkpoint, error) {
+	cp, err := s.store.GetLatestCheckpoint(s.ID)
+	if err != nil || cp == nil {
+		return nil, err
+	}
+	if cp.RoundNum != s.RoundNum+1 {
+		return nil, nil // stale checkpoint from a round that was saved later
 	}
+	return cp, nil
+}
 
+// SaveRound persists a completed round (planner + all executor steps + report)
+// and discards its checkpoint in a single transaction. The round number is
+// allocated by the store; if another process saved rounds to this session in
+// the meantime, the round is numbered after them and RoundNum catches up.
+func (s *Session) SaveRound(userQuery string, plan common.Plan, report, findings, opLog string, steps []common.StepRecord, iocs []common.IOC) error {
+	expected := s.RoundNum + 1
+	_, roundNum, err := s.store.AppendRound(RoundRecord{
+		SessionID:    s.ID,
+		UserQuery:    userQuery,
+		Plan:         plan,
+		Report:       report,
+		Findings:     findings,
+		OperationLog: opLog,
+		Steps:        steps,
+		IOCs:         iocs,
+		Checkpoint:   expected,
+	})
+	if err != nil {
+		return fmt.Errorf("save round: %w", err)
+	}
+	if roundNum != expected {
+		logger.Warnf("[Session] %s gained rounds from another writer; this round was saved as round %d instead of %d", s.ID, roundNum, expected)
+	}
+	s.RoundNum = roundNum
 	return nil
 }
@@ -0,0 +1,76 @@
+package session_test
+
+import (
+	"strings"
+	"sync"
+	"testing"
+
+	"pcap_agent/internal/session"
+)
+
+func TestNewSessionIDsAreUnique(t *testing.T) {
+	store := session.NewMemoryStore()
+	pcaps := []session.SessionPcap{{ContainerPath: "/c/a.pcap"}}
+
+	const n = 50
+	ids := make([]string, n)
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sess, err := session.NewSession(store, pcaps)
+			if err == nil {
+				ids[i] = sess.ID
+			}
+			errs[i] = err
+		}()
+	}
+	wg.Wait()
+
+	seen := map[string]bool{}
+	for i, id := range ids {
+		if errs[i] != nil {
+			t.Fatalf("NewSession: %v", errs[i])
+		}
+		if seen[id] {
+			t.Fatalf("session ID %s handed out twice", id)
+		}
+		seen[id] = true
+	}
+	if list, _ := store.ListSessions(); len(list) != n {
+		t.Errorf("store has %d sessions, want %d", len(list), n)
+	}
+}
+
+func TestSessionCaptures(t *testing.T) {
+	store := session.NewMemoryStore()
+	sess, err := session.NewSession(store, []session.SessionPcap{
+		{ContainerPath: "/c/dns.pcap", FileName: "dns.pcap"},
+		{ContainerPath: "/c/web.pcap", FileName: "web.pcap"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []session.SessionPcap{
+		{ContainerPath: "/c/web.pcap", FileName: "web.pcap"}, // already attached
+		{ContainerPath: "/c/smb.pcap", FileName: "smb.pcap"},
+	} {
+		if err := sess.AddPcap(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	resumed, err := session.ResumeSession(store, sess.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, c := range resumed.Captures() {
+		names = append(names, c.Name+"@"+c.Path)
+	}
+	if got, want := strings.Join(names, " "), "dns.pcap@/c/dns.pcap web.pcap@/c/web.pcap smb.pcap@/c/smb.pcap"; got != want {
+		t.Errorf("captures = %s; want %s", got, want)
+	}
+}
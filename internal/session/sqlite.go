@@ -0,0 +1,734 @@
+package session
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"path"
+	"pcap_agent/internal/common"
+	"pcap_agent/internal/events"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// SQLiteStore is the Store backed by a local SQLite database file.
+type SQLiteStore struct {
+	db *sql.DB
+}
+
+var _ Store = (*SQLiteStore)(nil)
+
+// OpenSQLite opens (or creates) a SQLite database at the given path and runs migrations.
+func OpenSQLite(dbPath string) (*SQLiteStore, error) {
+	db, err := sql.Open("sqlite", sqliteDSN(dbPath))
+	if err != nil {
+		return nil, fmt.Errorf("open sqlite: %w", err)
+	}
+	if _, err := db.Exec("PRAGMA journal_mode=WAL"); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("set WAL mode: %w", err)
+	}
+	s := &SQLiteStore{db: db}
+	if err := s.migrate(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("migrate: %w", err)
+	}
+	return s, nil
+}
+
+// sqliteDSN adds the connection options every SQLiteStore connection needs:
+// a writer waits for another process's transaction instead of failing with
+// SQLITE_BUSY, and transactions take the write lock when they begin so that
+// read-then-write sequences (such as allocating a round number) cannot interleave.
+func sqliteDSN(dbPath string) string {
+	sep := "?"
+	if strings.Contains(dbPath, "?") {
+		sep = "&"
+	}
+	return dbPath + sep + "_pragma=busy_timeout(10000)&_txlock=immediate"
+}
+
+// Close closes the database connection.
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
+
+// SavePcapFile records a PCAP file for future reference.
+func (s *SQLiteStore) SavePcapFile(fileName, filePath string, fileSize int64, fileHash string) (int64, error) {
+	res, err := s.db.Exec(
+		"INSERT INTO pcap_files (file_name, file_path, file_size, file_hash) VALUES (?, ?, ?, ?)",
+		fileName, filePath, fileSize, fileHash,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// GetPcapFileByHash returns the registered file with the given SHA-256, or nil if none.
+func (s *SQLiteStore) GetPcapFileByHash(hash string) (*PcapFile, error) {
+	var f PcapFile
+	err := s.db.QueryRow(
+		"SELECT id, file_name, file_path, file_size, file_hash, created_at FROM pcap_files WHERE file_hash = ?",
+		hash,
+	).Scan(&f.ID, &f.FileName, &f.FilePath, &f.FileSize, &f.FileHash, &f.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+// ListPcapFiles returns all registered captures, newest first, each with the
+// IDs of the sessions linked to it (oldest first).
+func (s *SQLiteStore) ListPcapFiles() ([]PcapFileUsage, error) {
+	rows, err := s.db.Query(
+		`SELECT f.id, f.file_name, f.file_path, f.file_size, f.file_hash, f.created_at, COALESCE(s.id, '')
+		 FROM pcap_files f
+		 LEFT JOIN session_pcaps sp ON sp.pcap_file_id = f.id
+		 LEFT JOIN sessions s ON s.id = sp.session_id
+		 ORDER BY f.created_at DESC, f.id DESC, s.created_at ASC`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []PcapFileUsage
+	for rows.Next() {
+		var f PcapFile
+		var sessionID string
+		if err := rows.Scan(&f.ID, &f.FileName, &f.FilePath, &f.FileSize, &f.FileHash, &f.CreatedAt, &sessionID); err != nil {
+			return nil, err
+		}
+		if n := len(out); n == 0 || out[n-1].ID != f.ID {
+			out = append(out, PcapFileUsage{PcapFile: f, SessionIDs: []string{}})
+		}
+		if sessionID != "" {
+			last := &out[len(out)-1]
+			last.SessionIDs = append(last.SessionIDs, sessionID)
+		}
+	}
+	return out, rows.Err()
+}
+
+// GetPcapFileSessions returns the IDs of the sessions linked to a registered capture, oldest first.
+func (s *SQLiteStore) GetPcapFileSessions(fileID int64) ([]string, error) {
+	rows, err := s.db.Query(
+		`SELECT s.id FROM sessions s JOIN session_pcaps sp ON sp.session_id = s.id
+		 WHERE sp.pcap_file_id = ? ORDER BY s.created_at ASC`,
+		fileID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// CreateSession creates a new session record with its captures, in order.
+// sessions.pcap_path and pcap_file_id keep the first capture for older readers.
+func (s *SQLiteStore) CreateSession(id string, pcaps []SessionPcap) error {
+	if len(pcaps) == 0 {
+		return fmt.Errorf("session %s has no captures", id)
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(
+		"INSERT INTO sessions (id, pcap_path, pcap_file_id) VALUES (?, ?, ?)",
+		id, pcaps[0].ContainerPath, nullableID(pcaps[0].PcapFileID),
+	); err != nil {
+		return err
+	}
+	for _, p := range pcaps {
+		if err := addSessionPcap(tx, id, p); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// AddSessionPcap attaches another capture to a session. Adding a container path
+// the session already has is a no-op.
+func (s *SQLiteStore) AddSessionPcap(sessionID string, p SessionPcap) error {
+	return addSessionPcap(s.db, sessionID, p)
+}
+
+func addSessionPcap(db execer, sessionID string, p SessionPcap) error {
+	_, err := db.Exec(
+		`INSERT OR IGNORE INTO session_pcaps (session_id, pcap_file_id, container_path, position)
+		 VALUES (?, ?, ?, (SELECT COALESCE(MAX(position) + 1, 0) FROM session_pcaps WHERE session_id = ?))`,
+		sessionID, nullableID(p.PcapFileID), p.ContainerPath, sessionID,
+	)
+	if err != nil {
+		return fmt.Errorf("add capture %s: %w", p.ContainerPath, err)
+	}
+	return nil
+}
+
+// GetSessionPcaps returns the captures of a session in the order they were added.
+func (s *SQLiteStore) GetSessionPcaps(sessionID string) ([]SessionPcap, error) {
+	rows, err := s.db.Query(
+		`SELECT COALESCE(sp.pcap_file_id, 0), sp.container_path,
+			COALESCE(f.file_name, ''), COALESCE(f.file_size, 0), COALESCE(f.file_hash, '')
+		 FROM session_pcaps sp LEFT JOIN pcap_files f ON f.id = sp.pcap_file_id
+		 WHERE sp.session_id = ?
+		 ORDER BY sp.position ASC`,
+		sessionID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []SessionPcap
+	for rows.Next() {
+		var p SessionPcap
+		if err := rows.Scan(&p.PcapFileID, &p.ContainerPath, &p.FileName, &p.FileSize, &p.FileHash); err != nil {
+			return nil, err
+		}
+		if p.FileName == "" {
+			p.FileName = path.Base(p.ContainerPath)
+		}
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
+
+// nullableID maps 0 to NULL for optional foreign keys.
+func nullableID(id int64) any {
+	if id > 0 {
+		return id
+	}
+	return nil
+}
+
+// TouchSession updates the session's updated_at timestamp.
+func (s *SQLiteStore) TouchSession(id string) error {
+	_, err := s.db.Exec(
+		"UPDATE sessions SET updated_at = ? WHERE id = ?",
+		time.Now().UTC().Format(time.RFC3339), id,
+	)
+	return err
+}
+
+// SessionExists checks if a session with the given ID exists.
+func (s *SQLiteStore) SessionExists(id string) (bool, error) {
+	var count int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM sessions WHERE id = ?", id).Scan(&count)
+	return count > 0, err
+}
+
+// AppendRound saves a completed round with its steps and indicators as the
+// session's next round, touches the session and deletes the checkpoint the
+// round completes, in one transaction. Transactions take the write lock up
+// front (see sqliteDSN), so the round number read here cannot be taken by a
+// concurrent writer before the insert.
+func (s *SQLiteStore) AppendRound(rec RoundRecord) (int64, int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	var roundNum int
+	err = tx.QueryRow(
+		"SELECT (SELECT COALESCE(MAX(round_num), 0) + 1 FROM rounds WHERE session_id = ?) FROM sessions WHERE id = ?",
+		rec.SessionID, rec.SessionID,
+	).Scan(&roundNum)
+	if err == sql.ErrNoRows {
+		return 0, 0, fmt.Errorf("session %s not found", rec.SessionID)
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+
+	roundID, err := insertRound(tx, rec.SessionID, roundNum, rec.UserQuery, rec.Plan, rec.Report, rec.Findings, rec.OperationLog)
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, step := range rec.Steps {
+		if err := insertStep(tx, roundID, step); err != nil {
+			return 0, 0, fmt.Errorf("save step %d: %w", step.StepID, err)
+		}
+	}
+	if err := insertIOCs(tx, roundID, rec.IOCs); err != nil {
+		return 0, 0, fmt.Errorf("save iocs: %w", err)
+	}
+	if _, err := tx.Exec(
+		"UPDATE sessions SET updated_at = ? WHERE id = ?",
+		time.Now().UTC().Format(time.RFC3339), rec.SessionID,
+	); err != nil {
+		return 0, 0, fmt.Errorf("touch session: %w", err)
+	}
+	if rec.Checkpoint > 0 {
+		if _, err := tx.Exec("DELETE FROM checkpoints WHERE session_id = ? AND round_num = ?", rec.SessionID, rec.Checkpoint); err != nil {
+			return 0, 0, fmt.Errorf("delete checkpoint: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, 0, err
+	}
+	return roundID, roundNum, nil
+}
+
+// SaveRound saves a round with the given number and indexes its text for search.
+func (s *SQLiteStore) SaveRound(sessionID string, roundNum int, userQuery string, plan common.Plan, report, findings, opLog string) (int64, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	roundID, err := insertRound(tx, sessionID, roundNum, userQuery, plan, report, findings, opLog)
+	if err != nil {
+		return 0, err
+	}
+	return roundID, tx.Commit()
+}
+
+// insertRound inserts a round and indexes its text.
+func insertRound(db execer, sessionID string, roundNum int, userQuery string, plan common.Plan, report, findings, opLog string) (int64, error) {
+	planJSON, _ := json.Marshal(plan)
+	res, err := db.Exec(
+		"INSERT INTO rounds (session_id, round_num, user_query, plan_json, table_schema, report, findings, operation_log) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		sessionID, roundNum, userQuery, string(planJSON), plan.TableSchema, report, findings, opLog,
+	)
+	if err != nil {
+		return 0, err
+	}
+	roundID, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	for _, f := range []struct{ field, body string }{
+		{SearchFieldQuery, userQuery},
+		{SearchFieldFindings, findings},
+		{SearchFieldReport, report},
+	} {
+		if err := indexText(db, roundID, 0, f.field, f.body); err != nil {
+			return 0, err
+		}
+	}
+	return roundID, nil
+}
+
+// SaveRoundDigest caches the digest of a round.
+func (s *SQLiteStore) SaveRoundDigest(roundID int64, digest string) error {
+	_, err := s.db.Exec("UPDATE rounds SET digest = ? WHERE id = ?", digest, roundID)
+	return err
+}
+
+// RestoreSessionMetadata sets the timestamps and fork linkage of a session
+// recreated from an export. Empty timestamps are left unchanged.
+func (s *SQLiteStore) RestoreSessionMetadata(info SessionInfo) error {
+	_, err := s.db.Exec(
+		`UPDATE sessions SET
+			created_at = COALESCE(NULLIF(?, ''), created_at),
+			updated_at = COALESCE(NULLIF(?, ''), updated_at),
+			parent_session_id = NULLIF(?, ''),
+			parent_round = ?
+		 WHERE id = ?`,
+		info.CreatedAt, info.UpdatedAt, info.ParentSessionID, info.ParentRound, info.ID,
+	)
+	return err
+}
+
+// RestoreRoundCreatedAt sets the creation time of a round recreated from an export.
+func (s *SQLiteStore) RestoreRoundCreatedAt(roundID int64, createdAt string) error {
+	if createdAt == "" {
+		return nil
+	}
+	_, err := s.db.Exec("UPDATE rounds SET created_at = ? WHERE id = ?", createdAt, roundID)
+	return err
+}
+
+// GetRoundCount returns the number of rounds in a session.
+func (s *SQLiteStore) GetRoundCount(sessionID string) (int, error) {
+	var count int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM rounds WHERE session_id = ?", sessionID).Scan(&count)
+	return count, err
+}
+
+// SaveStep saves the record of an executed (or skipped) step within a round and
+// indexes its findings for search.
+func (s *SQLiteStore) SaveStep(roundID int64, rec common.StepRecord) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := insertStep(tx, roundID, rec); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func insertStep(db execer, roundID int64, rec common.StepRecord) error {
+	_, err := db.Exec(
+		`INSERT INTO steps (round_id, step_id, intent, findings, actions, status,
+			started_at, finished_at, tool_calls, prompt_tokens, completion_tokens, total_tokens)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		roundID, rec.StepID, rec.Intent, rec.Findings, rec.Actions, rec.Status,
+		formatTime(rec.StartedAt), formatTime(rec.FinishedAt),
+		rec.ToolCalls, rec.PromptTokens, rec.CompletionTokens, rec.TotalTokens,
+	)
+	if err != nil {
+		return err
+	}
+	return indexText(db, roundID, rec.StepID, SearchFieldStep, rec.Findings)
+}
+
+// GetRoundSteps returns the step records of a session's round, in execution order.
+func (s *SQLiteStore) GetRoundSteps(sessionID string, roundNum int) ([]common.StepRecord, error) {
+	rows, err := s.db.Query(
+		`SELECT st.step_id, st.intent, st.findings, st.actions, st.status, st.started_at, st.finished_at,
+			st.tool_calls, st.prompt_tokens, st.completion_tokens, st.total_tokens
+		 FROM steps st JOIN rounds r ON r.id = st.round_id
+		 WHERE r.session_id = ? AND r.round_num = ?
+		 ORDER BY st.id ASC`,
+		sessionID, roundNum,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []common.StepRecord
+	for rows.Next() {
+		var rec common.StepRecord
+		var startedAt, finishedAt string
+		if err := rows.Scan(&rec.StepID, &rec.Intent, &rec.Findings, &rec.Actions, &rec.Status,
+			&startedAt, &finishedAt, &rec.ToolCalls, &rec.PromptTokens, &rec.CompletionTokens, &rec.TotalTokens); err != nil {
+			return nil, err
+		}
+		rec.StartedAt = parseTime(startedAt)
+		rec.FinishedAt = parseTime(finishedAt)
+		records = append(records, rec)
+	}
+	return records, rows.Err()
+}
+
+// formatTime renders t for storage; the zero time is stored as an empty string.
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// parseTime parses a timestamp written by formatTime. Invalid or empty values yield the zero time.
+func parseTime(v string) time.Time {
+	t, err := time.Parse(time.RFC3339Nano, v)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// SaveIOCs saves the deduplicated indicators of a round.
+func (s *SQLiteStore) SaveIOCs(roundID int64, iocs []common.IOC) error {
+	return insertIOCs(s.db, roundID, iocs)
+}
+
+func insertIOCs(db execer, roundID int64, iocs []common.IOC) error {
+	for _, ioc := range iocs {
+		stepIDs, _ := json.Marshal(ioc.StepIDs)
+		if _, err := db.Exec(
+			"INSERT OR IGNORE INTO iocs (round_id, type, value, context, step_ids) VALUES (?, ?, ?, ?, ?)",
+			roundID, ioc.Type, ioc.Value, ioc.Context, string(stepIDs),
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetSessionIOCs returns every indicator recorded in a session, ordered by round.
+// An indicator seen in several rounds appears once per round.
+func (s *SQLiteStore) GetSessionIOCs(sessionID string) ([]SessionIOC, error) {
+	rows, err := s.db.Query(
+		`SELECT r.round_num, i.type, i.value, i.context, i.step_ids
+		 FROM iocs i JOIN rounds r ON r.id = i.round_id
+		 WHERE r.session_id = ?
+		 ORDER BY r.round_num ASC, i.id ASC`,
+		sessionID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []SessionIOC
+	for rows.Next() {
+		var ioc SessionIOC
+		var stepIDs string
+		if err := rows.Scan(&ioc.RoundNum, &ioc.Type, &ioc.Value, &ioc.Context, &stepIDs); err != nil {
+			return nil, err
+		}
+		_ = json.Unmarshal([]byte(stepIDs), &ioc.StepIDs)
+		out = append(out, ioc)
+	}
+	return out, rows.Err()
+}
+
+// SaveCheckpoint upserts the executor state of an in-flight round.
+func (s *SQLiteStore) SaveCheckpoint(sessionID string, roundNum int, userQuery string, state *common.PlanState) error {
+	stateJSON, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("marshal plan state: %w", err)
+	}
+	_, err = s.db.Exec(
+		`INSERT INTO checkpoints (session_id, round_num, user_query, state_json, status, error, updated_at)
+		 VALUES (?, ?, ?, ?, ?, '', ?)
+		 ON CONFLICT(session_id, round_num) DO UPDATE SET
+			user_query = excluded.user_query,
+			state_json = excluded.state_json,
+			status     = excluded.status,
+			error      = '',
+			updated_at = excluded.updated_at`,
+		sessionID, roundNum, userQuery, string(stateJSON), CheckpointRunning, time.Now().UTC().Format(time.RFC3339),
+	)
+	return err
+}
+
+// FailCheckpoint marks an in-flight round's checkpoint as failed with the given error.
+func (s *SQLiteStore) FailCheckpoint(sessionID string, roundNum int, errMsg string) error {
+	_, err := s.db.Exec(
+		"UPDATE checkpoints SET status = ?, error = ?, updated_at = ? WHERE session_id = ? AND round_num = ?",
+		CheckpointFailed, errMsg, time.Now().UTC().Format(time.RFC3339), sessionID, roundNum,
+	)
+	return err
+}
+
+// GetLatestCheckpoint returns the most recent unfinished round of a session, or nil if none.
+func (s *SQLiteStore) GetLatestCheckpoint(sessionID string) (*Checkpoint, error) {
+	var cp Checkpoint
+	var stateJSON string
+	err := s.db.QueryRow(
+		`SELECT session_id, round_num, user_query, state_json, status, error, updated_at
+		 FROM checkpoints WHERE session_id = ? ORDER BY round_num DESC LIMIT 1`,
+		sessionID,
+	).Scan(&cp.SessionID, &cp.RoundNum, &cp.UserQuery, &stateJSON, &cp.Status, &cp.Error, &cp.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(stateJSON), &cp.State); err != nil {
+		return nil, fmt.Errorf("unmarshal plan state: %w", err)
+	}
+	return &cp, nil
+}
+
+// DeleteCheckpoint removes a round's checkpoint once the round has been saved.
+func (s *SQLiteStore) DeleteCheckpoint(sessionID string, roundNum int) error {
+	_, err := s.db.Exec("DELETE FROM checkpoints WHERE session_id = ? AND round_num = ?", sessionID, roundNum)
+	return err
+}
+
+// AppendEvents appends evs, numbered by the caller, to the session's event log.
+func (s *SQLiteStore) AppendEvents(sessionID string, evs []events.Event) error {
+	if len(evs) == 0 {
+		return nil
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var last int64
+	err = tx.QueryRow(
+		"SELECT (SELECT COALESCE(MAX(seq), 0) FROM events WHERE session_id = ?) FROM sessions WHERE id = ?",
+		sessionID, sessionID,
+	).Scan(&last)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("session %s not found", sessionID)
+	}
+	if err != nil {
+		return err
+	}
+	if err := checkEventSeqs(sessionID, last, evs); err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare("INSERT INTO events (session_id, seq, type, round_num, run_id, step_id, data, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, ev := range evs {
+		if _, err := stmt.Exec(sessionID, ev.Seq, ev.Type, ev.Round, ev.RunID, ev.StepID, eventData(ev), eventTime(ev)); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// LastEventSeq returns the highest logged sequence number of a session.
+func (s *SQLiteStore) LastEventSeq(sessionID string) (int64, error) {
+	var seq int64
+	err := s.db.QueryRow("SELECT COALESCE(MAX(seq), 0) FROM events WHERE session_id = ?", sessionID).Scan(&seq)
+	return seq, err
+}
+
+// GetEvents returns the logged events of a session after afterSeq, in order.
+func (s *SQLiteStore) GetEvents(sessionID string, afterSeq int64) ([]events.Event, error) {
+	rows, err := s.db.Query(
+		"SELECT seq, type, round_num, run_id, step_id, data, created_at FROM events WHERE session_id = ? AND seq > ? ORDER BY seq",
+		sessionID, afterSeq,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return scanEvents(rows, sessionID)
+}
+
+const sessionInfoQuery = `
+	SELECT s.id, s.pcap_path, COALESCE(s.pcap_file_id, 0),
+		(SELECT COUNT(*) FROM session_pcaps sp WHERE sp.session_id = s.id),
+		s.created_at, s.updated_at,
+		(SELECT COUNT(*) FROM rounds r WHERE r.session_id = s.id),
+		COALESCE((SELECT r.user_query FROM rounds r WHERE r.session_id = s.id ORDER BY r.round_num DESC LIMIT 1), ''),
+		COALESCE(s.parent_session_id, ''), COALESCE(s.parent_round, 0)
+	FROM sessions s`
+
+// ListSessions returns all sessions, most recently updated first.
+func (s *SQLiteStore) ListSessions() ([]SessionInfo, error) {
+	rows, err := s.db.Query(sessionInfoQuery + " ORDER BY s.updated_at DESC, s.created_at DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []SessionInfo
+	for rows.Next() {
+		info, err := scanSessionInfo(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, *info)
+	}
+	return out, rows.Err()
+}
+
+// GetSession returns a session's summary, or nil if it does not exist.
+func (s *SQLiteStore) GetSession(id string) (*SessionInfo, error) {
+	info, err := scanSessionInfo(s.db.QueryRow(sessionInfoQuery+" WHERE s.id = ?", id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return info, err
+}
+
+func scanSessionInfo(row interface{ Scan(...any) error }) (*SessionInfo, error) {
+	var info SessionInfo
+	if err := row.Scan(&info.ID, &info.PcapPath, &info.PcapFileID, &info.PcapCount, &info.CreatedAt, &info.UpdatedAt, &info.RoundCount, &info.LastQuery, &info.ParentSessionID, &info.ParentRound); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+const roundQuery = `
+	SELECT id, session_id, round_num, user_query, plan_json, report, findings, operation_log, digest, created_at
+	FROM rounds`
+
+func scanRound(row interface{ Scan(...any) error }) (*Round, error) {
+	var r Round
+	var planJSON string
+	if err := row.Scan(&r.ID, &r.SessionID, &r.RoundNum, &r.UserQuery, &planJSON, &r.Report, &r.Findings, &r.OperationLog, &r.Digest, &r.CreatedAt); err != nil {
+		return nil, err
+	}
+	if planJSON != "" {
+		if err := json.Unmarshal([]byte(planJSON), &r.Plan); err != nil {
+			return nil, fmt.Errorf("unmarshal plan of round %d: %w", r.RoundNum, err)
+		}
+	}
+	return &r, nil
+}
+
+// GetRounds returns all rounds of a session in order.
+func (s *SQLiteStore) GetRounds(sessionID string) ([]Round, error) {
+	rows, err := s.db.Query(roundQuery+" WHERE session_id = ? ORDER BY round_num ASC", sessionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []Round
+	for rows.Next() {
+		r, err := scanRound(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, *r)
+	}
+	return out, rows.Err()
+}
+
+// GetRound returns round roundNum of a session, or nil if it does not exist.
+func (s *SQLiteStore) GetRound(sessionID string, roundNum int) (*Round, error) {
+	r, err := scanRound(s.db.QueryRow(roundQuery+" WHERE session_id = ? AND round_num = ?", sessionID, roundNum))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return r, err
+}
+
+// DeleteSession removes a session and everything recorded under it.
+// Its forks keep their own copies of the inherited rounds and are detached:
+// their parent link is cleared. It returns false if the session does not exist.
+func (s *SQLiteStore) DeleteSession(id string) (bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	stmts := []string{
+		"DELETE FROM search_index WHERE round_id IN (SELECT id FROM rounds WHERE session_id = ?)",
+		"DELETE FROM iocs WHERE round_id IN (SELECT id FROM rounds WHERE session_id = ?)",
+		"DELETE FROM steps WHERE round_id IN (SELECT id FROM rounds WHERE session_id = ?)",
+		"DELETE FROM rounds WHERE session_id = ?",
+		"DELETE FROM checkpoints WHERE session_id = ?",
+		"DELETE FROM events WHERE session_id = ?",
+		"DELETE FROM session_pcaps WHERE session_id = ?",
+		"UPDATE sessions SET parent_session_id = NULL, parent_round = 0 WHERE parent_session_id = ?",
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt, id); err != nil {
+			return false, err
+		}
+	}
+	res, err := tx.Exec("DELETE FROM sessions WHERE id = ?", id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if n == 0 {
+		return false, nil
+	}
+	return true, tx.Commit()
+}
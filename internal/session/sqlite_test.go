@@ -0,0 +1,56 @@
+package session_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"pcap_agent/internal/session"
+	"pcap_agent/internal/session/storetest"
+)
+
+func TestSQLiteStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) session.Store {
+		s, err := session.OpenSQLite(filepath.Join(t.TempDir(), "sessions.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	})
+}
+
+func TestOpenExisting(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.db")
+
+	if _, err := session.OpenExisting(path); !errors.Is(err, session.ErrNoDatabase) {
+		t.Fatalf("OpenExisting(missing) err = %v, want ErrNoDatabase", err)
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("OpenExisting created %s (stat err %v)", path, err)
+	}
+
+	s, err := session.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CreateSession("s", []session.SessionPcap{{ContainerPath: "/home/linuxbrew/s.pcap"}}); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	s, err = session.OpenExisting(path + "?_pragma=foreign_keys(1)")
+	if err != nil {
+		t.Fatalf("OpenExisting(existing): %v", err)
+	}
+	defer s.Close()
+	if info, err := s.GetSession("s"); err != nil || info == nil {
+		t.Fatalf("GetSession = %v, %v", info, err)
+	}
+
+	m, err := session.OpenExisting("memory:")
+	if err != nil {
+		t.Fatalf("OpenExisting(memory:): %v", err)
+	}
+	m.Close()
+}
@@ -1,206 +1,260 @@
 package session
 
 import (
-	"database/sql"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"pcap_agent/internal/common"
-	"time"
+	"os"
+	"strings"
 
-	_ "modernc.org/sqlite"
+	"pcap_agent/internal/common"
+	"pcap_agent/internal/events"
 )
 
-// Store handles SQLite persistence for sessions, rounds, and steps.
-type Store struct {
-	db *sql.DB
-}
+// Store persists sessions, their captures, rounds, steps, indicators, event
+// logs and checkpoints. SQLiteStore is the default backend; MemoryStore keeps everything
+// in process and PostgresStore serves shared deployments. Every backend must
+// pass the conformance suite in package storetest.
+//
+// Lookups of a single record return nil (and no error) when it does not exist.
+// Timestamps are returned as strings in the backend's storage format.
+type Store interface {
+	// Close releases the backend's resources.
+	Close() error
 
-// OpenStore opens (or creates) a SQLite database at the given path and runs migrations.
-func OpenStore(dbPath string) (*Store, error) {
-	db, err := sql.Open("sqlite", dbPath)
-	if err != nil {
-		return nil, fmt.Errorf("open sqlite: %w", err)
-	}
-	if _, err := db.Exec("PRAGMA journal_mode=WAL"); err != nil {
-		db.Close()
-		return nil, fmt.Errorf("set WAL mode: %w", err)
-	}
-	s := &Store{db: db}
-	if err := s.migrate(); err != nil {
-		db.Close()
-		return nil, fmt.Errorf("migrate: %w", err)
-	}
-	return s, nil
-}
+	// SavePcapFile records a capture file and returns its ID. Non-empty hashes are unique.
+	SavePcapFile(fileName, filePath string, fileSize int64, fileHash string) (int64, error)
+	// GetPcapFileByHash returns the registered file with the given SHA-256, or nil if none.
+	GetPcapFileByHash(hash string) (*PcapFile, error)
+	// ListPcapFiles returns all registered captures, newest first, each with the
+	// IDs of the sessions linked to it (oldest first).
+	ListPcapFiles() ([]PcapFileUsage, error)
+	// GetPcapFileSessions returns the IDs of the sessions linked to a registered capture, oldest first.
+	GetPcapFileSessions(fileID int64) ([]string, error)
+
+	// CreateSession creates a new session record with its captures, in order.
+	CreateSession(id string, pcaps []SessionPcap) error
+	// AddSessionPcap attaches another capture to a session. Adding a container path
+	// the session already has is a no-op.
+	AddSessionPcap(sessionID string, p SessionPcap) error
+	// GetSessionPcaps returns the captures of a session in the order they were added.
+	GetSessionPcaps(sessionID string) ([]SessionPcap, error)
+	// TouchSession updates the session's updated_at timestamp.
+	TouchSession(id string) error
+	// SessionExists checks if a session with the given ID exists.
+	SessionExists(id string) (bool, error)
+	// GetSession returns a session's summary, or nil if it does not exist.
+	GetSession(id string) (*SessionInfo, error)
+	// ListSessions returns all sessions, most recently updated first.
+	ListSessions() ([]SessionInfo, error)
+	// GetSessionForks returns the sessions forked directly from id, oldest first.
+	GetSessionForks(id string) ([]SessionInfo, error)
+	// ForkSession creates session newID as a branch of srcID that inherits rounds
+	// 1..roundNum with their steps, IOCs and digests, and the same captures.
+	ForkSession(srcID string, roundNum int, newID string) error
+	// RestoreSessionMetadata sets the timestamps and fork linkage of a session
+	// recreated from an export. Empty timestamps are left unchanged.
+	RestoreSessionMetadata(info SessionInfo) error
+	// DeleteSession removes a session and everything recorded under it.
+	// Its forks are detached rather than deleted. It returns false if the
+	// session does not exist.
+	DeleteSession(id string) (bool, error)
 
-func (s *Store) migrate() error {
-	ddl := `
-	CREATE TABLE IF NOT EXISTS pcap_files (
-		id          INTEGER PRIMARY KEY AUTOINCREMENT,
-		file_name   TEXT NOT NULL,
-		file_path   TEXT NOT NULL,
-		file_size   INTEGER DEFAULT 0,
-		file_hash   TEXT DEFAULT '',
-		created_at  TEXT NOT NULL DEFAULT (datetime('now'))
-	);
-	CREATE TABLE IF NOT EXISTS sessions (
-		id          TEXT PRIMARY KEY,
-		pcap_path   TEXT NOT NULL,
-		pcap_file_id INTEGER REFERENCES pcap_files(id),
-		created_at  TEXT NOT NULL DEFAULT (datetime('now')),
-		updated_at  TEXT NOT NULL DEFAULT (datetime('now'))
-	);
-	CREATE TABLE IF NOT EXISTS rounds (
-		id          INTEGER PRIMARY KEY AUTOINCREMENT,
-		session_id  TEXT NOT NULL REFERENCES sessions(id),
-		round_num   INTEGER NOT NULL,
-		user_query  TEXT NOT NULL,
-		plan_json   TEXT DEFAULT '',
-		table_schema TEXT DEFAULT '',
-		report      TEXT DEFAULT '',
-		findings    TEXT DEFAULT '',
-		operation_log TEXT DEFAULT '',
-		created_at  TEXT NOT NULL DEFAULT (datetime('now'))
-	);
-	CREATE TABLE IF NOT EXISTS steps (
-		id          INTEGER PRIMARY KEY AUTOINCREMENT,
-		round_id    INTEGER NOT NULL REFERENCES rounds(id),
-		step_id     INTEGER NOT NULL,
-		intent      TEXT NOT NULL,
-		findings    TEXT DEFAULT '',
-		actions     TEXT DEFAULT '',
-		status      TEXT DEFAULT 'pending',
-		created_at  TEXT NOT NULL DEFAULT (datetime('now'))
-	);`
-	_, err := s.db.Exec(ddl)
-	return err
+	// AppendRound saves a completed round with its steps and indicators as the
+	// session's next round, touches the session and deletes the checkpoint the
+	// round completes, all atomically. The round number is allocated by the
+	// store, so concurrent writers to one session get distinct, consecutive
+	// numbers. It fails if the session does not exist.
+	AppendRound(rec RoundRecord) (roundID int64, roundNum int, err error)
+	// SaveRound saves a round with the given number and indexes its text for
+	// search. It is used to recreate rounds, e.g. on import; new rounds go
+	// through AppendRound.
+	SaveRound(sessionID string, roundNum int, userQuery string, plan common.Plan, report, findings, opLog string) (int64, error)
+	// SaveRoundDigest caches the digest of a round.
+	SaveRoundDigest(roundID int64, digest string) error
+	// RestoreRoundCreatedAt sets the creation time of a round recreated from an export.
+	RestoreRoundCreatedAt(roundID int64, createdAt string) error
+	// GetRoundCount returns the number of rounds in a session.
+	GetRoundCount(sessionID string) (int, error)
+	// GetRounds returns all rounds of a session in order.
+	GetRounds(sessionID string) ([]Round, error)
+	// GetRound returns round roundNum of a session, or nil if it does not exist.
+	GetRound(sessionID string, roundNum int) (*Round, error)
+
+	// SaveStep saves the record of an executed (or skipped) step within a round
+	// and indexes its findings for search.
+	SaveStep(roundID int64, rec common.StepRecord) error
+	// GetRoundSteps returns the step records of a session's round, in execution order.
+	GetRoundSteps(sessionID string, roundNum int) ([]common.StepRecord, error)
+	// SaveIOCs saves the deduplicated indicators of a round. An indicator already
+	// recorded for the round is left unchanged.
+	SaveIOCs(roundID int64, iocs []common.IOC) error
+	// GetSessionIOCs returns every indicator recorded in a session, ordered by round.
+	GetSessionIOCs(sessionID string) ([]SessionIOC, error)
+
+	// Search returns the best matches for text across every session's queries,
+	// findings, reports and step findings, best first. Every whitespace-separated
+	// term must match literally.
+	Search(text string, limit int) ([]SearchHit, error)
+
+	// AppendEvents appends evs to the session's event log in one transaction.
+	// Sequence numbers are assigned by the caller (see EventLog): they must
+	// increase and be above LastEventSeq. It fails if the session does not exist
+	// or a number is already taken.
+	AppendEvents(sessionID string, evs []events.Event) error
+	// LastEventSeq returns the highest logged sequence number of a session (0 if
+	// none).
+	LastEventSeq(sessionID string) (int64, error)
+	// GetEvents returns the logged events of a session with a sequence number
+	// above afterSeq, in order, with SessionID and Seq set.
+	GetEvents(sessionID string, afterSeq int64) ([]events.Event, error)
+
+	// SaveCheckpoint upserts the executor state of an in-flight round.
+	SaveCheckpoint(sessionID string, roundNum int, userQuery string, state *common.PlanState) error
+	// FailCheckpoint marks an in-flight round's checkpoint as failed with the given error.
+	FailCheckpoint(sessionID string, roundNum int, errMsg string) error
+	// GetLatestCheckpoint returns the most recent unfinished round of a session, or nil if none.
+	GetLatestCheckpoint(sessionID string) (*Checkpoint, error)
+	// DeleteCheckpoint removes a round's checkpoint once the round has been saved.
+	DeleteCheckpoint(sessionID string, roundNum int) error
 }
 
-// Close closes the database connection.
-func (s *Store) Close() error {
-	return s.db.Close()
+// Open opens the store named by dsn:
+//
+//   - "postgres://..." or "postgresql://..." connects to PostgreSQL
+//   - "memory:" creates an empty in-process store
+//   - anything else is the path of a SQLite database file
+func Open(dsn string) (Store, error) {
+	switch {
+	case strings.HasPrefix(dsn, "postgres://"), strings.HasPrefix(dsn, "postgresql://"):
+		return OpenPostgres(dsn)
+	case dsn == "memory:":
+		return NewMemoryStore(), nil
+	case dsn == "":
+		return nil, fmt.Errorf("empty store location")
+	default:
+		return OpenSQLite(dsn)
+	}
 }
 
-// SavePcapFile records a PCAP file for future reference.
-func (s *Store) SavePcapFile(fileName, filePath string, fileSize int64, fileHash string) (int64, error) {
-	res, err := s.db.Exec(
-		"INSERT INTO pcap_files (file_name, file_path, file_size, file_hash) VALUES (?, ?, ?, ?)",
-		fileName, filePath, fileSize, fileHash,
-	)
-	if err != nil {
-		return 0, err
+// ErrNoDatabase is returned by OpenExisting when the SQLite database file is missing.
+var ErrNoDatabase = errors.New("database does not exist")
+
+// OpenExisting is like Open but refuses to create a SQLite database, so that
+// a mistyped path fails instead of silently yielding an empty store.
+func OpenExisting(dsn string) (Store, error) {
+	if isSQLitePath(dsn) {
+		path, _, _ := strings.Cut(dsn, "?")
+		if _, err := os.Stat(path); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil, fmt.Errorf("%w: %s", ErrNoDatabase, path)
+			}
+			return nil, fmt.Errorf("stat %s: %w", path, err)
+		}
 	}
-	return res.LastInsertId()
+	return Open(dsn)
 }
 
-// CreateSession creates a new session record.
-func (s *Store) CreateSession(id, pcapPath string) error {
-	_, err := s.db.Exec(
-		"INSERT INTO sessions (id, pcap_path) VALUES (?, ?)",
-		id, pcapPath,
-	)
-	return err
+// isSQLitePath reports whether Open treats dsn as a SQLite database path.
+func isSQLitePath(dsn string) bool {
+	return dsn != "" && dsn != "memory:" &&
+		!strings.HasPrefix(dsn, "postgres://") && !strings.HasPrefix(dsn, "postgresql://")
 }
 
-// TouchSession updates the session's updated_at timestamp.
-func (s *Store) TouchSession(id string) error {
-	_, err := s.db.Exec(
-		"UPDATE sessions SET updated_at = ? WHERE id = ?",
-		time.Now().UTC().Format(time.RFC3339), id,
-	)
-	return err
+// PcapFile is a registered capture file.
+type PcapFile struct {
+	ID        int64  `json:"id"`
+	FileName  string `json:"file_name"`
+	FilePath  string `json:"file_path"`
+	FileSize  int64  `json:"file_size"`
+	FileHash  string `json:"file_hash"` // hex SHA-256 of the file contents
+	CreatedAt string `json:"created_at"`
 }
 
-// SessionExists checks if a session with the given ID exists.
-func (s *Store) SessionExists(id string) (bool, error) {
-	var count int
-	err := s.db.QueryRow("SELECT COUNT(*) FROM sessions WHERE id = ?", id).Scan(&count)
-	return count > 0, err
+// PcapFileUsage is a registered capture with the sessions that analysed it.
+type PcapFileUsage struct {
+	PcapFile
+	SessionIDs []string `json:"session_ids"`
 }
 
-// GetSessionPcapPath returns the PCAP path for a session.
-func (s *Store) GetSessionPcapPath(id string) (string, error) {
-	var path string
-	err := s.db.QueryRow("SELECT pcap_path FROM sessions WHERE id = ?", id).Scan(&path)
-	return path, err
+// SessionPcap is a capture attached to a session. The file fields come from the
+// capture registry and are empty for unregistered captures.
+type SessionPcap struct {
+	PcapFileID    int64  `json:"pcap_file_id,omitempty"`
+	ContainerPath string `json:"container_path"`
+	FileName      string `json:"file_name"`
+	FileSize      int64  `json:"file_size,omitempty"`
+	FileHash      string `json:"file_hash,omitempty"`
 }
 
-// SaveRound saves a completed round of planner-executor pipeline.
-func (s *Store) SaveRound(sessionID string, roundNum int, userQuery string, plan common.Plan, report, findings, opLog string) (int64, error) {
-	planJSON, _ := json.Marshal(plan)
-	res, err := s.db.Exec(
-		"INSERT INTO rounds (session_id, round_num, user_query, plan_json, table_schema, report, findings, operation_log) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
-		sessionID, roundNum, userQuery, string(planJSON), plan.TableSchema, report, findings, opLog,
-	)
-	if err != nil {
-		return 0, err
-	}
-	return res.LastInsertId()
+// Capture returns p as the capture description given to the agents.
+func (p SessionPcap) Capture() common.Capture {
+	return common.Capture{Name: p.FileName, Path: p.ContainerPath, Size: p.FileSize, SHA256: p.FileHash}
 }
 
-// GetRoundCount returns the number of rounds in a session.
-func (s *Store) GetRoundCount(sessionID string) (int, error) {
-	var count int
-	err := s.db.QueryRow("SELECT COUNT(*) FROM rounds WHERE session_id = ?", sessionID).Scan(&count)
-	return count, err
+// SessionIOC is an indicator together with the round that reported it.
+type SessionIOC struct {
+	RoundNum int
+	common.IOC
 }
 
-// SaveStep saves a completed step within a round.
-func (s *Store) SaveStep(roundID int64, stepID int, intent, findings, actions, status string) error {
-	_, err := s.db.Exec(
-		"INSERT INTO steps (round_id, step_id, intent, findings, actions, status) VALUES (?, ?, ?, ?, ?, ?)",
-		roundID, stepID, intent, findings, actions, status,
-	)
-	return err
+// Checkpoint statuses.
+const (
+	CheckpointRunning = "running"
+	CheckpointFailed  = "failed"
+)
+
+// Checkpoint is the persisted executor state of an unfinished round.
+type Checkpoint struct {
+	SessionID string
+	RoundNum  int
+	UserQuery string
+	State     common.PlanState
+	Status    string
+	Error     string
+	UpdatedAt string
 }
 
-// GetSessionHistory loads accumulated context from all previous rounds.
-func (s *Store) GetSessionHistory(sessionID string) (*common.SessionHistory, error) {
-	rows, err := s.db.Query(
-		"SELECT round_num, findings, operation_log, report FROM rounds WHERE session_id = ? ORDER BY round_num ASC",
-		sessionID,
-	)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
+// SessionInfo summarizes a session for listings.
+type SessionInfo struct {
+	ID         string `json:"id"`
+	PcapPath   string `json:"pcap_path"`              // first capture
+	PcapFileID int64  `json:"pcap_file_id,omitempty"` // first capture
+	PcapCount  int    `json:"pcap_count"`
+	CreatedAt  string `json:"created_at"`
+	UpdatedAt  string `json:"updated_at"`
+	RoundCount int    `json:"round_count"`
+	LastQuery  string `json:"last_query,omitempty"`
 
-	history := &common.SessionHistory{}
-	var allFindings []string
-	var allOpLogs []string
+	ParentSessionID string `json:"parent_session_id,omitempty"` // set for forks
+	ParentRound     int    `json:"parent_round,omitempty"`      // last round inherited from the parent
+}
 
-	for rows.Next() {
-		var roundNum int
-		var findings, opLog, report string
-		if err := rows.Scan(&roundNum, &findings, &opLog, &report); err != nil {
-			return nil, err
-		}
-		if findings != "" {
-			allFindings = append(allFindings, fmt.Sprintf("## Round %d\n%s", roundNum, findings))
-		}
-		if opLog != "" {
-			allOpLogs = append(allOpLogs, fmt.Sprintf("## Round %d\n%s", roundNum, opLog))
-		}
-		if report != "" {
-			history.AllReports = append(history.AllReports, report)
-			history.PreviousReport = report
-		}
-	}
+// RoundRecord is a completed round as handed to AppendRound.
+type RoundRecord struct {
+	SessionID    string
+	UserQuery    string
+	Plan         common.Plan
+	Report       string
+	Findings     string
+	OperationLog string
+	Steps        []common.StepRecord
+	IOCs         []common.IOC
 
-	if len(allFindings) > 0 {
-		result := ""
-		for _, f := range allFindings {
-			result += f + "\n\n"
-		}
-		history.Findings = result
-	}
-	if len(allOpLogs) > 0 {
-		result := ""
-		for _, l := range allOpLogs {
-			result += l + "\n\n"
-		}
-		history.OperationLog = result
-	}
+	// Checkpoint is the round number of the checkpoint this round completes.
+	// It is deleted in the same transaction; 0 deletes nothing.
+	Checkpoint int
+}
 
-	return history, rows.Err()
+// Round is a persisted planner-executor round.
+type Round struct {
+	ID           int64       `json:"-"`
+	SessionID    string      `json:"session_id"`
+	RoundNum     int         `json:"round_num"`
+	UserQuery    string      `json:"user_query"`
+	Plan         common.Plan `json:"plan"`
+	Report       string      `json:"report"`
+	Findings     string      `json:"findings"`
+	OperationLog string      `json:"operation_log"`
+	Digest       string      `json:"digest,omitempty"` // compact summary used in later rounds' history, generated on demand
+	CreatedAt    string      `json:"created_at"`
 }
@@ -0,0 +1,885 @@
+// Package storetest is the conformance suite for session.Store backends. A
+// backend's tests call Run with a function that returns a new, empty store:
+//
+//	func TestMemoryStore(t *testing.T) {
+//		storetest.Run(t, func(t *testing.T) session.Store {
+//			return session.NewMemoryStore()
+//		})
+//	}
+//
+// Every backend must pass the whole suite.
+package storetest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"pcap_agent/internal/common"
+	"pcap_agent/internal/events"
+	"pcap_agent/internal/session"
+	"pcap_agent/pkg/logger"
+)
+
+// Open returns a new, empty store. It is called once per subtest; the suite
+// closes the store when the subtest ends.
+type Open func(t *testing.T) session.Store
+
+// Run runs the conformance suite against the stores returned by open.
+func Run(t *testing.T, open Open) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, s session.Store)
+	}{
+		{"PcapFiles", testPcapFiles},
+		{"Sessions", testSessions},
+		{"SessionPcaps", testSessionPcaps},
+		{"Rounds", testRounds},
+		{"AppendRound", testAppendRound},
+		{"ConcurrentAppend", testConcurrentAppend},
+		{"StepsAndIOCs", testStepsAndIOCs},
+		{"Search", testSearch},
+		{"Checkpoints", testCheckpoints},
+		{"Events", testEvents},
+		{"EventLog", testEventLog},
+		{"Fork", testFork},
+		{"RestoreMetadata", testRestoreMetadata},
+		{"Delete", testDelete},
+		{"History", testHistory},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			s := open(t)
+			t.Cleanup(func() { s.Close() })
+			tc.fn(t, s)
+		})
+	}
+}
+
+func must(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newSession creates session id over a single unregistered capture.
+func newSession(t *testing.T, s session.Store, id string) {
+	t.Helper()
+	must(t, s.CreateSession(id, []session.SessionPcap{{ContainerPath: "/home/linuxbrew/" + id + ".pcap"}}))
+}
+
+// saveRound saves round n of session id with text derived from n and returns its ID.
+func saveRound(t *testing.T, s session.Store, id string, n int, text string) int64 {
+	t.Helper()
+	plan := common.Plan{Thought: "plan " + text, TableSchema: "schema", Steps: []common.Step{{StepID: 1, Intent: "look at " + text}}}
+	roundID, err := s.SaveRound(id, n, "query "+text, plan, "report "+text, "findings "+text, "oplog "+text)
+	must(t, err)
+	return roundID
+}
+
+func testPcapFiles(t *testing.T, s session.Store) {
+	if f, err := s.GetPcapFileByHash("nope"); err != nil || f != nil {
+		t.Fatalf("GetPcapFileByHash(missing) = %v, %v; want nil, nil", f, err)
+	}
+	id, err := s.SavePcapFile("a.pcap", "/tmp/a.pcap", 100, "aaaa")
+	must(t, err)
+	if id <= 0 {
+		t.Fatalf("SavePcapFile id = %d; want > 0", id)
+	}
+	f, err := s.GetPcapFileByHash("aaaa")
+	must(t, err)
+	if f == nil || f.ID != id || f.FileName != "a.pcap" || f.FilePath != "/tmp/a.pcap" || f.FileSize != 100 || f.CreatedAt == "" {
+		t.Fatalf("GetPcapFileByHash = %+v", f)
+	}
+	if _, err := s.SavePcapFile("copy.pcap", "/tmp/copy.pcap", 100, "aaaa"); err == nil {
+		t.Fatal("SavePcapFile with a duplicate hash succeeded")
+	}
+	// Empty hashes are not unique.
+	for i := 0; i < 2; i++ {
+		if _, err := s.SavePcapFile("nohash.pcap", "/tmp/nohash.pcap", 1, ""); err != nil {
+			t.Fatalf("SavePcapFile without hash #%d: %v", i+1, err)
+		}
+	}
+
+	files, err := s.ListPcapFiles()
+	must(t, err)
+	if len(files) != 3 {
+		t.Fatalf("ListPcapFiles returned %d files; want 3", len(files))
+	}
+	if files[0].ID < files[len(files)-1].ID {
+		t.Errorf("ListPcapFiles is not newest first: %d before %d", files[0].ID, files[len(files)-1].ID)
+	}
+	for _, f := range files {
+		if f.SessionIDs == nil || len(f.SessionIDs) != 0 {
+			t.Errorf("unused file %d has SessionIDs %#v; want empty", f.ID, f.SessionIDs)
+		}
+	}
+}
+
+func testSessions(t *testing.T, s session.Store) {
+	if err := s.CreateSession("empty", nil); err == nil {
+		t.Fatal("CreateSession without captures succeeded")
+	}
+	if ok, err := s.SessionExists("a"); err != nil || ok {
+		t.Fatalf("SessionExists(missing) = %v, %v", ok, err)
+	}
+	if info, err := s.GetSession("a"); err != nil || info != nil {
+		t.Fatalf("GetSession(missing) = %v, %v", info, err)
+	}
+
+	newSession(t, s, "a")
+	newSession(t, s, "b")
+	if err := s.CreateSession("a", []session.SessionPcap{{ContainerPath: "/x.pcap"}}); err == nil {
+		t.Fatal("CreateSession with a duplicate ID succeeded")
+	}
+	if ok, err := s.SessionExists("a"); err != nil || !ok {
+		t.Fatalf("SessionExists(a) = %v, %v", ok, err)
+	}
+
+	info, err := s.GetSession("a")
+	must(t, err)
+	if info == nil || info.ID != "a" || info.PcapPath != "/home/linuxbrew/a.pcap" || info.PcapCount != 1 ||
+		info.RoundCount != 0 || info.LastQuery != "" || info.CreatedAt == "" || info.UpdatedAt == "" || info.ParentSessionID != "" {
+		t.Fatalf("GetSession(a) = %+v", info)
+	}
+
+	// Touching a session moves it to the front of the listing.
+	must(t, s.TouchSession("a"))
+	list, err := s.ListSessions()
+	must(t, err)
+	if len(list) != 2 || list[0].ID != "a" || list[1].ID != "b" {
+		t.Fatalf("ListSessions = %+v; want a, b", list)
+	}
+	must(t, s.RestoreSessionMetadata(session.SessionInfo{ID: "a", UpdatedAt: "2020-01-01T00:00:00Z"}))
+	list, err = s.ListSessions()
+	must(t, err)
+	if len(list) != 2 || list[0].ID != "b" {
+		t.Fatalf("ListSessions after backdating a = %+v; want b first", list)
+	}
+	must(t, s.TouchSession("missing"))
+}
+
+func testSessionPcaps(t *testing.T, s session.Store) {
+	fileID, err := s.SavePcapFile("first.pcap", "/local/first.pcap", 10, "h1")
+	must(t, err)
+	must(t, s.CreateSession("a", []session.SessionPcap{
+		{PcapFileID: fileID, ContainerPath: "/c/first.pcap"},
+		{ContainerPath: "/c/unregistered.pcap"},
+	}))
+	must(t, s.AddSessionPcap("a", session.SessionPcap{ContainerPath: "/c/third.pcap"}))
+	must(t, s.AddSessionPcap("a", session.SessionPcap{ContainerPath: "/c/first.pcap"})) // no-op
+
+	pcaps, err := s.GetSessionPcaps("a")
+	must(t, err)
+	want := []session.SessionPcap{
+		{PcapFileID: fileID, ContainerPath: "/c/first.pcap", FileName: "first.pcap", FileSize: 10, FileHash: "h1"},
+		{ContainerPath: "/c/unregistered.pcap", FileName: "unregistered.pcap"},
+		{ContainerPath: "/c/third.pcap", FileName: "third.pcap"},
+	}
+	if len(pcaps) != len(want) {
+		t.Fatalf("GetSessionPcaps = %+v; want %+v", pcaps, want)
+	}
+	for i := range want {
+		if pcaps[i] != want[i] {
+			t.Errorf("capture %d = %+v; want %+v", i, pcaps[i], want[i])
+		}
+	}
+
+	info, err := s.GetSession("a")
+	must(t, err)
+	if info.PcapPath != "/c/first.pcap" || info.PcapFileID != fileID || info.PcapCount != 3 {
+		t.Errorf("GetSession(a) = %+v; want first capture and 3 captures", info)
+	}
+
+	must(t, s.CreateSession("b", []session.SessionPcap{{PcapFileID: fileID, ContainerPath: "/c/first.pcap"}}))
+	ids, err := s.GetPcapFileSessions(fileID)
+	must(t, err)
+	if strings.Join(ids, ",") != "a,b" {
+		t.Errorf("GetPcapFileSessions = %v; want [a b]", ids)
+	}
+	files, err := s.ListPcapFiles()
+	must(t, err)
+	if len(files) != 1 || strings.Join(files[0].SessionIDs, ",") != "a,b" {
+		t.Errorf("ListPcapFiles = %+v; want one file used by a, b", files)
+	}
+}
+
+func testRounds(t *testing.T, s session.Store) {
+	newSession(t, s, "a")
+	if n, err := s.GetRoundCount("a"); err != nil || n != 0 {
+		t.Fatalf("GetRoundCount(empty) = %d, %v", n, err)
+	}
+	id1 := saveRound(t, s, "a", 1, "one")
+	id2 := saveRound(t, s, "a", 2, "two")
+	if id1 <= 0 || id2 <= 0 || id1 == id2 {
+		t.Fatalf("round IDs %d, %d; want distinct positive IDs", id1, id2)
+	}
+	if n, err := s.GetRoundCount("a"); err != nil || n != 2 {
+		t.Fatalf("GetRoundCount = %d, %v; want 2", n, err)
+	}
+
+	rounds, err := s.GetRounds("a")
+	must(t, err)
+	if len(rounds) != 2 || rounds[0].RoundNum != 1 || rounds[1].RoundNum != 2 {
+		t.Fatalf("GetRounds = %+v", rounds)
+	}
+	r := rounds[0]
+	if r.ID != id1 || r.SessionID != "a" || r.UserQuery != "query one" || r.Report != "report one" ||
+		r.Findings != "findings one" || r.OperationLog != "oplog one" || r.Digest != "" || r.CreatedAt == "" {
+		t.Errorf("round 1 = %+v", r)
+	}
+	if r.Plan.Thought != "plan one" || r.Plan.TableSchema != "schema" || len(r.Plan.Steps) != 1 || r.Plan.Steps[0].Intent != "look at one" {
+		t.Errorf("round 1 plan = %+v", r.Plan)
+	}
+
+	if got, err := s.GetRound("a", 3); err != nil || got != nil {
+		t.Fatalf("GetRound(missing) = %v, %v", got, err)
+	}
+	must(t, s.SaveRoundDigest(id2, "digest two"))
+	must(t, s.RestoreRoundCreatedAt(id2, "2024-01-02 03:04:05"))
+	must(t, s.RestoreRoundCreatedAt(id2, "")) // no-op
+	got, err := s.GetRound("a", 2)
+	must(t, err)
+	if got == nil || got.ID != id2 || got.Digest != "digest two" || got.CreatedAt != "2024-01-02 03:04:05" {
+		t.Fatalf("GetRound(a, 2) = %+v", got)
+	}
+
+	info, err := s.GetSession("a")
+	must(t, err)
+	if info.RoundCount != 2 || info.LastQuery != "query two" {
+		t.Errorf("GetSession after two rounds = %+v", info)
+	}
+}
+
+func testAppendRound(t *testing.T, s session.Store) {
+	if _, _, err := s.AppendRound(session.RoundRecord{SessionID: "missing", UserQuery: "q"}); err == nil {
+		t.Fatal("AppendRound to a missing session succeeded")
+	}
+
+	newSession(t, s, "a")
+	must(t, s.RestoreSessionMetadata(session.SessionInfo{ID: "a", UpdatedAt: "2020-01-01T00:00:00Z"}))
+	must(t, s.SaveCheckpoint("a", 1, "q1", &common.PlanState{}))
+	roundID, roundNum, err := s.AppendRound(session.RoundRecord{
+		SessionID:    "a",
+		UserQuery:    "q1",
+		Plan:         common.Plan{Thought: "t1"},
+		Report:       "report kilo",
+		Findings:     "findings",
+		OperationLog: "log",
+		Steps:        []common.StepRecord{{StepID: 1, Intent: "i1", Findings: "step kilo"}, {StepID: 2, Intent: "i2"}},
+		IOCs:         []common.IOC{{Type: "ip", Value: "192.0.2.9"}, {Type: "ip", Value: "192.0.2.9"}},
+		Checkpoint:   1,
+	})
+	must(t, err)
+	if roundID <= 0 || roundNum != 1 {
+		t.Fatalf("AppendRound = %d, %d; want a round ID and round 1", roundID, roundNum)
+	}
+
+	r, err := s.GetRound("a", 1)
+	must(t, err)
+	if r == nil || r.ID != roundID || r.UserQuery != "q1" || r.Plan.Thought != "t1" || r.Report != "report kilo" ||
+		r.Findings != "findings" || r.OperationLog != "log" {
+		t.Errorf("appended round = %+v", r)
+	}
+	if steps, _ := s.GetRoundSteps("a", 1); len(steps) != 2 || steps[0].StepID != 1 || steps[1].StepID != 2 {
+		t.Errorf("appended steps = %+v", steps)
+	}
+	if iocs, _ := s.GetSessionIOCs("a"); len(iocs) != 1 {
+		t.Errorf("appended indicators = %+v; want one", iocs)
+	}
+	if cp, _ := s.GetLatestCheckpoint("a"); cp != nil {
+		t.Errorf("checkpoint of the appended round survived: %+v", cp)
+	}
+	info, err := s.GetSession("a")
+	must(t, err)
+	if info.UpdatedAt == "2020-01-01T00:00:00Z" || info.RoundCount != 1 {
+		t.Errorf("session after AppendRound = %+v; want it touched with 1 round", info)
+	}
+	if hits, _ := s.Search("kilo", 0); len(hits) != 2 {
+		t.Errorf("Search(kilo) = %+v; want report and step hits", hits)
+	}
+
+	// Numbers continue after the highest round, and only the named checkpoint is deleted.
+	saveRound(t, s, "a", 5, "five")
+	must(t, s.SaveCheckpoint("a", 7, "q7", &common.PlanState{}))
+	_, roundNum, err = s.AppendRound(session.RoundRecord{SessionID: "a", UserQuery: "q6", Checkpoint: 6})
+	must(t, err)
+	if roundNum != 6 {
+		t.Errorf("AppendRound after round 5 = round %d; want 6", roundNum)
+	}
+	if cp, _ := s.GetLatestCheckpoint("a"); cp == nil || cp.RoundNum != 7 {
+		t.Errorf("unrelated checkpoint = %+v; want round 7 kept", cp)
+	}
+
+	// Rounds are numbered per session.
+	newSession(t, s, "b")
+	if _, n, err := s.AppendRound(session.RoundRecord{SessionID: "b", UserQuery: "q"}); err != nil || n != 1 {
+		t.Errorf("AppendRound to b = round %d, %v; want 1", n, err)
+	}
+}
+
+// testConcurrentAppend appends rounds to one session from several goroutines,
+// as separate processes sharing a database would, and expects consecutive,
+// distinct round numbers with every round's steps intact.
+func testConcurrentAppend(t *testing.T, s session.Store) {
+	const writers, perWriter = 4, 5
+	newSession(t, s, "a")
+
+	var wg sync.WaitGroup
+	errs := make(chan error, writers*perWriter)
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWriter; i++ {
+				q := fmt.Sprintf("writer %d round %d", w, i)
+				_, _, err := s.AppendRound(session.RoundRecord{
+					SessionID: "a",
+					UserQuery: q,
+					Steps:     []common.StepRecord{{StepID: 1, Intent: q}},
+				})
+				if err != nil {
+					errs <- err
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("concurrent AppendRound: %v", err)
+	}
+
+	rounds, err := s.GetRounds("a")
+	must(t, err)
+	if len(rounds) != writers*perWriter {
+		t.Fatalf("got %d rounds; want %d", len(rounds), writers*perWriter)
+	}
+	for i, r := range rounds {
+		if r.RoundNum != i+1 {
+			t.Fatalf("round %d has number %d; want consecutive numbers", i+1, r.RoundNum)
+		}
+		steps, err := s.GetRoundSteps("a", r.RoundNum)
+		must(t, err)
+		if len(steps) != 1 || steps[0].Intent != r.UserQuery {
+			t.Errorf("round %d steps = %+v", r.RoundNum, steps)
+		}
+	}
+}
+
+func testStepsAndIOCs(t *testing.T, s session.Store) {
+	newSession(t, s, "a")
+	id1 := saveRound(t, s, "a", 1, "one")
+	id2 := saveRound(t, s, "a", 2, "two")
+
+	start := time.Date(2025, 3, 4, 5, 6, 7, 890000000, time.FixedZone("X", 3600))
+	steps := []common.StepRecord{
+		{StepID: 2, Intent: "second", Findings: "f2", Actions: "a2", Status: "completed",
+			StartedAt: start, FinishedAt: start.Add(time.Minute), ToolCalls: 3, PromptTokens: 10, CompletionTokens: 5, TotalTokens: 15},
+		{StepID: 1, Intent: "first", Status: "skipped"},
+	}
+	for _, rec := range steps {
+		must(t, s.SaveStep(id1, rec))
+	}
+	got, err := s.GetRoundSteps("a", 1)
+	must(t, err)
+	if len(got) != 2 || got[0].StepID != 2 || got[1].StepID != 1 {
+		t.Fatalf("GetRoundSteps = %+v; want execution order 2, 1", got)
+	}
+	g := got[0]
+	if g.Intent != "second" || g.Findings != "f2" || g.Actions != "a2" || g.Status != "completed" ||
+		g.ToolCalls != 3 || g.PromptTokens != 10 || g.CompletionTokens != 5 || g.TotalTokens != 15 {
+		t.Errorf("step 2 = %+v", g)
+	}
+	if !g.StartedAt.Equal(start) || !g.FinishedAt.Equal(start.Add(time.Minute)) {
+		t.Errorf("step 2 times = %v, %v; want %v, %v", g.StartedAt, g.FinishedAt, start, start.Add(time.Minute))
+	}
+	if !got[1].StartedAt.IsZero() || !got[1].FinishedAt.IsZero() {
+		t.Errorf("step 1 times = %v, %v; want zero", got[1].StartedAt, got[1].FinishedAt)
+	}
+	if none, err := s.GetRoundSteps("a", 2); err != nil || len(none) != 0 {
+		t.Errorf("GetRoundSteps(round without steps) = %v, %v", none, err)
+	}
+
+	must(t, s.SaveIOCs(id2, []common.IOC{{Type: "ip", Value: "10.0.0.2", StepIDs: []int{1}}}))
+	must(t, s.SaveIOCs(id1, []common.IOC{
+		{Type: "ip", Value: "10.0.0.1", Context: "c2", StepIDs: []int{1, 2}},
+		{Type: "domain", Value: "evil.example"},
+	}))
+	must(t, s.SaveIOCs(id1, []common.IOC{{Type: "ip", Value: "10.0.0.1", Context: "duplicate"}}))
+
+	iocs, err := s.GetSessionIOCs("a")
+	must(t, err)
+	if len(iocs) != 3 {
+		t.Fatalf("GetSessionIOCs = %+v; want 3 indicators", iocs)
+	}
+	first := iocs[0]
+	if first.RoundNum != 1 || first.Type != "ip" || first.Value != "10.0.0.1" || first.Context != "c2" ||
+		len(first.StepIDs) != 2 || first.StepIDs[0] != 1 || first.StepIDs[1] != 2 {
+		t.Errorf("first indicator = %+v", first)
+	}
+	if iocs[1].RoundNum != 1 || iocs[1].Value != "evil.example" || iocs[2].RoundNum != 2 || iocs[2].Value != "10.0.0.2" {
+		t.Errorf("indicator order = %+v", iocs)
+	}
+}
+
+func testSearch(t *testing.T, s session.Store) {
+	newSession(t, s, "a")
+	newSession(t, s, "b")
+	roundID, err := s.SaveRound("a", 1, "who talked to 203.0.113.7", common.Plan{}, "", "", "")
+	must(t, err)
+	must(t, s.SaveStep(roundID, common.StepRecord{StepID: 1, Intent: "x", Findings: "beacon to 203.0.113.7 every 60s over tls"}))
+	_, err = s.SaveRound("b", 1, "dns tunnelling", common.Plan{}, "no beacon seen", "", "")
+	must(t, err)
+
+	if _, err := s.Search("   ", 0); err == nil {
+		t.Error("Search with an empty query succeeded")
+	}
+
+	hits, err := s.Search("203.0.113.7", 0)
+	must(t, err)
+	if len(hits) != 2 {
+		t.Fatalf("Search(ip) = %+v; want 2 hits", hits)
+	}
+	fields := map[string]session.SearchHit{}
+	for _, h := range hits {
+		if h.SessionID != "a" || h.RoundNum != 1 {
+			t.Errorf("hit %+v; want session a round 1", h)
+		}
+		if !strings.Contains(h.Snippet, "[") || !strings.Contains(h.Snippet, "]") || !strings.Contains(h.Snippet, "203") {
+			t.Errorf("snippet %q does not mark the match", h.Snippet)
+		}
+		fields[h.Field] = h
+	}
+	if _, ok := fields[session.SearchFieldQuery]; !ok {
+		t.Errorf("no hit in the query: %+v", hits)
+	}
+	if h, ok := fields[session.SearchFieldStep]; !ok || h.StepID != 1 {
+		t.Errorf("no hit in step 1 findings: %+v", hits)
+	}
+
+	// Every term must match.
+	hits, err = s.Search("beacon tls", 0)
+	must(t, err)
+	if len(hits) != 1 || hits[0].Field != session.SearchFieldStep {
+		t.Errorf("Search(beacon tls) = %+v; want the step hit only", hits)
+	}
+	hits, err = s.Search("BEACON", 0)
+	must(t, err)
+	if len(hits) != 2 {
+		t.Errorf("Search(BEACON) = %+v; want 2 case-insensitive hits", hits)
+	}
+	hits, err = s.Search("beacon", 1)
+	must(t, err)
+	if len(hits) != 1 {
+		t.Errorf("Search with limit 1 returned %d hits", len(hits))
+	}
+	hits, err = s.Search("nothing-like-this", 0)
+	must(t, err)
+	if len(hits) != 0 {
+		t.Errorf("Search(no match) = %+v", hits)
+	}
+}
+
+func testCheckpoints(t *testing.T, s session.Store) {
+	newSession(t, s, "a")
+	if cp, err := s.GetLatestCheckpoint("a"); err != nil || cp != nil {
+		t.Fatalf("GetLatestCheckpoint(none) = %v, %v", cp, err)
+	}
+	state := &common.PlanState{Plan: common.Plan{Thought: "t", Steps: []common.Step{{StepID: 1, Intent: "i"}}}}
+	must(t, s.SaveCheckpoint("a", 1, "q1", state))
+	must(t, s.SaveCheckpoint("a", 2, "q2", state))
+	must(t, s.FailCheckpoint("a", 2, "boom"))
+
+	cp, err := s.GetLatestCheckpoint("a")
+	must(t, err)
+	if cp == nil || cp.SessionID != "a" || cp.RoundNum != 2 || cp.UserQuery != "q2" ||
+		cp.Status != session.CheckpointFailed || cp.Error != "boom" || cp.UpdatedAt == "" {
+		t.Fatalf("GetLatestCheckpoint = %+v", cp)
+	}
+	if cp.State.Plan.Thought != "t" || len(cp.State.Plan.Steps) != 1 {
+		t.Errorf("checkpoint state = %+v", cp.State)
+	}
+
+	// Saving again resumes the round and clears the error.
+	must(t, s.SaveCheckpoint("a", 2, "q2 again", state))
+	cp, err = s.GetLatestCheckpoint("a")
+	must(t, err)
+	if cp.Status != session.CheckpointRunning || cp.Error != "" || cp.UserQuery != "q2 again" {
+		t.Errorf("checkpoint after re-save = %+v", cp)
+	}
+
+	must(t, s.DeleteCheckpoint("a", 2))
+	cp, err = s.GetLatestCheckpoint("a")
+	must(t, err)
+	if cp == nil || cp.RoundNum != 1 {
+		t.Fatalf("GetLatestCheckpoint after deleting round 2 = %+v; want round 1", cp)
+	}
+	must(t, s.DeleteCheckpoint("a", 1))
+	if cp, err := s.GetLatestCheckpoint("a"); err != nil || cp != nil {
+		t.Errorf("GetLatestCheckpoint after deleting all = %v, %v", cp, err)
+	}
+}
+
+func testEvents(t *testing.T, s session.Store) {
+	newSession(t, s, "a")
+	newSession(t, s, "b")
+	if err := s.AppendEvents("missing", []events.Event{{Seq: 1, Type: events.TypeInfo}}); err == nil {
+		t.Error("AppendEvents to a missing session succeeded")
+	}
+	if evs, err := s.GetEvents("a", 0); err != nil || len(evs) != 0 {
+		t.Fatalf("GetEvents on an empty log = %+v, %v", evs, err)
+	}
+	if seq, err := s.LastEventSeq("a"); err != nil || seq != 0 {
+		t.Errorf("LastEventSeq on an empty log = %d, %v", seq, err)
+	}
+	must(t, s.AppendEvents("a", nil))
+
+	ts := time.Date(2026, 3, 4, 5, 6, 7, 891000000, time.UTC)
+	ctx := logger.WithRun(context.Background(), "a", 2, "run-a2")
+	var batch []events.Event
+	for i := 1; i <= 3; i++ {
+		ev := events.NewCtxEvent(logger.WithStep(ctx, i), events.TypeStepStarted, events.StepStartedData{StepID: i, Intent: fmt.Sprintf("step %d", i)})
+		ev.Seq = int64(i)
+		ev.Timestamp = ts.Add(time.Duration(i) * time.Second)
+		batch = append(batch, ev)
+	}
+	must(t, s.AppendEvents("a", batch[:1]))
+	must(t, s.AppendEvents("a", batch[1:]))
+	must(t, s.AppendEvents("b", []events.Event{{Seq: 1, Type: events.TypeInfo}}))
+	if seq, err := s.LastEventSeq("a"); err != nil || seq != 3 {
+		t.Errorf("LastEventSeq = %d, %v; want 3", seq, err)
+	}
+
+	// A batch reusing a logged number is refused as a whole.
+	if err := s.AppendEvents("a", []events.Event{{Seq: 4, Type: events.TypeInfo}, {Seq: 3, Type: events.TypeInfo}}); err == nil {
+		t.Error("AppendEvents with a taken seq succeeded")
+	}
+	if err := s.AppendEvents("a", []events.Event{{Seq: 2, Type: events.TypeInfo}}); err == nil {
+		t.Error("AppendEvents with a taken seq succeeded")
+	}
+
+	evs, err := s.GetEvents("a", 0)
+	must(t, err)
+	if len(evs) != 3 {
+		t.Fatalf("got %d events; want 3", len(evs))
+	}
+	for i, ev := range evs {
+		var d events.StepStartedData
+		must(t, json.Unmarshal(ev.Data, &d))
+		if ev.Seq != int64(i+1) || ev.SessionID != "a" || ev.Type != events.TypeStepStarted || d.StepID != i+1 {
+			t.Errorf("event %d = %+v (%+v)", i+1, ev, d)
+		}
+		if ev.Round != 2 || ev.RunID != "run-a2" || ev.StepID != i+1 {
+			t.Errorf("event %d correlation = round %d run %q step %d; want round 2 run \"run-a2\" step %d", i+1, ev.Round, ev.RunID, ev.StepID, i+1)
+		}
+		if want := ts.Add(time.Duration(i+1) * time.Second); !ev.Timestamp.Equal(want) {
+			t.Errorf("event %d timestamp = %v; want %v", i+1, ev.Timestamp, want)
+		}
+	}
+	if evs, _ := s.GetEvents("a", 2); len(evs) != 1 || evs[0].Seq != 3 {
+		t.Errorf("GetEvents after 2 = %+v", evs)
+	}
+	if evs, _ := s.GetEvents("a", 3); len(evs) != 0 {
+		t.Errorf("GetEvents after the last event = %+v", evs)
+	}
+	if evs, _ := s.GetEvents("b", 0); len(evs) != 1 || string(evs[0].Data) != "null" {
+		t.Errorf("event without data = %+v", evs)
+	}
+}
+
+// testEventLog checks that a subscriber joining mid-stream sees every event
+// exactly once and in order: the logged ones first, then the live ones.
+func testEventLog(t *testing.T, s session.Store) {
+	const total = 200
+	newSession(t, s, "a")
+	log := session.NewEventLog(s, "a")
+	defer log.Close()
+
+	emitted := make(chan struct{})
+	go func() {
+		defer close(emitted)
+		for i := 1; i <= total; i++ {
+			log.Emit(events.NewEvent(events.TypeReportDelta, "", events.ReportDeltaData{Seq: i}))
+		}
+	}()
+
+	time.Sleep(time.Millisecond)
+	ch, cancel, err := log.SubscribeFrom(0, nil)
+	must(t, err)
+	defer cancel()
+	timeout := time.After(10 * time.Second)
+	for want := int64(1); want <= total; want++ {
+		select {
+		case ev, ok := <-ch:
+			if !ok {
+				t.Fatalf("stream closed after %d events", want-1)
+			}
+			if ev.Seq != want || ev.SessionID != "a" {
+				t.Fatalf("got event seq %d session %q; want seq %d", ev.Seq, ev.SessionID, want)
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for event %d", want)
+		}
+	}
+	<-emitted
+
+	ch2, cancel2, err := log.SubscribeFrom(total-1, nil)
+	must(t, err)
+	if ev := <-ch2; ev.Seq != total {
+		t.Errorf("SubscribeFrom(%d) started at seq %d", total-1, ev.Seq)
+	}
+	cancel2()
+	if _, ok := <-ch2; ok {
+		t.Error("stream still open after cancel")
+	}
+
+	log.Close()
+	if _, ok := <-ch; ok {
+		t.Error("stream still open after Close")
+	}
+	// Close writes the buffered events; a new log continues the numbering.
+	if evs, err := s.GetEvents("a", 0); err != nil || len(evs) != total {
+		t.Fatalf("logged %d events, %v; want %d", len(evs), err, total)
+	}
+	log2 := session.NewEventLog(s, "a")
+	log2.Emit(events.NewEvent(events.TypeInfo, "", nil))
+	log2.Flush()
+	if evs, _ := s.GetEvents("a", total); len(evs) != 1 || evs[0].Seq != total+1 {
+		t.Errorf("event of a reopened log = %+v", evs)
+	}
+	log2.Close()
+}
+
+func testFork(t *testing.T, s session.Store) {
+	must(t, s.CreateSession("src", []session.SessionPcap{{ContainerPath: "/c/1.pcap"}, {ContainerPath: "/c/2.pcap"}}))
+	id1 := saveRound(t, s, "src", 1, "alpha")
+	saveRound(t, s, "src", 2, "bravo")
+	must(t, s.SaveStep(id1, common.StepRecord{StepID: 1, Intent: "i", Findings: "found alpha"}))
+	must(t, s.SaveIOCs(id1, []common.IOC{{Type: "ip", Value: "192.0.2.1"}}))
+	must(t, s.SaveRoundDigest(id1, "digest alpha"))
+	must(t, s.SaveCheckpoint("src", 3, "unfinished", &common.PlanState{}))
+
+	if err := s.ForkSession("missing", 0, "x"); err == nil {
+		t.Error("ForkSession of a missing session succeeded")
+	}
+	if err := s.ForkSession("src", 3, "x"); err == nil {
+		t.Error("ForkSession past the last round succeeded")
+	}
+	if err := s.ForkSession("src", -1, "x"); err == nil {
+		t.Error("ForkSession at a negative round succeeded")
+	}
+	if ok, _ := s.SessionExists("x"); ok {
+		t.Error("failed ForkSession left a session behind")
+	}
+
+	must(t, s.ForkSession("src", 1, "fork"))
+	info, err := s.GetSession("fork")
+	must(t, err)
+	if info == nil || info.ParentSessionID != "src" || info.ParentRound != 1 || info.RoundCount != 1 ||
+		info.PcapCount != 2 || info.PcapPath != "/c/1.pcap" || info.LastQuery != "query alpha" {
+		t.Fatalf("GetSession(fork) = %+v", info)
+	}
+	pcaps, err := s.GetSessionPcaps("fork")
+	must(t, err)
+	if len(pcaps) != 2 || pcaps[1].ContainerPath != "/c/2.pcap" {
+		t.Errorf("fork captures = %+v", pcaps)
+	}
+
+	src, err := s.GetRound("src", 1)
+	must(t, err)
+	r, err := s.GetRound("fork", 1)
+	must(t, err)
+	if r == nil || r.ID == src.ID || r.SessionID != "fork" || r.UserQuery != "query alpha" ||
+		r.Digest != "digest alpha" || r.CreatedAt != src.CreatedAt || r.Plan.Thought != "plan alpha" {
+		t.Errorf("forked round = %+v", r)
+	}
+	if steps, _ := s.GetRoundSteps("fork", 1); len(steps) != 1 || steps[0].Findings != "found alpha" {
+		t.Errorf("forked steps = %+v", steps)
+	}
+	if iocs, _ := s.GetSessionIOCs("fork"); len(iocs) != 1 || iocs[0].Value != "192.0.2.1" {
+		t.Errorf("forked indicators = %+v", iocs)
+	}
+	if cp, _ := s.GetLatestCheckpoint("fork"); cp != nil {
+		t.Errorf("fork inherited checkpoint %+v", cp)
+	}
+	hits, err := s.Search("alpha", 0)
+	must(t, err)
+	inFork := 0
+	for _, h := range hits {
+		if h.SessionID == "fork" {
+			inFork++
+		}
+	}
+	if inFork == 0 {
+		t.Errorf("fork is not searchable: %+v", hits)
+	}
+
+	// Writing to the fork leaves the source alone.
+	saveRound(t, s, "fork", 2, "charlie")
+	if r, _ := s.GetRound("src", 2); r == nil || r.UserQuery != "query bravo" {
+		t.Errorf("source round 2 = %+v", r)
+	}
+
+	must(t, s.ForkSession("fork", 2, "grandchild"))
+	must(t, s.ForkSession("src", 0, "empty"))
+	if info, _ := s.GetSession("empty"); info == nil || info.RoundCount != 0 || info.ParentRound != 0 {
+		t.Errorf("fork at round 0 = %+v", info)
+	}
+
+	forks, err := s.GetSessionForks("src")
+	must(t, err)
+	if len(forks) != 2 || forks[0].ID == forks[1].ID || (forks[0].ID != "fork" && forks[0].ID != "empty") {
+		t.Errorf("GetSessionForks(src) = %+v; want fork and empty", forks)
+	}
+	lineage, err := session.GetSessionLineage(s, "grandchild")
+	must(t, err)
+	var ids []string
+	for _, l := range lineage {
+		ids = append(ids, l.ID)
+	}
+	if strings.Join(ids, ",") != "src,fork,grandchild" {
+		t.Errorf("GetSessionLineage = %v", ids)
+	}
+
+	// Deleting a session detaches its forks, which keep their rounds.
+	ok, err := s.DeleteSession("fork")
+	must(t, err)
+	if !ok {
+		t.Fatal("DeleteSession(fork) = false")
+	}
+	info, err = s.GetSession("grandchild")
+	must(t, err)
+	if info == nil || info.ParentSessionID != "" || info.ParentRound != 0 || info.RoundCount != 2 {
+		t.Errorf("orphaned fork = %+v", info)
+	}
+	if r, _ := s.GetRound("grandchild", 2); r == nil || r.UserQuery != "query charlie" {
+		t.Errorf("orphaned fork round 2 = %+v", r)
+	}
+	if lineage, _ := session.GetSessionLineage(s, "grandchild"); len(lineage) != 1 {
+		t.Errorf("GetSessionLineage after parent delete = %+v", lineage)
+	}
+	if forks, _ := s.GetSessionForks("fork"); len(forks) != 0 {
+		t.Errorf("GetSessionForks(deleted) = %+v", forks)
+	}
+}
+
+func testRestoreMetadata(t *testing.T, s session.Store) {
+	newSession(t, s, "a")
+	must(t, s.RestoreSessionMetadata(session.SessionInfo{
+		ID: "a", CreatedAt: "2024-05-06 07:08:09", UpdatedAt: "2024-05-07T00:00:00Z", ParentSessionID: "gone", ParentRound: 4,
+	}))
+	info, err := s.GetSession("a")
+	must(t, err)
+	if info.CreatedAt != "2024-05-06 07:08:09" || info.UpdatedAt != "2024-05-07T00:00:00Z" ||
+		info.ParentSessionID != "gone" || info.ParentRound != 4 {
+		t.Fatalf("GetSession after restore = %+v", info)
+	}
+
+	// Empty timestamps are kept; the parent is cleared.
+	must(t, s.RestoreSessionMetadata(session.SessionInfo{ID: "a"}))
+	info, err = s.GetSession("a")
+	must(t, err)
+	if info.CreatedAt != "2024-05-06 07:08:09" || info.UpdatedAt != "2024-05-07T00:00:00Z" ||
+		info.ParentSessionID != "" || info.ParentRound != 0 {
+		t.Fatalf("GetSession after second restore = %+v", info)
+	}
+}
+
+func testDelete(t *testing.T, s session.Store) {
+	fileID, err := s.SavePcapFile("a.pcap", "/a.pcap", 1, "ha")
+	must(t, err)
+	must(t, s.CreateSession("a", []session.SessionPcap{{PcapFileID: fileID, ContainerPath: "/c/a.pcap"}}))
+	newSession(t, s, "keep")
+	roundID := saveRound(t, s, "a", 1, "zulu")
+	must(t, s.SaveStep(roundID, common.StepRecord{StepID: 1, Intent: "i", Findings: "zulu step"}))
+	must(t, s.SaveIOCs(roundID, []common.IOC{{Type: "ip", Value: "198.51.100.1"}}))
+	must(t, s.SaveCheckpoint("a", 2, "q", &common.PlanState{}))
+	ev := events.NewEvent(events.TypeInfo, "a", events.InfoData{Message: "m"})
+	ev.Seq = 1
+	must(t, s.AppendEvents("a", []events.Event{ev}))
+	saveRound(t, s, "keep", 1, "yankee")
+
+	ok, err := s.DeleteSession("a")
+	must(t, err)
+	if !ok {
+		t.Fatal("DeleteSession(a) = false")
+	}
+	if ok, err := s.DeleteSession("a"); err != nil || ok {
+		t.Errorf("second DeleteSession(a) = %v, %v; want false", ok, err)
+	}
+	if info, _ := s.GetSession("a"); info != nil {
+		t.Errorf("deleted session still listed: %+v", info)
+	}
+	if n, _ := s.GetRoundCount("a"); n != 0 {
+		t.Errorf("deleted session has %d rounds", n)
+	}
+	if steps, _ := s.GetRoundSteps("a", 1); len(steps) != 0 {
+		t.Errorf("deleted session has steps %+v", steps)
+	}
+	if iocs, _ := s.GetSessionIOCs("a"); len(iocs) != 0 {
+		t.Errorf("deleted session has indicators %+v", iocs)
+	}
+	if cp, _ := s.GetLatestCheckpoint("a"); cp != nil {
+		t.Errorf("deleted session has checkpoint %+v", cp)
+	}
+	if pcaps, _ := s.GetSessionPcaps("a"); len(pcaps) != 0 {
+		t.Errorf("deleted session has captures %+v", pcaps)
+	}
+	if evs, _ := s.GetEvents("a", 0); len(evs) != 0 {
+		t.Errorf("deleted session has events %+v", evs)
+	}
+	if hits, _ := s.Search("zulu", 0); len(hits) != 0 {
+		t.Errorf("deleted session is still searchable: %+v", hits)
+	}
+	if ids, _ := s.GetPcapFileSessions(fileID); len(ids) != 0 {
+		t.Errorf("capture still linked to %v", ids)
+	}
+	if f, _ := s.GetPcapFileByHash("ha"); f == nil {
+		t.Error("DeleteSession removed the registered capture")
+	}
+
+	if list, _ := s.ListSessions(); len(list) != 1 || list[0].ID != "keep" {
+		t.Errorf("ListSessions after delete = %+v", list)
+	}
+	if hits, _ := s.Search("yankee", 0); len(hits) == 0 {
+		t.Error("other session is no longer searchable")
+	}
+}
+
+func testHistory(t *testing.T, s session.Store) {
+	newSession(t, s, "a")
+	h, err := session.GetSessionHistory(context.Background(), s, "a", nil)
+	must(t, err)
+	if h.PreviousReport != "" || h.Findings != "" || h.Digests != "" {
+		t.Errorf("history of an empty session = %+v", h)
+	}
+
+	for i, text := range []string{"one", "two", "three"} {
+		saveRound(t, s, "a", i+1, text)
+	}
+	h, err = session.GetSessionHistory(context.Background(), s, "a", &session.HistoryConfig{
+		RecentRounds: 1,
+		Digest: func(ctx context.Context, r session.Round) (string, error) {
+			return "digest of " + r.UserQuery, nil
+		},
+	})
+	must(t, err)
+	if h.PreviousReport != "report three" || !strings.Contains(h.Findings, "findings three") {
+		t.Errorf("history = %+v", h)
+	}
+	if !strings.Contains(h.Digests, "digest of query one") || !strings.Contains(h.Digests, "digest of query two") {
+		t.Errorf("history digests = %q", h.Digests)
+	}
+	// Generated digests are cached with the round.
+	r, err := s.GetRound("a", 1)
+	must(t, err)
+	if r.Digest != "digest of query one" {
+		t.Errorf("cached digest = %q", r.Digest)
+	}
+}
@@ -73,13 +73,16 @@ func (b *bashTool) InvokableRun(ctx context.Context, argumentsInJSON string, opt
 		return "command cannot be empty", nil
 	}
 	o := tool.GetImplSpecificOptions(&options{b.op}, opts...)
+	call := startToolCall(ctx, bashToolInfo.Name, input.Command)
 	cmd, err := o.op.RunCommand(ctx, []string{"bash", "-c", input.Command})
 	if err != nil {
+		call.fail(err)
 		if strings.HasPrefix(err.Error(), "internal error") {
 			return err.Error(), nil
 		}
 		return "", err
 	}
+	call.finish(cmd.ExitCode, cmd.Stdout, cmd.Stderr)
 	return FormatCommandOutput(cmd), nil
 }
 
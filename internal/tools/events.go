@@ -0,0 +1,113 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"pcap_agent/internal/common"
+	"pcap_agent/internal/events"
+
+	"github.com/cloudwego/eino-ext/components/tool/commandline"
+	"github.com/cloudwego/eino/components/tool"
+	"github.com/cloudwego/eino/compose"
+	"github.com/cloudwego/eino/schema"
+)
+
+const (
+	maxEventCommandLen = 1000 // command text kept in tool events
+	maxPreviewLen      = 500  // output kept in tool.finished previews
+)
+
+// toolCall reports one tool invocation as a tool.started and a tool.finished
+// event on the emitter carried by the context (see events.WithEmitter). Without
+// one, nothing is reported.
+type toolCall struct {
+	ctx     context.Context
+	emitter events.Emitter
+	start   time.Time
+	data    events.ToolFinishedData
+}
+
+// startToolCall emits tool.started and returns the call to finish.
+func startToolCall(ctx context.Context, toolName, command string) *toolCall {
+	c := &toolCall{
+		ctx:     ctx,
+		emitter: events.EmitterFrom(ctx),
+		start:   time.Now(),
+		data: events.ToolFinishedData{
+			CallID:  compose.GetToolCallID(ctx),
+			Tool:    toolName,
+			Command: common.TruncateStr(command, maxEventCommandLen),
+		},
+	}
+	c.emitter.Emit(events.NewCtxEvent(ctx, events.TypeToolStarted, events.ToolStartedData{
+		CallID:  c.data.CallID,
+		Tool:    c.data.Tool,
+		Command: c.data.Command,
+	}))
+	return c
+}
+
+// finish emits tool.finished for a call that exited with exitCode.
+func (c *toolCall) finish(exitCode int, stdout, stderr string) {
+	d := c.data
+	d.ExitCode = exitCode
+	d.DurationMs = time.Since(c.start).Milliseconds()
+	d.StdoutBytes = len(stdout)
+	d.StderrBytes = len(stderr)
+	// A failed command is best summed up by its error output.
+	preview := stdout
+	if strings.TrimSpace(preview) == "" || (exitCode != 0 && strings.TrimSpace(stderr) != "") {
+		preview = stderr
+	}
+	d.Preview = common.TruncateStr(preview, maxPreviewLen)
+	c.emitter.Emit(events.NewCtxEvent(c.ctx, events.TypeToolFinished, d))
+}
+
+// fail emits tool.finished for a call that could not be run.
+func (c *toolCall) fail(err error) {
+	d := c.data
+	d.ExitCode = -1
+	d.Error = err.Error()
+	d.DurationMs = time.Since(c.start).Milliseconds()
+	c.emitter.Emit(events.NewCtxEvent(c.ctx, events.TypeToolFinished, d))
+}
+
+// EditorEventsWrapper reports each call of a str_replace_editor as tool.started
+// and tool.finished events, with the editor command and path as the command
+// text. Wrap the editor with it before WrapToolSafe so failed calls are seen.
+type EditorEventsWrapper struct {
+	inner tool.InvokableTool
+}
+
+// WrapEditorEvents wraps a str_replace_editor so its calls are reported as events.
+func WrapEditorEvents(t tool.InvokableTool) tool.InvokableTool {
+	return &EditorEventsWrapper{inner: t}
+}
+
+func (w *EditorEventsWrapper) Info(ctx context.Context) (*schema.ToolInfo, error) {
+	return w.inner.Info(ctx)
+}
+
+func (w *EditorEventsWrapper) InvokableRun(ctx context.Context, argumentsInJSON string, opts ...tool.Option) (string, error) {
+	name := "str_replace_editor"
+	if info, err := w.inner.Info(ctx); err == nil && info != nil {
+		name = info.Name
+	}
+	var params commandline.StrReplaceEditorParams
+	command := argumentsInJSON
+	if err := json.Unmarshal([]byte(argumentsInJSON), &params); err == nil {
+		command = strings.TrimSpace(string(params.Command) + " " + params.Path)
+	}
+
+	call := startToolCall(ctx, name, command)
+	result, err := w.inner.InvokableRun(ctx, argumentsInJSON, opts...)
+	if err != nil {
+		call.finish(1, "", err.Error())
+		return "", err
+	}
+	call.finish(0, result, "")
+	return result, nil
+}
@@ -0,0 +1,135 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"pcap_agent/internal/events"
+	"pcap_agent/pkg/logger"
+
+	"github.com/cloudwego/eino-ext/components/tool/commandline"
+	"github.com/cloudwego/eino/components/tool"
+	"github.com/cloudwego/eino/schema"
+)
+
+// recorder keeps every emitted event.
+type recorder struct {
+	events.NopEmitter
+	evs []events.Event
+}
+
+func (r *recorder) Emit(ev events.Event) { r.evs = append(r.evs, ev) }
+
+// fakeOperator answers every command with out or err.
+type fakeOperator struct {
+	commandline.Operator
+	out *commandline.CommandOutput
+	err error
+}
+
+func (o *fakeOperator) RunCommand(context.Context, []string) (*commandline.CommandOutput, error) {
+	return o.out, o.err
+}
+
+// fakeEditor answers every call with result or err.
+type fakeEditor struct {
+	result string
+	err    error
+}
+
+func (e *fakeEditor) Info(context.Context) (*schema.ToolInfo, error) {
+	return &schema.ToolInfo{Name: "str_replace_editor"}, nil
+}
+
+func (e *fakeEditor) InvokableRun(context.Context, string, ...tool.Option) (string, error) {
+	return e.result, e.err
+}
+
+func TestToolEvents(t *testing.T) {
+	tests := []struct {
+		name    string
+		tool    tool.InvokableTool
+		args    string
+		command string
+		wantErr bool
+		want    events.ToolFinishedData // compared without DurationMs
+	}{
+		{
+			name: "bash", tool: NewBashTool(&fakeOperator{out: &commandline.CommandOutput{Stdout: "42\n"}}),
+			args: `{"command":"tshark -r a.pcap | wc -l"}`, command: "tshark -r a.pcap | wc -l",
+			want: events.ToolFinishedData{Tool: "bash", StdoutBytes: 3, Preview: "42\n"},
+		},
+		{
+			name: "bash exit status", tool: NewBashTool(&fakeOperator{out: &commandline.CommandOutput{Stdout: "partial", Stderr: "no such file", ExitCode: 2}}),
+			args: `{"command":"cat b.pcap"}`, command: "cat b.pcap",
+			want: events.ToolFinishedData{Tool: "bash", ExitCode: 2, StdoutBytes: 7, StderrBytes: 12, Preview: "no such file"},
+		},
+		{
+			name: "bash not run", tool: NewBashTool(&fakeOperator{err: errors.New("container is gone")}),
+			args: `{"command":"ls"}`, command: "ls", wantErr: true,
+			want: events.ToolFinishedData{Tool: "bash", ExitCode: -1, Error: "container is gone"},
+		},
+		{
+			// Internal errors go back to the model as the result, but are still reported.
+			name: "bash internal error", tool: NewBashTool(&fakeOperator{err: errors.New("internal error: exec timed out")}),
+			args: `{"command":"sleep 600"}`, command: "sleep 600",
+			want: events.ToolFinishedData{Tool: "bash", ExitCode: -1, Error: "internal error: exec timed out"},
+		},
+		{
+			name: "editor", tool: WrapEditorEvents(&fakeEditor{result: "file contents"}),
+			args: `{"command":"view","path":"/tmp/notes.md"}`, command: "view /tmp/notes.md",
+			want: events.ToolFinishedData{Tool: "str_replace_editor", StdoutBytes: 13, Preview: "file contents"},
+		},
+		{
+			name: "editor error", tool: WrapEditorEvents(&fakeEditor{err: errors.New("path does not exist")}),
+			args: `{"command":"view","path":"/nope"}`, command: "view /nope", wantErr: true,
+			want: events.ToolFinishedData{Tool: "str_replace_editor", ExitCode: 1, StderrBytes: 19, Preview: "path does not exist"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := &recorder{}
+			ctx := logger.WithStep(logger.WithRun(context.Background(), "sess_1", 2, "run1"), 3)
+			ctx = events.WithEmitter(ctx, rec)
+			if _, err := tt.tool.InvokableRun(ctx, tt.args); (err != nil) != tt.wantErr {
+				t.Fatalf("InvokableRun error = %v; want error %v", err, tt.wantErr)
+			}
+
+			if len(rec.evs) != 2 || rec.evs[0].Type != events.TypeToolStarted || rec.evs[1].Type != events.TypeToolFinished {
+				t.Fatalf("events = %+v; want tool.started then tool.finished", rec.evs)
+			}
+			for _, ev := range rec.evs {
+				if ev.SessionID != "sess_1" || ev.Round != 2 || ev.RunID != "run1" || ev.StepID != 3 {
+					t.Errorf("%s event IDs = %q %d %q %d", ev.Type, ev.SessionID, ev.Round, ev.RunID, ev.StepID)
+				}
+			}
+			var started events.ToolStartedData
+			if err := json.Unmarshal(rec.evs[0].Data, &started); err != nil {
+				t.Fatal(err)
+			}
+			if started != (events.ToolStartedData{Tool: tt.want.Tool, Command: tt.command}) {
+				t.Errorf("tool.started = %+v", started)
+			}
+			var finished events.ToolFinishedData
+			if err := json.Unmarshal(rec.evs[1].Data, &finished); err != nil {
+				t.Fatal(err)
+			}
+			finished.DurationMs = 0
+			tt.want.Command = tt.command
+			if finished != tt.want {
+				t.Errorf("tool.finished = %+v\nwant %+v", finished, tt.want)
+			}
+		})
+	}
+}
+
+// TestToolEventsWithoutEmitter checks that a context without an emitter is
+// not an error.
+func TestToolEventsWithoutEmitter(t *testing.T) {
+	bash := NewBashTool(&fakeOperator{out: &commandline.CommandOutput{Stdout: "ok"}})
+	if out, err := bash.InvokableRun(context.Background(), `{"command":"true"}`); err != nil || out != FormatCommandOutput(&commandline.CommandOutput{Stdout: "ok"}) {
+		t.Errorf("InvokableRun = %q, %v", out, err)
+	}
+}
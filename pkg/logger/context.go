@@ -0,0 +1,95 @@
+package logger
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Fields identify the investigation a log line or event belongs to: the
+// session, the round being run, a run ID unique to one attempt at that round
+// (a resumed round gets a new one) and, inside the executor, the plan step.
+// Zero values are omitted.
+type Fields struct {
+	SessionID string `json:"session_id,omitempty"`
+	Round     int    `json:"round,omitempty"`
+	RunID     string `json:"run_id,omitempty"`
+	StepID    int    `json:"step_id,omitempty"`
+}
+
+// String formats the non-zero fields as "session=… round=… run=… step=…".
+func (f Fields) String() string {
+	var parts []string
+	if f.SessionID != "" {
+		parts = append(parts, "session="+f.SessionID)
+	}
+	if f.Round > 0 {
+		parts = append(parts, fmt.Sprintf("round=%d", f.Round))
+	}
+	if f.RunID != "" {
+		parts = append(parts, "run="+f.RunID)
+	}
+	if f.StepID > 0 {
+		parts = append(parts, fmt.Sprintf("step=%d", f.StepID))
+	}
+	return strings.Join(parts, " ")
+}
+
+type fieldsKey struct{}
+
+// WithRun returns a context carrying the session, round and run ID. Any step ID
+// from the parent context is dropped.
+func WithRun(ctx context.Context, sessionID string, round int, runID string) context.Context {
+	return context.WithValue(ctx, fieldsKey{}, Fields{SessionID: sessionID, Round: round, RunID: runID})
+}
+
+// WithStep returns a context carrying the fields of ctx plus the plan step.
+func WithStep(ctx context.Context, stepID int) context.Context {
+	f := FieldsFrom(ctx)
+	f.StepID = stepID
+	return context.WithValue(ctx, fieldsKey{}, f)
+}
+
+// FieldsFrom returns the fields carried by ctx (zero if none).
+func FieldsFrom(ctx context.Context) Fields {
+	if ctx == nil {
+		return Fields{}
+	}
+	f, _ := ctx.Value(fieldsKey{}).(Fields)
+	return f
+}
+
+// NewRunID returns a random 16-character hex ID for one run of a round.
+func NewRunID() string {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(fmt.Sprintf("logger: read random run id: %v", err))
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// CtxInfof is Infof with the fields of ctx prepended to the message.
+func CtxInfof(ctx context.Context, format string, args ...interface{}) {
+	Infof("%s%s", ctxPrefix(ctx), fmt.Sprintf(format, args...))
+}
+
+// CtxWarnf is Warnf with the fields of ctx prepended to the message.
+func CtxWarnf(ctx context.Context, format string, args ...interface{}) {
+	Warnf("%s%s", ctxPrefix(ctx), fmt.Sprintf(format, args...))
+}
+
+// CtxErrorf is Errorf with the fields of ctx prepended to the message.
+func CtxErrorf(ctx context.Context, format string, args ...interface{}) {
+	Errorf("%s%s", ctxPrefix(ctx), fmt.Sprintf(format, args...))
+}
+
+// ctxPrefix returns "[fields] ", or "" if ctx carries none.
+func ctxPrefix(ctx context.Context) string {
+	s := FieldsFrom(ctx).String()
+	if s == "" {
+		return ""
+	}
+	return "[" + s + "] "
+}
@@ -0,0 +1,60 @@
+package logger
+
+import (
+	"context"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+// captureStdout returns what f prints to standard output.
+func captureStdout(t *testing.T, f func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+	f()
+	w.Close()
+	out, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+func TestContextFields(t *testing.T) {
+	ctx := WithStep(WithRun(context.Background(), "sess_1", 2, "abc"), 3)
+	if f := FieldsFrom(ctx); f != (Fields{SessionID: "sess_1", Round: 2, RunID: "abc", StepID: 3}) {
+		t.Errorf("FieldsFrom = %+v", f)
+	}
+	out := captureStdout(t, func() {
+		CtxInfof(ctx, "ran %d commands", 4)
+		CtxWarnf(WithRun(ctx, "sess_1", 3, "def"), "retrying")
+		CtxErrorf(context.Background(), "no fields")
+	})
+	for _, want := range []string{
+		"[INFO]", "[session=sess_1 round=2 run=abc step=3] ran 4 commands",
+		// A new run drops the step of the previous one.
+		"[WARN]", "[session=sess_1 round=3 run=def] retrying",
+		"[ERROR]", " no fields",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("log output lacks %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "[] ") {
+		t.Errorf("empty fields were printed:\n%s", out)
+	}
+}
+
+func TestNewRunID(t *testing.T) {
+	a, b := NewRunID(), NewRunID()
+	if len(a) != 16 || a == b {
+		t.Errorf("NewRunID() = %q, %q; want distinct 16-character IDs", a, b)
+	}
+}
@@ -14,10 +14,16 @@ import (
 type WrapperStruct struct {
 	LogType   string      `json:"LOGTYPE"`
 	Timestamp time.Time   `json:"@timestamp"`
+	Fields                // 会话/轮次/run/step 关联字段，为空时省略
 	Data      interface{} `json:"data"`
 }
 
 func SendWrappedLog(client *elasticsearch.Client, streamName string, logType string, rawData interface{}) error {
+	return CtxSendWrappedLog(context.Background(), client, streamName, logType, rawData)
+}
+
+// CtxSendWrappedLog 与 SendWrappedLog 相同，并附带 ctx 中的关联字段（见 WithRun / WithStep）
+func CtxSendWrappedLog(ctx context.Context, client *elasticsearch.Client, streamName string, logType string, rawData interface{}) error {
 	if client == nil {
 		return nil
 	}
@@ -25,6 +31,7 @@ func SendWrappedLog(client *elasticsearch.Client, streamName string, logType str
 	payload := WrapperStruct{
 		LogType:   logType,
 		Timestamp: time.Now(),
+		Fields:    FieldsFrom(ctx),
 		Data:      rawData,
 	}
 
@@ -19,7 +19,7 @@ type LoggerCallback struct {
 }
 
 func (cb *LoggerCallback) OnStart(ctx context.Context, info *callbacks.RunInfo, input callbacks.CallbackInput) context.Context {
-	err := SendWrappedLog(cb.Es, "test_logs", "callback", input)
+	err := CtxSendWrappedLog(ctx, cb.Es, "test_logs", "callback", input)
 	if err != nil {
 		Warnf("[OnStart] ES 日志写入失败: %v", err)
 	}
@@ -30,7 +30,7 @@ func (cb *LoggerCallback) OnStart(ctx context.Context, info *callbacks.RunInfo,
 }
 
 func (cb *LoggerCallback) OnEnd(ctx context.Context, info *callbacks.RunInfo, output callbacks.CallbackOutput) context.Context {
-	err := SendWrappedLog(cb.Es, "test_logs", "callback", output)
+	err := CtxSendWrappedLog(ctx, cb.Es, "test_logs", "callback", output)
 	if err != nil {
 		Warnf("[OnEnd] ES 日志写入失败: %v", err)
 	}
@@ -53,13 +53,14 @@ type PrettyLoggerCallback struct {
 }
 
 func (cb *PrettyLoggerCallback) OnStart(ctx context.Context, info *callbacks.RunInfo, input callbacks.CallbackInput) context.Context {
-	err := SendWrappedLog(cb.Es, "test_logs", "callback", input)
+	err := CtxSendWrappedLog(ctx, cb.Es, "test_logs", "callback", input)
 	if err != nil {
 		Warnf("[OnStart] ES 日志写入失败: %v", err)
 	}
 	cb.Step++
 	fmt.Printf("\n╔════════════════════════════════════════════════════════════╗\n")
 	fmt.Printf("║ 步骤 #%d - %s 开始\n", cb.Step, info.Name)
+	printFields(ctx, "║")
 	fmt.Printf("╠════════════════════════════════════════════════════════════╣\n")
 
 	// 美化输入展示
@@ -95,12 +96,13 @@ func (cb *PrettyLoggerCallback) OnStart(ctx context.Context, info *callbacks.Run
 }
 
 func (cb *PrettyLoggerCallback) OnEnd(ctx context.Context, info *callbacks.RunInfo, output callbacks.CallbackOutput) context.Context {
-	err := SendWrappedLog(cb.Es, "test_logs", "callback", output)
+	err := CtxSendWrappedLog(ctx, cb.Es, "test_logs", "callback", output)
 	if err != nil {
 		Warnf("[OnEnd] ES 日志写入失败: %v", err)
 	}
 	fmt.Printf("\n┌────────────────────────────────────────────────────────────┐\n")
 	fmt.Printf("│ %s 完成\n", info.Name)
+	printFields(ctx, "│")
 	fmt.Printf("├────────────────────────────────────────────────────────────┤\n")
 
 	// 美化输出展示
@@ -135,6 +137,7 @@ func (cb *PrettyLoggerCallback) OnEnd(ctx context.Context, info *callbacks.RunIn
 func (cb *PrettyLoggerCallback) OnError(ctx context.Context, info *callbacks.RunInfo, err error) context.Context {
 	fmt.Printf("\n╔════════════════════════════════════════════════════════════╗\n")
 	fmt.Printf("║ ❌ 错误发生在: %s\n", info.Name)
+	printFields(ctx, "║")
 	fmt.Printf("╠════════════════════════════════════════════════════════════╣\n")
 	fmt.Printf("║ %s\n", err.Error())
 	fmt.Printf("╚════════════════════════════════════════════════════════════╝\n")
@@ -142,6 +145,14 @@ func (cb *PrettyLoggerCallback) OnError(ctx context.Context, info *callbacks.Run
 }
 
 // 辅助函数
+
+// printFields 在框线内打印 ctx 中的会话/轮次/run/step 关联字段（没有则不打印）
+func printFields(ctx context.Context, border string) {
+	if f := FieldsFrom(ctx).String(); f != "" {
+		fmt.Printf("%s %s\n", border, f)
+	}
+}
+
 func truncate(s string, maxLen int) string {
 	if len(s) <= maxLen {
 		return s